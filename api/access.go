@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/rbac"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// accessLevel distinguishes viewing a service from changing it
+type accessLevel int
+
+const (
+	accessRead accessLevel = iota
+	accessMutate
+)
+
+// serviceAccess applies the role permissions and the per-service policies
+// from the configuration for the current OS to API callers
+type serviceAccess struct {
+	services map[string]utils.Service
+	restrict bool
+	policy   *rbac.Policy
+}
+
+// newServiceAccess builds the access policy from cfg and the permissions of
+// policy
+func newServiceAccess(cfg utils.Config, policy *rbac.Policy) *serviceAccess {
+	configured, restrict := cfg.ManagedServices()
+	return &serviceAccess{services: configured, restrict: restrict, policy: policy}
+}
+
+// lookup finds the policy for name. Names match with or without the
+// ".service" suffix, so "nginx" configures "nginx.service" and vice versa.
+func (a *serviceAccess) lookup(name string) (utils.Service, bool) {
+	if policy, ok := a.services[name]; ok {
+		return policy, true
+	}
+	alias := name + ".service"
+	if trimmed, ok := strings.CutSuffix(name, ".service"); ok {
+		alias = trimmed
+	}
+	policy, ok := a.services[alias]
+	return policy, ok
+}
+
+// visible reports whether name exists as far as the API is concerned:
+// disabled services, and unconfigured ones when restricted, are hidden
+func (a *serviceAccess) visible(name string) bool {
+	policy, ok := a.lookup(name)
+	if !ok {
+		return !a.restrict
+	}
+	return policy.Enabled
+}
+
+// allowed reports whether a caller holding roles may perform op on name at
+// level
+func (a *serviceAccess) allowed(op, name string, roles []string, level accessLevel) bool {
+	return a.visible(name) && a.permitted(op, name, roles) && a.policyAllows(name, roles, level)
+}
+
+// permitted reports whether roles grant the permission for op on name
+func (a *serviceAccess) permitted(op, name string, roles []string) bool {
+	return a.policy.Allowed(roles, rbac.ServicePermission(op), name)
+}
+
+// policyAllows reports whether the service's own allowedRoles and readRoles
+// admit a caller holding roles at level
+func (a *serviceAccess) policyAllows(name string, roles []string, level accessLevel) bool {
+	policy, ok := a.lookup(name)
+	if !ok {
+		return true
+	}
+
+	required := policy.AllowedRoles
+	if level == accessRead && len(policy.ReadRoles) > 0 {
+		required = policy.ReadRoles
+	}
+	return len(required) == 0 || hasAnyRole(roles, required)
+}
+
+// check returns a ServiceError for op when the caller may not access name
+// at level: ErrNotFound for hidden services, ErrPermissionDenied otherwise
+func (a *serviceAccess) check(op, name string, roles []string, level accessLevel) error {
+	if !a.visible(name) {
+		return &services.ServiceError{Op: op, Name: name, Err: services.ErrNotFound,
+			Detail: fmt.Sprintf("Service %s not found", name)}
+	}
+	if !a.permitted(op, name, roles) || !a.policyAllows(name, roles, level) {
+		verb := op
+		if level == accessRead {
+			verb = "view"
+		}
+		return &services.ServiceError{Op: op, Name: name, Err: services.ErrPermissionDenied,
+			Detail: fmt.Sprintf("Not allowed to %s service %s", verb, name)}
+	}
+	return nil
+}
+
+// keyAllows reports whether the caller's API key covers the service name.
+// Callers without an API key are not limited.
+func keyAllows(r *http.Request, name string) bool {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	return claims == nil || claims.APIKey == nil || claims.APIKey.AllowsService(name)
+}
+
+// checkKeyScope returns a ServiceError for op when the caller's API key does
+// not cover the service name
+func checkKeyScope(r *http.Request, op, name string) error {
+	if !keyAllows(r, name) {
+		return &services.ServiceError{Op: op, Name: name, Err: services.ErrPermissionDenied,
+			Detail: fmt.Sprintf("API key is not scoped for service %s", name)}
+	}
+	return nil
+}
+
+// callerRoles returns the roles in the request's JWT claims
+func callerRoles(r *http.Request) []string {
+	if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil {
+		return claims.Roles
+	}
+	return nil
+}
+
+// hasAnyRole reports whether roles and required share at least one role
+func hasAnyRole(roles, required []string) bool {
+	for _, role := range roles {
+		for _, want := range required {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+const (
+	// followBacklogLines is the number of existing entries sent before new
+	// ones when following without an explicit lines parameter
+	followBacklogLines = 10
+	// streamKeepAlive is how often an idle stream is pinged so proxies and
+	// clients do not time it out
+	streamKeepAlive = 15 * time.Second
+	// streamWriteTimeout bounds a single write to a streaming client
+	streamWriteTimeout = 10 * time.Second
+)
+
+// upgrader upgrades follow requests to WebSocket. Requests are authenticated
+// with the Authorization header rather than cookies, so any origin may
+// connect, matching the CORS policy of the rest of the API.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// logStreams tracks live log followers so they can be capped per user and
+// ended when the server shuts down
+type logStreams struct {
+	mu       sync.Mutex
+	perUser  map[string]int
+	max      int
+	shutdown chan struct{}
+	closed   bool
+}
+
+// activeLogStreams is set up by SetupRoutes
+var activeLogStreams = newLogStreams(0)
+
+func newLogStreams(max int) *logStreams {
+	return &logStreams{
+		perUser:  make(map[string]int),
+		max:      max,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// acquire reserves a stream slot for user, reporting false when the user is
+// at the limit or the server is shutting down
+func (s *logStreams) acquire(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || (s.max > 0 && s.perUser[user] >= s.max) {
+		return false
+	}
+	s.perUser[user]++
+	return true
+}
+
+// release frees a stream slot held by user
+func (s *logStreams) release(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.perUser[user]--
+	if s.perUser[user] <= 0 {
+		delete(s.perUser, user)
+	}
+}
+
+// close ends every stream and refuses new ones
+func (s *logStreams) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.shutdown)
+	}
+}
+
+// ShutdownLogStreams ends every live log stream. Register it with
+// http.Server.RegisterOnShutdown so Shutdown does not wait for streaming
+// clients to disconnect on their own.
+func ShutdownLogStreams() {
+	activeLogStreams.close()
+}
+
+// followLogs streams log entries of the named service over WebSocket when
+// the client asks for an upgrade, and as Server-Sent Events otherwise
+func (h *serviceHandlers) followLogs(w http.ResponseWriter, r *http.Request, name string, query services.LogQuery) {
+	follower, ok := h.manager.(services.LogFollower)
+	if !ok {
+		utils.WriteErrorResponse(w, "Live log streaming is not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+
+	user := ""
+	if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil {
+		user = claims.UserID
+	}
+	streams := activeLogStreams
+	if !streams.acquire(user) {
+		utils.WriteErrorResponse(w, fmt.Sprintf("Too many live log streams (limit %d per user)", streams.max), http.StatusTooManyRequests)
+		return
+	}
+	defer streams.release(user)
+
+	// Stop following when the client disconnects or the server shuts down
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-streams.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	entries, err := follower.FollowLogs(ctx, name, query)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	// Streams outlive the server's WriteTimeout; each write sets its own
+	// deadline instead
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamWebSocket(ctx, cancel, w, r, entries)
+		return
+	}
+	streamSSE(ctx, w, entries)
+}
+
+// streamSSE writes entries as Server-Sent Events. Each event's id is the
+// entry's cursor, so a reconnecting EventSource resumes via Last-Event-ID.
+func streamSSE(ctx context.Context, w http.ResponseWriter, entries <-chan services.LogEntry) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case entry, ok := <-entries:
+			if !ok {
+				fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				rc.Flush()
+				return
+			}
+			data, _ := json.Marshal(entry)
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			_, err = fmt.Fprintf(w, "id: %s\nevent: log\ndata: %s\n\n", entry.Cursor, data)
+		case <-keepAlive.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// streamWebSocket upgrades the connection and writes each entry as a JSON
+// text message. Messages from the client are discarded; reading them is how
+// a disconnect is noticed.
+func streamWebSocket(ctx context.Context, cancel context.CancelFunc, w http.ResponseWriter, r *http.Request, entries <-chan services.LogEntry) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "stream ended"),
+				time.Now().Add(time.Second))
+			return
+		case entry, ok := <-entries:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "end of log"),
+					time.Now().Add(time.Second))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(entry); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
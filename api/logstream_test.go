@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/rbac"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// fakeFollower follows logs by sending entries, then, with hold set, waiting
+// for the follower to go away before ending the stream
+type fakeFollower struct {
+	services.ServiceManager
+	entries []services.LogEntry
+	hold    bool
+}
+
+func (f *fakeFollower) FollowLogs(ctx context.Context, name string, query services.LogQuery) (<-chan services.LogEntry, error) {
+	ch := make(chan services.LogEntry, len(f.entries))
+	for _, entry := range f.entries {
+		ch <- entry
+	}
+	go func() {
+		if f.hold {
+			<-ctx.Done()
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// newTestLogServer serves the logs endpoint of follower behind the JWT
+// middleware, allowing at most max live streams per user
+func newTestLogServer(t *testing.T, follower *fakeFollower, max int) *httptest.Server {
+	t.Helper()
+	middleware.InitAuth(middleware.AuthConfig{SecretKey: "test-secret", TokenDuration: time.Minute})
+	policy := newTestPolicy(t, map[string]utils.RoleConfig{"viewer": {Permissions: []string{rbac.LogsRead}}})
+
+	previous := activeLogStreams
+	activeLogStreams = newLogStreams(max)
+	t.Cleanup(func() { activeLogStreams = previous })
+
+	h := newServiceHandlers(follower, &serviceAccess{policy: policy}, nil, nil)
+	server := httptest.NewServer(middleware.AuthMiddleware(http.HandlerFunc(h.ViewServiceLogs)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testToken returns an access token for user with the viewer role
+func testToken(t *testing.T, user string) string {
+	t.Helper()
+	token, err := middleware.CreateToken(user, []string{"viewer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// follow starts following the logs of app as user, returning the response
+// and a function ending the stream
+func follow(t *testing.T, server *httptest.Server, user string) (*http.Response, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/services/logs/app?follow=true", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, user))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	return resp, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+func TestFollowLogsPerUserLimit(t *testing.T) {
+	server := newTestLogServer(t, &fakeFollower{hold: true}, 1)
+
+	first, end := follow(t, server, "alice")
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("first stream status = %d", first.StatusCode)
+	}
+
+	second, endSecond := follow(t, server, "alice")
+	endSecond()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second stream status = %d, want %d", second.StatusCode, http.StatusTooManyRequests)
+	}
+
+	other, endOther := follow(t, server, "bob")
+	endOther()
+	if other.StatusCode != http.StatusOK {
+		t.Errorf("other user's stream status = %d, want %d", other.StatusCode, http.StatusOK)
+	}
+
+	// Ending a stream frees its slot
+	end()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, end := follow(t, server, "alice")
+		end()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stream status = %d after the first stream ended", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFollowLogsSSE(t *testing.T) {
+	entries := []services.LogEntry{
+		{Cursor: "c1", Message: "first", Level: "info"},
+		{Cursor: "c2", Message: "multi\nline", Level: "err"},
+	}
+	server := newTestLogServer(t, &fakeFollower{entries: entries}, 0)
+
+	resp, end := follow(t, server, "alice")
+	defer end()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	var want strings.Builder
+	for _, entry := range entries {
+		data, _ := json.Marshal(entry)
+		fmt.Fprintf(&want, "id: %s\nevent: log\ndata: %s\n\n", entry.Cursor, data)
+	}
+	want.WriteString("event: end\ndata: {}\n\n")
+	if string(body) != want.String() {
+		t.Errorf("body = %q, want %q", body, want.String())
+	}
+}
+
+func TestFollowLogsWebSocketAuth(t *testing.T) {
+	entry := services.LogEntry{Cursor: "c1", Message: "hello"}
+	server := newTestLogServer(t, &fakeFollower{entries: []services.LogEntry{entry}}, 0)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/services/logs/app?follow=true"
+	token := testToken(t, "alice")
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+	}{
+		// Any origin may connect, so a browser's cookies must not
+		// authenticate a page on another site
+		{"cookie from another origin", http.Header{"Origin": {"https://evil.example"}, "Cookie": {"token=" + token}}, http.StatusUnauthorized},
+		{"no credentials", http.Header{}, http.StatusUnauthorized},
+		{"bearer token from another origin", http.Header{"Origin": {"https://evil.example"}, "Authorization": {"Bearer " + token}}, http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := websocket.DefaultDialer.Dial(url, tt.header)
+			if resp == nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+
+			var got services.LogEntry
+			if err := conn.ReadJSON(&got); err != nil || got.Cursor != entry.Cursor || got.Message != entry.Message {
+				t.Fatalf("ReadJSON() = %+v, %v", got, err)
+			}
+			if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("ReadMessage() error = %v, want a normal close", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/metrics"
+	"github.com/therealtoxicdev/chronoserve/probes"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// serviceMetricsTimeout bounds the service listing done for each scrape
+const serviceMetricsTimeout = 10 * time.Second
+
+// newMetricsHandler serves the metrics in metrics.Default, requiring the
+// basic auth credentials or bearer token in cfg when set
+func newMetricsHandler(cfg utils.MetricsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !metricsAuthorized(cfg, r) {
+			if cfg.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			}
+			utils.WriteErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.Default.WriteTo(w)
+	}
+}
+
+// metricsAuthorized checks r against the credentials in cfg. Either
+// credential is accepted when both are configured.
+func metricsAuthorized(cfg utils.MetricsConfig, r *http.Request) bool {
+	if cfg.Username == "" && cfg.Token == "" {
+		return true
+	}
+	if cfg.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) == 1 {
+			return true
+		}
+	}
+	if cfg.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(cfg.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.Password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// registerServiceMetrics adds per-service gauges for the enabled services in
+// configured, collected from a single listing at scrape time
+func registerServiceMetrics(manager services.ServiceManager, configured map[string]utils.Service, prober *probes.Prober) {
+	var names []string
+	for name, service := range configured {
+		if service.Enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	metrics.Default.NewCollector(map[string]string{
+		"chronoserve_service_up":             "Whether the service manager has the configured service loaded (1) or not (0).",
+		"chronoserve_service_active":         "Whether the configured service is active (1) or not (0).",
+		"chronoserve_service_probes_healthy": "Whether the service's health probes pass (1) or not (0), once they have a verdict.",
+	}, func() map[string][]metrics.Sample {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceMetricsTimeout)
+		defer cancel()
+		list, err := manager.List(ctx)
+		if err != nil {
+			return nil
+		}
+		found := make(map[string]services.ServiceInfo, len(list))
+		for _, info := range list {
+			found[services.UnitBaseName(info.Name)] = info
+		}
+
+		samples := make(map[string][]metrics.Sample)
+		for _, name := range names {
+			labels := []string{name}
+			info, ok := found[services.UnitBaseName(name)]
+			up := ok && info.LoadState != "not-found"
+			samples["chronoserve_service_up"] = append(samples["chronoserve_service_up"],
+				metrics.Sample{Labels: labels, Value: boolValue(up)})
+			samples["chronoserve_service_active"] = append(samples["chronoserve_service_active"],
+				metrics.Sample{Labels: labels, Value: boolValue(up && info.ActiveState == "active")})
+
+			if health, _, ok := prober.Health(name); ok && health != probes.StateUnknown {
+				samples["chronoserve_service_probes_healthy"] = append(samples["chronoserve_service_probes_healthy"],
+					metrics.Sample{Labels: labels, Value: boolValue(health == probes.StateHealthy)})
+			}
+		}
+		return samples
+	}, "service")
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/fleet"
+	"github.com/therealtoxicdev/chronoserve/rbac"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// nodeHandlers exposes the services of fleet nodes through the controller.
+// Each agent applies its own per-service policy to the caller's roles, so
+// the controller checks only the permissions of the roles.
+type nodeHandlers struct {
+	controller *fleet.Controller
+	policy     *rbac.Policy
+	bus        *events.Bus
+}
+
+// newNodeHandlers creates the HTTP adapters for controller, granting the
+// permissions of policy. Actions run on nodes are published on bus.
+func newNodeHandlers(controller *fleet.Controller, policy *rbac.Policy, bus *events.Bus) *nodeHandlers {
+	return &nodeHandlers{controller: controller, policy: policy, bus: bus}
+}
+
+// Nodes lists the configured nodes and whether their agents are connected
+func (h *nodeHandlers) Nodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	utils.WriteSuccessResponse(w, "Nodes retrieved successfully", h.controller.Nodes())
+}
+
+// Service adapts a serviceHandlers method to the node named in the path,
+// e.g. (*serviceHandlers).StartService for /nodes/{node}/services/start/
+func (h *nodeHandlers) Service(handler func(*serviceHandlers, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		node := r.PathValue("node")
+		manager, err := h.controller.Node(node, callerRoles(r))
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		access := &serviceAccess{policy: h.policy}
+		handler(&serviceHandlers{manager: manager, access: access, bus: h.bus, node: node}, w, r)
+	}
+}
+
+// nodeServices is one node's part of the fleet-wide service list
+type nodeServices struct {
+	Node     string                 `json:"node"`
+	Services []services.ServiceInfo `json:"services"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// FleetServices lists the services of every node, asking the connected
+// nodes in parallel. A node that is offline or fails to answer is listed
+// with its error instead of failing the whole request.
+func (h *nodeHandlers) FleetServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodes := h.controller.Nodes()
+	results := make([]nodeServices, len(nodes))
+	roles := callerRoles(r)
+	var wg sync.WaitGroup
+	for i, info := range nodes {
+		results[i] = nodeServices{Node: info.Name, Services: []services.ServiceInfo{}}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manager, err := h.controller.Node(info.Name, roles)
+			if err == nil {
+				var list []services.ServiceInfo
+				if list, err = manager.List(r.Context()); err == nil {
+					for _, svc := range list {
+						if h.policy.Allowed(roles, rbac.ServicesList, svc.Name) && keyAllows(r, svc.Name) {
+							results[i].Services = append(results[i].Services, svc)
+						}
+					}
+				}
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				var svcErr *services.ServiceError
+				if errors.As(err, &svcErr) && svcErr.Detail != "" {
+					results[i].Error = svcErr.Detail
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	utils.WriteSuccessResponse(w, "Fleet services retrieved successfully", results)
+}
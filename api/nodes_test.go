@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/fleet"
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/rbac"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// fakeStarter records the services it starts
+type fakeStarter struct {
+	services.ServiceManager
+	mu      sync.Mutex
+	started []string
+}
+
+func (m *fakeStarter) Start(ctx context.Context, name string) (*services.ActionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = append(m.started, name)
+	return &services.ActionResult{Name: name, Action: "start", Message: "Service " + name + " started"}, nil
+}
+
+func newTestLogger(t *testing.T) *utils.Logger {
+	t.Helper()
+	logger, err := utils.NewLogger(utils.LoggerOptions{Level: utils.ERROR, Directory: t.TempDir(), Filename: "test.log", MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+	return logger
+}
+
+// newTestPolicy builds a policy from roles, failing the test if it is invalid
+func newTestPolicy(t *testing.T, roles map[string]utils.RoleConfig) *rbac.Policy {
+	t.Helper()
+	policy, err := rbac.New(roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestNodeServiceForwardsRoles(t *testing.T) {
+	middleware.InitAuth(middleware.AuthConfig{SecretKey: "test-secret", TokenDuration: time.Minute})
+	logger := newTestLogger(t)
+	start := rbac.ServicePermission("start")
+
+	// The controller lets viewers and operators start services, but the
+	// node's own policy only lets operators
+	controllerPolicy := newTestPolicy(t, map[string]utils.RoleConfig{
+		"viewer":   {Permissions: []string{start}},
+		"operator": {Permissions: []string{start}},
+	})
+	nodeAccess := &serviceAccess{policy: newTestPolicy(t, map[string]utils.RoleConfig{
+		"operator": {Permissions: []string{start}},
+	})}
+
+	controller, err := fleet.NewController(utils.ControllerConfig{RequestTimeout: "5s", Agents: map[string]utils.AgentCredentials{
+		"web1": {Token: "web1-secret"},
+	}}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeHandler := newNodeHandlers(controller, controllerPolicy, events.NewBus(nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fleet/connect", controller.HandleConnect)
+	mux.Handle("/nodes/{node}/services/start/", middleware.AuthMiddleware(nodeHandler.Service((*serviceHandlers).StartService)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		controller.Close()
+		server.Close()
+	})
+
+	manager := &fakeStarter{}
+	authorize := func(op, name string, roles []string, mutate bool) error {
+		level := accessRead
+		if mutate {
+			level = accessMutate
+		}
+		return nodeAccess.check(op, name, roles, level)
+	}
+	agent, err := fleet.NewAgent(utils.AgentConfig{
+		Controller: "ws" + strings.TrimPrefix(server.URL, "http") + "/fleet/connect",
+		Node:       "web1",
+		Token:      "web1-secret",
+	}, manager, authorize, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.Start()
+	t.Cleanup(agent.Stop)
+	deadline := time.Now().Add(5 * time.Second)
+	for !controller.Nodes()[0].Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("agent did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name        string
+		node        string
+		roles       []string
+		wantStatus  int
+		wantStarted bool
+	}{
+		{"allowed by both", "web1", []string{"operator"}, http.StatusOK, true},
+		{"denied by the node", "web1", []string{"viewer"}, http.StatusForbidden, false},
+		{"denied by the controller", "web1", []string{"guest"}, http.StatusForbidden, false},
+		{"unknown node", "web2", []string{"operator"}, http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager.mu.Lock()
+			manager.started = nil
+			manager.mu.Unlock()
+
+			token, err := middleware.CreateToken("alice", tt.roles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/nodes/"+tt.node+"/services/start/app", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			manager.mu.Lock()
+			started := len(manager.started) > 0
+			manager.mu.Unlock()
+			if started != tt.wantStarted {
+				t.Errorf("service started = %v, want %v", started, tt.wantStarted)
+			}
+		})
+	}
+}
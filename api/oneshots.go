@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/scheduler"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// oneShotRequest is the body of POST /actions. At most one of At and Delay
+// may be set; with neither the action runs immediately.
+type oneShotRequest struct {
+	Service  string `json:"service"`
+	Action   string `json:"action"`
+	At       string `json:"at"`       // RFC 3339 time to run at
+	Delay    string `json:"delay"`    // run this long from now, e.g. "30m"
+	Duration string `json:"duration"` // undo the action this long after it runs
+}
+
+// timeBoxUndo maps the actions a time-boxed request accepts to the action
+// that ends them
+var timeBoxUndo = map[string]string{
+	"start": "stop",
+	"stop":  "start",
+}
+
+// OneShots lists one-shot actions (GET) or creates one (POST)
+func (h *scheduleHandlers) OneShots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		roles := callerRoles(r)
+		shots := make([]scheduler.OneShot, 0)
+		for _, shot := range h.scheduler.OneShots() {
+			if h.access.allowed("list", shot.Service, roles, accessRead) {
+				shots = append(shots, shot)
+			}
+		}
+		utils.WriteSuccessResponse(w, "Actions retrieved successfully", shots)
+	case http.MethodPost:
+		h.createOneShot(w, r)
+	default:
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// OneShot returns (GET) or cancels (DELETE) the one-shot action named in the
+// path. Actions on services the caller cannot view are reported as not
+// found.
+func (h *scheduleHandlers) OneShot(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/actions/")
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles := callerRoles(r)
+	shot, ok := h.scheduler.OneShot(id)
+	if !ok || !h.access.allowed("list", shot.Service, roles, accessRead) {
+		utils.WriteErrorResponse(w, "Action not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		utils.WriteSuccessResponse(w, "Action retrieved successfully", shot)
+	case http.MethodDelete:
+		// Cancelling needs the same access as scheduling the action
+		if err := h.checkOneShotAccess(shot, roles); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		cancelled, err := h.scheduler.CancelOneShot(id)
+		if err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+		utils.WriteSuccessResponse(w, "Action cancelled successfully", cancelled)
+	}
+}
+
+// checkOneShotAccess returns a ServiceError when a caller holding roles may
+// not run every step of shot
+func (h *scheduleHandlers) checkOneShotAccess(shot scheduler.OneShot, roles []string) error {
+	for _, action := range []string{shot.Action, shot.Then} {
+		if action == "" {
+			continue
+		}
+		if err := h.access.check(action, shot.Service, roles, accessMutate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createOneShot schedules the action in the request body on behalf of the
+// caller
+func (h *scheduleHandlers) createOneShot(w http.ResponseWriter, r *http.Request) {
+	var req oneShotRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		utils.WriteValidationError(w, "Invalid request body: "+err.Error())
+		return
+	}
+
+	shot, err := req.toOneShot(time.Now())
+	if err != nil {
+		utils.WriteValidationError(w, err.Error())
+		return
+	}
+
+	if err := h.checkOneShotAccess(shot, callerRoles(r)); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil {
+		shot.CreatedBy = claims.UserID
+	}
+
+	created, err := h.scheduler.AddOneShot(shot)
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+	utils.WriteSuccessResponse(w, "Action scheduled successfully", created)
+}
+
+// toOneShot resolves the request's relative times against now
+func (req oneShotRequest) toOneShot(now time.Time) (scheduler.OneShot, error) {
+	shot := scheduler.OneShot{Service: req.Service, Action: req.Action, RunAt: now}
+	if !services.ValidAction(req.Action) {
+		return shot, fmt.Errorf("unknown action %q, must be one of %s", req.Action, strings.Join(services.Actions, ", "))
+	}
+
+	switch {
+	case req.At != "" && req.Delay != "":
+		return shot, errors.New("at and delay are mutually exclusive")
+	case req.At != "":
+		at, err := time.Parse(time.RFC3339, req.At)
+		if err != nil {
+			return shot, fmt.Errorf("invalid at: %s", req.At)
+		}
+		shot.RunAt = at
+	case req.Delay != "":
+		delay, err := time.ParseDuration(req.Delay)
+		if err != nil || delay < 0 {
+			return shot, fmt.Errorf("invalid delay: %s", req.Delay)
+		}
+		shot.RunAt = now.Add(delay)
+	}
+
+	if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			return shot, fmt.Errorf("invalid duration: %s", req.Duration)
+		}
+		undo, ok := timeBoxUndo[req.Action]
+		if !ok {
+			return shot, fmt.Errorf("duration is only supported for start and stop, not %q", req.Action)
+		}
+		thenAt := shot.RunAt.Add(duration)
+		shot.Then, shot.ThenAt = undo, &thenAt
+	}
+	return shot, nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/rbac"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// policyHandlers explains access decisions without performing any action
+type policyHandlers struct {
+	policy *rbac.Policy
+	access *serviceAccess
+}
+
+// newPolicyHandlers creates the HTTP adapters for policy and access
+func newPolicyHandlers(policy *rbac.Policy, access *serviceAccess) *policyHandlers {
+	return &policyHandlers{policy: policy, access: access}
+}
+
+// servicePolicyExplanation is the decision of a service's own policy from
+// the services configuration
+type servicePolicyExplanation struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+// policyExplanation is the payload of the policy dry-run endpoint. The
+// action is allowed if both the role permissions and, for a service, the
+// service's own policy allow it.
+type policyExplanation struct {
+	User          string                    `json:"user,omitempty"`
+	Roles         []string                  `json:"roles"`
+	Allowed       bool                      `json:"allowed"`
+	Reason        string                    `json:"reason"`
+	Permissions   rbac.Explanation          `json:"permissions"`
+	ServicePolicy *servicePolicyExplanation `json:"servicePolicy,omitempty"`
+}
+
+// Explain reports whether a user, or a set of roles, is allowed a permission
+// on a service and why. The permission and service query parameters name
+// the action; user names a configured user, and role (repeatable) names
+// roles directly. Without either the caller is explained.
+func (h *policyHandlers) Explain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	perm, service := query.Get("permission"), query.Get("service")
+	if !rbac.IsPermission(perm) {
+		utils.WriteValidationError(w, fmt.Sprintf("permission must be one of %s", strings.Join(rbac.Permissions(), ", ")))
+		return
+	}
+
+	var e policyExplanation
+	switch {
+	case query.Has("user"):
+		user, ok := utils.GetConfig().Auth.Users[query.Get("user")]
+		if !ok {
+			utils.WriteErrorResponse(w, "User not found", http.StatusNotFound)
+			return
+		}
+		e.User, e.Roles = query.Get("user"), user.Roles
+	case query.Has("role"):
+		e.Roles = query["role"]
+	default:
+		if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil {
+			e.User, e.Roles = claims.UserID, claims.Roles
+		}
+	}
+	if e.Roles == nil {
+		e.Roles = []string{}
+	}
+
+	e.Permissions = h.policy.Explain(e.Roles, perm, service)
+	e.Allowed, e.Reason = e.Permissions.Allowed, e.Permissions.Reason
+	if op, level, ok := serviceOp(perm); ok && service != "" {
+		e.ServicePolicy = h.explainServicePolicy(service, e.Roles, level)
+		if e.Allowed && !e.ServicePolicy.Allowed {
+			e.Allowed, e.Reason = false, fmt.Sprintf("%s, but not allowed to %s service %s by its policy", e.Reason, op, service)
+		}
+	}
+	utils.WriteSuccessResponse(w, "Policy explained", e)
+}
+
+// explainServicePolicy applies the service's own visibility and roles from
+// the services configuration
+func (h *policyHandlers) explainServicePolicy(name string, roles []string, level accessLevel) *servicePolicyExplanation {
+	if !h.access.visible(name) {
+		return &servicePolicyExplanation{Reason: fmt.Sprintf("service %s is disabled or not configured", name)}
+	}
+	policy, ok := h.access.lookup(name)
+	if !ok {
+		return &servicePolicyExplanation{Allowed: true, Reason: fmt.Sprintf("service %s has no policy", name)}
+	}
+
+	required, kind := policy.AllowedRoles, "allowedRoles"
+	if level == accessRead && len(policy.ReadRoles) > 0 {
+		required, kind = policy.ReadRoles, "readRoles"
+	}
+	if len(required) == 0 {
+		return &servicePolicyExplanation{Allowed: true, Reason: fmt.Sprintf("service %s does not restrict roles", name)}
+	}
+	allowed := hasAnyRole(roles, required)
+	verb := "admit"
+	if !allowed {
+		verb = "do not admit"
+	}
+	return &servicePolicyExplanation{Allowed: allowed,
+		Reason: fmt.Sprintf("the %s of service %s (%s) %s these roles", kind, name, strings.Join(required, ", "), verb)}
+}
+
+// serviceOp returns the service operation that perm permits and its access
+// level, if perm is about a single service
+func serviceOp(perm string) (string, accessLevel, bool) {
+	switch perm {
+	case rbac.ServicesList, rbac.ServicesStatus, rbac.LogsRead:
+		return "view", accessRead, true
+	}
+	if op, ok := rbac.ServiceAction(perm); ok {
+		return op, accessMutate, true
+	}
+	return "", accessRead, false
+}
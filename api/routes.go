@@ -1,30 +1,57 @@
 package api
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/fleet"
 	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/probes"
+	"github.com/therealtoxicdev/chronoserve/rbac"
+	"github.com/therealtoxicdev/chronoserve/scheduler"
 	"github.com/therealtoxicdev/chronoserve/services"
 	"github.com/therealtoxicdev/chronoserve/utils"
+	"github.com/therealtoxicdev/chronoserve/watchdog"
+	"github.com/therealtoxicdev/chronoserve/webhooks"
 )
 
 const (
 	apiPrefix = "/"
 )
 
-// Route represents an API route with its handler and required role
+// Route represents an API route with its handler and required permission
 type Route struct {
-	Path        string
-	Handler     http.HandlerFunc
-	RequireAuth bool
-	Roles       []string
+	Path           string
+	Handler        http.HandlerFunc
+	RequireAuth    bool
+	Permission     string // permission the route needs, empty for any authenticated caller
+	ReadPermission string // permission GET requests need instead, for routes that both read and change
+	Scoped         bool   // the last path segment names the service the permission must cover
+	Action         string // API key action the route performs; routes without one refuse API keys
+}
+
+// serviceRoute returns the route at path performing op on the service named
+// by its last path segment, which needs rbac.ServicePermission(op)
+func serviceRoute(path, op string, handler http.HandlerFunc) Route {
+	return Route{Path: path, Handler: handler, RequireAuth: true, Permission: rbac.ServicePermission(op), Scoped: true, Action: op}
+}
+
+// permission returns the permission r needs
+func (route Route) permission(r *http.Request) string {
+	if r.Method == http.MethodGet && route.ReadPermission != "" {
+		return route.ReadPermission
+	}
+	return route.Permission
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Auth-Token, X-Request-Id, X-Request-Start")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Auth-Token, X-Request-Id, X-Request-Start")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -34,18 +61,151 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// Background components started by SetupRoutes and stopped by Shutdown
+var (
+	activeScheduler  *scheduler.Scheduler
+	activeWatcher    *services.Watcher
+	activeWatchdog   *watchdog.Watchdog
+	activeProber     *probes.Prober
+	activeWebhooks   *webhooks.Dispatcher
+	activeManager    services.ServiceManager
+	activeController *fleet.Controller
+	activeAgent      *fleet.Agent
+	eventBus         *events.Bus
+)
+
+// Shutdown stops the background work started by SetupRoutes, waiting for
+// scheduled actions in progress to finish. Call it after http.Server.Shutdown.
+func Shutdown() {
+	if activeAgent != nil {
+		activeAgent.Stop()
+	}
+	if activeController != nil {
+		activeController.Close()
+	}
+	if activeWatchdog != nil {
+		activeWatchdog.Stop()
+	}
+	if activeWatcher != nil {
+		activeWatcher.Stop()
+	}
+	if activeProber != nil {
+		activeProber.Stop()
+	}
+	if activeScheduler != nil {
+		activeScheduler.Stop()
+	}
+	// Stops supervised programs and closes the D-Bus connection
+	if closer, ok := activeManager.(io.Closer); ok {
+		closer.Close()
+	}
+	// Last, so events from the components above are still delivered
+	if activeWebhooks != nil {
+		activeWebhooks.Stop()
+	}
+}
+
+// newComponentLogger creates the log file of a background component
+func newComponentLogger(filename string) *utils.Logger {
+	cfg := utils.GetConfig()
+	logger, err := utils.NewLogger(utils.LoggerOptions{
+		Level:      utils.GetLogLevel(cfg.Logging.Level),
+		Directory:  cfg.Logging.Directory,
+		MaxSize:    10,
+		MaxBackups: 5,
+		Filename:   filename,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize %s logger: %v", filename, err))
+	}
+	return logger
+}
+
 func SetupRoutes() http.Handler {
 	mux := http.NewServeMux()
 
-	// Initialize service handler based on OS
-	var serviceHandler services.ServiceHandler
-	switch utils.GetOperatingSystem() {
-	case "linux":
-		serviceHandler = services.NewSystemdService()
-	case "windows":
-		serviceHandler = services.NewWindowsService()
-	default:
-		panic("Unsupported operating system")
+	// Initialize service manager based on OS and configuration
+	serviceManager, err := services.NewServiceManager(utils.GetConfig())
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize service manager: %v", err))
+	}
+	activeManager = serviceManager
+	cfg := utils.GetConfig()
+	eventBus = events.NewBus(newComponentLogger("events.log"))
+
+	// Roles grant permissions, checked per route and per service
+	policy, err := rbac.New(cfg.Auth.Roles)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize roles: %v", err))
+	}
+	access := newServiceAccess(cfg, policy)
+	configured, _ := cfg.ManagedServices()
+
+	// Probe services that have health probes, and report failing probes in
+	// the health endpoint
+	activeProber, err = probes.New(configured, newComponentLogger("probes.log"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize health probes: %v", err))
+	}
+	activeProber.Start()
+	if activeProber.Len() > 0 {
+		utils.RegisterHealthCheck("probes", activeProber.Check)
+	}
+
+	serviceHandler := newServiceHandlers(serviceManager, access, eventBus, activeProber)
+	activeLogStreams = newLogStreams(cfg.Server.MaxLogFollowers)
+
+	// Start the scheduler for configured and saved jobs
+	activeScheduler, err = scheduler.New(serviceManager, cfg.Scheduler, eventBus, newComponentLogger("scheduler.log"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize scheduler: %v", err))
+	}
+	activeScheduler.Start()
+	scheduleHandler := newScheduleHandlers(activeScheduler, access)
+
+	// Watch service states in the background, publishing transitions on the
+	// event bus and keeping cached status fresh
+	watchInterval, _ := time.ParseDuration(cfg.Watcher.Interval)
+	if watchInterval > 0 {
+		activeWatcher = services.NewWatcher(serviceManager, eventBus, watchInterval, cfg.WatchedServices())
+		activeWatcher.Start()
+	}
+
+	// Restart failed services that have a restart policy
+	activeWatchdog, err = watchdog.New(serviceManager, configured, watchInterval, eventBus, newComponentLogger("watchdog.log"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize watchdog: %v", err))
+	}
+	activeWatchdog.Start()
+	watchdogHandler := newWatchdogHandlers(activeWatchdog, access)
+
+	// Report per-service gauges alongside the request metrics
+	if cfg.Metrics.Enabled {
+		registerServiceMetrics(serviceManager, configured, activeProber)
+	}
+
+	// Notify webhook targets of events
+	activeWebhooks, err = webhooks.New(cfg.Webhooks, eventBus, newComponentLogger("webhooks.log"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize webhooks: %v", err))
+	}
+	activeWebhooks.Start()
+
+	// Serve this host's services to a fleet controller, applying the local
+	// per-service policy to the roles of the controller's caller
+	if cfg.Fleet.Agent.Enabled {
+		authorize := func(op, name string, roles []string, mutate bool) error {
+			level := accessRead
+			if mutate {
+				level = accessMutate
+			}
+			return access.check(op, name, roles, level)
+		}
+		activeAgent, err = fleet.NewAgent(cfg.Fleet.Agent, serviceManager, authorize, newComponentLogger("fleet.log"))
+		if err != nil {
+			panic(fmt.Sprintf("Failed to initialize fleet agent: %v", err))
+		}
+		activeAgent.Start()
 	}
 
 	// Define routes
@@ -53,13 +213,76 @@ func SetupRoutes() http.Handler {
 		// Public endpoints
 		{Path: "health", Handler: utils.HealthCheck, RequireAuth: false},
 		{Path: "auth/login", Handler: middleware.HandleLogin, RequireAuth: false},
+		{Path: "auth/refresh", Handler: middleware.HandleRefresh, RequireAuth: false},
+		{Path: ".well-known/jwks.json", Handler: middleware.HandleJWKS, RequireAuth: false},
+
+		// Sessions
+		{Path: "auth/logout", Handler: middleware.HandleLogout, RequireAuth: true},
+		{Path: "auth/revoke/", Handler: middleware.HandleRevokeSessions, RequireAuth: true, Permission: rbac.SessionsRevoke},
+
+		// API keys for automation
+		{Path: "apikeys", Handler: middleware.HandleAPIKeys, RequireAuth: true, Permission: rbac.APIKeysWrite, ReadPermission: rbac.APIKeysRead},
+		{Path: "apikeys/", Handler: middleware.HandleAPIKey, RequireAuth: true, Permission: rbac.APIKeysWrite},
 
 		// Protected service endpoints
-		{Path: "services", Handler: serviceHandler.ListServices, RequireAuth: true, Roles: []string{"admin", "viewer"}},
-		{Path: "services/start/", Handler: serviceHandler.StartService, RequireAuth: true, Roles: []string{"admin"}},
-		{Path: "services/stop/", Handler: serviceHandler.StopService, RequireAuth: true, Roles: []string{"admin"}},
-		{Path: "services/logs/", Handler: serviceHandler.ViewServiceLogs, RequireAuth: true, Roles: []string{"admin", "viewer"}},
-		{Path: "services/status/", Handler: serviceHandler.GetServiceStatus, RequireAuth: true, Roles: []string{"admin", "viewer"}},
+		{Path: "services", Handler: serviceHandler.ListServices, RequireAuth: true, Permission: rbac.ServicesList, Action: "list"},
+		serviceRoute("services/start/", "start", serviceHandler.StartService),
+		serviceRoute("services/stop/", "stop", serviceHandler.StopService),
+		serviceRoute("services/restart/", "restart", serviceHandler.RestartService),
+		serviceRoute("services/reload/", "reload", serviceHandler.ReloadService),
+		serviceRoute("services/reload-or-restart/", "reload-or-restart", serviceHandler.ReloadOrRestartService),
+		serviceRoute("services/enable/", "enable", serviceHandler.EnableService),
+		serviceRoute("services/disable/", "disable", serviceHandler.DisableService),
+		serviceRoute("services/mask/", "mask", serviceHandler.MaskService),
+		serviceRoute("services/unmask/", "unmask", serviceHandler.UnmaskService),
+		serviceRoute("services/logs/", "logs", serviceHandler.ViewServiceLogs),
+		serviceRoute("services/status/", "status", serviceHandler.GetServiceStatus),
+
+		// Scheduled and one-shot service actions
+		{Path: "schedules", Handler: scheduleHandler.Schedules, RequireAuth: true, Permission: rbac.SchedulesWrite, ReadPermission: rbac.SchedulesRead},
+		{Path: "schedules/", Handler: scheduleHandler.Schedule, RequireAuth: true, Permission: rbac.SchedulesWrite, ReadPermission: rbac.SchedulesRead},
+		{Path: "actions", Handler: scheduleHandler.OneShots, RequireAuth: true, Permission: rbac.SchedulesWrite, ReadPermission: rbac.SchedulesRead},
+		{Path: "actions/", Handler: scheduleHandler.OneShot, RequireAuth: true, Permission: rbac.SchedulesWrite, ReadPermission: rbac.SchedulesRead},
+
+		// Restart watchdog
+		{Path: "watchdog", Handler: watchdogHandler.Services, RequireAuth: true, Permission: rbac.WatchdogRead},
+		{Path: "watchdog/", Handler: watchdogHandler.Service, RequireAuth: true, Permission: rbac.WatchdogRead, Scoped: true},
+		{Path: "watchdog/release/", Handler: watchdogHandler.Release, RequireAuth: true, Permission: rbac.WatchdogRelease, Scoped: true},
+
+		// Policy dry run
+		{Path: "policy/explain", Handler: newPolicyHandlers(policy, access).Explain, RequireAuth: true, Permission: rbac.PolicyRead},
+	}
+
+	// Fleet nodes, when this instance is a controller. Agents authenticate
+	// with their own credentials rather than a JWT.
+	if cfg.Fleet.Controller.Enabled {
+		activeController, err = fleet.NewController(cfg.Fleet.Controller, newComponentLogger("fleet.log"))
+		if err != nil {
+			panic(fmt.Sprintf("Failed to initialize fleet controller: %v", err))
+		}
+		nodeHandler := newNodeHandlers(activeController, policy, eventBus)
+		routes = append(routes,
+			Route{Path: "fleet/connect", Handler: activeController.HandleConnect, RequireAuth: false},
+			Route{Path: "fleet/services", Handler: nodeHandler.FleetServices, RequireAuth: true, Permission: rbac.ServicesList, Action: "list"},
+			Route{Path: "nodes", Handler: nodeHandler.Nodes, RequireAuth: true, Permission: rbac.NodesRead, Action: "list"},
+			Route{Path: "nodes/{node}/services", Handler: nodeHandler.Service((*serviceHandlers).ListServices), RequireAuth: true, Permission: rbac.ServicesList, Action: "list"},
+			serviceRoute("nodes/{node}/services/start/", "start", nodeHandler.Service((*serviceHandlers).StartService)),
+			serviceRoute("nodes/{node}/services/stop/", "stop", nodeHandler.Service((*serviceHandlers).StopService)),
+			serviceRoute("nodes/{node}/services/restart/", "restart", nodeHandler.Service((*serviceHandlers).RestartService)),
+			serviceRoute("nodes/{node}/services/reload/", "reload", nodeHandler.Service((*serviceHandlers).ReloadService)),
+			serviceRoute("nodes/{node}/services/reload-or-restart/", "reload-or-restart", nodeHandler.Service((*serviceHandlers).ReloadOrRestartService)),
+			serviceRoute("nodes/{node}/services/enable/", "enable", nodeHandler.Service((*serviceHandlers).EnableService)),
+			serviceRoute("nodes/{node}/services/disable/", "disable", nodeHandler.Service((*serviceHandlers).DisableService)),
+			serviceRoute("nodes/{node}/services/mask/", "mask", nodeHandler.Service((*serviceHandlers).MaskService)),
+			serviceRoute("nodes/{node}/services/unmask/", "unmask", nodeHandler.Service((*serviceHandlers).UnmaskService)),
+			serviceRoute("nodes/{node}/services/logs/", "logs", nodeHandler.Service((*serviceHandlers).ViewServiceLogs)),
+			serviceRoute("nodes/{node}/services/status/", "status", nodeHandler.Service((*serviceHandlers).GetServiceStatus)),
+		)
+	}
+
+	// Prometheus metrics, protected by their own credentials rather than a JWT
+	if cfg.Metrics.Enabled {
+		routes = append(routes, Route{Path: "metrics", Handler: newMetricsHandler(cfg.Metrics), RequireAuth: false})
 	}
 
 	// Register routes
@@ -69,10 +292,12 @@ func SetupRoutes() http.Handler {
 		if route.RequireAuth {
 			// Add authentication and role-based access
 			chainedHandler := middleware.Chain(
+				middleware.Metrics(apiPrefix+route.Path),
 				middleware.Recovery,
 				middleware.Logger,
 				middleware.AuthMiddleware,
-				middleware.RequireAnyRole(route.Roles...), // Updated to use RequireAnyRole
+				middleware.RequirePermission(policy, route.permission, route.Scoped),
+				middleware.RequireAPIKeyScope(route.Action),
 			)(http.HandlerFunc(handler))
 
 			// Convert http.Handler back to http.HandlerFunc
@@ -80,6 +305,7 @@ func SetupRoutes() http.Handler {
 		} else {
 			// Only add basic middleware for public endpoints
 			chainedHandler := middleware.Chain(
+				middleware.Metrics(apiPrefix+route.Path),
 				middleware.Recovery,
 				middleware.Logger,
 			)(http.HandlerFunc(handler))
@@ -93,7 +319,7 @@ func SetupRoutes() http.Handler {
 			if r.Method == http.MethodOptions {
 				w.Header().Set("Access-Control-Allow-Origin", "*")
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Auth-Token, X-Request-Id, X-Request-Start")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Auth-Token, X-Request-Id, X-Request-Start")
 				w.WriteHeader(http.StatusOK)
 				return
 			}
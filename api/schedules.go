@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/scheduler"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// scheduleHandlers exposes the scheduler's cron jobs and one-shot actions
+// over HTTP
+type scheduleHandlers struct {
+	scheduler *scheduler.Scheduler
+	access    *serviceAccess
+}
+
+// newScheduleHandlers creates the HTTP adapters for s
+func newScheduleHandlers(s *scheduler.Scheduler, access *serviceAccess) *scheduleHandlers {
+	return &scheduleHandlers{scheduler: s, access: access}
+}
+
+// Schedules lists jobs (GET) or adds a job (POST)
+func (h *scheduleHandlers) Schedules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		roles := callerRoles(r)
+		jobs := make([]scheduler.JobStatus, 0)
+		for _, job := range h.scheduler.Jobs() {
+			if h.access.allowed("list", job.Service, roles, accessRead) {
+				jobs = append(jobs, job)
+			}
+		}
+		utils.WriteSuccessResponse(w, "Schedules retrieved successfully", jobs)
+	case http.MethodPost:
+		h.createSchedule(w, r)
+	default:
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Schedule returns (GET) or removes (DELETE) the job named in the path.
+// Jobs on services the caller cannot view are reported as not found.
+func (h *scheduleHandlers) Schedule(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/schedules/")
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles := callerRoles(r)
+	status, ok := h.scheduler.Job(id)
+	if !ok || !h.access.allowed("list", status.Service, roles, accessRead) {
+		utils.WriteErrorResponse(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		utils.WriteSuccessResponse(w, "Schedule retrieved successfully", status)
+	case http.MethodDelete:
+		// Removing a job needs the same access as creating it
+		if err := h.access.check(status.Action, status.Service, roles, accessMutate); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		if err := h.scheduler.Remove(id); err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+		utils.WriteSuccessResponse(w, "Schedule deleted successfully", nil)
+	}
+}
+
+// createSchedule adds the job in the request body on behalf of the caller
+func (h *scheduleHandlers) createSchedule(w http.ResponseWriter, r *http.Request) {
+	var job scheduler.Job
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&job); err != nil {
+		utils.WriteValidationError(w, "Invalid request body: "+err.Error())
+		return
+	}
+	if !services.ValidAction(job.Action) {
+		utils.WriteValidationError(w, fmt.Sprintf("unknown action %q, must be one of %s", job.Action, strings.Join(services.Actions, ", ")))
+		return
+	}
+
+	// Scheduling an action needs the same access as running it now
+	if err := h.access.check(job.Action, job.Service, callerRoles(r), accessMutate); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil {
+		job.CreatedBy = claims.UserID
+	}
+
+	status, err := h.scheduler.Add(job)
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+	utils.WriteSuccessResponse(w, "Schedule created successfully", status)
+}
+
+// writeSchedulerError maps a scheduler error onto an HTTP response
+func writeSchedulerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrInvalidJob), errors.Is(err, scheduler.ErrInvalidOneShot):
+		utils.WriteValidationError(w, err.Error())
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		utils.WriteErrorResponse(w, "Schedule not found", http.StatusNotFound)
+	case errors.Is(err, scheduler.ErrOneShotNotFound):
+		utils.WriteErrorResponse(w, "Action not found", http.StatusNotFound)
+	case errors.Is(err, scheduler.ErrJobExists), errors.Is(err, scheduler.ErrReadOnlyJob),
+		errors.Is(err, scheduler.ErrOneShotFinished), errors.Is(err, scheduler.ErrOneShotRunning):
+		utils.WriteErrorResponse(w, err.Error(), http.StatusConflict)
+	default:
+		utils.WriteInternalError(w, err)
+	}
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/fleet"
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/probes"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// serviceHandlers adapts a services.ServiceManager to HTTP, enforcing the
+// configured per-service access policy
+type serviceHandlers struct {
+	manager services.ServiceManager
+	access  *serviceAccess
+	bus     *events.Bus
+	probes  *probes.Prober // nil for fleet nodes, which probe their own services
+	node    string         // fleet node the manager acts on, empty for this host
+}
+
+// newServiceHandlers creates the HTTP adapters for manager. Completed
+// actions are published on bus, and status includes the results of prober.
+func newServiceHandlers(manager services.ServiceManager, access *serviceAccess, bus *events.Bus, prober *probes.Prober) *serviceHandlers {
+	return &serviceHandlers{manager: manager, access: access, bus: bus, probes: prober}
+}
+
+// ListServices lists the services known to the backend that the caller may
+// view
+func (h *serviceHandlers) ListServices(w http.ResponseWriter, r *http.Request) {
+	list, err := h.manager.List(r.Context())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	roles := callerRoles(r)
+	visible := make([]services.ServiceInfo, 0, len(list))
+	for _, info := range list {
+		if h.access.allowed("list", info.Name, roles, accessRead) && keyAllows(r, info.Name) {
+			visible = append(visible, info)
+		}
+	}
+	utils.WriteSuccessResponse(w, "Services retrieved successfully", visible)
+}
+
+// GetServiceStatus gets the current status of a service
+func (h *serviceHandlers) GetServiceStatus(w http.ResponseWriter, r *http.Request) {
+	name := utils.ExtractServiceName(r.URL.Path)
+	if err := h.authorize(r, "status", name, accessRead); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	status, err := h.manager.Status(r.Context(), name)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if h.probes != nil {
+		if health, results, ok := h.probes.Health(name); ok {
+			// The backend may cache status, so annotate a copy
+			probed := *status
+			probed.Health, probed.Probes = health, results
+			status = &probed
+		}
+	}
+	utils.WriteSuccessResponse(w, "Service status retrieved successfully", status)
+}
+
+// logsResponse is the payload of the logs endpoint. Cursor is the cursor of
+// the last entry returned; pass it back as ?cursor= to fetch the next page.
+type logsResponse struct {
+	Logs   []services.LogEntry `json:"logs"`
+	Cursor string              `json:"cursor,omitempty"`
+}
+
+// ViewServiceLogs retrieves logs for a service, filtered by the lines, since,
+// until, priority, grep, reverse and cursor query parameters. With
+// follow=true the logs are streamed live instead.
+func (h *serviceHandlers) ViewServiceLogs(w http.ResponseWriter, r *http.Request) {
+	name := utils.ExtractServiceName(r.URL.Path)
+	if err := h.authorize(r, "logs", name, accessRead); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	values := r.URL.Query()
+	follow, _ := strconv.ParseBool(values.Get("follow"))
+	if follow && values.Get("cursor") == "" && r.Header.Get("Last-Event-ID") != "" {
+		// A reconnecting EventSource resumes after the last event it saw
+		values.Set("cursor", r.Header.Get("Last-Event-ID"))
+	}
+	if follow && values.Get("lines") == "" {
+		values.Set("lines", strconv.Itoa(followBacklogLines))
+	}
+
+	query, err := parseLogQuery(values)
+	if err != nil {
+		utils.WriteValidationError(w, err.Error())
+		return
+	}
+
+	if follow {
+		h.followLogs(w, r, name, query)
+		return
+	}
+
+	logs, err := h.manager.Logs(r.Context(), name, query)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	response := logsResponse{Logs: logs, Cursor: query.Cursor}
+	if len(logs) > 0 {
+		response.Cursor = logs[len(logs)-1].Cursor
+	}
+	utils.WriteSuccessResponse(w, "Service logs retrieved successfully", response)
+}
+
+// parseLogQuery builds a LogQuery from the logs endpoint's query parameters
+func parseLogQuery(values url.Values) (services.LogQuery, error) {
+	query := services.LogQuery{
+		Priority: values.Get("priority"),
+		Grep:     values.Get("grep"),
+		Cursor:   values.Get("cursor"),
+	}
+
+	if lines := values.Get("lines"); lines != "" {
+		n, err := strconv.Atoi(lines)
+		if err != nil {
+			return query, fmt.Errorf("invalid lines: %s", lines)
+		}
+		query.Lines = n
+	}
+	if since := values.Get("since"); since != "" {
+		t, err := services.ParseLogTime(since)
+		if err != nil {
+			return query, fmt.Errorf("invalid since: %w", err)
+		}
+		query.Since = t
+	}
+	if until := values.Get("until"); until != "" {
+		t, err := services.ParseLogTime(until)
+		if err != nil {
+			return query, fmt.Errorf("invalid until: %w", err)
+		}
+		query.Until = t
+	}
+	if reverse := values.Get("reverse"); reverse != "" {
+		b, err := strconv.ParseBool(reverse)
+		if err != nil {
+			return query, fmt.Errorf("invalid reverse: %s", reverse)
+		}
+		query.Reverse = b
+	}
+
+	if err := query.Validate(); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+// StartService starts a service
+func (h *serviceHandlers) StartService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "start", h.manager.Start)
+}
+
+// StopService stops a service
+func (h *serviceHandlers) StopService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "stop", h.manager.Stop)
+}
+
+// RestartService restarts a service
+func (h *serviceHandlers) RestartService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "restart", h.manager.Restart)
+}
+
+// ReloadService reloads a service's configuration
+func (h *serviceHandlers) ReloadService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "reload", h.manager.Reload)
+}
+
+// ReloadOrRestartService reloads a service, or restarts it if it cannot reload
+func (h *serviceHandlers) ReloadOrRestartService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "reload-or-restart", h.manager.ReloadOrRestart)
+}
+
+// EnableService enables a service at boot, honouring ?now=true
+func (h *serviceHandlers) EnableService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "enable", withNow(r, h.manager.Enable))
+}
+
+// DisableService disables a service at boot, honouring ?now=true
+func (h *serviceHandlers) DisableService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "disable", withNow(r, h.manager.Disable))
+}
+
+// MaskService masks a service, honouring ?now=true
+func (h *serviceHandlers) MaskService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "mask", withNow(r, h.manager.Mask))
+}
+
+// UnmaskService removes a service's mask
+func (h *serviceHandlers) UnmaskService(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r, "unmask", h.manager.Unmask)
+}
+
+// serviceAction is the common signature of the ServiceManager action methods
+type serviceAction func(ctx context.Context, name string) (*services.ActionResult, error)
+
+// runAction runs action, named op, against the service named in the request
+// path if the caller may change that service
+func (h *serviceHandlers) runAction(w http.ResponseWriter, r *http.Request, op string, action serviceAction) {
+	name := utils.ExtractServiceName(r.URL.Path)
+	if err := h.authorize(r, op, name, accessMutate); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	result, err := action(r.Context(), name)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	actor := ""
+	if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil {
+		actor = claims.UserID
+	}
+	h.bus.Publish(events.Event{
+		Type:    events.ServiceAction,
+		Service: name,
+		Node:    h.node,
+		Action:  op,
+		Actor:   actor,
+		Message: result.Message,
+	})
+	utils.WriteSuccessResponse(w, result.Message, nil)
+}
+
+// authorize checks that the caller may access name at level for op, both by
+// the per-service policy and by the scope of their API key
+func (h *serviceHandlers) authorize(r *http.Request, op, name string, level accessLevel) error {
+	if err := h.access.check(op, name, callerRoles(r), level); err != nil {
+		return err
+	}
+	return checkKeyScope(r, op, name)
+}
+
+// withNow binds the ?now=true query parameter to a unit-file action
+func withNow(r *http.Request, action func(ctx context.Context, name string, now bool) (*services.ActionResult, error)) serviceAction {
+	now, _ := strconv.ParseBool(r.URL.Query().Get("now"))
+	return func(ctx context.Context, name string) (*services.ActionResult, error) {
+		return action(ctx, name, now)
+	}
+}
+
+// writeServiceError maps a ServiceManager error onto an HTTP response
+func writeServiceError(w http.ResponseWriter, err error) {
+	var svcErr *services.ServiceError
+	message := err.Error()
+	if errors.As(err, &svcErr) && svcErr.Detail != "" {
+		message = svcErr.Detail
+	}
+
+	switch {
+	case errors.Is(err, services.ErrAlreadyInState):
+		// Asking for the state a service is already in is not a failure
+		utils.WriteSuccessResponse(w, message, nil)
+	case errors.Is(err, services.ErrInvalidName):
+		utils.WriteErrorResponse(w, "Invalid service name", http.StatusBadRequest)
+	case errors.Is(err, services.ErrInvalidArgument):
+		utils.WriteValidationError(w, message)
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteErrorResponse(w, message, http.StatusNotFound)
+	case errors.Is(err, services.ErrPermissionDenied):
+		utils.WriteErrorResponse(w, message, http.StatusForbidden)
+	case errors.Is(err, services.ErrTimeout):
+		utils.WriteErrorResponse(w, message, http.StatusGatewayTimeout)
+	case errors.Is(err, services.ErrUnsupported):
+		utils.WriteErrorResponse(w, message, http.StatusNotImplemented)
+	case errors.Is(err, fleet.ErrUnknownNode):
+		utils.WriteErrorResponse(w, message, http.StatusNotFound)
+	case errors.Is(err, fleet.ErrNodeOffline):
+		utils.WriteErrorResponse(w, message, http.StatusServiceUnavailable)
+	default:
+		utils.WriteInternalError(w, err)
+	}
+}
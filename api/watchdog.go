@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/therealtoxicdev/chronoserve/middleware"
+	"github.com/therealtoxicdev/chronoserve/utils"
+	"github.com/therealtoxicdev/chronoserve/watchdog"
+)
+
+// watchdogHandlers exposes the restart watchdog's state and history over
+// HTTP
+type watchdogHandlers struct {
+	watchdog *watchdog.Watchdog
+	access   *serviceAccess
+}
+
+// newWatchdogHandlers creates the HTTP adapters for wd
+func newWatchdogHandlers(wd *watchdog.Watchdog, access *serviceAccess) *watchdogHandlers {
+	return &watchdogHandlers{watchdog: wd, access: access}
+}
+
+// Services lists the supervised services the caller may view
+func (h *watchdogHandlers) Services(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles := callerRoles(r)
+	statuses := make([]watchdog.Status, 0)
+	for _, status := range h.watchdog.Statuses() {
+		if h.access.allowed("status", status.Service, roles, accessRead) {
+			statuses = append(statuses, status)
+		}
+	}
+	utils.WriteSuccessResponse(w, "Supervised services retrieved successfully", statuses)
+}
+
+// Service returns the supervision state and history of the service named in
+// the path
+func (h *watchdogHandlers) Service(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/watchdog/")
+	if err := h.access.check("status", name, callerRoles(r), accessRead); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	status, ok := h.watchdog.Status(name)
+	if !ok {
+		utils.WriteErrorResponse(w, "Service is not supervised", http.StatusNotFound)
+		return
+	}
+	utils.WriteSuccessResponse(w, "Supervised service retrieved successfully", status)
+}
+
+// Release ends the quarantine of the service named in the path
+func (h *watchdogHandlers) Release(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/watchdog/release/")
+	// Releasing lets the watchdog restart the service again
+	if err := h.access.check("restart", name, callerRoles(r), accessMutate); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	actor := "unknown"
+	if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil {
+		actor = claims.UserID
+	}
+
+	status, err := h.watchdog.Release(name, actor)
+	switch {
+	case errors.Is(err, watchdog.ErrNotSupervised):
+		utils.WriteErrorResponse(w, "Service is not supervised", http.StatusNotFound)
+	case errors.Is(err, watchdog.ErrNotQuarantined):
+		utils.WriteErrorResponse(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		utils.WriteInternalError(w, err)
+	default:
+		utils.WriteSuccessResponse(w, "Service released from quarantine", status)
+	}
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/therealtoxicdev/chronoserve/api"
 	"github.com/therealtoxicdev/chronoserve/middleware"
 	"github.com/therealtoxicdev/chronoserve/utils"
+	"golang.org/x/term"
 )
 
 var (
@@ -31,7 +36,56 @@ func init() {
 	}
 }
 
+// hashPassword implements "chronoserve hash-password", which prints the
+// hash of a password for auth.users in config.yaml. The password is read
+// from the terminal without echo, or from the first line of stdin.
+func hashPassword(args []string) {
+	flags := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	scheme := flags.String("algorithm", utils.PasswordBcrypt, "Hashing algorithm, bcrypt or argon2id")
+	flags.Parse(args)
+
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		first, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		fmt.Fprint(os.Stderr, "Confirm password: ")
+		second, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		if string(first) != string(second) {
+			log.Fatal("Passwords do not match")
+		}
+		password = string(first)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		log.Fatal("Password must not be empty")
+	}
+
+	hash, err := utils.HashPassword(password, *scheme)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	fmt.Println(hash)
+}
+
 func main() {
+	if flag.Arg(0) == "hash-password" {
+		hashPassword(flag.Args()[1:])
+		return
+	}
+
 	// Initialize configuration
 	if err := utils.InitConfig(configFile); err != nil {
 		log.Fatalf("Failed to initialize configuration: %v", err)
@@ -56,9 +110,14 @@ func main() {
 
 	// Initialize auth middleware
 	middleware.InitAuth(middleware.AuthConfig{
-		SecretKey:     config.Auth.SecretKey,
-		TokenDuration: config.Auth.TokenDuration,
-		IssuedBy:      config.Auth.IssuedBy,
+		SecretKey:            config.Auth.SecretKey,
+		SigningKeys:          config.Auth.SigningKeys,
+		SigningKeyID:         config.Auth.SigningKeyID,
+		TokenDuration:        config.Auth.TokenDuration,
+		RefreshTokenDuration: config.Auth.RefreshTokenDuration,
+		IssuedBy:             config.Auth.IssuedBy,
+		SessionFile:          config.Auth.SessionFile,
+		APIKeyFile:           config.Auth.APIKeyFile,
 	})
 
 	// Setup routes
@@ -75,6 +134,25 @@ func main() {
 		WriteTimeout:   writeTimeout,
 		MaxHeaderBytes: config.Server.MaxHeaderBytes,
 	}
+	// Verify client certificates, e.g. of fleet agents, when a client CA is
+	// configured
+	if config.Server.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.Server.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to read client CA: %v", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in %s", config.Server.TLS.ClientCAFile)
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
+	// End live log streams on shutdown instead of waiting for clients to leave
+	srv.RegisterOnShutdown(api.ShutdownLogStreams)
 
 	// Graceful shutdown setup
 	done := make(chan bool)
@@ -91,6 +169,7 @@ func main() {
 		if err := srv.Shutdown(ctx); err != nil {
 			logger.Error("Could not gracefully shutdown the server: %v", err)
 		}
+		api.Shutdown()
 		close(done)
 	}()
 
@@ -98,7 +177,12 @@ func main() {
 	logger.Info("ChronoServe is online and awaiting requests")
 	logger.Info("Listening on %s:%d", config.Server.Host, config.Server.Port)
 
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if config.Server.TLS.CertFile != "" {
+		err = srv.ListenAndServeTLS(config.Server.TLS.CertFile, config.Server.TLS.KeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		logger.Error("Server failed to start: %v", err)
 		os.Exit(1)
 	}
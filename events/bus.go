@@ -0,0 +1,163 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/metrics"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// dropLogInterval is the least time between two warnings about events a
+// subscriber missed
+const dropLogInterval = 10 * time.Second
+
+// Type identifies what an Event reports
+type Type string
+
+const (
+	// ServiceStateChanged reports a transition not covered by a more
+	// specific type, e.g. activating -> active
+	ServiceStateChanged Type = "service.state_changed"
+	// ServiceFailed reports a service entering the failed state
+	ServiceFailed Type = "service.failed"
+	// ServiceRecovered reports a failed service becoming active again
+	ServiceRecovered Type = "service.recovered"
+	// ServiceAction reports an action, such as a stop, run against a
+	// service by a user or by ChronoServe itself
+	ServiceAction Type = "service.action"
+	// ServiceQuarantined reports the restart watchdog giving up on a
+	// service that keeps failing
+	ServiceQuarantined Type = "service.quarantined"
+)
+
+// State is the state of a service on one side of a transition
+type State struct {
+	ActiveState string `json:"activeState"`
+	SubState    string `json:"subState,omitempty"`
+}
+
+// Event is published on a Bus when something happens to a service
+type Event struct {
+	ID      string    `json:"id"`
+	Type    Type      `json:"type"`
+	Service string    `json:"service"`
+	Node    string    `json:"node,omitempty"` // fleet node, empty for this host
+	From    *State    `json:"from,omitempty"`
+	To      *State    `json:"to,omitempty"`
+	Action  string    `json:"action,omitempty"` // ServiceAction only, e.g. "stop"
+	Actor   string    `json:"actor,omitempty"`  // who ran the action
+	Message string    `json:"message,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// whose buffer is full misses the event. Missed events are counted in
+// chronoserve_events_dropped_total and logged.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[*subscription]struct{}
+	logger *utils.Logger
+}
+
+// subscription is a subscriber's channel and type filter
+type subscription struct {
+	name  string
+	ch    chan Event
+	types map[Type]bool // nil receives every type
+
+	// Events missed since the last warning, and when it was logged
+	dropMu     sync.Mutex
+	dropped    int
+	lastWarned time.Time
+}
+
+// NewBus creates an empty Bus that logs missed events to logger, which may
+// be nil
+func NewBus(logger *utils.Logger) *Bus {
+	return &Bus{subs: make(map[*subscription]struct{}), logger: logger}
+}
+
+// Subscribe returns a channel receiving events of the given types, or of
+// every type if none are given, and a function that ends the subscription
+// and closes the channel. name identifies the subscriber in metrics and
+// logs.
+//
+// Delivery is lossy: events published while the channel's buffer of the
+// given size is full are dropped for this subscriber. Subscribers that must
+// not miss events, such as those acting on failures, should size the buffer
+// for bursts and reconcile against the current state, as a missed
+// transition is not redelivered.
+func (b *Bus) Subscribe(name string, buffer int, types ...Type) (<-chan Event, func()) {
+	sub := &subscription{name: name, ch: make(chan Event, buffer)}
+	if len(types) > 0 {
+		sub.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+// Publish sends e to every interested subscriber, filling in its ID and
+// time if unset
+func (b *Bus) Publish(e Event) {
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if sub.types != nil && !sub.types[e.Type] {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.dropped(sub, e)
+		}
+	}
+}
+
+// dropped records that sub missed e, warning at most once per
+// dropLogInterval with the number of events missed since the last warning
+func (b *Bus) dropped(sub *subscription, e Event) {
+	metrics.EventsDropped.Inc(sub.name, string(e.Type))
+
+	sub.dropMu.Lock()
+	defer sub.dropMu.Unlock()
+	sub.dropped++
+	if b.logger == nil || time.Since(sub.lastWarned) < dropLogInterval {
+		return
+	}
+	b.logger.Warn("Event subscriber %s is not keeping up: dropped %d events, the latest %s for %s",
+		sub.name, sub.dropped, e.Type, e.Service)
+	sub.dropped = 0
+	sub.lastWarned = time.Now()
+}
+
+// newID returns a random event ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
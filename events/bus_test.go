@@ -0,0 +1,69 @@
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/therealtoxicdev/chronoserve/metrics"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+func TestSubscribeFiltersTypes(t *testing.T) {
+	bus := NewBus(nil)
+	ch, unsubscribe := bus.Subscribe("test-filter", 4, ServiceFailed)
+
+	bus.Publish(Event{Type: ServiceStateChanged, Service: "app"})
+	bus.Publish(Event{Type: ServiceFailed, Service: "app"})
+
+	e := <-ch
+	if e.Type != ServiceFailed || e.ID == "" || e.At.IsZero() {
+		t.Errorf("event = %+v", e)
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+}
+
+func TestPublishCountsAndLogsDrops(t *testing.T) {
+	dir := t.TempDir()
+	logger, err := utils.NewLogger(utils.LoggerOptions{Level: utils.WARN, Directory: dir, Filename: "events.log", MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	bus := NewBus(logger)
+	ch, unsubscribe := bus.Subscribe("test-slow", 1)
+	defer unsubscribe()
+
+	before := metrics.EventsDropped.Value("test-slow", string(ServiceFailed))
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Type: ServiceFailed, Service: "app"})
+	}
+	if len(ch) != 1 {
+		t.Errorf("buffered events = %d, want 1", len(ch))
+	}
+	if got := metrics.EventsDropped.Value("test-slow", string(ServiceFailed)) - before; got != 4 {
+		t.Errorf("dropped events counted = %v, want 4", got)
+	}
+
+	logger.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "events.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first drop is logged; the rest fall inside dropLogInterval
+	if n := strings.Count(string(data), "test-slow is not keeping up"); n != 1 {
+		t.Errorf("logged %d warnings, want 1:\n%s", n, data)
+	}
+}
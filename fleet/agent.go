@@ -0,0 +1,350 @@
+package fleet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+const (
+	// Reconnection backoff after the connection to the controller fails
+	initialReconnectDelay = time.Second
+	maxReconnectDelay     = time.Minute
+)
+
+// Agent connects to a controller and performs the service operations it
+// requests against the local ServiceManager
+type Agent struct {
+	url       string
+	node      string
+	header    http.Header
+	dialer    *websocket.Dialer
+	manager   services.ServiceManager
+	authorize Authorizer
+	logger    *utils.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewAgent creates an agent from cfg serving manager, checking every request
+// with authorize, or allowing all of them if it is nil. It does not connect
+// until Start is called.
+func NewAgent(cfg utils.AgentConfig, manager services.ServiceManager, authorize Authorizer, logger *utils.Logger) (*Agent, error) {
+	u, err := url.Parse(cfg.Controller)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid controller URL %q, expected ws:// or wss://", cfg.Controller)
+	}
+	if cfg.Node == "" {
+		return nil, fmt.Errorf("agent node name is required")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read controller CA: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load agent certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if authorize == nil {
+		authorize = func(string, string, []string, bool) error { return nil }
+	}
+
+	header := http.Header{}
+	header.Set(NodeHeader, cfg.Node)
+	if cfg.Token != "" {
+		header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Agent{
+		url:    u.String(),
+		node:   cfg.Node,
+		header: header,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 30 * time.Second,
+			TLSClientConfig:  tlsConfig,
+		},
+		manager:   manager,
+		authorize: authorize,
+		logger:    logger,
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// Start connects to the controller in the background, reconnecting with
+// backoff whenever the connection is lost
+func (a *Agent) Start() {
+	a.wg.Add(1)
+	go a.run()
+}
+
+// Stop closes the connection and waits for operations in progress to end
+func (a *Agent) Stop() {
+	a.cancel()
+	a.wg.Wait()
+}
+
+func (a *Agent) run() {
+	defer a.wg.Done()
+	delay := initialReconnectDelay
+	for {
+		conn, resp, err := a.dialer.DialContext(a.ctx, a.url, a.header)
+		if err != nil {
+			if a.ctx.Err() != nil {
+				return
+			}
+			if resp != nil {
+				err = fmt.Errorf("%w (HTTP %d)", err, resp.StatusCode)
+			}
+			a.logger.Warn("Failed to connect to controller %s as %s: %v; retrying in %s", a.url, a.node, err, delay)
+		} else {
+			a.logger.Info("Connected to controller %s as %s", a.url, a.node)
+			connected := time.Now()
+			err = a.serve(conn)
+			if a.ctx.Err() != nil {
+				return
+			}
+			a.logger.Warn("Connection to controller lost: %v", err)
+			if time.Since(connected) > maxReconnectDelay {
+				delay = initialReconnectDelay
+			}
+		}
+
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// session is one connection to the controller
+type session struct {
+	agent   *Agent
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	follows map[uint64]context.CancelFunc
+}
+
+// serve handles requests on conn until it fails or the agent stops
+func (a *Agent) serve(conn *websocket.Conn) error {
+	ctx, cancel := context.WithCancel(a.ctx)
+	s := &session{agent: a, conn: conn, follows: make(map[uint64]context.CancelFunc)}
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		conn.Close()
+		wg.Wait()
+	}()
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	// Ping the controller so a dead connection is noticed from this side too
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.writeMu.Lock()
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "agent stopping"),
+					time.Now().Add(time.Second))
+				s.writeMu.Unlock()
+				conn.Close()
+				return
+			case <-ticker.C:
+				s.writeMu.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+				s.writeMu.Unlock()
+				if err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var req Request
+		if err := conn.ReadJSON(&req); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		if req.Op == OpCancel {
+			s.mu.Lock()
+			if stop, ok := s.follows[req.ID]; ok {
+				stop()
+			}
+			s.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, req)
+		}()
+	}
+}
+
+// send writes resp, reporting whether the connection is still usable
+func (s *session) send(resp Response) bool {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := s.conn.WriteJSON(resp); err != nil {
+		s.conn.Close()
+		return false
+	}
+	return true
+}
+
+// handle performs req and sends its response
+func (s *session) handle(ctx context.Context, req Request) {
+	if req.Op == OpFollow {
+		s.follow(ctx, req)
+		return
+	}
+
+	result, err := s.agent.execute(ctx, req)
+	resp := Response{ID: req.ID}
+	if err != nil {
+		resp.Error = encodeError(err)
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = encodeError(err)
+	}
+	s.send(resp)
+}
+
+// follow streams log entries until the controller cancels, the entries end
+// or the connection closes
+func (s *session) follow(ctx context.Context, req Request) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.follows[req.ID] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.follows, req.ID)
+		s.mu.Unlock()
+	}()
+
+	if err := s.agent.authorize(req.Op, req.Name, req.Roles, false); err != nil {
+		s.send(Response{ID: req.ID, Error: encodeError(err), Done: true})
+		return
+	}
+	follower, ok := s.agent.manager.(services.LogFollower)
+	if !ok {
+		s.send(Response{ID: req.ID, Error: encodeError(services.ErrUnsupported), Done: true})
+		return
+	}
+	var query services.LogQuery
+	if req.Query != nil {
+		query = *req.Query
+	}
+	entries, err := follower.FollowLogs(ctx, req.Name, query)
+	if err != nil {
+		s.send(Response{ID: req.ID, Error: encodeError(err), Done: true})
+		return
+	}
+	if !s.send(Response{ID: req.ID}) {
+		return
+	}
+	for entry := range entries {
+		if !s.send(Response{ID: req.ID, Entry: &entry}) {
+			return
+		}
+	}
+	s.send(Response{ID: req.ID, Done: true})
+}
+
+// execute runs a single request against the local ServiceManager
+func (a *Agent) execute(ctx context.Context, req Request) (any, error) {
+	if req.Op != OpList {
+		if err := a.authorize(req.Op, req.Name, req.Roles, !readOps[req.Op]); err != nil {
+			return nil, err
+		}
+	}
+
+	m := a.manager
+	switch req.Op {
+	case OpList:
+		list, err := m.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		visible := make([]services.ServiceInfo, 0, len(list))
+		for _, info := range list {
+			if a.authorize(OpList, info.Name, req.Roles, false) == nil {
+				visible = append(visible, info)
+			}
+		}
+		return visible, nil
+	case OpStatus:
+		return m.Status(ctx, req.Name)
+	case OpLogs:
+		var query services.LogQuery
+		if req.Query != nil {
+			query = *req.Query
+		}
+		return m.Logs(ctx, req.Name, query)
+	case OpStart:
+		return m.Start(ctx, req.Name)
+	case OpStop:
+		return m.Stop(ctx, req.Name)
+	case OpRestart:
+		return m.Restart(ctx, req.Name)
+	case OpReload:
+		return m.Reload(ctx, req.Name)
+	case OpReloadOrRestart:
+		return m.ReloadOrRestart(ctx, req.Name)
+	case OpEnable:
+		return m.Enable(ctx, req.Name, req.Now)
+	case OpDisable:
+		return m.Disable(ctx, req.Name, req.Now)
+	case OpMask:
+		return m.Mask(ctx, req.Name, req.Now)
+	case OpUnmask:
+		return m.Unmask(ctx, req.Name)
+	default:
+		return nil, fmt.Errorf("unknown operation %q: %w", req.Op, services.ErrUnsupported)
+	}
+}
@@ -0,0 +1,282 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// fakeManager serves app and secret, recording the services started. Its
+// follows send entries, then wait for the follower to go away.
+type fakeManager struct {
+	services.ServiceManager
+	entries []services.LogEntry
+
+	mu       sync.Mutex
+	started  []string
+	query    services.LogQuery
+	followed chan struct{} // closed when a follow's context ends
+}
+
+func (m *fakeManager) List(ctx context.Context) ([]services.ServiceInfo, error) {
+	return []services.ServiceInfo{{Name: "app", ActiveState: "active"}, {Name: "secret", ActiveState: "active"}}, nil
+}
+
+func (m *fakeManager) Status(ctx context.Context, name string) (*services.ServiceStatus, error) {
+	return &services.ServiceStatus{Name: name, Status: "active", IsActive: true}, nil
+}
+
+func (m *fakeManager) Start(ctx context.Context, name string) (*services.ActionResult, error) {
+	m.mu.Lock()
+	m.started = append(m.started, name)
+	m.mu.Unlock()
+	return &services.ActionResult{Name: name, Action: "start", Message: "Service " + name + " started"}, nil
+}
+
+func (m *fakeManager) FollowLogs(ctx context.Context, name string, query services.LogQuery) (<-chan services.LogEntry, error) {
+	m.mu.Lock()
+	m.query = query
+	m.mu.Unlock()
+	ch := make(chan services.LogEntry, len(m.entries))
+	for _, entry := range m.entries {
+		ch <- entry
+	}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+		close(m.followed)
+	}()
+	return ch, nil
+}
+
+// authorization is one call of a recordingAuthorizer
+type authorization struct {
+	op, name string
+	roles    []string
+	mutate   bool
+}
+
+// recordingAuthorizer records the roles the agent is asked to check.
+// Callers without roles are denied everything, viewers may only view, and
+// nobody may see the secret service.
+type recordingAuthorizer struct {
+	mu    sync.Mutex
+	calls []authorization
+}
+
+func (a *recordingAuthorizer) authorize(op, name string, roles []string, mutate bool) error {
+	a.mu.Lock()
+	a.calls = append(a.calls, authorization{op, name, roles, mutate})
+	a.mu.Unlock()
+	if len(roles) == 0 || name == "secret" || (mutate && !slices.Contains(roles, "operator")) {
+		return &services.ServiceError{Op: op, Name: name, Err: services.ErrPermissionDenied,
+			Detail: "Not allowed to " + op + " service " + name}
+	}
+	return nil
+}
+
+// last returns the most recent call
+func (a *recordingAuthorizer) last() authorization {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.calls) == 0 {
+		return authorization{}
+	}
+	return a.calls[len(a.calls)-1]
+}
+
+// startTestAgent connects an agent for node web1 serving manager to a new
+// controller, checking requests with authorizer
+func startTestAgent(t *testing.T, manager *fakeManager, authorizer *recordingAuthorizer) *Controller {
+	t.Helper()
+	c, server := newTestController(t, map[string]utils.AgentCredentials{"web1": {Token: "web1-secret"}})
+	agent, err := NewAgent(utils.AgentConfig{Controller: wsURL(server), Node: "web1", Token: "web1-secret"},
+		manager, authorizer.authorize, newTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.Start()
+	t.Cleanup(agent.Stop)
+	waitConnected(t, c, "web1")
+	return c
+}
+
+func TestAgentChecksForwardedRoles(t *testing.T) {
+	tests := []struct {
+		name        string
+		roles       []string
+		call        func(ctx context.Context, m *RemoteManager) error
+		wantAuth    authorization
+		wantErr     error
+		wantStarted bool
+	}{
+		{
+			name:  "viewer views",
+			roles: []string{"viewer"},
+			call: func(ctx context.Context, m *RemoteManager) error {
+				_, err := m.Status(ctx, "app")
+				return err
+			},
+			wantAuth: authorization{op: OpStatus, name: "app", roles: []string{"viewer"}},
+		},
+		{
+			name:  "viewer starts",
+			roles: []string{"viewer"},
+			call: func(ctx context.Context, m *RemoteManager) error {
+				_, err := m.Start(ctx, "app")
+				return err
+			},
+			wantAuth: authorization{op: OpStart, name: "app", roles: []string{"viewer"}, mutate: true},
+			wantErr:  services.ErrPermissionDenied,
+		},
+		{
+			name:  "operator starts",
+			roles: []string{"viewer", "operator"},
+			call: func(ctx context.Context, m *RemoteManager) error {
+				_, err := m.Start(ctx, "app")
+				return err
+			},
+			wantAuth:    authorization{op: OpStart, name: "app", roles: []string{"viewer", "operator"}, mutate: true},
+			wantStarted: true,
+		},
+		{
+			name:  "no roles",
+			roles: nil,
+			call: func(ctx context.Context, m *RemoteManager) error {
+				_, err := m.Status(ctx, "app")
+				return err
+			},
+			wantAuth: authorization{op: OpStatus, name: "app"},
+			wantErr:  services.ErrPermissionDenied,
+		},
+		{
+			name:  "follow without roles",
+			roles: nil,
+			call: func(ctx context.Context, m *RemoteManager) error {
+				_, err := m.FollowLogs(ctx, "app", services.LogQuery{})
+				return err
+			},
+			wantAuth: authorization{op: OpFollow, name: "app"},
+			wantErr:  services.ErrPermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := &fakeManager{followed: make(chan struct{})}
+			authorizer := &recordingAuthorizer{}
+			c := startTestAgent(t, manager, authorizer)
+			m, err := c.Node("web1", tt.roles)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.call(context.Background(), m)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got := authorizer.last(); got.op != tt.wantAuth.op || got.name != tt.wantAuth.name ||
+				!slices.Equal(got.roles, tt.wantAuth.roles) || got.mutate != tt.wantAuth.mutate {
+				t.Errorf("agent authorized %+v, want %+v", got, tt.wantAuth)
+			}
+			// A denial arrives as the agent's ServiceError
+			var svcErr *services.ServiceError
+			if tt.wantErr != nil && (!errors.As(err, &svcErr) || svcErr.Op != tt.wantAuth.op || svcErr.Name != "app" || svcErr.Detail == "") {
+				t.Errorf("error = %#v, want the agent's ServiceError", err)
+			}
+			manager.mu.Lock()
+			started := len(manager.started) > 0
+			manager.mu.Unlock()
+			if started != tt.wantStarted {
+				t.Errorf("service started = %v, want %v", started, tt.wantStarted)
+			}
+		})
+	}
+}
+
+func TestAgentFiltersList(t *testing.T) {
+	authorizer := &recordingAuthorizer{}
+	c := startTestAgent(t, &fakeManager{}, authorizer)
+	m, err := c.Node("web1", []string{"viewer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := m.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "app" {
+		t.Errorf("List() = %+v, want only app", list)
+	}
+	authorizer.mu.Lock()
+	defer authorizer.mu.Unlock()
+	for _, call := range authorizer.calls {
+		if call.op != OpList || !slices.Equal(call.roles, []string{"viewer"}) {
+			t.Errorf("agent authorized %+v, want list as viewer", call)
+		}
+	}
+}
+
+func TestFollowLogsCancel(t *testing.T) {
+	entries := []services.LogEntry{{Cursor: "c1", Message: "first"}, {Cursor: "c2", Message: "second"}}
+	manager := &fakeManager{entries: entries, followed: make(chan struct{})}
+	authorizer := &recordingAuthorizer{}
+	c := startTestAgent(t, manager, authorizer)
+	m, err := c.Node("web1", []string{"viewer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := m.FollowLogs(ctx, "app", services.LogQuery{Lines: 5, Grep: "first|second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range entries {
+		select {
+		case got := <-ch:
+			if got.Cursor != want.Cursor || got.Message != want.Message {
+				t.Errorf("entry = %+v, want %+v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry %s not received", want.Cursor)
+		}
+	}
+	manager.mu.Lock()
+	query := manager.query
+	manager.mu.Unlock()
+	if query.Lines != 5 || query.Grep != "first|second" {
+		t.Errorf("agent followed with query %+v", query)
+	}
+	if got := authorizer.last(); got.op != OpFollow || got.mutate {
+		t.Errorf("agent authorized %+v, want a read-only follow", got)
+	}
+
+	// Cancelling ends the follow on the agent and closes the stream
+	cancel()
+	select {
+	case <-manager.followed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent kept following after the controller cancelled")
+	}
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("entry received after cancelling")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed after cancelling")
+	}
+
+	// The connection still serves requests
+	if _, err := m.Status(context.Background(), "app"); err != nil {
+		t.Errorf("Status() after cancelling a follow error = %v", err)
+	}
+}
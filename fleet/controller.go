@@ -0,0 +1,506 @@
+package fleet
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+var (
+	// ErrUnknownNode means no agent with that name is configured
+	ErrUnknownNode = errors.New("unknown node")
+	// ErrNodeOffline means the node's agent is not connected
+	ErrNodeOffline = errors.New("node is offline")
+)
+
+// followBuffer is the number of log entries buffered per followed stream.
+// Entries arriving while the buffer is full are dropped rather than
+// stalling every other call to the same agent.
+const followBuffer = 256
+
+// NodeInfo describes a configured node and its agent's connection
+type NodeInfo struct {
+	Name        string     `json:"name"`
+	Connected   bool       `json:"connected"`
+	RemoteAddr  string     `json:"remoteAddr,omitempty"`
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	LastSeen    *time.Time `json:"lastSeen,omitempty"`
+}
+
+// Controller accepts agent connections and forwards service operations to
+// them
+type Controller struct {
+	agents   map[string]utils.AgentCredentials
+	timeout  time.Duration
+	logger   *utils.Logger
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex
+	conns  map[string]*agentConn
+	closed bool
+}
+
+// NewController creates a controller accepting the agents in cfg
+func NewController(cfg utils.ControllerConfig, logger *utils.Logger) (*Controller, error) {
+	timeout, err := time.ParseDuration(cfg.RequestTimeout)
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("invalid fleet controller request timeout %q", cfg.RequestTimeout)
+	}
+	for node, creds := range cfg.Agents {
+		if creds.Token == "" && creds.CommonName == "" {
+			return nil, fmt.Errorf("fleet agent %q needs a token or a certificate common name", node)
+		}
+	}
+	return &Controller{
+		agents:  cfg.Agents,
+		timeout: timeout,
+		logger:  logger,
+		// Agents are not browsers, so there is no origin to check
+		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		conns:    make(map[string]*agentConn),
+	}, nil
+}
+
+// HandleConnect authenticates an agent and serves its connection until it
+// closes. A node that connects again replaces its previous connection.
+func (c *Controller) HandleConnect(w http.ResponseWriter, r *http.Request) {
+	node := r.Header.Get(NodeHeader)
+	creds, ok := c.agents[node]
+	if !ok || !authenticate(r, creds) {
+		c.logger.Warn("Rejected agent connection from %s for node %q", r.RemoteAddr, node)
+		utils.WriteErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the agent
+		c.logger.Warn("Failed to upgrade agent connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	a := &agentConn{
+		node:        node,
+		conn:        conn,
+		remoteAddr:  r.RemoteAddr,
+		connectedAt: time.Now(),
+		pending:     make(map[uint64]*pendingCall),
+		done:        make(chan struct{}),
+		logger:      c.logger,
+	}
+	a.lastSeen.Store(a.connectedAt.UnixNano())
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return
+	}
+	previous := c.conns[node]
+	c.conns[node] = a
+	c.mu.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	c.logger.Info("Agent %s connected from %s", node, r.RemoteAddr)
+
+	err = a.serve()
+
+	c.mu.Lock()
+	if c.conns[node] == a {
+		delete(c.conns, node)
+	}
+	c.mu.Unlock()
+	c.logger.Info("Agent %s disconnected: %v", node, err)
+}
+
+// authenticate checks the agent's bearer token or its verified client
+// certificate against creds
+func authenticate(r *http.Request, creds utils.AgentCredentials) bool {
+	if creds.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(creds.Token)) == 1 {
+			return true
+		}
+	}
+	if creds.CommonName != "" && r.TLS != nil {
+		for _, chain := range r.TLS.VerifiedChains {
+			if len(chain) > 0 && chain[0].Subject.CommonName == creds.CommonName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Nodes lists the configured nodes, sorted by name
+func (c *Controller) Nodes() []NodeInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nodes := make([]NodeInfo, 0, len(c.agents))
+	for name := range c.agents {
+		info := NodeInfo{Name: name}
+		if a, ok := c.conns[name]; ok {
+			connectedAt := a.connectedAt
+			lastSeen := time.Unix(0, a.lastSeen.Load())
+			info.Connected = true
+			info.RemoteAddr = a.remoteAddr
+			info.ConnectedAt = &connectedAt
+			info.LastSeen = &lastSeen
+		}
+		nodes = append(nodes, info)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// Node returns a ServiceManager for the named node's services, acting for a
+// caller holding roles. It fails with ErrUnknownNode or ErrNodeOffline.
+func (c *Controller) Node(name string, roles []string) (*RemoteManager, error) {
+	if _, ok := c.agents[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownNode, name)
+	}
+	c.mu.Lock()
+	a, ok := c.conns[name]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNodeOffline, name)
+	}
+	return &RemoteManager{agent: a, roles: roles, timeout: c.timeout}, nil
+}
+
+// Close disconnects every agent and rejects new connections
+func (c *Controller) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conns := make([]*agentConn, 0, len(c.conns))
+	for _, a := range c.conns {
+		conns = append(conns, a)
+	}
+	c.mu.Unlock()
+	for _, a := range conns {
+		a.close()
+	}
+	return nil
+}
+
+// pendingCall receives the responses to one request
+type pendingCall struct {
+	ch      chan Response
+	stream  bool
+	dropped bool
+}
+
+// agentConn is the connection of one agent
+type agentConn struct {
+	node        string
+	conn        *websocket.Conn
+	remoteAddr  string
+	connectedAt time.Time
+	lastSeen    atomic.Int64
+	logger      *utils.Logger
+	writeMu     sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]*pendingCall
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// close closes the connection, failing the calls waiting on it
+func (a *agentConn) close() {
+	a.closeOnce.Do(func() {
+		close(a.done)
+		a.conn.Close()
+	})
+}
+
+// serve reads responses and dispatches them to the waiting calls until the
+// connection fails
+func (a *agentConn) serve() error {
+	defer a.close()
+
+	a.conn.SetReadLimit(maxMessageSize)
+	a.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	a.conn.SetPongHandler(func(string) error {
+		a.lastSeen.Store(time.Now().UnixNano())
+		return a.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.done:
+				return
+			case <-ticker.C:
+				if err := a.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+					a.close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var resp Response
+		if err := a.conn.ReadJSON(&resp); err != nil {
+			return err
+		}
+		a.lastSeen.Store(time.Now().UnixNano())
+		a.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		a.dispatch(resp)
+	}
+}
+
+// dispatch hands resp to the call waiting for it. Responses to calls that
+// have already given up are discarded.
+func (a *agentConn) dispatch(resp Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	call, ok := a.pending[resp.ID]
+	if !ok {
+		return
+	}
+	if !call.stream || resp.Done {
+		delete(a.pending, resp.ID)
+	}
+	select {
+	case call.ch <- resp:
+	default:
+		if resp.Done {
+			// The final response must arrive even when the buffer is full
+			go func() {
+				select {
+				case call.ch <- resp:
+				case <-a.done:
+				}
+			}()
+			return
+		}
+		if !call.dropped {
+			call.dropped = true
+			a.logger.Warn("Dropping log entries streamed by agent %s: reader is too slow", a.node)
+		}
+	}
+}
+
+// send registers a call and writes its request
+func (a *agentConn) send(req Request, stream bool) (uint64, *pendingCall, error) {
+	call := &pendingCall{ch: make(chan Response, 1), stream: stream}
+	if stream {
+		call.ch = make(chan Response, followBuffer)
+	}
+
+	a.mu.Lock()
+	a.nextID++
+	req.ID = a.nextID
+	a.pending[req.ID] = call
+	a.mu.Unlock()
+
+	if err := a.write(req); err != nil {
+		a.forget(req.ID)
+		return 0, nil, err
+	}
+	return req.ID, call, nil
+}
+
+// write writes a request to the agent
+func (a *agentConn) write(req Request) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	select {
+	case <-a.done:
+		return fmt.Errorf("%w: %s", ErrNodeOffline, a.node)
+	default:
+	}
+	a.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := a.conn.WriteJSON(req); err != nil {
+		a.close()
+		return fmt.Errorf("%w: %s: %v", ErrNodeOffline, a.node, err)
+	}
+	return nil
+}
+
+// forget stops waiting for the responses to a call
+func (a *agentConn) forget(id uint64) {
+	a.mu.Lock()
+	delete(a.pending, id)
+	a.mu.Unlock()
+}
+
+// wait waits for the next response to a call
+func (a *agentConn) wait(ctx context.Context, id uint64, call *pendingCall) (Response, error) {
+	select {
+	case resp := <-call.ch:
+		if resp.Error != nil {
+			return resp, resp.Error.decode()
+		}
+		return resp, nil
+	case <-ctx.Done():
+		a.forget(id)
+		return Response{}, fmt.Errorf("agent %s did not respond: %w", a.node, services.ErrTimeout)
+	case <-a.done:
+		return Response{}, fmt.Errorf("%w: %s: connection lost", ErrNodeOffline, a.node)
+	}
+}
+
+// Ensure RemoteManager implements ServiceManager and LogFollower
+var (
+	_ services.ServiceManager = (*RemoteManager)(nil)
+	_ services.LogFollower    = (*RemoteManager)(nil)
+)
+
+// RemoteManager performs service operations on a node through its agent
+type RemoteManager struct {
+	agent   *agentConn
+	roles   []string
+	timeout time.Duration
+}
+
+// call performs a request and decodes its result into a T
+func call[T any](ctx context.Context, m *RemoteManager, req Request) (T, error) {
+	var result T
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	req.Roles = m.roles
+	id, pending, err := m.agent.send(req, false)
+	if err != nil {
+		return result, err
+	}
+	resp, err := m.agent.wait(ctx, id, pending)
+	if err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return result, fmt.Errorf("invalid response from agent %s: %w", m.agent.node, err)
+	}
+	return result, nil
+}
+
+// List lists the node's services
+func (m *RemoteManager) List(ctx context.Context) ([]services.ServiceInfo, error) {
+	return call[[]services.ServiceInfo](ctx, m, Request{Op: OpList})
+}
+
+// Status returns the status of a service on the node
+func (m *RemoteManager) Status(ctx context.Context, name string) (*services.ServiceStatus, error) {
+	return call[*services.ServiceStatus](ctx, m, Request{Op: OpStatus, Name: name})
+}
+
+// Logs returns log entries of a service on the node
+func (m *RemoteManager) Logs(ctx context.Context, name string, query services.LogQuery) ([]services.LogEntry, error) {
+	return call[[]services.LogEntry](ctx, m, Request{Op: OpLogs, Name: name, Query: &query})
+}
+
+// Start starts a service on the node
+func (m *RemoteManager) Start(ctx context.Context, name string) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpStart, Name: name})
+}
+
+// Stop stops a service on the node
+func (m *RemoteManager) Stop(ctx context.Context, name string) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpStop, Name: name})
+}
+
+// Restart restarts a service on the node
+func (m *RemoteManager) Restart(ctx context.Context, name string) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpRestart, Name: name})
+}
+
+// Reload reloads a service on the node
+func (m *RemoteManager) Reload(ctx context.Context, name string) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpReload, Name: name})
+}
+
+// ReloadOrRestart reloads a service on the node, restarting it if it
+// cannot be reloaded
+func (m *RemoteManager) ReloadOrRestart(ctx context.Context, name string) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpReloadOrRestart, Name: name})
+}
+
+// Enable enables a service on the node
+func (m *RemoteManager) Enable(ctx context.Context, name string, now bool) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpEnable, Name: name, Now: now})
+}
+
+// Disable disables a service on the node
+func (m *RemoteManager) Disable(ctx context.Context, name string, now bool) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpDisable, Name: name, Now: now})
+}
+
+// Mask masks a service on the node
+func (m *RemoteManager) Mask(ctx context.Context, name string, now bool) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpMask, Name: name, Now: now})
+}
+
+// Unmask unmasks a service on the node
+func (m *RemoteManager) Unmask(ctx context.Context, name string) (*services.ActionResult, error) {
+	return call[*services.ActionResult](ctx, m, Request{Op: OpUnmask, Name: name})
+}
+
+// FollowLogs streams log entries of a service on the node until ctx is
+// cancelled or the agent disconnects
+func (m *RemoteManager) FollowLogs(ctx context.Context, name string, query services.LogQuery) (<-chan services.LogEntry, error) {
+	a := m.agent
+	id, pending, err := a.send(Request{Op: OpFollow, Name: name, Query: &query, Roles: m.roles}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The agent acknowledges the follow once its backend has accepted it
+	ackCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	resp, err := a.wait(ackCtx, id, pending)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Done {
+		entries := make(chan services.LogEntry)
+		close(entries)
+		return entries, nil
+	}
+
+	entries := make(chan services.LogEntry)
+	go func() {
+		defer close(entries)
+		for {
+			select {
+			case <-ctx.Done():
+				a.forget(id)
+				a.write(Request{ID: id, Op: OpCancel})
+				return
+			case <-a.done:
+				return
+			case resp := <-pending.ch:
+				if resp.Entry != nil {
+					select {
+					case entries <- *resp.Entry:
+					case <-ctx.Done():
+						continue
+					}
+				}
+				if resp.Done {
+					return
+				}
+			}
+		}
+	}()
+	return entries, nil
+}
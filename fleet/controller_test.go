@@ -0,0 +1,283 @@
+package fleet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+func newTestLogger(t *testing.T) *utils.Logger {
+	t.Helper()
+	logger, err := utils.NewLogger(utils.LoggerOptions{Level: utils.ERROR, Directory: t.TempDir(), Filename: "test.log", MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+	return logger
+}
+
+// newTestController creates a controller accepting agents and serves its
+// connect endpoint over plain HTTP
+func newTestController(t *testing.T, agents map[string]utils.AgentCredentials) (*Controller, *httptest.Server) {
+	t.Helper()
+	c, err := NewController(utils.ControllerConfig{RequestTimeout: "5s", Agents: agents}, newTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(c.HandleConnect))
+	t.Cleanup(func() {
+		c.Close()
+		server.Close()
+	})
+	return c, server
+}
+
+// wsURL returns the WebSocket URL of server
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// waitConnected waits until the agent of node is connected to c
+func waitConnected(t *testing.T, c *Controller, node string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, info := range c.Nodes() {
+			if info.Name == node && info.Connected {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("agent %s did not connect", node)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleConnectToken(t *testing.T) {
+	_, server := newTestController(t, map[string]utils.AgentCredentials{
+		"web1": {Token: "web1-secret"},
+		"web2": {Token: "web2-secret"},
+		"db1":  {CommonName: "db1.agents"},
+	})
+
+	tests := []struct {
+		name       string
+		node       string
+		token      string
+		wantStatus int
+	}{
+		{"valid token", "web1", "web1-secret", http.StatusSwitchingProtocols},
+		{"wrong token", "web1", "guess", http.StatusUnauthorized},
+		{"no token", "web1", "", http.StatusUnauthorized},
+		{"token of another node", "web1", "web2-secret", http.StatusUnauthorized},
+		{"unknown node", "web3", "web1-secret", http.StatusUnauthorized},
+		{"no node", "", "web1-secret", http.StatusUnauthorized},
+		// An empty token must not match a node without one
+		{"certificate node without TLS", "db1", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{NodeHeader: {tt.node}}
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL(server), header)
+			if resp == nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// writePEM writes a PEM block of type kind holding der to a file in dir and
+// returns its path
+func writePEM(t *testing.T, dir, name, kind string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestCertificate creates a certificate for commonName signed by parent,
+// or self-signed when parent is nil
+func newTestCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestHandleConnectClientCertificate(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "Test Agent CA", nil, nil)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	c, err := NewController(utils.ControllerConfig{RequestTimeout: "5s", Agents: map[string]utils.AgentCredentials{
+		"web1": {CommonName: "web1.agents"},
+	}}, newTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(c.HandleConnect))
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(func() {
+		c.Close()
+		server.Close()
+	})
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	dir := t.TempDir()
+	caFile := writePEM(t, dir, "controller-ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	// clientCertificate returns a certificate for commonName issued by the
+	// agent CA
+	clientCertificate := func(commonName string) tls.Certificate {
+		cert, key := newTestCertificate(t, commonName, ca, caKey)
+		return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+	}
+	otherCA, otherKey := newTestCertificate(t, "Another CA", nil, nil)
+	untrusted, untrustedKey := newTestCertificate(t, "web1.agents", otherCA, otherKey)
+
+	tests := []struct {
+		name         string
+		certificates []tls.Certificate
+		wantStatus   int
+	}{
+		{"matching common name", []tls.Certificate{clientCertificate("web1.agents")}, http.StatusSwitchingProtocols},
+		{"other common name", []tls.Certificate{clientCertificate("web2.agents")}, http.StatusUnauthorized},
+		{"no certificate", nil, http.StatusUnauthorized},
+		{"untrusted issuer", []tls.Certificate{{Certificate: [][]byte{untrusted.Raw}, PrivateKey: untrustedKey}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: tt.certificates}}
+			conn, resp, err := dialer.Dial(url, http.Header{NodeHeader: {"web1"}})
+			if conn != nil {
+				conn.Close()
+			}
+			if tt.wantStatus == 0 {
+				// Either the handshake fails or the certificate is not
+				// offered, depending on the TLS client
+				if err == nil || (resp != nil && resp.StatusCode != http.StatusUnauthorized) {
+					t.Errorf("Dial() = %v, %v, want the connection refused", resp, err)
+				}
+				return
+			}
+			if resp == nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("agent", func(t *testing.T) {
+		cert, key := newTestCertificate(t, "web1.agents", ca, caKey)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		agent, err := NewAgent(utils.AgentConfig{
+			Controller: url,
+			Node:       "web1",
+			CAFile:     caFile,
+			CertFile:   writePEM(t, dir, "agent.pem", "CERTIFICATE", cert.Raw),
+			KeyFile:    writePEM(t, dir, "agent-key.pem", "EC PRIVATE KEY", keyDER),
+		}, &fakeManager{}, nil, newTestLogger(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		agent.Start()
+		defer agent.Stop()
+		waitConnected(t, c, "web1")
+	})
+}
+
+func TestNodeErrors(t *testing.T) {
+	c, _ := newTestController(t, map[string]utils.AgentCredentials{"web1": {Token: "web1-secret"}})
+
+	if _, err := c.Node("web2", nil); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("Node() of an unconfigured node error = %v, want %v", err, ErrUnknownNode)
+	}
+	if _, err := c.Node("web1", nil); !errors.Is(err, ErrNodeOffline) {
+		t.Errorf("Node() of a disconnected node error = %v, want %v", err, ErrNodeOffline)
+	}
+}
+
+func TestErrorRoundTrip(t *testing.T) {
+	tests := []error{
+		&services.ServiceError{Op: "start", Name: "app", Err: services.ErrPermissionDenied, Detail: "Not allowed to start service app"},
+		&services.ServiceError{Op: "status", Name: "ghost", Err: services.ErrNotFound},
+		services.ErrUnsupported,
+	}
+	for _, err := range tests {
+		decoded := encodeError(err).decode()
+		var sentinel error
+		for _, s := range errorKinds {
+			if errors.Is(err, s) {
+				sentinel = s
+			}
+		}
+		if !errors.Is(decoded, sentinel) {
+			t.Errorf("decoded %v = %v, want it to wrap %v", err, decoded, sentinel)
+		}
+		var want, got *services.ServiceError
+		if errors.As(err, &want) && (!errors.As(decoded, &got) || *got != *want) {
+			t.Errorf("decoded %v = %#v", err, decoded)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package fleet implements controller/agent mode. Agents keep a WebSocket
+// connection open to their controller, which sends them service operations
+// as JSON requests and receives the results over the same connection, so
+// the controller never needs inbound access to an agent.
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/services"
+)
+
+// Headers an agent identifies itself with when connecting
+const (
+	NodeHeader = "X-ChronoServe-Node"
+)
+
+// Operations an agent performs for the controller. Every ServiceManager
+// method has one, plus follow (LogFollower.FollowLogs) and cancel, which
+// ends a follow.
+const (
+	OpList            = "list"
+	OpStatus          = "status"
+	OpLogs            = "logs"
+	OpFollow          = "follow"
+	OpCancel          = "cancel"
+	OpStart           = "start"
+	OpStop            = "stop"
+	OpRestart         = "restart"
+	OpReload          = "reload"
+	OpReloadOrRestart = "reload-or-restart"
+	OpEnable          = "enable"
+	OpDisable         = "disable"
+	OpMask            = "mask"
+	OpUnmask          = "unmask"
+)
+
+const (
+	// pingInterval is how often each side pings the other
+	pingInterval = 30 * time.Second
+	// readTimeout closes a connection on which nothing, not even a ping or
+	// pong, has arrived for this long
+	readTimeout = 3 * pingInterval
+	// writeTimeout bounds a single message write
+	writeTimeout = 10 * time.Second
+	// maxMessageSize bounds a single message, e.g. a page of logs
+	maxMessageSize = 32 << 20
+)
+
+// Request is a message from the controller to an agent. Roles are those of
+// the API caller on the controller, which the agent checks against its own
+// per-service policy.
+type Request struct {
+	ID    uint64             `json:"id"`
+	Op    string             `json:"op"`
+	Name  string             `json:"name,omitempty"`
+	Now   bool               `json:"now,omitempty"`
+	Query *services.LogQuery `json:"query,omitempty"`
+	Roles []string           `json:"roles,omitempty"`
+}
+
+// Authorizer decides whether a caller holding roles may perform op on the
+// named service, returning a ServiceError if not. mutate is set for
+// operations that change the service.
+type Authorizer func(op, name string, roles []string, mutate bool) error
+
+// readOps are the operations that only view a service
+var readOps = map[string]bool{OpList: true, OpStatus: true, OpLogs: true, OpFollow: true}
+
+// Response is a message from an agent to the controller. A request gets a
+// single response, except follow, which is acknowledged with an empty
+// response and then receives one response per entry and a final one with
+// Done set.
+type Response struct {
+	ID     uint64             `json:"id"`
+	Result json.RawMessage    `json:"result,omitempty"`
+	Entry  *services.LogEntry `json:"entry,omitempty"`
+	Done   bool               `json:"done,omitempty"`
+	Error  *Error             `json:"error,omitempty"`
+}
+
+// Error carries a ServiceError across the connection
+type Error struct {
+	Kind    string `json:"kind,omitempty"` // sentinel error, see errorKinds
+	Op      string `json:"op,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	Message string `json:"message"`
+}
+
+// errorKinds names the sentinel errors of the services package on the wire
+var errorKinds = map[string]error{
+	"invalid_name":      services.ErrInvalidName,
+	"invalid_argument":  services.ErrInvalidArgument,
+	"not_found":         services.ErrNotFound,
+	"permission_denied": services.ErrPermissionDenied,
+	"timeout":           services.ErrTimeout,
+	"already_in_state":  services.ErrAlreadyInState,
+	"unsupported":       services.ErrUnsupported,
+}
+
+// encodeError converts err for a Response
+func encodeError(err error) *Error {
+	e := &Error{Message: err.Error()}
+	var svcErr *services.ServiceError
+	if errors.As(err, &svcErr) {
+		e.Op, e.Name, e.Detail = svcErr.Op, svcErr.Name, svcErr.Detail
+		e.Message = svcErr.Err.Error()
+	}
+	for kind, sentinel := range errorKinds {
+		if errors.Is(err, sentinel) {
+			e.Kind = kind
+			break
+		}
+	}
+	if e.Kind == "" && errors.Is(err, context.DeadlineExceeded) {
+		e.Kind = "timeout"
+	}
+	return e
+}
+
+// decode rebuilds the ServiceError an agent reported, so errors.Is works
+// on the controller as it would on the agent
+func (e *Error) decode() error {
+	cause, ok := errorKinds[e.Kind]
+	if !ok {
+		cause = errors.New(e.Message)
+	}
+	if e.Op == "" {
+		return fmt.Errorf("agent: %w", cause)
+	}
+	return &services.ServiceError{Op: e.Op, Name: e.Name, Err: cause, Detail: e.Detail}
+}
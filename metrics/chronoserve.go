@@ -0,0 +1,34 @@
+package metrics
+
+// ChronoServe's own metrics, updated where the events happen
+var (
+	HTTPRequests = Default.NewCounter("chronoserve_http_requests_total",
+		"HTTP requests handled, by route, status and caller role.", "route", "status", "role")
+	HTTPRequestDuration = Default.NewHistogram("chronoserve_http_request_duration_seconds",
+		"HTTP request latency, by route, status and caller role.", DefaultBuckets, "route", "status", "role")
+
+	Logins = Default.NewCounter("chronoserve_logins_total",
+		"Login attempts, by result (success or failure).", "result")
+
+	CommandExecutions = Default.NewCounter("chronoserve_command_executions_total",
+		"External commands run by service backends, by command and operation.", "command", "op")
+	CommandFailures = Default.NewCounter("chronoserve_command_failures_total",
+		"External commands that failed, timed out or could not be started, by command and operation.", "command", "op")
+
+	StatusCacheLookups = Default.NewCounter("chronoserve_status_cache_lookups_total",
+		"Service status lookups, by whether the cache answered them (hit or miss).", "result")
+
+	EventsDropped = Default.NewCounter("chronoserve_events_dropped_total",
+		"Events an event bus subscriber missed because its buffer was full, by subscriber and event type.", "subscriber", "type")
+)
+
+func init() {
+	Default.NewGaugeFunc("chronoserve_status_cache_hit_ratio",
+		"Share of service status lookups answered from the cache since startup.", func() []Sample {
+			hits, misses := StatusCacheLookups.Value("hit"), StatusCacheLookups.Value("miss")
+			if hits+misses == 0 {
+				return nil
+			}
+			return []Sample{{Value: hits / (hits + misses)}}
+		})
+}
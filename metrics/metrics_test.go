@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// exposition writes r and returns the output
+func exposition(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != b.Len() {
+		t.Errorf("WriteTo() = %d bytes, wrote %d", n, b.Len())
+	}
+	return b.String()
+}
+
+func TestCounterExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Requests served.\nBy path and \\ code.", "path", "code")
+	// Added out of order, so the output must be sorted
+	c.Inc("/b", "500")
+	c.Add(2.5, "/a", "200")
+	c.Inc(`C:\dir`, "200")
+	c.Inc("say \"hi\"\nnow", "200")
+	c.Inc("/a", "200")
+
+	want := `# HELP test_requests_total Requests served.\nBy path and \\ code.
+# TYPE test_requests_total counter
+test_requests_total{path="/a",code="200"} 3.5
+test_requests_total{path="/b",code="500"} 1
+test_requests_total{path="C:\\dir",code="200"} 1
+test_requests_total{path="say \"hi\"\nnow",code="200"} 1
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+	if got := c.Value("/a", "200"); got != 3.5 {
+		t.Errorf("Value() = %g, want 3.5", got)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("test_duration_seconds", "Request latency.", []float64{0.1, 0.5, 1}, "route")
+	h.Observe(0.05, "status")
+	h.Observe(0.1, "status") // bounds are inclusive
+	h.Observe(0.7, "status")
+	h.Observe(30, "status")
+	h.Observe(0.2, "logs")
+
+	want := `# HELP test_duration_seconds Request latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="logs",le="0.1"} 0
+test_duration_seconds_bucket{route="logs",le="0.5"} 1
+test_duration_seconds_bucket{route="logs",le="1"} 1
+test_duration_seconds_bucket{route="logs",le="+Inf"} 1
+test_duration_seconds_sum{route="logs"} 0.2
+test_duration_seconds_count{route="logs"} 1
+test_duration_seconds_bucket{route="status",le="0.1"} 2
+test_duration_seconds_bucket{route="status",le="0.5"} 2
+test_duration_seconds_bucket{route="status",le="1"} 3
+test_duration_seconds_bucket{route="status",le="+Inf"} 4
+test_duration_seconds_sum{route="status"} 30.85
+test_duration_seconds_count{route="status"} 4
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeExposition(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("test_ratio", "A ratio without labels.", func() []Sample {
+		return []Sample{{Value: 0.25}}
+	})
+	r.NewCollector(map[string]string{
+		"test_up":     "Whether the service is up.",
+		"test_active": "Whether the service is active.",
+	}, func() map[string][]Sample {
+		return map[string][]Sample{
+			"test_up": {
+				{Labels: []string{"nginx"}, Value: 1},
+				{Labels: []string{"app"}, Value: 0},
+				{Labels: []string{"wrong", "arity"}, Value: 1},
+			},
+			"test_active": {{Labels: []string{"nginx"}, Value: math.Inf(1)}},
+		}
+	}, "service")
+
+	// Metrics are written in registration order, collector gauges by name,
+	// and gauge samples in the order they were collected
+	want := `# HELP test_ratio A ratio without labels.
+# TYPE test_ratio gauge
+test_ratio 0.25
+# HELP test_active Whether the service is active.
+# TYPE test_active gauge
+test_active{service="nginx"} +Inf
+# HELP test_up Whether the service is up.
+# TYPE test_up gauge
+test_up{service="nginx"} 1
+test_up{service="app"} 0
+`
+	for i := 0; i < 3; i++ {
+		if got := exposition(t, r); got != want {
+			t.Fatalf("exposition %d:\n%s\nwant:\n%s", i+1, got, want)
+		}
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "A counter.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name did not panic")
+		}
+	}()
+	r.NewCollector(map[string]string{"test_total": "A gauge."}, func() map[string][]Sample { return nil })
+}
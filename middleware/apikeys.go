@@ -0,0 +1,443 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/rbac"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// apiKeyPrefix starts every API key, so keys are easy to recognise in
+// configuration files and by secret scanners
+const apiKeyPrefix = "csk_"
+
+// lastUsedSaveInterval limits how often using a key rewrites the key file.
+// Last-used times are kept in memory exactly and persisted to this
+// precision.
+const lastUsedSaveInterval = time.Minute
+
+// APIKeyActions are the actions an API key can be scoped to, besides "*"
+// for all of them. Each API key route performs one of them.
+var APIKeyActions = []string{
+	"list", "status", "logs",
+	"start", "stop", "restart", "reload", "reload-or-restart",
+	"enable", "disable", "mask", "unmask",
+}
+
+var (
+	// errInvalidAPIKey means the key is unknown or revoked
+	errInvalidAPIKey = errors.New("unknown API key")
+	// errAPIKeyExpired means the key is past its expiry time
+	errAPIKeyExpired = errors.New("API key expired")
+	// errAPIKeyAddress means the request comes from outside the key's CIDRs
+	errAPIKeyAddress = errors.New("API key not allowed from this address")
+)
+
+// APIKey is a long-lived credential for automation, limited to some actions
+// on the services matching some patterns. The key itself is only shown when
+// it is created; the server keeps its SHA-256 hash.
+type APIKey struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"` // first characters of the key, to tell keys apart
+	Roles        []string   `json:"roles"`
+	Actions      []string   `json:"actions"`
+	Services     []string   `json:"services"` // glob patterns such as "nginx" or "app-*"
+	AllowedCIDRs []string   `json:"allowedCidrs,omitempty"`
+	CreatedBy    string     `json:"createdBy"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedFrom string     `json:"lastUsedFrom,omitempty"`
+}
+
+// AllowsAction reports whether the key is scoped for action
+func (k *APIKey) AllowsAction(action string) bool {
+	return slices.Contains(k.Actions, "*") || slices.Contains(k.Actions, action)
+}
+
+// AllowsService reports whether name matches one of the key's service
+// patterns. Names match with or without the ".service" suffix.
+func (k *APIKey) AllowsService(name string) bool {
+	return rbac.MatchService(k.Services, name)
+}
+
+// allowsAddress reports whether ip is inside one of the key's CIDRs. Keys
+// without CIDRs may be used from anywhere.
+func (k *APIKey) allowsAddress(ip net.IP) bool {
+	if len(k.AllowedCIDRs) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range k.AllowedCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// storedAPIKey is an API key as kept in the key file
+type storedAPIKey struct {
+	APIKey
+	Hash string `json:"hash"` // SHA-256 of the key
+}
+
+// apiKeyStore keeps the API keys, persisted to a file
+type apiKeyStore struct {
+	path   string
+	saveMu sync.Mutex // serializes writes of the key file
+
+	mu     sync.Mutex
+	keys   map[string]*storedAPIKey // by ID
+	hashes map[string]string        // key hash -> ID
+}
+
+// newAPIKeyStore loads the API keys in path. A missing file, or an empty
+// path for a store kept in memory only, starts with no keys.
+func newAPIKeyStore(path string) (*apiKeyStore, error) {
+	s := &apiKeyStore{
+		path:   path,
+		keys:   make(map[string]*storedAPIKey),
+		hashes: make(map[string]string),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading API keys: %w", err)
+	}
+	var stored []*storedAPIKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("error parsing API keys %s: %w", path, err)
+	}
+	for _, key := range stored {
+		s.keys[key.ID] = key
+		s.hashes[key.Hash] = key.ID
+	}
+	return s, nil
+}
+
+// create adds key, filling in its ID, prefix and creation time, and returns
+// the secret key along with the stored details
+func (s *apiKeyStore) create(key APIKey) (string, APIKey, error) {
+	id, err := randomToken(9)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	secret = apiKeyPrefix + secret
+
+	key.ID = id
+	key.Prefix = secret[:len(apiKeyPrefix)+6]
+	key.CreatedAt = time.Now()
+	key.LastUsedAt, key.LastUsedFrom = nil, ""
+	stored := &storedAPIKey{APIKey: key, Hash: hashToken(secret)}
+
+	s.mu.Lock()
+	s.keys[id] = stored
+	s.hashes[stored.Hash] = id
+	s.mu.Unlock()
+	s.save()
+	return secret, key, nil
+}
+
+// list returns the keys, oldest first
+func (s *apiKeyStore) list() []APIKey {
+	s.mu.Lock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key.APIKey)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// revoke deletes the key with ID id, reporting whether it existed
+func (s *apiKeyStore) revoke(id string) (APIKey, bool) {
+	s.mu.Lock()
+	key, ok := s.keys[id]
+	if ok {
+		delete(s.keys, id)
+		delete(s.hashes, key.Hash)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return APIKey{}, false
+	}
+	s.save()
+	return key.APIKey, true
+}
+
+// authenticate finds the key matching secret and checks that it may be used
+// now from ip, recording the use
+func (s *apiKeyStore) authenticate(secret string, ip net.IP) (APIKey, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	key, ok := s.keys[s.hashes[hashToken(secret)]]
+	if !ok {
+		s.mu.Unlock()
+		return APIKey{}, errInvalidAPIKey
+	}
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		s.mu.Unlock()
+		return key.APIKey, errAPIKeyExpired
+	}
+	if !key.allowsAddress(ip) {
+		s.mu.Unlock()
+		return key.APIKey, errAPIKeyAddress
+	}
+	persist := key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedSaveInterval
+	key.LastUsedAt, key.LastUsedFrom = &now, ""
+	if ip != nil {
+		key.LastUsedFrom = ip.String()
+	}
+	used := key.APIKey
+	s.mu.Unlock()
+
+	if persist {
+		s.save()
+	}
+	return used, nil
+}
+
+// save writes the keys to the key file
+func (s *apiKeyStore) save() {
+	if s.path == "" {
+		return
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	stored := make([]storedAPIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		stored = append(stored, *key)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		logger.Error("Failed to save API keys: %v", err)
+	}
+}
+
+// extractAPIKey returns the API key in the X-API-Key header or in an
+// "Authorization: ApiKey ..." header, if the request carries one
+func extractAPIKey(r *http.Request) (string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "ApiKey") && key != "" {
+		return key, true
+	}
+	return "", false
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// authenticateAPIKey builds the claims of a request made with an API key.
+// The caller is named after the key, and holds the key's roles.
+func authenticateAPIKey(r *http.Request, secret string) (*Claims, error) {
+	key, err := apiKeys.authenticate(secret, clientIP(r))
+	if err != nil {
+		return nil, err
+	}
+	return &Claims{UserID: "apikey:" + key.Name, Roles: key.Roles, APIKey: &key}, nil
+}
+
+// RequireAPIKeyScope lets callers with an API key through only if the key is
+// scoped for action. Routes with no action refuse API keys altogether.
+// Callers with a JWT are not affected.
+func RequireAPIKeyScope(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetClaimsFromContext(r.Context())
+			if claims != nil && claims.APIKey != nil {
+				if action == "" {
+					logger.Warn("API key %s used on %s, which does not accept API keys", claims.APIKey.ID, r.URL.Path)
+					utils.WriteErrorResponse(w, "API keys cannot access this endpoint", http.StatusForbidden)
+					return
+				}
+				if !claims.APIKey.AllowsAction(action) {
+					logger.Warn("API key %s is not scoped for %s", claims.APIKey.ID, action)
+					utils.WriteErrorResponse(w, fmt.Sprintf("API key is not scoped for %s", action), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CreateAPIKeyRequest is the body of POST /apikeys
+type CreateAPIKeyRequest struct {
+	Name         string     `json:"name"`
+	Roles        []string   `json:"roles"`
+	Actions      []string   `json:"actions"`
+	Services     []string   `json:"services"`
+	AllowedCIDRs []string   `json:"allowedCidrs"`
+	ExpiresAt    *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIKeyResponse returns a new key. The key cannot be retrieved again.
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"apiKey"`
+}
+
+// HandleAPIKeys lists the API keys (GET) or creates one (POST)
+func HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		utils.WriteSuccessResponse(w, "API keys retrieved successfully", apiKeys.list())
+	case http.MethodPost:
+		createAPIKey(w, r)
+	default:
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAPIKey revokes (DELETE) the API key whose ID is in the path,
+// /apikeys/{id}
+func HandleAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/apikeys/")
+	key, ok := apiKeys.revoke(id)
+	if !ok {
+		utils.WriteErrorResponse(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if claims := GetClaimsFromContext(r.Context()); claims != nil {
+		logger.Info("User %s revoked API key %s (%s)", claims.UserID, key.ID, key.Name)
+	}
+	utils.WriteSuccessResponse(w, "API key revoked", nil)
+}
+
+// createAPIKey creates the key in the request body on behalf of the caller
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		utils.WriteValidationError(w, "Invalid request body: "+err.Error())
+		return
+	}
+
+	claims := GetClaimsFromContext(r.Context())
+	if claims == nil {
+		utils.WriteErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := validateAPIKeyRequest(req, claims.Roles); err != nil {
+		utils.WriteValidationError(w, err.Error())
+		return
+	}
+
+	secret, key, err := apiKeys.create(APIKey{
+		Name:         req.Name,
+		Roles:        req.Roles,
+		Actions:      req.Actions,
+		Services:     req.Services,
+		AllowedCIDRs: req.AllowedCIDRs,
+		ExpiresAt:    req.ExpiresAt,
+		CreatedBy:    claims.UserID,
+	})
+	if err != nil {
+		utils.WriteInternalError(w, err)
+		return
+	}
+	logger.Info("User %s created API key %s (%s)", claims.UserID, key.ID, key.Name)
+	utils.WriteSuccessResponse(w, "API key created; store it now, it cannot be shown again",
+		CreateAPIKeyResponse{Key: secret, APIKey: key})
+}
+
+// validateAPIKeyRequest checks a new key's scope. A key may only hold roles
+// its creator holds, so creating keys never grants more access.
+func validateAPIKeyRequest(req CreateAPIKeyRequest, creatorRoles []string) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("name is required")
+	}
+
+	if len(req.Roles) == 0 {
+		return errors.New("at least one role is required")
+	}
+	definedRoles := utils.GetConfig().Auth.Roles
+	for _, role := range req.Roles {
+		if _, ok := definedRoles[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		if !slices.Contains(creatorRoles, role) {
+			return fmt.Errorf("cannot grant role %q, which you do not hold", role)
+		}
+	}
+
+	if len(req.Actions) == 0 {
+		return errors.New("at least one action is required")
+	}
+	for _, action := range req.Actions {
+		if action != "*" && !slices.Contains(APIKeyActions, action) {
+			return fmt.Errorf("unknown action %q, expected * or one of %s", action, strings.Join(APIKeyActions, ", "))
+		}
+	}
+
+	if len(req.Services) == 0 {
+		return errors.New("at least one service pattern is required, \"*\" for all services")
+	}
+	for _, pattern := range req.Services {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid service pattern %q", pattern)
+		}
+	}
+
+	for _, cidr := range req.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expiresAt must be in the future")
+	}
+	return nil
+}
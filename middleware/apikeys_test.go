@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTestAPIKey creates req as alice through the API, returning the
+// status and the response
+func createTestAPIKey(t *testing.T, req CreateAPIKeyRequest) (int, CreateAPIKeyResponse) {
+	t.Helper()
+	admin := login(t, "alice")
+	status, data := post(t, HandleAPIKeys, "/apikeys", admin.Token, req)
+	var resp CreateAPIKeyResponse
+	if status == http.StatusOK {
+		if err := json.Unmarshal(data, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return status, resp
+}
+
+// serveWithAPIKey sends a request authenticated with secret from remoteAddr
+// through AuthMiddleware and RequireAPIKeyScope for action
+func serveWithAPIKey(secret, remoteAddr, action string) int {
+	req := httptest.NewRequest(http.MethodPost, "/services/restart/app-web", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-API-Key", secret)
+	rec := httptest.NewRecorder()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	AuthMiddleware(RequireAPIKeyScope(action)(ok)).ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	setupTestAuth(t)
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	valid := CreateAPIKeyRequest{Name: "ci", Roles: []string{"admin"}, Actions: []string{"restart"}, Services: []string{"app-*"}}
+
+	tests := []struct {
+		name    string
+		change  func(req *CreateAPIKeyRequest)
+		wantErr string
+	}{
+		{"valid", func(req *CreateAPIKeyRequest) {}, ""},
+		{"every action until a time", func(req *CreateAPIKeyRequest) {
+			req.Actions, req.ExpiresAt, req.AllowedCIDRs = []string{"*"}, &future, []string{"10.0.0.0/8", "fd00::/8"}
+		}, ""},
+		// alice is an admin but not a viewer
+		{"role the creator lacks", func(req *CreateAPIKeyRequest) { req.Roles = []string{"admin", "viewer"} }, `cannot grant role "viewer"`},
+		{"unknown role", func(req *CreateAPIKeyRequest) { req.Roles = []string{"root"} }, `unknown role "root"`},
+		{"no roles", func(req *CreateAPIKeyRequest) { req.Roles = nil }, "at least one role"},
+		{"no name", func(req *CreateAPIKeyRequest) { req.Name = " " }, "name is required"},
+		{"unknown action", func(req *CreateAPIKeyRequest) { req.Actions = []string{"explode"} }, `unknown action "explode"`},
+		{"no services", func(req *CreateAPIKeyRequest) { req.Services = nil }, "at least one service pattern"},
+		{"invalid service pattern", func(req *CreateAPIKeyRequest) { req.Services = []string{"app-["} }, `invalid service pattern "app-["`},
+		{"invalid CIDR", func(req *CreateAPIKeyRequest) { req.AllowedCIDRs = []string{"10.0.0.1"} }, `invalid CIDR "10.0.0.1"`},
+		{"expiry in the past", func(req *CreateAPIKeyRequest) { req.ExpiresAt = &past }, "must be in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.change(&req)
+			err := validateAPIKeyRequest(req, []string{"admin"})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateAPIKeyRequest() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateAPIKeyRequest() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The API refuses keys with roles the caller lacks
+	if status, _ := createTestAPIKey(t, CreateAPIKeyRequest{Name: "ci", Roles: []string{"viewer"}, Actions: []string{"*"}, Services: []string{"*"}}); status != http.StatusBadRequest {
+		t.Errorf("creating a key with a role the caller lacks status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	setupTestAuth(t)
+	expired := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		key        APIKey
+		remoteAddr string
+		wantStatus int
+	}{
+		{"unrestricted", APIKey{}, "203.0.113.7:4000", http.StatusOK},
+		{"inside CIDR", APIKey{AllowedCIDRs: []string{"10.0.0.0/8"}}, "10.1.2.3:4000", http.StatusOK},
+		{"inside second CIDR", APIKey{AllowedCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}}, "[2001:db8::1]:4000", http.StatusOK},
+		{"outside CIDR", APIKey{AllowedCIDRs: []string{"10.0.0.0/8"}}, "192.168.1.10:4000", http.StatusUnauthorized},
+		{"IPv6 outside CIDR", APIKey{AllowedCIDRs: []string{"10.0.0.0/8"}}, "[2001:db8::1]:4000", http.StatusUnauthorized},
+		{"unknown address", APIKey{AllowedCIDRs: []string{"10.0.0.0/8"}}, "pipe", http.StatusUnauthorized},
+		{"expired", APIKey{ExpiresAt: &expired}, "10.1.2.3:4000", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			key.Name, key.Roles, key.Actions, key.Services = "ci", []string{"admin"}, []string{"*"}, []string{"*"}
+			secret, _, err := apiKeys.create(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := serveWithAPIKey(secret, tt.remoteAddr, "restart"); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+
+	secret, key, err := apiKeys.create(APIKey{Name: "ci", Roles: []string{"admin"}, Actions: []string{"*"}, Services: []string{"*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := apiKeys.revoke(key.ID); !ok {
+		t.Fatal("revoke() did not find the key")
+	}
+	if status := serveWithAPIKey(secret, "10.1.2.3:4000", "restart"); status != http.StatusUnauthorized {
+		t.Errorf("revoked key status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := serveWithAPIKey("csk_unknown", "10.1.2.3:4000", "restart"); status != http.StatusUnauthorized {
+		t.Errorf("unknown key status = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireAPIKeyScope(t *testing.T) {
+	setupTestAuth(t)
+	restart, _, err := apiKeys.create(APIKey{Name: "deploy", Roles: []string{"admin"}, Actions: []string{"restart", "status"}, Services: []string{"*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, _, err := apiKeys.create(APIKey{Name: "ops", Roles: []string{"admin"}, Actions: []string{"*"}, Services: []string{"*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		secret     string
+		action     string
+		wantStatus int
+	}{
+		{"scoped action", restart, "restart", http.StatusOK},
+		{"other scoped action", restart, "status", http.StatusOK},
+		{"action out of scope", restart, "stop", http.StatusForbidden},
+		{"every action", all, "mask", http.StatusOK},
+		// Routes without an action, such as managing API keys, refuse
+		// every key
+		{"route without action", restart, "", http.StatusForbidden},
+		{"route without action and every action", all, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := serveWithAPIKey(tt.secret, "10.1.2.3:4000", tt.action); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+
+	// Callers with a JWT are not limited
+	token, err := CreateToken("alice", []string{"admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/apikeys", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	AuthMiddleware(RequireAPIKeyScope("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("JWT caller on a route without action status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestAPIKeyStoredHashed(t *testing.T) {
+	sessionFile := setupTestAuth(t)
+	keyFile := filepath.Join(filepath.Dir(sessionFile), "apikeys.json")
+
+	status, created := createTestAPIKey(t, CreateAPIKeyRequest{Name: "ci", Roles: []string{"admin"}, Actions: []string{"*"}, Services: []string{"*"}})
+	if status != http.StatusOK {
+		t.Fatalf("create status = %d", status)
+	}
+	if !strings.HasPrefix(created.Key, apiKeyPrefix) || created.APIKey.Prefix != created.Key[:len(apiKeyPrefix)+6] || created.APIKey.CreatedBy != "alice" {
+		t.Errorf("created key %q with details %+v", created.Key, created.APIKey)
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), created.Key) {
+		t.Error("key file holds the key itself")
+	}
+	var stored []storedAPIKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Hash != hashToken(created.Key) {
+		t.Fatalf("key file = %s, want the SHA-256 of the key", data)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", mode)
+	}
+
+	// Listing keys does not reveal them either
+	admin := login(t, "alice")
+	req := httptest.NewRequest(http.MethodGet, "/apikeys", nil)
+	req.Header.Set("Authorization", "Bearer "+admin.Token)
+	rec := httptest.NewRecorder()
+	AuthMiddleware(http.HandlerFunc(HandleAPIKeys)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), created.Key) || strings.Contains(rec.Body.String(), stored[0].Hash) {
+		t.Errorf("list = %d %s, want the keys without secrets", rec.Code, rec.Body.String())
+	}
+
+	// The key works after reloading the file
+	reloaded, err := newAPIKeyStore(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys = reloaded
+	if status := serveWithAPIKey(created.Key, "10.1.2.3:4000", "restart"); status != http.StatusOK {
+		t.Errorf("key status after reloading = %d, want %d", status, http.StatusOK)
+	}
+}
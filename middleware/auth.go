@@ -8,24 +8,35 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/therealtoxicdev/chronoserve/rbac"
 	"github.com/therealtoxicdev/chronoserve/utils"
 )
 
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string   `json:"uid"`
-	Roles  []string `json:"roles"`
+	UserID    string   `json:"uid"`
+	Roles     []string `json:"roles"`
+	SessionID string   `json:"sid,omitempty"` // login session, empty for tokens not issued by login
+	APIKey    *APIKey  `json:"-"`             // set when the caller authenticated with an API key
 }
 
 type AuthConfig struct {
-	SecretKey     string        `yaml:"secretKey"`
-	TokenDuration time.Duration `yaml:"tokenDuration"`
-	IssuedBy      string        `yaml:"issuedBy"`
+	SecretKey            string             `yaml:"secretKey"`
+	SigningKeys          []utils.SigningKey `yaml:"signingKeys"`          // asymmetric keys, replacing SecretKey
+	SigningKeyID         string             `yaml:"signingKeyId"`         // key that signs new tokens
+	TokenDuration        time.Duration      `yaml:"tokenDuration"`        // lifetime of access tokens
+	RefreshTokenDuration time.Duration      `yaml:"refreshTokenDuration"` // lifetime of refresh tokens, renewed on each refresh
+	IssuedBy             string             `yaml:"issuedBy"`
+	SessionFile          string             `yaml:"sessionFile"` // sessions and revoked tokens, kept in memory if empty
+	APIKeyFile           string             `yaml:"apiKeyFile"`  // hashed API keys, kept in memory if empty
 }
 
 var (
-	logger *utils.Logger
-	config AuthConfig
+	logger      *utils.Logger
+	config      AuthConfig
+	sessions    *sessionStore
+	apiKeys     *apiKeyStore
+	signingKeys *keySet
 )
 
 // InitAuth initializes the authentication configuration
@@ -43,11 +54,42 @@ func InitAuth(cfg AuthConfig) {
 		panic(fmt.Sprintf("Failed to initialize logger: %v", err))
 	}
 	config = cfg
+
+	signingKeys, err = loadKeySet(cfg.SigningKeys, cfg.SigningKeyID)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load signing keys: %v", err))
+	}
+	if signingKeys.active != nil {
+		logger.Info("Signing tokens with %s key %s", signingKeys.active.method.Alg(), signingKeys.active.id)
+	}
+
+	// Refusing to start is safer than forgetting revoked tokens
+	sessions, err = newSessionStore(cfg.SessionFile, cfg.RefreshTokenDuration)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load sessions: %v", err))
+	}
+	apiKeys, err = newAPIKeyStore(cfg.APIKeyFile)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load API keys: %v", err))
+	}
 }
 
-// AuthMiddleware provides JWT authentication
+// AuthMiddleware authenticates callers with a JWT, or with an API key in
+// the X-API-Key header or an "Authorization: ApiKey" header
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret, ok := extractAPIKey(r); ok {
+			claims, err := authenticateAPIKey(r, secret)
+			if err != nil {
+				logger.Warn("API key rejected from %s: %v", r.RemoteAddr, err)
+				utils.WriteErrorResponse(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			setRequestRoles(r.Context(), claims.Roles)
+			next.ServeHTTP(w, r.WithContext(AddClaimsToContext(r.Context(), claims)))
+			return
+		}
+
 		token, err := extractToken(r)
 		if err != nil {
 			logger.Error("Auth failed: %v", err)
@@ -61,38 +103,60 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			utils.WriteErrorResponse(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
+		if sessions.isRevoked(claims.ID) {
+			logger.Warn("Revoked token presented for user %s", claims.UserID)
+			utils.WriteErrorResponse(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		}
 
 		// Add claims to request context
+		setRequestRoles(r.Context(), claims.Roles)
 		ctx := AddClaimsToContext(r.Context(), claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// CreateToken generates a new JWT token
+// CreateToken generates a new JWT access token outside any login session
 func CreateToken(userID string, roles []string) (string, error) {
-	claims := Claims{
+	token, _, err := createAccessToken(userID, roles, "")
+	return token, err
+}
+
+// createAccessToken generates a JWT access token for a login session. Every
+// token has a unique ID (jti) by which it can be revoked.
+func createAccessToken(userID string, roles []string, sessionID string) (string, *Claims, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.TokenDuration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        id,
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.TokenDuration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    config.IssuedBy,
 			Subject:   userID,
 		},
-		UserID: userID,
-		Roles:  roles,
+		UserID:    userID,
+		Roles:     roles,
+		SessionID: sessionID,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.SecretKey))
+	token, err := signingKeys.sign(claims, config.SecretKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
 }
 
+// validateToken parses a token signed with one of the configured algorithms
+// and keys, or with the secret key when there are no asymmetric keys
 func validateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(config.SecretKey), nil
-	})
+		return signingKeys.verificationKey(token, config.SecretKey)
+	}, jwt.WithValidMethods(signingKeys.algorithms()))
 
 	if err != nil {
 		return nil, err
@@ -124,39 +188,12 @@ func extractToken(r *http.Request) (string, error) {
 	return parts[1], nil
 }
 
-// RequireRole middleware checks if the user has the required role
-func RequireRole(role string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			claims := GetClaimsFromContext(r.Context())
-			if claims == nil {
-				logger.Warn("No claims found in context")
-				utils.WriteErrorResponse(w, "Forbidden", http.StatusForbidden)
-				return
-			}
-
-			// Check if user has the required role
-			hasRole := false
-			for _, userRole := range claims.Roles {
-				if userRole == role {
-					hasRole = true
-					break
-				}
-			}
-
-			if !hasRole {
-				logger.Warn("Unauthorized role access attempt. Required: %s, Has: %v", role, claims.Roles)
-				utils.WriteErrorResponse(w, "Forbidden", http.StatusForbidden)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-// RequireAnyRole helper function to check if the user has any of the required roles
-func RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
+// RequirePermission lets a request through if the caller's roles grant the
+// permission that permission returns for it. With scoped set, the last path
+// segment names the service the permission must cover; otherwise a grant on
+// any service will do, and the handler checks the services it acts on. An
+// empty permission only requires the caller to be authenticated.
+func RequirePermission(policy *rbac.Policy, permission func(*http.Request) string, scoped bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			claims := GetClaimsFromContext(r.Context())
@@ -166,22 +203,12 @@ func RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Check if user has any of the required roles
-			hasRole := false
-			for _, requiredRole := range roles {
-				for _, userRole := range claims.Roles {
-					if userRole == requiredRole {
-						hasRole = true
-						break
-					}
-				}
-				if hasRole {
-					break
-				}
+			perm, service := permission(r), ""
+			if scoped {
+				service = utils.ExtractServiceName(r.URL.Path)
 			}
-
-			if !hasRole {
-				logger.Warn("Unauthorized role access attempt. Required any of: %v, Has: %v", roles, claims.Roles)
+			if perm != "" && !policy.Allowed(claims.Roles, perm, service) {
+				logger.Warn("Permission denied for %s: %s on %q with roles %v", claims.UserID, perm, service, claims.Roles)
 				utils.WriteErrorResponse(w, "Forbidden", http.StatusForbidden)
 				return
 			}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// minRSABits is the smallest RSA key accepted for signing tokens
+const minRSABits = 2048
+
+// signingKey is a loaded asymmetric JWT key
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey // nil for keys that only verify
+	public  crypto.PublicKey
+}
+
+// keySet holds the asymmetric keys tokens are signed and verified with.
+// An empty set means tokens are signed with the HS256 secret key instead.
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	order  []*signingKey // as configured, for the JWKS
+}
+
+// loadKeySet reads the key files of keys. activeID names the key that signs
+// new tokens; empty picks the first key with a private key.
+func loadKeySet(keys []utils.SigningKey, activeID string) (*keySet, error) {
+	set := &keySet{keys: make(map[string]*signingKey)}
+	for _, cfg := range keys {
+		key, err := loadSigningKey(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", cfg.ID, err)
+		}
+		set.keys[key.id] = key
+		set.order = append(set.order, key)
+		if set.active == nil && key.private != nil && (activeID == "" || activeID == key.id) {
+			set.active = key
+		}
+	}
+	if len(keys) > 0 && set.active == nil {
+		return nil, fmt.Errorf("no signing key with a private key to sign tokens with")
+	}
+	return set, nil
+}
+
+// loadSigningKey parses the PEM files of a key, checking that they suit its
+// algorithm. The public key is derived from the private key when there is
+// one.
+func loadSigningKey(cfg utils.SigningKey) (*signingKey, error) {
+	key := &signingKey{id: cfg.ID, method: jwt.GetSigningMethod(cfg.Algorithm)}
+	if key.method == nil {
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Algorithm)
+	}
+
+	file, private := cfg.PublicKeyFile, false
+	if cfg.PrivateKeyFile != "" {
+		file, private = cfg.PrivateKeyFile, true
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Algorithm {
+	case "RS256":
+		var pub *rsa.PublicKey
+		if private {
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.private, pub = priv, &priv.PublicKey
+		} else if pub, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, err
+		}
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key has %d bits, at least %d are required", pub.N.BitLen(), minRSABits)
+		}
+		key.public = pub
+	case "ES256":
+		var pub *ecdsa.PublicKey
+		if private {
+			priv, err := jwt.ParseECPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.private, pub = priv, &priv.PublicKey
+		} else if pub, err = jwt.ParseECPublicKeyFromPEM(data); err != nil {
+			return nil, err
+		}
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 requires a P-256 key")
+		}
+		key.public = pub
+	case "EdDSA":
+		if private {
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			edPriv, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("not an Ed25519 private key")
+			}
+			key.private, key.public = edPriv, edPriv.Public()
+		} else if key.public, err = jwt.ParseEdPublicKeyFromPEM(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Algorithm)
+	}
+	return key, nil
+}
+
+// algorithms returns the algorithms tokens may be signed with
+func (s *keySet) algorithms() []string {
+	if len(s.keys) == 0 {
+		return []string{jwt.SigningMethodHS256.Alg()}
+	}
+	algs := make([]string, 0, len(s.keys))
+	seen := make(map[string]bool)
+	for _, key := range s.order {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// sign signs claims with the active key, or with secret when there are no
+// asymmetric keys
+func (s *keySet) sign(claims jwt.Claims, secret string) (string, error) {
+	if s.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	}
+	token := jwt.NewWithClaims(s.active.method, claims)
+	token.Header["kid"] = s.active.id
+	return token.SignedString(s.active.private)
+}
+
+// verificationKey finds the key a token was signed with, by its kid header,
+// and checks that the token uses that key's algorithm
+func (s *keySet) verificationKey(token *jwt.Token, secret string) (interface{}, error) {
+	if len(s.keys) == 0 {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("signing method %v does not match key %s", token.Header["alg"], kid)
+	}
+	return key.public, nil
+}
+
+// jwk is a public key in JSON Web Key format (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jwks returns the public keys as a JSON Web Key Set
+func (s *keySet) jwks() map[string][]jwk {
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys := make([]jwk, 0, len(s.order))
+	for _, key := range s.order {
+		k := jwk{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			k.Kty, k.N, k.E = "RSA", b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			k.Kty, k.Crv = "EC", pub.Curve.Params().Name
+			k.X, k.Y = b64(pub.X.FillBytes(make([]byte, size))), b64(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			k.Kty, k.Crv, k.X = "OKP", "Ed25519", b64(pub)
+		}
+		keys = append(keys, k)
+	}
+	return map[string][]jwk{"keys": keys}
+}
+
+// HandleJWKS publishes the public keys tokens are signed with at
+// /.well-known/jwks.json, so other services can verify ChronoServe tokens.
+// The set is empty while tokens are signed with the HS256 secret key.
+func HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, signingKeys.jwks(), http.StatusOK)
+}
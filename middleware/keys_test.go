@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// writeKey writes the PKCS #8 private key, or with public set the PKIX
+// public key, of key to a file in dir and returns its path
+func writeKey(t *testing.T, dir, name string, key crypto.Signer, public bool) string {
+	t.Helper()
+	kind, der, err := "PRIVATE KEY", []byte(nil), error(nil)
+	if public {
+		kind = "PUBLIC KEY"
+		der, err = x509.MarshalPKIXPublicKey(key.Public())
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustEdKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestLoadSigningKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	small := mustRSAKey(t, 1024)
+	p384 := mustECKey(t, elliptic.P384())
+
+	tests := []struct {
+		name    string
+		key     utils.SigningKey
+		wantErr string
+	}{
+		{"small RSA private key", utils.SigningKey{ID: "k", Algorithm: "RS256", PrivateKeyFile: writeKey(t, dir, "rsa1024.pem", small, false)}, "at least 2048"},
+		{"small RSA public key", utils.SigningKey{ID: "k", Algorithm: "RS256", PublicKeyFile: writeKey(t, dir, "rsa1024.pub", small, true)}, "at least 2048"},
+		{"P-384 private key", utils.SigningKey{ID: "k", Algorithm: "ES256", PrivateKeyFile: writeKey(t, dir, "p384.pem", p384, false)}, "P-256"},
+		{"P-384 public key", utils.SigningKey{ID: "k", Algorithm: "ES256", PublicKeyFile: writeKey(t, dir, "p384.pub", p384, true)}, "P-256"},
+		{"EC key for RS256", utils.SigningKey{ID: "k", Algorithm: "RS256", PrivateKeyFile: writeKey(t, dir, "p384-rs.pem", p384, false)}, "not a valid RSA"},
+		{"RSA key for EdDSA", utils.SigningKey{ID: "k", Algorithm: "EdDSA", PrivateKeyFile: writeKey(t, dir, "rsa-ed.pem", small, false)}, "Ed25519"},
+		{"symmetric algorithm", utils.SigningKey{ID: "k", Algorithm: "HS256", PrivateKeyFile: writeKey(t, dir, "hs.pem", small, false)}, "unsupported algorithm"},
+		{"missing file", utils.SigningKey{ID: "k", Algorithm: "ES256", PrivateKeyFile: filepath.Join(dir, "missing.pem")}, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadKeySet([]utils.SigningKey{tt.key}, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadKeySet() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A set of public keys only cannot sign tokens
+	pub := utils.SigningKey{ID: "k", Algorithm: "ES256", PublicKeyFile: writeKey(t, dir, "p256.pub", mustECKey(t, elliptic.P256()), true)}
+	if _, err := loadKeySet([]utils.SigningKey{pub}, ""); err == nil {
+		t.Error("loadKeySet() of public keys only succeeded")
+	}
+}
+
+// testKeys are the private keys of the test key set. Only the public key of
+// previous is loaded, like that of a key rotated out.
+type testKeys struct {
+	rsa      *rsa.PrivateKey
+	ec       *ecdsa.PrivateKey
+	ed       ed25519.PrivateKey
+	previous *ecdsa.PrivateKey
+}
+
+// setupTestKeys loads testKeys as the signing keys, with the RSA key active
+func setupTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	dir := t.TempDir()
+	keys := testKeys{rsa: mustRSAKey(t, 2048), ec: mustECKey(t, elliptic.P256()), ed: mustEdKey(t), previous: mustECKey(t, elliptic.P256())}
+	set, err := loadKeySet([]utils.SigningKey{
+		{ID: "previous", Algorithm: "ES256", PublicKeyFile: writeKey(t, dir, "previous.pub", keys.previous, true)},
+		{ID: "rsa", Algorithm: "RS256", PrivateKeyFile: writeKey(t, dir, "rsa.pem", keys.rsa, false)},
+		{ID: "ec", Algorithm: "ES256", PrivateKeyFile: writeKey(t, dir, "ec.pem", keys.ec, false)},
+		{ID: "ed", Algorithm: "EdDSA", PrivateKeyFile: writeKey(t, dir, "ed.pem", keys.ed, false)},
+	}, "rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousKeys, previousConfig := signingKeys, config
+	signingKeys, config = set, AuthConfig{SecretKey: "test-secret", TokenDuration: time.Minute}
+	t.Cleanup(func() { signingKeys, config = previousKeys, previousConfig })
+	return keys
+}
+
+// signWith signs a token for alice with method and key under the kid header
+func signWith(t *testing.T, method jwt.SigningMethod, kid string, key any) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+		UserID:           "alice",
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestValidateTokenKeys(t *testing.T) {
+	keys := setupTestKeys(t)
+	active, err := CreateToken("alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"active key", active, ""},
+		{"other key", signWith(t, jwt.SigningMethodES256, "ec", keys.ec), ""},
+		{"Ed25519 key", signWith(t, jwt.SigningMethodEdDSA, "ed", keys.ed), ""},
+		{"public key of a rotated key", signWith(t, jwt.SigningMethodES256, "previous", keys.previous), ""},
+		{"unknown kid", signWith(t, jwt.SigningMethodES256, "retired", keys.ec), "unknown signing key"},
+		{"no kid", signWith(t, jwt.SigningMethodRS256, "", keys.rsa), "unknown signing key"},
+		{"kid of another key", signWith(t, jwt.SigningMethodES256, "previous", keys.ec), "verification error"},
+		{"algorithm of another key", signWith(t, jwt.SigningMethodRS256, "ec", keys.rsa), "does not match key ec"},
+		// HS256 with the secret key is refused once asymmetric keys are set
+		{"secret key", signWith(t, jwt.SigningMethodHS256, "rsa", []byte("test-secret")), "signing method HS256 is invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := validateToken(tt.token)
+			if tt.wantErr == "" {
+				if err != nil || claims.UserID != "alice" {
+					t.Errorf("validateToken() = %+v, %v", claims, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateToken() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// publicKey rebuilds the public key of a JSON Web Key
+func publicKey(t *testing.T, k jwk) any {
+	t.Helper()
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("key %s: %v", k.Kid, err)
+		}
+		return b
+	}
+	switch {
+	case k.Kty == "RSA":
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(decode(k.N)), E: int(new(big.Int).SetBytes(decode(k.E)).Int64())}
+	case k.Kty == "EC" && k.Crv == "P-256":
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(decode(k.X)), Y: new(big.Int).SetBytes(decode(k.Y))}
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		return ed25519.PublicKey(decode(k.X))
+	}
+	t.Fatalf("unexpected key %+v", k)
+	return nil
+}
+
+func TestHandleJWKS(t *testing.T) {
+	keys := setupTestKeys(t)
+	rec := httptest.NewRecorder()
+	HandleJWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+
+	// A verifier that only knows the published set accepts every token
+	published := make(map[string]jwk)
+	var ids []string
+	for _, k := range set.Keys {
+		if k.Use != "sig" {
+			t.Errorf("key %s use = %q", k.Kid, k.Use)
+		}
+		published[k.Kid] = k
+		ids = append(ids, k.Kid)
+	}
+	if want := "previous,rsa,ec,ed"; strings.Join(ids, ",") != want {
+		t.Errorf("published keys %v, want %s in configured order", ids, want)
+	}
+	verify := func(token *jwt.Token) (any, error) {
+		k := published[token.Header["kid"].(string)]
+		if token.Method.Alg() != k.Alg {
+			t.Errorf("token algorithm %s, published %s", token.Method.Alg(), k.Alg)
+		}
+		return publicKey(t, k), nil
+	}
+
+	active, err := CreateToken("alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{
+		active,
+		signWith(t, jwt.SigningMethodES256, "ec", keys.ec),
+		signWith(t, jwt.SigningMethodEdDSA, "ed", keys.ed),
+		signWith(t, jwt.SigningMethodES256, "previous", keys.previous),
+	} {
+		if _, err := jwt.Parse(token, verify); err != nil {
+			t.Errorf("token not verified with the published keys: %v", err)
+		}
+	}
+}
+
+func TestHandleJWKSSecretKey(t *testing.T) {
+	previous := signingKeys
+	signingKeys = &keySet{keys: make(map[string]*signingKey)}
+	t.Cleanup(func() { signingKeys = previous })
+
+	rec := httptest.NewRecorder()
+	HandleJWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"keys":[]}` {
+		t.Errorf("JWKS with a secret key = %s, want no keys", got)
+	}
+}
@@ -2,8 +2,13 @@ package middleware
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/therealtoxicdev/chronoserve/metrics"
 	"github.com/therealtoxicdev/chronoserve/utils"
 )
 
@@ -12,9 +17,19 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// LoginResponse carries a short-lived access token, and a refresh token that
+// /auth/refresh exchanges for new tokens
 type LoginResponse struct {
-	Token string   `json:"token"`
-	Roles []string `json:"roles"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+	Roles            []string  `json:"roles"`
+}
+
+// RefreshRequest is the body of /auth/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 // HandleLogin processes login requests and returns JWT tokens
@@ -33,26 +48,128 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// Validate credentials against config
 	user, valid := validateCredentials(req.Username, req.Password)
 	if !valid {
+		metrics.Logins.Inc("failure")
 		utils.WriteErrorResponse(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate JWT token - Remove the ... since CreateToken now accepts []string
-	token, err := CreateToken(req.Username, user.Roles)
+	// Start a session and issue its first tokens
+	sessionID, refresh, refreshExpiresAt, err := sessions.create(req.Username)
 	if err != nil {
 		utils.WriteErrorResponse(w, "Failed to create token", http.StatusInternalServerError)
 		return
 	}
-
-	// Return token to client
-	response := LoginResponse{
-		Token: token,
-		Roles: user.Roles,
+	response, err := issueTokens(req.Username, user.Roles, sessionID, refresh, refreshExpiresAt)
+	if err != nil {
+		utils.WriteErrorResponse(w, "Failed to create token", http.StatusInternalServerError)
+		return
 	}
 
+	metrics.Logins.Inc("success")
 	utils.WriteSuccessResponse(w, "Login successful", response)
 }
 
+// HandleRefresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once: presenting it again revokes
+// the session, since it has probably been stolen.
+func HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		utils.WriteErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, userID, refresh, refreshExpiresAt, err := sessions.rotate(req.RefreshToken)
+	if errors.Is(err, errRefreshTokenReused) {
+		logger.Warn("Refresh token of user %s reused, session revoked", userID)
+	}
+	if err != nil {
+		utils.WriteErrorResponse(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// Roles may have changed since login, and the user may be gone
+	user, exists := utils.GetConfig().Auth.Users[userID]
+	if !exists {
+		sessions.revokeSession(sessionID)
+		utils.WriteErrorResponse(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	response, err := issueTokens(userID, user.Roles, sessionID, refresh, refreshExpiresAt)
+	if err != nil {
+		utils.WriteErrorResponse(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	utils.WriteSuccessResponse(w, "Token refreshed", response)
+}
+
+// HandleLogout ends the caller's session, revoking its refresh token and
+// every access token issued for it
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := GetClaimsFromContext(r.Context())
+	if claims == nil {
+		utils.WriteErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if claims.SessionID != "" {
+		sessions.revokeSession(claims.SessionID)
+	}
+	if claims.ExpiresAt != nil {
+		sessions.revokeToken(claims.ID, claims.ExpiresAt.Time)
+	}
+	utils.WriteSuccessResponse(w, "Logged out", nil)
+}
+
+// HandleRevokeSessions ends every session of the user named in the path,
+// /auth/revoke/{user}, revoking their refresh tokens and the access tokens
+// issued for those sessions. Tokens from CreateToken belong to no session
+// and stay valid until they expire.
+func HandleRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := strings.TrimPrefix(r.URL.Path, "/auth/revoke/")
+	if userID == "" || strings.Contains(userID, "/") {
+		utils.WriteValidationError(w, "A user name is required")
+		return
+	}
+
+	n := sessions.revokeUser(userID)
+	if claims := GetClaimsFromContext(r.Context()); claims != nil {
+		logger.Info("User %s revoked %d sessions of user %s", claims.UserID, n, userID)
+	}
+	utils.WriteSuccessResponse(w, fmt.Sprintf("Revoked %d sessions of user %s and their access tokens", n, userID), map[string]int{"revoked": n})
+}
+
+// issueTokens creates an access token for a session and pairs it with the
+// session's refresh token
+func issueTokens(userID string, roles []string, sessionID, refresh string, refreshExpiresAt time.Time) (*LoginResponse, error) {
+	token, claims, err := createAccessToken(userID, roles, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	sessions.recordAccessToken(sessionID, claims.ID, claims.ExpiresAt.Time)
+	return &LoginResponse{
+		Token:            token,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpiresAt,
+		Roles:            roles,
+	}, nil
+}
+
 // validateCredentials checks if the provided credentials are valid against the config
 func validateCredentials(username, password string) (*utils.Credentials, bool) {
 	config := utils.GetConfig()
@@ -63,8 +180,9 @@ func validateCredentials(username, password string) (*utils.Credentials, bool) {
 		return nil, false
 	}
 
-	// Validate password
-	if user.Password != password {
+	// Validate password against its hash, or in constant time if stored
+	// in plaintext
+	if !user.CheckPassword(password) {
 		return nil, false
 	}
 
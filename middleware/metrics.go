@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/metrics"
+)
+
+// requestRoles carries the caller's roles from AuthMiddleware back out to
+// Metrics, which wraps it
+type requestRoles struct {
+	roles []string
+}
+
+type requestRolesKey struct{}
+
+// label returns the roles as a metric label: sorted and comma separated, or
+// "none" for unauthenticated requests
+func (r *requestRoles) label() string {
+	if len(r.roles) == 0 {
+		return "none"
+	}
+	roles := append([]string(nil), r.roles...)
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+// setRequestRoles records the authenticated caller's roles for Metrics
+func setRequestRoles(ctx context.Context, roles []string) {
+	if holder, ok := ctx.Value(requestRolesKey{}).(*requestRoles); ok {
+		holder.roles = roles
+	}
+}
+
+// Metrics returns middleware counting requests to route and recording their
+// latency, by status and caller role. It must wrap AuthMiddleware to see the
+// caller's roles.
+func Metrics(route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			holder := &requestRoles{}
+			sw := &statusWriter{ResponseWriter: w}
+
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestRolesKey{}, holder)))
+
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			labels := []string{route, strconv.Itoa(status), holder.label()}
+			metrics.HTTPRequests.Inc(labels...)
+			metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), labels...)
+		})
+	}
+}
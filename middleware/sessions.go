@@ -0,0 +1,317 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// errInvalidRefreshToken means the refresh token is unknown or expired
+	errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// errRefreshTokenReused means a refresh token was presented again after
+	// it had been rotated, so it has probably been stolen
+	errRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// maxUsedHashes bounds the rotated-out refresh tokens remembered per
+// session. Older ones are merely invalid rather than revoking the session.
+const maxUsedHashes = 50
+
+// session is one login. Its refresh token is rotated on every use, and the
+// access tokens issued for it are remembered until they expire so that
+// revoking the session revokes them too.
+type session struct {
+	ID           string               `json:"id"`
+	UserID       string               `json:"userId"`
+	RefreshHash  string               `json:"refreshHash"` // SHA-256 of the current refresh token
+	UsedHashes   []string             `json:"usedHashes"`  // refresh tokens rotated out, to detect reuse
+	AccessTokens map[string]time.Time `json:"accessTokens"`
+	CreatedAt    time.Time            `json:"createdAt"`
+	ExpiresAt    time.Time            `json:"expiresAt"` // of the current refresh token
+}
+
+// sessionState is the content of the session file
+type sessionState struct {
+	Sessions []*session           `json:"sessions"`
+	Denylist map[string]time.Time `json:"denylist"` // revoked token IDs and when the tokens expire
+}
+
+// sessionStore keeps sessions and the token denylist, persisted to a file
+// so revocations survive restarts
+type sessionStore struct {
+	path            string
+	refreshDuration time.Duration
+	saveMu          sync.Mutex // serializes writes of the session file
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	refreshes map[string]string // refresh token hash -> session ID, current and used
+	denylist  map[string]time.Time
+}
+
+// newSessionStore loads the sessions in path. A missing file, or an empty
+// path for a store kept in memory only, starts with no sessions.
+func newSessionStore(path string, refreshDuration time.Duration) (*sessionStore, error) {
+	s := &sessionStore{
+		path:            path,
+		refreshDuration: refreshDuration,
+		sessions:        make(map[string]*session),
+		refreshes:       make(map[string]string),
+		denylist:        make(map[string]time.Time),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading sessions: %w", err)
+	}
+	var st sessionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("error parsing sessions %s: %w", path, err)
+	}
+	for _, sess := range st.Sessions {
+		s.add(sess)
+	}
+	for id, exp := range st.Denylist {
+		s.denylist[id] = exp
+	}
+	s.prune(time.Now())
+	return s, nil
+}
+
+// add indexes sess
+func (s *sessionStore) add(sess *session) {
+	if sess.AccessTokens == nil {
+		sess.AccessTokens = make(map[string]time.Time)
+	}
+	s.sessions[sess.ID] = sess
+	s.refreshes[sess.RefreshHash] = sess.ID
+	for _, hash := range sess.UsedHashes {
+		s.refreshes[hash] = sess.ID
+	}
+}
+
+// remove drops sess and denylists the access tokens issued for it
+func (s *sessionStore) remove(sess *session) {
+	for id, exp := range sess.AccessTokens {
+		s.denylist[id] = exp
+	}
+	s.forget(sess)
+}
+
+// forget drops sess without revoking its access tokens
+func (s *sessionStore) forget(sess *session) {
+	delete(s.refreshes, sess.RefreshHash)
+	for _, hash := range sess.UsedHashes {
+		delete(s.refreshes, hash)
+	}
+	delete(s.sessions, sess.ID)
+}
+
+// create starts a session for userID, returning its ID and first refresh
+// token
+func (s *sessionStore) create(userID string) (string, string, time.Time, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	now := time.Now()
+	sess := &session{
+		ID:          id,
+		UserID:      userID,
+		RefreshHash: hashToken(refresh),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.refreshDuration),
+	}
+	s.mu.Lock()
+	s.add(sess)
+	s.mu.Unlock()
+	s.save()
+	return id, refresh, sess.ExpiresAt, nil
+}
+
+// rotate exchanges a refresh token for a new one, returning the session's
+// ID and user. Presenting a token that was already rotated out revokes the
+// whole session; the user is still returned, for logging.
+func (s *sessionStore) rotate(refresh string) (id, userID, next string, expiresAt time.Time, err error) {
+	if next, err = randomToken(32); err != nil {
+		return "", "", "", time.Time{}, err
+	}
+	hash := hashToken(refresh)
+	now := time.Now()
+
+	s.mu.Lock()
+	sess, ok := s.sessions[s.refreshes[hash]]
+	if !ok || now.After(sess.ExpiresAt) {
+		s.mu.Unlock()
+		return "", "", "", time.Time{}, errInvalidRefreshToken
+	}
+	if sess.RefreshHash != hash {
+		s.remove(sess)
+		s.mu.Unlock()
+		s.save()
+		return "", sess.UserID, "", time.Time{}, errRefreshTokenReused
+	}
+	sess.UsedHashes = append(sess.UsedHashes, sess.RefreshHash)
+	if len(sess.UsedHashes) > maxUsedHashes {
+		delete(s.refreshes, sess.UsedHashes[0])
+		sess.UsedHashes = sess.UsedHashes[1:]
+	}
+	sess.RefreshHash = hashToken(next)
+	sess.ExpiresAt = now.Add(s.refreshDuration)
+	s.refreshes[sess.RefreshHash] = sess.ID
+	id, userID, expiresAt = sess.ID, sess.UserID, sess.ExpiresAt
+	s.mu.Unlock()
+
+	s.save()
+	return id, userID, next, expiresAt, nil
+}
+
+// recordAccessToken remembers an access token issued for a session
+func (s *sessionStore) recordAccessToken(sessionID, tokenID string, expiresAt time.Time) {
+	s.mu.Lock()
+	if sess, ok := s.sessions[sessionID]; ok {
+		sess.AccessTokens[tokenID] = expiresAt
+	}
+	s.mu.Unlock()
+	s.save()
+}
+
+// revokeSession ends a session and revokes its access tokens
+func (s *sessionStore) revokeSession(sessionID string) {
+	s.mu.Lock()
+	if sess, ok := s.sessions[sessionID]; ok {
+		s.remove(sess)
+	}
+	s.mu.Unlock()
+	s.save()
+}
+
+// revokeUser ends every session of userID, returning how many there were.
+// Only access tokens issued for a session are revoked.
+func (s *sessionStore) revokeUser(userID string) int {
+	s.mu.Lock()
+	n := 0
+	for _, sess := range s.sessions {
+		if sess.UserID == userID {
+			s.remove(sess)
+			n++
+		}
+	}
+	s.mu.Unlock()
+	s.save()
+	return n
+}
+
+// revokeToken denylists a single access token until it expires
+func (s *sessionStore) revokeToken(tokenID string, expiresAt time.Time) {
+	s.mu.Lock()
+	s.denylist[tokenID] = expiresAt
+	s.mu.Unlock()
+	s.save()
+}
+
+// isRevoked reports whether the access token with ID tokenID is denylisted
+func (s *sessionStore) isRevoked(tokenID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.denylist[tokenID]
+	return ok
+}
+
+// prune forgets expired sessions, expired access tokens and denylist
+// entries of tokens that have expired anyway. Call with s.mu held.
+func (s *sessionStore) prune(now time.Time) {
+	for id, exp := range s.denylist {
+		if now.After(exp) {
+			delete(s.denylist, id)
+		}
+	}
+	for _, sess := range s.sessions {
+		for id, exp := range sess.AccessTokens {
+			if now.After(exp) {
+				delete(sess.AccessTokens, id)
+			}
+		}
+		if now.After(sess.ExpiresAt) {
+			s.forget(sess)
+		}
+	}
+}
+
+// save prunes the store and writes the sessions and the denylist to the
+// session file
+func (s *sessionStore) save() {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	s.prune(time.Now())
+	if s.path == "" {
+		s.mu.Unlock()
+		return
+	}
+	st := sessionState{Sessions: make([]*session, 0, len(s.sessions)), Denylist: s.denylist}
+	for _, sess := range s.sessions {
+		st.Sessions = append(st.Sessions, sess)
+	}
+	// Marshal under the lock, since the state shares the store's maps
+	data, err := json.MarshalIndent(st, "", "  ")
+	s.mu.Unlock()
+
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		logger.Error("Failed to save sessions: %v", err)
+	}
+}
+
+// writeFileAtomic writes data to path through a temporary file, so a crash
+// never leaves a truncated file behind. The file holds token hashes, so
+// only the owner may read it.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// randomToken returns n random bytes, base64url encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a token, which is how refresh tokens
+// are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
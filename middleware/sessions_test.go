@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// testConfig has the users alice, an admin, and bob, a viewer, whose
+// passwords are their names
+const testConfig = `
+logging:
+  directory: %s
+auth:
+  roles:
+    admin:
+      permissions: ["*"]
+    viewer:
+      permissions: [services:list, services:status, logs:read]
+  users:
+    alice:
+      username: alice
+      password: alice
+      roles: [admin]
+    bob:
+      username: bob
+      password: bob
+      roles: [viewer]
+`
+
+// setupTestAuth loads testConfig and initializes authentication with
+// sessions kept in a file, returning the file's path
+func setupTestAuth(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(testConfig, dir)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.LoadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	sessionFile := filepath.Join(dir, "sessions.json")
+	InitAuth(AuthConfig{
+		SecretKey:            "test-secret",
+		TokenDuration:        time.Minute,
+		RefreshTokenDuration: time.Hour,
+		SessionFile:          sessionFile,
+		APIKeyFile:           filepath.Join(dir, "apikeys.json"),
+	})
+	t.Cleanup(func() { logger.Close() })
+	return sessionFile
+}
+
+// post sends body to handler behind AuthMiddleware when token is set,
+// returning the status and the response's data
+func post(t *testing.T, handler http.HandlerFunc, path, token string, body any) (int, json.RawMessage) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(data)))
+	var h http.Handler = handler
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		h = AuthMiddleware(handler)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp.Data
+}
+
+// login logs user in, whose password is their name
+func login(t *testing.T, user string) LoginResponse {
+	t.Helper()
+	status, data := post(t, HandleLogin, "/auth/login", "", LoginRequest{Username: user, Password: user})
+	if status != http.StatusOK {
+		t.Fatalf("login of %s status = %d", user, status)
+	}
+	var resp LoginResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// refresh exchanges a refresh token, returning the status and the new tokens
+func refresh(t *testing.T, token string) (int, LoginResponse) {
+	t.Helper()
+	status, data := post(t, HandleRefresh, "/auth/refresh", "", RefreshRequest{RefreshToken: token})
+	var resp LoginResponse
+	if status == http.StatusOK {
+		if err := json.Unmarshal(data, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return status, resp
+}
+
+// accepted reports whether AuthMiddleware lets an access token through
+func accepted(token string) bool {
+	req := httptest.NewRequest(http.MethodGet, "/services", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+	return rec.Code == http.StatusOK
+}
+
+func TestRefreshRotation(t *testing.T) {
+	setupTestAuth(t)
+	first := login(t, "alice")
+
+	status, second := refresh(t, first.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("refresh status = %d", status)
+	}
+	if second.RefreshToken == first.RefreshToken || second.Token == first.Token {
+		t.Fatal("refresh did not rotate the tokens")
+	}
+	if !accepted(first.Token) || !accepted(second.Token) {
+		t.Fatal("access tokens rejected before the refresh token was reused")
+	}
+
+	// Reusing a rotated refresh token revokes the whole session
+	if status, _ := refresh(t, first.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("reused refresh token status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, _ := refresh(t, second.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("current refresh token status after reuse = %d, want %d", status, http.StatusUnauthorized)
+	}
+	for _, token := range []string{first.Token, second.Token} {
+		if accepted(token) {
+			t.Error("access token of a revoked session accepted")
+		}
+	}
+}
+
+func TestLogoutDenylistsToken(t *testing.T) {
+	setupTestAuth(t)
+	other := login(t, "alice")
+
+	tests := []struct {
+		name  string
+		token func() (access, refresh string)
+	}{
+		{"session token", func() (string, string) {
+			resp := login(t, "alice")
+			return resp.Token, resp.RefreshToken
+		}},
+		{"token without a session", func() (string, string) {
+			token, err := CreateToken("alice", []string{"admin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token, ""
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			access, refreshToken := tt.token()
+			if status, _ := post(t, HandleLogout, "/auth/logout", access, nil); status != http.StatusOK {
+				t.Fatalf("logout status = %d", status)
+			}
+
+			claims, err := validateToken(access)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sessions.isRevoked(claims.ID) {
+				t.Errorf("jti %s not on the denylist", claims.ID)
+			}
+			if accepted(access) {
+				t.Error("access token accepted after logout")
+			}
+			if refreshToken != "" {
+				if status, _ := refresh(t, refreshToken); status != http.StatusUnauthorized {
+					t.Errorf("refresh after logout status = %d, want %d", status, http.StatusUnauthorized)
+				}
+			}
+		})
+	}
+
+	// Other sessions of the same user are not affected
+	if !accepted(other.Token) {
+		t.Error("another session's token rejected after logout")
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	setupTestAuth(t)
+	admin := login(t, "alice")
+	first, second := login(t, "bob"), login(t, "bob")
+
+	status, data := post(t, HandleRevokeSessions, "/auth/revoke/bob", admin.Token, nil)
+	if status != http.StatusOK || string(data) != `{"revoked":2}` {
+		t.Fatalf("revoke = %d %s, want 2 sessions revoked", status, data)
+	}
+	for _, resp := range []LoginResponse{first, second} {
+		if accepted(resp.Token) {
+			t.Error("access token of a revoked user accepted")
+		}
+		if status, _ := refresh(t, resp.RefreshToken); status != http.StatusUnauthorized {
+			t.Errorf("refresh of a revoked user status = %d", status)
+		}
+	}
+	if !accepted(admin.Token) {
+		t.Error("another user's token rejected")
+	}
+}
+
+func TestRevocationSurvivesReload(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, resp LoginResponse)
+	}{
+		{"logout", func(t *testing.T, resp LoginResponse) {
+			post(t, HandleLogout, "/auth/logout", resp.Token, nil)
+		}},
+		{"revoke user", func(t *testing.T, resp LoginResponse) {
+			sessions.revokeUser("bob")
+		}},
+		{"refresh token reuse", func(t *testing.T, resp LoginResponse) {
+			refresh(t, resp.RefreshToken)
+			refresh(t, resp.RefreshToken)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionFile := setupTestAuth(t)
+			resp := login(t, "bob")
+			tt.revoke(t, resp)
+
+			// Reload the sessions as a restart would
+			reloaded, err := newSessionStore(sessionFile, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessions = reloaded
+
+			if accepted(resp.Token) {
+				t.Error("revoked access token accepted after reloading")
+			}
+			if status, _ := refresh(t, resp.RefreshToken); status != http.StatusUnauthorized {
+				t.Errorf("revoked refresh token status after reloading = %d", status)
+			}
+		})
+	}
+}
+
+func TestMaxUsedHashes(t *testing.T) {
+	s, err := newSessionStore("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, token, _, err := s.create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := []string{token}
+	for i := 0; i <= maxUsedHashes; i++ {
+		_, _, next, _, err := s.rotate(tokens[len(tokens)-1])
+		if err != nil {
+			t.Fatalf("rotation %d: %v", i+1, err)
+		}
+		tokens = append(tokens, next)
+	}
+	if n := len(s.sessions[id].UsedHashes); n != maxUsedHashes {
+		t.Fatalf("%d used hashes remembered, want %d", n, maxUsedHashes)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		// The oldest token has been forgotten, so it is merely invalid
+		{"forgotten token", tokens[0], errInvalidRefreshToken},
+		{"unknown token", "not-a-token", errInvalidRefreshToken},
+		{"remembered token", tokens[1], errRefreshTokenReused},
+	}
+	for _, tt := range tests {
+		_, _, _, _, err := s.rotate(tt.token)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("rotate(%s) error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr == errInvalidRefreshToken {
+			if _, ok := s.sessions[id]; !ok {
+				t.Errorf("rotate(%s) revoked the session", tt.name)
+			}
+		}
+	}
+	if _, ok := s.sessions[id]; ok {
+		t.Error("session not revoked after a remembered token was reused")
+	}
+}
@@ -0,0 +1,401 @@
+package probes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// Probe types
+const (
+	TypeHTTP = "http"
+	TypeTCP  = "tcp"
+	TypeExec = "exec"
+)
+
+// Probe and service health states
+const (
+	StateUnknown   = "unknown"
+	StateHealthy   = "healthy"
+	StateUnhealthy = "unhealthy"
+)
+
+const (
+	defaultInterval         = 30 * time.Second
+	defaultTimeout          = 5 * time.Second
+	defaultFailureThreshold = 3
+	defaultSuccessThreshold = 1
+	// maxBody bounds the HTTP response body matched against ExpectBody
+	maxBody = 1 << 20
+	// maxMessage bounds the command output kept in a result message
+	maxMessage = 256
+)
+
+// probe is a configured probe ready to run
+type probe struct {
+	service          string
+	name             string
+	kind             string
+	check            func(ctx context.Context) (string, error)
+	interval         time.Duration
+	timeout          time.Duration
+	failureThreshold int
+	successThreshold int
+
+	result services.ProbeResult // guarded by Prober.mu
+}
+
+// Prober runs the health probes attached to services in the configuration
+// and keeps the latest result of each. Every probe runs on its own interval
+// in the background.
+type Prober struct {
+	logger *utils.Logger
+
+	mu     sync.Mutex
+	probes map[string][]*probe // keyed by unit base name
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New validates the probes of the enabled services in configured and
+// creates a prober for them
+func New(configured map[string]utils.Service, logger *utils.Logger) (*Prober, error) {
+	p := &Prober{logger: logger, probes: make(map[string][]*probe)}
+	for name, service := range configured {
+		if !service.Enabled {
+			continue
+		}
+		for i, c := range service.Probes {
+			pr, err := newProbe(name, i, c)
+			if err != nil {
+				return nil, fmt.Errorf("probe %d of %s: %w", i+1, name, err)
+			}
+			base := services.UnitBaseName(name)
+			p.probes[base] = append(p.probes[base], pr)
+		}
+	}
+	return p, nil
+}
+
+// newProbe validates c, the probe at index i of service, and fills in
+// defaults
+func newProbe(service string, i int, c utils.Probe) (*probe, error) {
+	p := &probe{
+		service:          service,
+		name:             c.Name,
+		kind:             c.Type,
+		interval:         defaultInterval,
+		timeout:          defaultTimeout,
+		failureThreshold: defaultFailureThreshold,
+		successThreshold: defaultSuccessThreshold,
+	}
+	if p.name == "" {
+		p.name = fmt.Sprintf("%s-%d", c.Type, i+1)
+	}
+
+	switch c.Type {
+	case TypeHTTP:
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url %q", c.URL)
+		}
+		var body *regexp.Regexp
+		if c.ExpectBody != "" {
+			if body, err = regexp.Compile(c.ExpectBody); err != nil {
+				return nil, fmt.Errorf("invalid expectBody: %v", err)
+			}
+		}
+		p.check = httpCheck(c.URL, c.ExpectStatus, body)
+	case TypeTCP:
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return nil, fmt.Errorf("invalid address %q", c.Address)
+		}
+		p.check = tcpCheck(c.Address)
+	case TypeExec:
+		if len(c.Command) == 0 || c.Command[0] == "" {
+			return nil, errors.New("command is required")
+		}
+		p.check = execCheck(c.Command, c.ExpectExitCode)
+	default:
+		return nil, fmt.Errorf("unknown type %q", c.Type)
+	}
+
+	for _, t := range []struct {
+		value int
+		field *int
+		name  string
+	}{
+		{c.FailureThreshold, &p.failureThreshold, "failureThreshold"},
+		{c.SuccessThreshold, &p.successThreshold, "successThreshold"},
+	} {
+		if t.value < 0 {
+			return nil, fmt.Errorf("invalid %s %d", t.name, t.value)
+		} else if t.value > 0 {
+			*t.field = t.value
+		}
+	}
+	for _, d := range []struct {
+		value string
+		field *time.Duration
+		name  string
+	}{
+		{c.Interval, &p.interval, "interval"},
+		{c.Timeout, &p.timeout, "timeout"},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.field = parsed
+	}
+
+	p.result = services.ProbeResult{Name: p.name, Type: p.kind, State: StateUnknown}
+	return p, nil
+}
+
+// httpCheck fetches rawURL and checks the response status and body
+func httpCheck(rawURL string, expectStatus int, body *regexp.Regexp) func(context.Context) (string, error) {
+	client := &http.Client{
+		// A redirect is an answer in its own right, not something to follow
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return func(ctx context.Context) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("User-Agent", "ChronoServe/"+utils.Version)
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if expectStatus != 0 && resp.StatusCode != expectStatus {
+			return "", fmt.Errorf("status %s, expected %d", resp.Status, expectStatus)
+		}
+		if expectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 399) {
+			return "", fmt.Errorf("status %s", resp.Status)
+		}
+		if body != nil {
+			data, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+			if err != nil {
+				return "", fmt.Errorf("reading body: %w", err)
+			}
+			if !body.Match(data) {
+				return "", fmt.Errorf("body does not match %q", body)
+			}
+		}
+		return "status " + resp.Status, nil
+	}
+}
+
+// tcpCheck connects to address
+func tcpCheck(address string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "connected to " + address, nil
+	}
+}
+
+// execCheck runs command and checks its exit code
+func execCheck(command []string, expectExitCode int) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.WaitDelay = time.Second
+		output, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return "", err
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		code := cmd.ProcessState.ExitCode()
+		out := truncate(strings.TrimSpace(string(output)))
+		if code != expectExitCode {
+			msg := fmt.Sprintf("exit code %d, expected %d", code, expectExitCode)
+			if out != "" {
+				msg += ": " + out
+			}
+			return "", errors.New(msg)
+		}
+		return fmt.Sprintf("exit code %d", code), nil
+	}
+}
+
+// truncate shortens s to maxMessage bytes
+func truncate(s string) string {
+	if len(s) <= maxMessage {
+		return s
+	}
+	return s[:maxMessage] + "..."
+}
+
+// Start runs every probe in the background
+func (p *Prober) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	count := 0
+	for _, list := range p.probes {
+		for _, pr := range list {
+			p.wg.Add(1)
+			go p.run(ctx, pr)
+			count++
+		}
+	}
+	if count > 0 {
+		p.logger.Info("Running %d health probes for %d services", count, len(p.probes))
+	}
+}
+
+// Stop stops every probe and waits for checks in progress to finish
+func (p *Prober) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+}
+
+// run checks pr immediately and then on its interval
+func (p *Prober) run(ctx context.Context, pr *probe) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(pr.interval)
+	defer ticker.Stop()
+	for {
+		p.runOnce(ctx, pr)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce runs a single check of pr and records its result
+func (p *Prober) runOnce(ctx context.Context, pr *probe) {
+	checkCtx, cancel := context.WithTimeout(ctx, pr.timeout)
+	start := time.Now()
+	message, err := pr.check(checkCtx)
+	elapsed := time.Since(start)
+	if err != nil && errors.Is(checkCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", pr.timeout)
+	}
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down: the check was interrupted, not failed
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := &pr.result
+	r.LastCheck = &start
+	r.LastDuration = elapsed.Round(time.Millisecond).String()
+	previous := r.State
+	if err != nil {
+		r.Message = err.Error()
+		r.ConsecutiveFailures++
+		r.ConsecutiveSuccesses = 0
+		if r.ConsecutiveFailures >= pr.failureThreshold {
+			r.State = StateUnhealthy
+		}
+	} else {
+		r.Message = message
+		r.ConsecutiveSuccesses++
+		r.ConsecutiveFailures = 0
+		if r.ConsecutiveSuccesses >= pr.successThreshold {
+			r.State = StateHealthy
+		}
+	}
+
+	if r.State != previous {
+		if r.State == StateUnhealthy {
+			p.logger.Warn("Probe %s of %s is unhealthy: %s", pr.name, pr.service, r.Message)
+		} else {
+			p.logger.Info("Probe %s of %s is %s", pr.name, pr.service, r.State)
+		}
+	}
+}
+
+// Health returns the probe verdict for the named service and the results of
+// its probes. ok is false if the service has no probes.
+func (p *Prober) Health(name string) (state string, results []services.ProbeResult, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list, ok := p.probes[services.UnitBaseName(name)]
+	if !ok {
+		return "", nil, false
+	}
+	results = snapshot(list)
+	return verdict(results), results, true
+}
+
+// snapshot copies the results of list. Callers hold Prober.mu.
+func snapshot(list []*probe) []services.ProbeResult {
+	results := make([]services.ProbeResult, len(list))
+	for i, pr := range list {
+		results[i] = pr.result
+	}
+	return results
+}
+
+// verdict combines probe results: unhealthy if any probe is, healthy if all
+// are, unknown otherwise
+func verdict(results []services.ProbeResult) string {
+	state := StateHealthy
+	for _, r := range results {
+		switch r.State {
+		case StateUnhealthy:
+			return StateUnhealthy
+		case StateUnknown:
+			state = StateUnknown
+		}
+	}
+	return state
+}
+
+// Len returns the number of services with probes
+func (p *Prober) Len() int {
+	return len(p.probes)
+}
+
+// Check is a utils.HealthCheckFunc that fails while any probed service is
+// unhealthy
+func (p *Prober) Check() (bool, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	unhealthy := 0
+	for _, list := range p.probes {
+		if verdict(snapshot(list)) == StateUnhealthy {
+			unhealthy++
+		}
+	}
+	return unhealthy == 0, fmt.Sprintf("%d of %d probed services unhealthy", unhealthy, len(p.probes))
+}
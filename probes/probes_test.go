@@ -0,0 +1,274 @@
+package probes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+func TestHTTPCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, `{"status":"up"}`) })
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "down", http.StatusInternalServerError) })
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/down", http.StatusFound) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		expectStatus int
+		expectBody   string
+		wantErr      string
+	}{
+		{name: "2xx", path: "/ok"},
+		{name: "5xx", path: "/down", wantErr: "status 500 Internal Server Error"},
+		{name: "redirect is not followed", path: "/moved"},
+		{name: "expected status", path: "/empty", expectStatus: http.StatusNoContent},
+		{name: "unexpected status", path: "/ok", expectStatus: http.StatusNoContent, wantErr: "status 200 OK, expected 204"},
+		{name: "expected 5xx", path: "/down", expectStatus: http.StatusInternalServerError},
+		{name: "body matches", path: "/ok", expectBody: `"status":\s*"up"`},
+		{name: "body does not match", path: "/ok", expectBody: `"status":"down"`, wantErr: "body does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body *regexp.Regexp
+			if tt.expectBody != "" {
+				body = regexp.MustCompile(tt.expectBody)
+			}
+			message, err := httpCheck(server.URL+tt.path, tt.expectStatus, body)(context.Background())
+			checkResult(t, message, err, tt.wantErr)
+		})
+	}
+}
+
+func TestTCPCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+
+	message, err := tcpCheck(address)(context.Background())
+	checkResult(t, message, err, "")
+	if message != "connected to "+address {
+		t.Errorf("message = %q", message)
+	}
+
+	listener.Close()
+	_, err = tcpCheck(address)(context.Background())
+	checkResult(t, "", err, "connection refused")
+}
+
+func TestExecCheck(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	tests := []struct {
+		name     string
+		script   string
+		expected int
+		wantErr  string
+	}{
+		{name: "exit 0", script: "exit 0"},
+		{name: "expected non-zero", script: "exit 3", expected: 3},
+		{name: "unexpected exit code", script: "echo degraded; exit 2", wantErr: "exit code 2, expected 0: degraded"},
+		{name: "output truncated", script: "head -c 300 /dev/zero | tr '\\0' x; exit 1", wantErr: "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := execCheck([]string{"sh", "-c", tt.script}, tt.expected)(context.Background())
+			checkResult(t, message, err, tt.wantErr)
+			if err != nil && len(err.Error()) > maxMessage+64 {
+				t.Errorf("message not truncated: %d bytes", len(err.Error()))
+			}
+		})
+	}
+
+	_, err := execCheck([]string{"/nonexistent/probe"}, 0)(context.Background())
+	checkResult(t, "", err, "no such file")
+}
+
+func TestRunOnceTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	p, pr := newTestProber(t, utils.Probe{Type: TypeExec, Command: []string{"sleep", "10"}, Timeout: "50ms", FailureThreshold: 1})
+
+	start := time.Now()
+	p.runOnce(context.Background(), pr)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("check ran for %s", elapsed)
+	}
+	_, results, _ := p.Health("app")
+	if results[0].State != StateUnhealthy || results[0].Message != "timed out after 50ms" {
+		t.Errorf("result = %+v", results[0])
+	}
+}
+
+func TestRunOnceThresholds(t *testing.T) {
+	p, pr := newTestProber(t, utils.Probe{Type: TypeTCP, Address: "127.0.0.1:1", FailureThreshold: 2, SuccessThreshold: 2})
+
+	steps := []struct {
+		fail bool
+		want string
+	}{
+		{false, StateUnknown}, // one success is below the success threshold
+		{false, StateHealthy},
+		{true, StateHealthy}, // one failure is below the failure threshold
+		{false, StateHealthy},
+		{true, StateHealthy},
+		{true, StateUnhealthy},
+		{false, StateUnhealthy},
+		{true, StateUnhealthy},
+		{false, StateUnhealthy},
+		{false, StateHealthy},
+	}
+	for i, step := range steps {
+		pr.check = func(context.Context) (string, error) {
+			if step.fail {
+				return "", errors.New("refused")
+			}
+			return "ok", nil
+		}
+		p.runOnce(context.Background(), pr)
+
+		state, results, ok := p.Health("app.service")
+		if !ok {
+			t.Fatal("Health() found no probes")
+		}
+		if state != step.want || results[0].State != step.want {
+			t.Fatalf("step %d: state = %s, probe state = %s, want %s", i+1, state, results[0].State, step.want)
+		}
+		if results[0].LastCheck == nil {
+			t.Errorf("step %d: LastCheck not set", i+1)
+		}
+	}
+}
+
+func TestRunOnceShuttingDown(t *testing.T) {
+	p, pr := newTestProber(t, utils.Probe{Type: TypeTCP, Address: "127.0.0.1:1", FailureThreshold: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	pr.check = func(context.Context) (string, error) {
+		cancel()
+		return "", context.Canceled
+	}
+	p.runOnce(ctx, pr)
+
+	if state, _, _ := p.Health("app"); state != StateUnknown {
+		t.Errorf("state = %s after an interrupted check, want %s", state, StateUnknown)
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	tests := []struct {
+		states []string
+		want   string
+	}{
+		{[]string{StateHealthy}, StateHealthy},
+		{[]string{StateHealthy, StateHealthy}, StateHealthy},
+		{[]string{StateHealthy, StateUnknown}, StateUnknown},
+		{[]string{StateUnknown, StateUnhealthy}, StateUnhealthy},
+		{[]string{StateHealthy, StateUnhealthy}, StateUnhealthy},
+	}
+	for _, tt := range tests {
+		results := make([]services.ProbeResult, len(tt.states))
+		for i, state := range tt.states {
+			results[i].State = state
+		}
+		if got := verdict(results); got != tt.want {
+			t.Errorf("verdict(%v) = %s, want %s", tt.states, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, pr := newTestProber(t, utils.Probe{Type: TypeTCP, Address: "127.0.0.1:1", FailureThreshold: 1})
+	if ok, _ := p.Check(); !ok {
+		t.Error("Check() failed before any probe ran")
+	}
+
+	pr.check = func(context.Context) (string, error) { return "", errors.New("refused") }
+	p.runOnce(context.Background(), pr)
+	if ok, message := p.Check(); ok || message != "1 of 1 probed services unhealthy" {
+		t.Errorf("Check() = %v, %q", ok, message)
+	}
+}
+
+func TestNewProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		probe   utils.Probe
+		wantErr string
+	}{
+		{name: "http", probe: utils.Probe{Type: TypeHTTP, URL: "http://127.0.0.1:8080/health"}},
+		{name: "http without scheme", probe: utils.Probe{Type: TypeHTTP, URL: "127.0.0.1:8080"}, wantErr: "invalid url"},
+		{name: "http bad body", probe: utils.Probe{Type: TypeHTTP, URL: "http://host/", ExpectBody: "("}, wantErr: "invalid expectBody"},
+		{name: "tcp without port", probe: utils.Probe{Type: TypeTCP, Address: "localhost"}, wantErr: "invalid address"},
+		{name: "exec without command", probe: utils.Probe{Type: TypeExec}, wantErr: "command is required"},
+		{name: "unknown type", probe: utils.Probe{Type: "grpc"}, wantErr: "unknown type"},
+		{name: "negative threshold", probe: utils.Probe{Type: TypeTCP, Address: "host:1", FailureThreshold: -1}, wantErr: "invalid failureThreshold"},
+		{name: "bad interval", probe: utils.Probe{Type: TypeTCP, Address: "host:1", Interval: "0s"}, wantErr: "invalid interval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, err := newProbe("app", 0, tt.probe)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newProbe() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pr.name != tt.probe.Type+"-1" || pr.interval != defaultInterval || pr.failureThreshold != defaultFailureThreshold {
+				t.Errorf("defaults not filled in: %+v", pr)
+			}
+		})
+	}
+}
+
+// newTestProber creates a prober with c as the only probe of app and returns
+// it with that probe
+func newTestProber(t *testing.T, c utils.Probe) (*Prober, *probe) {
+	t.Helper()
+	logger, err := utils.NewLogger(utils.LoggerOptions{Level: utils.ERROR, Directory: t.TempDir(), Filename: "test.log", MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+
+	p, err := New(map[string]utils.Service{"app": {Enabled: true, Probes: []utils.Probe{c}}}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, p.probes["app"][0]
+}
+
+// checkResult fails t unless err contains wantErr, or is nil with a message
+// when wantErr is empty
+func checkResult(t *testing.T, message string, err error, wantErr string) {
+	t.Helper()
+	if wantErr == "" {
+		if err != nil || message == "" {
+			t.Errorf("check = %q, %v, want success", message, err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("check error = %v, want %q", err, wantErr)
+	}
+}
@@ -0,0 +1,235 @@
+package rbac
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// Permissions that are not about a single service action. Each service
+// action has a "services:<action>" permission, see ServicePermission.
+const (
+	ServicesList    = "services:list"
+	ServicesStatus  = "services:status"
+	LogsRead        = "logs:read"
+	SchedulesRead   = "schedules:read"
+	SchedulesWrite  = "schedules:write"
+	WatchdogRead    = "watchdog:read"
+	WatchdogRelease = "watchdog:release"
+	NodesRead       = "nodes:read"
+	SessionsRevoke  = "sessions:revoke"
+	APIKeysRead     = "apikeys:read"
+	APIKeysWrite    = "apikeys:write"
+	PolicyRead      = "policy:read"
+)
+
+// servicePrefix starts the permission of each service action
+const servicePrefix = "services:"
+
+// serviceActions are the actions that change a service
+var serviceActions = []string{
+	"start", "stop", "restart", "reload", "reload-or-restart",
+	"enable", "disable", "mask", "unmask",
+}
+
+// Permissions returns every permission a role can be granted
+func Permissions() []string {
+	perms := []string{ServicesList, ServicesStatus, LogsRead}
+	for _, action := range serviceActions {
+		perms = append(perms, servicePrefix+action)
+	}
+	return append(perms, SchedulesRead, SchedulesWrite, WatchdogRead, WatchdogRelease,
+		NodesRead, SessionsRevoke, APIKeysRead, APIKeysWrite, PolicyRead)
+}
+
+// IsPermission reports whether perm is a known permission
+func IsPermission(perm string) bool {
+	return slices.Contains(Permissions(), perm)
+}
+
+// ServicePermission returns the permission needed to perform op on a
+// service: "list", "status", "logs" or "follow", or one of the actions
+func ServicePermission(op string) string {
+	switch op {
+	case "list":
+		return ServicesList
+	case "status":
+		return ServicesStatus
+	case "logs", "follow":
+		return LogsRead
+	default:
+		return servicePrefix + op
+	}
+}
+
+// ServiceAction returns the service action perm allows, if it is the
+// permission of a service action
+func ServiceAction(perm string) (string, bool) {
+	action, ok := strings.CutPrefix(perm, servicePrefix)
+	if !ok || !slices.Contains(serviceActions, action) {
+		return "", false
+	}
+	return action, true
+}
+
+// MatchService reports whether the service name matches one of patterns.
+// Names match with or without the ".service" suffix, so "nginx" matches
+// "nginx.service" and vice versa.
+func MatchService(patterns []string, name string) bool {
+	alias := name + ".service"
+	if trimmed, ok := strings.CutSuffix(name, ".service"); ok {
+		alias = trimmed
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, alias); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy decides what the roles defined in the configuration may do
+type Policy struct {
+	roles map[string]utils.RoleConfig
+}
+
+// New builds the policy of roles, checking that every permission pattern
+// matches a known permission and every service pattern is valid
+func New(roles map[string]utils.RoleConfig) (*Policy, error) {
+	known := Permissions()
+	for name, role := range roles {
+		for _, pattern := range role.Permissions {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("role %s: invalid permission %q", name, pattern)
+			}
+			if !slices.ContainsFunc(known, func(perm string) bool { return matchPermission(pattern, perm) }) {
+				return nil, fmt.Errorf("role %s: unknown permission %q", name, pattern)
+			}
+		}
+		for _, pattern := range role.Services {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return nil, fmt.Errorf("role %s: invalid service pattern %q", name, pattern)
+			}
+		}
+	}
+	return &Policy{roles: roles}, nil
+}
+
+// matchPermission reports whether the permission pattern, such as
+// "services:*" or "*", grants perm
+func matchPermission(pattern, perm string) bool {
+	ok, _ := path.Match(pattern, perm)
+	return ok
+}
+
+// grant returns the pattern by which role grants perm, if it does
+func grant(role utils.RoleConfig, perm string) (string, bool) {
+	for _, pattern := range role.Permissions {
+		if matchPermission(pattern, perm) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// covers reports whether role's permissions apply to service. Roles without
+// service patterns apply to every service, and any role applies when no
+// particular service is asked about.
+func covers(role utils.RoleConfig, service string) bool {
+	return service == "" || len(role.Services) == 0 || MatchService(role.Services, service)
+}
+
+// Allowed reports whether any of roles grants perm on service. With an
+// empty service, it reports whether roles grant perm on some service.
+func (p *Policy) Allowed(roles []string, perm, service string) bool {
+	for _, name := range roles {
+		role, ok := p.roles[name]
+		if !ok {
+			continue
+		}
+		if _, ok := grant(role, perm); ok && covers(role, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleExplanation is what a single role contributes to a decision
+type RoleExplanation struct {
+	Role              string   `json:"role"`
+	Defined           bool     `json:"defined"`
+	MatchedPermission string   `json:"matchedPermission,omitempty"` // the pattern granting the permission
+	Services          []string `json:"services,omitempty"`          // service patterns the role is limited to
+	CoversService     bool     `json:"coversService"`
+	Grants            bool     `json:"grants"`
+}
+
+// Explanation is a decision of the policy, with the reasons for it
+type Explanation struct {
+	Allowed    bool              `json:"allowed"`
+	Permission string            `json:"permission"`
+	Service    string            `json:"service,omitempty"`
+	Reason     string            `json:"reason"`
+	Roles      []RoleExplanation `json:"roles"`
+}
+
+// Explain decides like Allowed, and says why
+func (p *Policy) Explain(roles []string, perm, service string) Explanation {
+	e := Explanation{Permission: perm, Service: service, Roles: []RoleExplanation{}}
+	var granting, withPermission []string
+	for _, name := range roles {
+		role, defined := p.roles[name]
+		re := RoleExplanation{Role: name, Defined: defined, Services: role.Services}
+		if defined {
+			re.MatchedPermission, _ = grant(role, perm)
+			re.CoversService = covers(role, service)
+			re.Grants = re.MatchedPermission != "" && re.CoversService
+		}
+		if re.MatchedPermission != "" {
+			withPermission = append(withPermission, name)
+		}
+		if re.Grants {
+			granting = append(granting, name)
+		}
+		e.Roles = append(e.Roles, re)
+	}
+
+	target := ""
+	if service != "" {
+		target = " on service " + service
+	}
+	switch {
+	case len(granting) > 0:
+		e.Allowed = true
+		sort.Strings(granting)
+		e.Reason = fmt.Sprintf("%s granted%s by %s", perm, target, roleList(granting))
+	case len(roles) == 0:
+		e.Reason = "the caller has no roles"
+	case len(withPermission) > 0:
+		sort.Strings(withPermission)
+		e.Reason = fmt.Sprintf("%s %s %s, but not%s", roleList(withPermission), pluralize(len(withPermission), "grants", "grant"), perm, target)
+	default:
+		e.Reason = fmt.Sprintf("no role grants %s", perm)
+	}
+	return e
+}
+
+// roleList names roles for a reason, e.g. "role admin" or "roles a, b"
+func roleList(roles []string) string {
+	return pluralize(len(roles), "role ", "roles ") + strings.Join(roles, ", ")
+}
+
+// pluralize picks one or many by n
+func pluralize(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,202 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+func TestServicePermission(t *testing.T) {
+	tests := []struct {
+		op   string
+		want string
+	}{
+		{"list", ServicesList},
+		{"status", ServicesStatus},
+		{"logs", LogsRead},
+		{"follow", LogsRead},
+		{"start", "services:start"},
+		{"reload-or-restart", "services:reload-or-restart"},
+	}
+	for _, tt := range tests {
+		if got := ServicePermission(tt.op); got != tt.want {
+			t.Errorf("ServicePermission(%q) = %q, want %q", tt.op, got, tt.want)
+		}
+	}
+}
+
+func TestServiceAction(t *testing.T) {
+	for _, action := range serviceActions {
+		perm := ServicePermission(action)
+		if !IsPermission(perm) {
+			t.Errorf("%s is not a known permission", perm)
+		}
+		if got, ok := ServiceAction(perm); !ok || got != action {
+			t.Errorf("ServiceAction(%q) = %q, %v, want %q", perm, got, ok, action)
+		}
+	}
+	for _, perm := range []string{ServicesList, ServicesStatus, LogsRead, SchedulesWrite, "services:explode"} {
+		if action, ok := ServiceAction(perm); ok {
+			t.Errorf("ServiceAction(%q) = %q, want none", perm, action)
+		}
+	}
+}
+
+func TestMatchService(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		want     bool
+	}{
+		{[]string{"nginx"}, "nginx", true},
+		{[]string{"nginx"}, "nginx.service", true},
+		{[]string{"nginx.service"}, "nginx", true},
+		{[]string{"nginx"}, "nginx-debug", false},
+		{[]string{"app-*"}, "app-web", true},
+		{[]string{"app-*"}, "app-web.service", true},
+		{[]string{"app-*.service"}, "app-web", true},
+		{[]string{"app-*"}, "myapp-web", false},
+		{[]string{"app-?"}, "app-1", true},
+		{[]string{"app-?"}, "app-10", false},
+		{[]string{"[ab]pp"}, "bpp.service", true},
+		{[]string{"*"}, "anything.timer", true},
+		{[]string{"db", "app-*"}, "app-web", true},
+		{[]string{"db", "app-*"}, "cache", false},
+		{[]string{"*.socket"}, "docker.service", false},
+		{nil, "nginx", false},
+		// An invalid pattern never matches
+		{[]string{"app-["}, "app-[", false},
+	}
+	for _, tt := range tests {
+		if got := MatchService(tt.patterns, tt.name); got != tt.want {
+			t.Errorf("MatchService(%q, %q) = %v, want %v", tt.patterns, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    utils.RoleConfig
+		wantErr string
+	}{
+		{"exact permissions", utils.RoleConfig{Permissions: []string{ServicesList, "services:restart"}}, ""},
+		{"permission pattern", utils.RoleConfig{Permissions: []string{"services:*"}}, ""},
+		{"every permission", utils.RoleConfig{Permissions: []string{"*"}}, ""},
+		{"service patterns", utils.RoleConfig{Permissions: []string{"*"}, Services: []string{"app-*", "nginx"}}, ""},
+		{"no permissions", utils.RoleConfig{}, ""},
+		{"unknown permission", utils.RoleConfig{Permissions: []string{"services:explode"}}, `unknown permission "services:explode"`},
+		{"pattern matching nothing", utils.RoleConfig{Permissions: []string{"secrets:*"}}, `unknown permission "secrets:*"`},
+		{"invalid permission pattern", utils.RoleConfig{Permissions: []string{"services:["}}, `invalid permission "services:["`},
+		{"invalid service pattern", utils.RoleConfig{Permissions: []string{"*"}, Services: []string{"app-["}}, `invalid service pattern "app-["`},
+		{"empty service pattern", utils.RoleConfig{Permissions: []string{"*"}, Services: []string{""}}, `invalid service pattern ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(map[string]utils.RoleConfig{"ops": tt.role})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("New() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "role ops: "+tt.wantErr) {
+				t.Errorf("New() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testRoles are the roles the policy tests decide with
+var testRoles = map[string]utils.RoleConfig{
+	"admin":    {Permissions: []string{"*"}},
+	"viewer":   {Permissions: []string{ServicesList, ServicesStatus, LogsRead}},
+	"operator": {Permissions: []string{"services:*"}},
+	"app-ops":  {Permissions: []string{"services:restart", LogsRead}, Services: []string{"app-*"}},
+	"db-ops":   {Permissions: []string{"services:restart"}, Services: []string{"postgresql"}},
+}
+
+// decisions are the cases TestAllowed and TestExplain share
+var decisions = []struct {
+	roles   []string
+	perm    string
+	service string
+	want    bool
+}{
+	{[]string{"admin"}, "services:mask", "nginx", true},
+	{[]string{"admin"}, PolicyRead, "", true},
+	{[]string{"viewer"}, ServicesStatus, "nginx", true},
+	{[]string{"viewer"}, "services:restart", "nginx", false},
+	{[]string{"operator"}, "services:reload-or-restart", "nginx", true},
+	{[]string{"operator"}, SchedulesWrite, "", false},
+	{[]string{"app-ops"}, "services:restart", "app-web", true},
+	{[]string{"app-ops"}, "services:restart", "app-web.service", true},
+	{[]string{"app-ops"}, "services:restart", "nginx", false},
+	{[]string{"app-ops"}, "services:stop", "app-web", false},
+	// Without a service, a grant on any service will do
+	{[]string{"app-ops"}, "services:restart", "", true},
+	{[]string{"app-ops", "db-ops"}, "services:restart", "postgresql.service", true},
+	{[]string{"app-ops", "viewer"}, LogsRead, "nginx", true},
+	{[]string{"ghost"}, ServicesList, "", false},
+	{[]string{"ghost", "viewer"}, ServicesList, "", true},
+	{nil, ServicesList, "", false},
+}
+
+func TestAllowed(t *testing.T) {
+	p, err := New(testRoles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range decisions {
+		if got := p.Allowed(tt.roles, tt.perm, tt.service); got != tt.want {
+			t.Errorf("Allowed(%v, %s, %q) = %v, want %v", tt.roles, tt.perm, tt.service, got, tt.want)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	p, err := New(testRoles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range decisions {
+		e := p.Explain(tt.roles, tt.perm, tt.service)
+		if e.Allowed != tt.want {
+			t.Errorf("Explain(%v, %s, %q).Allowed = %v, want %v", tt.roles, tt.perm, tt.service, e.Allowed, tt.want)
+		}
+		granted := false
+		for _, re := range e.Roles {
+			granted = granted || re.Grants
+		}
+		if granted != e.Allowed || len(e.Roles) != len(tt.roles) {
+			t.Errorf("Explain(%v, %s, %q) roles %+v disagree with the decision", tt.roles, tt.perm, tt.service, e.Roles)
+		}
+	}
+
+	reasons := []struct {
+		roles   []string
+		perm    string
+		service string
+		want    string
+	}{
+		{[]string{"app-ops", "operator"}, "services:restart", "app-web", "services:restart granted on service app-web by roles app-ops, operator"},
+		{[]string{"app-ops"}, "services:restart", "nginx", "role app-ops grants services:restart, but not on service nginx"},
+		{[]string{"viewer", "ghost"}, "services:stop", "nginx", "no role grants services:stop"},
+		{nil, ServicesList, "", "the caller has no roles"},
+	}
+	for _, tt := range reasons {
+		if got := p.Explain(tt.roles, tt.perm, tt.service).Reason; got != tt.want {
+			t.Errorf("Explain(%v, %s, %q).Reason = %q, want %q", tt.roles, tt.perm, tt.service, got, tt.want)
+		}
+	}
+
+	e := p.Explain([]string{"ghost", "app-ops"}, "services:restart", "app-web")
+	ghost, appOps := e.Roles[0], e.Roles[1]
+	if ghost.Defined || ghost.Grants {
+		t.Errorf("undefined role explained as %+v", ghost)
+	}
+	if !appOps.Defined || appOps.MatchedPermission != "services:restart" || !appOps.CoversService || !appOps.Grants {
+		t.Errorf("app-ops explained as %+v", appOps)
+	}
+}
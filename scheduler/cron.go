@@ -0,0 +1,304 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type Schedule struct {
+	second, minute, hour, dom, month, dow uint64
+	loc                                   *time.Location
+}
+
+// bounds describes the values a cron field accepts
+type bounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	dom     = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as an alias for Sunday
+	dow = bounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// starBit marks a field written as "*" or "?", which matters for the
+// day-of-month / day-of-week rule in dayMatches
+const starBit = 1 << 63
+
+// macros are the predefined schedules, expanded to 6-field expressions
+var macros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ParseSchedule parses a standard 5-field cron expression (minute hour
+// day-of-month month day-of-week), a 6-field one with a leading seconds
+// field, or one of the @yearly, @monthly, @weekly, @daily and @hourly
+// macros. A CRON_TZ= or TZ= prefix overrides loc; a nil loc means the
+// local time zone.
+func ParseSchedule(expr string, loc *time.Location) (*Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		zone, rest, _ := strings.Cut(expr, " ")
+		_, name, _ := strings.Cut(zone, "=")
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", name, err)
+		}
+		loc, expr = l, strings.TrimSpace(rest)
+	}
+	if strings.HasPrefix(expr, "@") {
+		expanded, ok := macros[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule %q", expr)
+		}
+		expr = expanded
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields, found %d in %q", len(fields), expr)
+	}
+
+	s := &Schedule{loc: loc}
+	var err error
+	for i, target := range []struct {
+		field  *uint64
+		bounds bounds
+		name   string
+	}{
+		{&s.second, seconds, "second"},
+		{&s.minute, minutes, "minute"},
+		{&s.hour, hours, "hour"},
+		{&s.dom, dom, "day of month"},
+		{&s.month, months, "month"},
+		{&s.dow, dow, "day of week"},
+	} {
+		if *target.field, err = parseField(fields[i], target.bounds); err != nil {
+			return nil, fmt.Errorf("invalid %s field %q: %w", target.name, fields[i], err)
+		}
+	}
+
+	// Fold Sunday=7 onto Sunday=0
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+// parseField parses a comma-separated list of values, ranges ("1-5"),
+// wildcards and steps ("*/15", "10-40/10", "5/10")
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		var lo, hi uint
+		var extra uint64
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = b.min, b.max
+			if !hasStep {
+				extra = starBit
+			}
+		default:
+			start, end, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(start, b); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(end, b); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/10" means every 10 starting at 5
+				hi = b.max
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("range %d-%d is backwards", lo, hi)
+		}
+
+		step := uint(1)
+		if hasStep {
+			n, err := strconv.ParseUint(stepPart, 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = uint(n)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+		bits |= extra
+	}
+	return bits, nil
+}
+
+// parseValue parses a single number or name within b
+func parseValue(value string, b bounds) (uint, error) {
+	if n, ok := b.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if uint(n) < b.min || uint(n) > b.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, b.min, b.max)
+	}
+	return uint(n), nil
+}
+
+// Location returns the time zone the schedule is evaluated in
+func (s *Schedule) Location() *time.Location {
+	return s.loc
+}
+
+// Next returns the first time after t matching the schedule, in the
+// schedule's time zone, or the zero time if nothing matches within five
+// years (e.g. "0 0 30 2 *"). Times skipped when clocks go forward do not
+// match; times repeated when they go back match once, unless the hour
+// field is a wildcard.
+func (s *Schedule) Next(t time.Time) time.Time {
+	for {
+		t = s.next(t)
+		if t.IsZero() || s.hour&starBit != 0 || !repeatedWallClock(t) {
+			return t
+		}
+	}
+}
+
+// repeatedWallClock reports whether the wall clock time of t already
+// happened earlier, before clocks went back
+func repeatedWallClock(t time.Time) bool {
+	_, offset := t.Zone()
+	_, earlierOffset := t.Add(-time.Hour).Zone()
+	if earlierOffset <= offset {
+		return false
+	}
+	first := t.Add(-time.Duration(earlierOffset-offset) * time.Second)
+	return first.Hour() == t.Hour() && first.Minute() == t.Minute() && first.Second() == t.Second()
+}
+
+// next returns the first time after t matching the schedule's fields
+func (s *Schedule) next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	yearLimit := t.Year() + 5
+
+	// Once a field has been advanced, every finer field restarts from its
+	// minimum
+	added := false
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&s.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// A DST transition at midnight can move the day's start off 00:00
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Hour())&s.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, s.loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Minute())&s.minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Second())&s.second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+// dayMatches applies cron's day rule: when both day fields are restricted a
+// day matching either one matches, otherwise both must match
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&s.dom != 0
+	dowMatch := 1<<uint(t.Weekday())&s.dow != 0
+	if s.dom&starBit != 0 || s.dow&starBit != 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
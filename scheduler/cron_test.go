@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"@fortnightly", "unknown schedule"},
+		{"* * * *", "expected 5 or 6 fields"},
+		{"* * * * * * *", "expected 5 or 6 fields"},
+		{"60 * * * *", "out of range"},
+		{"* * 0 * *", "out of range"},
+		{"* * * * 8", "out of range"},
+		{"* * * smarch *", "invalid value"},
+		{"5-1 * * * *", "backwards"},
+		{"*/0 * * * *", "invalid step"},
+		{"TZ=Nowhere/Special * * * * *", "invalid time zone"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseSchedule(tt.expr, time.UTC); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseSchedule(%q) error = %v, want %q", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	santiago := mustLoadLocation(t, "America/Santiago")
+
+	tests := []struct {
+		name string
+		expr string
+		loc  *time.Location
+		from time.Time
+		want time.Time
+	}{
+		{"step from wildcard", "*/15 * * * *", time.UTC, date(time.UTC, 2025, 6, 4, 10, 7, 30), date(time.UTC, 2025, 6, 4, 10, 15, 0)},
+		{"step within range", "10-40/10 * * * *", time.UTC, date(time.UTC, 2025, 6, 4, 10, 40, 0), date(time.UTC, 2025, 6, 4, 11, 10, 0)},
+		{"step from start value", "5/20 * * * *", time.UTC, date(time.UTC, 2025, 6, 4, 10, 46, 0), date(time.UTC, 2025, 6, 4, 11, 5, 0)},
+		{"seconds field", "*/30 * * * * *", time.UTC, date(time.UTC, 2025, 6, 4, 10, 0, 10).Add(500 * time.Millisecond), date(time.UTC, 2025, 6, 4, 10, 0, 30)},
+		{"weekday names", "0 9 * * mon-fri", time.UTC, date(time.UTC, 2025, 6, 7, 12, 0, 0), date(time.UTC, 2025, 6, 9, 9, 0, 0)},
+		{"sunday as 7", "0 9 * * 7", time.UTC, date(time.UTC, 2025, 6, 4, 12, 0, 0), date(time.UTC, 2025, 6, 8, 9, 0, 0)},
+		{"sunday as 0", "0 9 * * 0", time.UTC, date(time.UTC, 2025, 6, 4, 12, 0, 0), date(time.UTC, 2025, 6, 8, 9, 0, 0)},
+		{"sunday by name", "0 9 * * SUN", time.UTC, date(time.UTC, 2025, 6, 4, 12, 0, 0), date(time.UTC, 2025, 6, 8, 9, 0, 0)},
+		{"month names", "0 0 1 jan,jul *", time.UTC, date(time.UTC, 2025, 2, 1, 0, 0, 0), date(time.UTC, 2025, 7, 1, 0, 0, 0)},
+		{"day of month or day of week", "0 0 13 * fri", time.UTC, date(time.UTC, 2025, 6, 1, 0, 0, 0), date(time.UTC, 2025, 6, 6, 0, 0, 0)},
+		{"feb 29 waits for a leap year", "0 0 29 2 *", time.UTC, date(time.UTC, 2025, 3, 1, 0, 0, 0), date(time.UTC, 2028, 2, 29, 0, 0, 0)},
+		{"feb 30 never matches", "0 0 30 2 *", time.UTC, date(time.UTC, 2025, 1, 1, 0, 0, 0), time.Time{}},
+		{"31st skips short months", "0 0 31 * *", time.UTC, date(time.UTC, 2025, 4, 1, 0, 0, 0), date(time.UTC, 2025, 5, 31, 0, 0, 0)},
+		{"@yearly", "@yearly", time.UTC, date(time.UTC, 2025, 6, 4, 0, 0, 0), date(time.UTC, 2026, 1, 1, 0, 0, 0)},
+		{"@monthly", "@monthly", time.UTC, date(time.UTC, 2025, 6, 4, 0, 0, 0), date(time.UTC, 2025, 7, 1, 0, 0, 0)},
+		{"@weekly", "@weekly", time.UTC, date(time.UTC, 2025, 6, 4, 0, 0, 0), date(time.UTC, 2025, 6, 8, 0, 0, 0)},
+		{"@daily", "@Daily", time.UTC, date(time.UTC, 2025, 6, 4, 0, 0, 0), date(time.UTC, 2025, 6, 5, 0, 0, 0)},
+		{"@hourly", "@hourly", time.UTC, date(time.UTC, 2025, 6, 4, 10, 0, 0), date(time.UTC, 2025, 6, 4, 11, 0, 0)},
+		{"time zone prefix", "CRON_TZ=America/New_York 0 9 * * *", time.UTC, date(time.UTC, 2025, 6, 4, 12, 0, 0), date(time.UTC, 2025, 6, 4, 13, 0, 0)},
+
+		// Clocks go forward from 02:00 to 03:00 on 9 March 2025: times in
+		// the skipped hour do not happen that day
+		{"spring forward skips missing time", "30 2 * * *", newYork, date(newYork, 2025, 3, 8, 12, 0, 0), date(newYork, 2025, 3, 10, 2, 30, 0)},
+		{"spring forward hourly", "0 * * * *", newYork, date(newYork, 2025, 3, 9, 1, 30, 0), date(newYork, 2025, 3, 9, 3, 0, 0)},
+		// Clocks go back from 02:00 to 01:00 on 2 November 2025: times in
+		// the repeated hour fire once, unless every hour is scheduled
+		{"fall back first pass", "30 1 * * *", newYork, date(newYork, 2025, 11, 2, 0, 0, 0), date(newYork, 2025, 11, 2, 1, 30, 0)},
+		{"fall back second pass", "30 1 * * *", newYork, date(newYork, 2025, 11, 2, 1, 30, 0), date(newYork, 2025, 11, 3, 1, 30, 0)},
+		{"fall back hourly", "0 * * * *", newYork, date(time.UTC, 2025, 11, 2, 5, 0, 0), date(time.UTC, 2025, 11, 2, 6, 0, 0)},
+		// Clocks go forward from 00:00 to 01:00 on 8 September 2024, so the
+		// day starts at 01:00
+		{"spring forward at midnight", "0 12 * * *", santiago, date(santiago, 2024, 9, 7, 13, 0, 0), date(santiago, 2024, 9, 8, 12, 0, 0)},
+		{"midnight that does not exist", "0 0 * * *", santiago, date(santiago, 2024, 9, 7, 13, 0, 0), date(santiago, 2024, 9, 9, 0, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+// date returns the time in loc, like time.Date
+func date(loc *time.Location, year int, month time.Month, day, hour, min, sec int) time.Time {
+	return time.Date(year, month, day, hour, min, sec, 0, loc)
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s not available: %v", name, err)
+	}
+	return loc
+}
@@ -0,0 +1,254 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// One-shot action states
+const (
+	OneShotPending   = "pending"   // waiting for RunAt
+	OneShotActive    = "active"    // Action done, waiting for ThenAt
+	OneShotSucceeded = "succeeded" // every step ran
+	OneShotFailed    = "failed"    // a step failed; later steps were not run
+	OneShotCancelled = "cancelled" // cancelled before every step ran
+)
+
+// oneShotRetention is how long finished one-shot actions stay listed
+const oneShotRetention = 24 * time.Hour
+
+var (
+	ErrInvalidOneShot  = errors.New("invalid one-shot action")
+	ErrOneShotNotFound = errors.New("one-shot action not found")
+	ErrOneShotFinished = errors.New("one-shot action already finished")
+	ErrOneShotRunning  = errors.New("one-shot action step is running")
+)
+
+// OneShot runs Action against Service once at RunAt. A time-boxed action
+// also runs Then at ThenAt, e.g. start now and stop again in two hours.
+type OneShot struct {
+	ID         string      `json:"id"`
+	Service    string      `json:"service"`
+	Action     string      `json:"action"`
+	RunAt      time.Time   `json:"runAt"`
+	Then       string      `json:"then,omitempty"`
+	ThenAt     *time.Time  `json:"thenAt,omitempty"`
+	State      string      `json:"state"`
+	CreatedBy  string      `json:"createdBy,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+	Runs       []RunResult `json:"runs,omitempty"`
+}
+
+// finished reports whether the action has no steps left to run
+func (o *OneShot) finished() bool {
+	return o.State != OneShotPending && o.State != OneShotActive
+}
+
+// clone returns a copy of o that shares no memory with it
+func (o *OneShot) clone() OneShot {
+	c := *o
+	c.Runs = append([]RunResult(nil), o.Runs...)
+	return c
+}
+
+// pendingOneShot is a one-shot action tracked by the scheduler
+type pendingOneShot struct {
+	shot    OneShot
+	cancel  context.CancelFunc
+	running bool // a step is being executed and can no longer be cancelled
+}
+
+// AddOneShot validates shot, persists it and arms it. An empty ID is
+// generated.
+func (s *Scheduler) AddOneShot(shot OneShot) (OneShot, error) {
+	if shot.ID == "" {
+		shot.ID = newID()
+	}
+	if !utils.ValidateServiceName(shot.Service) {
+		return OneShot{}, fmt.Errorf("%w: invalid service name %q", ErrInvalidOneShot, shot.Service)
+	}
+	if !services.ValidAction(shot.Action) {
+		return OneShot{}, fmt.Errorf("%w: unknown action %q", ErrInvalidOneShot, shot.Action)
+	}
+	if shot.RunAt.IsZero() {
+		return OneShot{}, fmt.Errorf("%w: runAt is required", ErrInvalidOneShot)
+	}
+	if shot.Then != "" {
+		if !services.ValidAction(shot.Then) {
+			return OneShot{}, fmt.Errorf("%w: unknown action %q", ErrInvalidOneShot, shot.Then)
+		}
+		if shot.ThenAt == nil || !shot.ThenAt.After(shot.RunAt) {
+			return OneShot{}, fmt.Errorf("%w: thenAt must be after runAt", ErrInvalidOneShot)
+		}
+	} else if shot.ThenAt != nil {
+		return OneShot{}, fmt.Errorf("%w: thenAt requires then", ErrInvalidOneShot)
+	}
+	shot.State = OneShotPending
+	shot.CreatedAt = time.Now()
+	shot.FinishedAt = nil
+	shot.Runs = nil
+
+	s.mu.Lock()
+	if _, exists := s.oneShots[shot.ID]; exists {
+		s.mu.Unlock()
+		return OneShot{}, fmt.Errorf("%w: id %s already exists", ErrInvalidOneShot, shot.ID)
+	}
+	p := &pendingOneShot{shot: shot}
+	s.oneShots[shot.ID] = p
+	if s.started {
+		s.startOneShot(p)
+	}
+	s.mu.Unlock()
+
+	s.save()
+	s.logger.Info("One-shot %s added by %q: %s %s at %s", shot.ID, shot.CreatedBy, shot.Action, shot.Service, shot.RunAt.Format(time.RFC3339))
+	return shot.clone(), nil
+}
+
+// CancelOneShot cancels the steps of a one-shot action that have not run
+// yet. A step that is already running cannot be cancelled.
+func (s *Scheduler) CancelOneShot(id string) (OneShot, error) {
+	s.mu.Lock()
+	p, ok := s.oneShots[id]
+	if !ok {
+		s.mu.Unlock()
+		return OneShot{}, fmt.Errorf("%w: %s", ErrOneShotNotFound, id)
+	}
+	if p.shot.finished() {
+		s.mu.Unlock()
+		return OneShot{}, fmt.Errorf("%w: %s is %s", ErrOneShotFinished, id, p.shot.State)
+	}
+	if p.running {
+		s.mu.Unlock()
+		return OneShot{}, fmt.Errorf("%w: %s", ErrOneShotRunning, id)
+	}
+	now := time.Now()
+	p.shot.State = OneShotCancelled
+	p.shot.FinishedAt = &now
+	if p.cancel != nil {
+		p.cancel()
+	}
+	shot := p.shot.clone()
+	s.mu.Unlock()
+
+	s.save()
+	s.logger.Info("One-shot %s cancelled", id)
+	return shot, nil
+}
+
+// OneShots returns pending and recently finished one-shot actions, ordered
+// by the time they run
+func (s *Scheduler) OneShots() []OneShot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneOneShots(time.Now())
+	list := make([]OneShot, 0, len(s.oneShots))
+	for _, p := range s.oneShots {
+		list = append(list, p.shot.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RunAt.Before(list[j].RunAt) })
+	return list
+}
+
+// OneShot returns the one-shot action with the given ID
+func (s *Scheduler) OneShot(id string) (OneShot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.oneShots[id]
+	if !ok {
+		return OneShot{}, false
+	}
+	return p.shot.clone(), true
+}
+
+// pruneOneShots forgets actions that finished more than oneShotRetention
+// before now. Callers must hold s.mu.
+func (s *Scheduler) pruneOneShots(now time.Time) {
+	for id, p := range s.oneShots {
+		if p.shot.finished() && p.shot.FinishedAt != nil && now.Sub(*p.shot.FinishedAt) > oneShotRetention {
+			delete(s.oneShots, id)
+		}
+	}
+}
+
+// startOneShot arms p. Callers must hold s.mu.
+func (s *Scheduler) startOneShot(p *pendingOneShot) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	p.cancel = cancel
+	s.wg.Add(1)
+	go s.runOneShot(ctx, p)
+}
+
+// runOneShot runs each remaining step of p when it is due. Steps whose time
+// passed while ChronoServe was down run immediately.
+func (s *Scheduler) runOneShot(ctx context.Context, p *pendingOneShot) {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		state, service := p.shot.State, p.shot.Service
+		var action string
+		var due time.Time
+		switch state {
+		case OneShotPending:
+			action, due = p.shot.Action, p.shot.RunAt
+		case OneShotActive:
+			action, due = p.shot.Then, *p.shot.ThenAt
+		}
+		s.mu.Unlock()
+		if action == "" {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// The action may have been cancelled after the timer fired; from
+		// here on cancelling is refused until the step has run
+		s.mu.Lock()
+		if p.shot.State != state {
+			s.mu.Unlock()
+			return
+		}
+		p.running = true
+		s.mu.Unlock()
+
+		actor := p.shot.CreatedBy
+		if actor == "" {
+			actor = "action:" + p.shot.ID
+		}
+		result := s.execute(context.WithoutCancel(ctx), "One-shot "+p.shot.ID, actor, action, service, due)
+
+		s.mu.Lock()
+		p.running = false
+		p.shot.Runs = append(p.shot.Runs, *result)
+		switch {
+		case result.Status == RunFailed:
+			p.shot.State = OneShotFailed
+		case state == OneShotPending && p.shot.Then != "":
+			p.shot.State = OneShotActive
+		default:
+			p.shot.State = OneShotSucceeded
+		}
+		if p.shot.finished() && p.shot.FinishedAt == nil {
+			now := time.Now()
+			p.shot.FinishedAt = &now
+		}
+		s.mu.Unlock()
+		s.save()
+	}
+}
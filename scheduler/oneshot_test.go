@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// blockingManager is a ServiceManager whose Start blocks until release is
+// closed, reporting each call on started
+type blockingManager struct {
+	services.ServiceManager
+	started chan string
+	release chan struct{}
+}
+
+func (m *blockingManager) Start(ctx context.Context, name string) (*services.ActionResult, error) {
+	m.started <- name
+	<-m.release
+	return &services.ActionResult{Name: name, Action: "start", Message: "Service " + name + " started successfully"}, nil
+}
+
+// newTestScheduler starts a scheduler for manager with its state in a
+// temporary directory
+func newTestScheduler(t *testing.T, manager services.ServiceManager) *Scheduler {
+	t.Helper()
+	s := loadTestScheduler(t, manager, filepath.Join(t.TempDir(), "state.json"))
+	s.Start()
+	return s
+}
+
+// loadTestScheduler creates a scheduler for manager from the state file at
+// path without starting it, and stops it when the test ends
+func loadTestScheduler(t *testing.T, manager services.ServiceManager, path string) *Scheduler {
+	t.Helper()
+	logger, err := utils.NewLogger(utils.LoggerOptions{Level: utils.ERROR, Directory: t.TempDir(), Filename: "test.log", MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+	s, err := New(manager, utils.SchedulerConfig{StateFile: path}, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestCancelOneShotWhileRunning(t *testing.T) {
+	manager := &blockingManager{started: make(chan string, 1), release: make(chan struct{})}
+	s := newTestScheduler(t, manager)
+
+	shot, err := s.AddOneShot(OneShot{Service: "app", Action: "start", RunAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-manager.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("action did not run")
+	}
+
+	if _, err := s.CancelOneShot(shot.ID); !errors.Is(err, ErrOneShotRunning) {
+		t.Fatalf("CancelOneShot() while running error = %v, want %v", err, ErrOneShotRunning)
+	}
+	close(manager.release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := s.OneShot(shot.ID)
+		if got.State == OneShotSucceeded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %s, want %s", got.State, OneShotSucceeded)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCancelOneShotBeforeRun(t *testing.T) {
+	manager := &blockingManager{started: make(chan string, 1), release: make(chan struct{})}
+	close(manager.release)
+	s := newTestScheduler(t, manager)
+
+	shot, err := s.AddOneShot(OneShot{Service: "app", Action: "start", RunAt: time.Now().Add(100 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := s.CancelOneShot(shot.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.State != OneShotCancelled {
+		t.Errorf("state = %s, want %s", cancelled.State, OneShotCancelled)
+	}
+
+	select {
+	case name := <-manager.started:
+		t.Fatalf("cancelled action ran against %s", name)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if _, err := s.CancelOneShot(shot.ID); !errors.Is(err, ErrOneShotFinished) {
+		t.Errorf("second CancelOneShot() error = %v, want %v", err, ErrOneShotFinished)
+	}
+}
@@ -0,0 +1,485 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// Misfire policies for runs missed while ChronoServe was not running
+const (
+	MisfireSkip    = "skip"     // drop missed runs and wait for the next one
+	MisfireRunOnce = "run-once" // run once at startup, however many were missed
+)
+
+// Job sources
+const (
+	SourceConfig = "config"
+	SourceAPI    = "api"
+)
+
+// Run statuses
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+)
+
+var (
+	ErrInvalidJob  = errors.New("invalid job")
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobExists   = errors.New("job already exists")
+	ErrReadOnlyJob = errors.New("job is defined in the configuration file")
+)
+
+// Job runs a service action whenever its schedule matches
+type Job struct {
+	ID        string `json:"id"`
+	Service   string `json:"service"`
+	Action    string `json:"action"`
+	Schedule  string `json:"schedule"`
+	Timezone  string `json:"timezone,omitempty"`
+	Jitter    string `json:"jitter,omitempty"`
+	Misfire   string `json:"misfire,omitempty"`
+	Source    string `json:"source"`
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+// RunResult records the outcome of one scheduled run
+type RunResult struct {
+	ScheduledAt time.Time `json:"scheduledAt"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Status      string    `json:"status"`
+	Message     string    `json:"message"`
+}
+
+// JobStatus is a job together with its run state
+type JobStatus struct {
+	Job
+	Running bool       `json:"running"`
+	NextRun *time.Time `json:"nextRun,omitempty"`
+	LastRun *RunResult `json:"lastRun,omitempty"`
+}
+
+// scheduledJob is a job compiled for running
+type scheduledJob struct {
+	job      Job
+	schedule *Schedule
+	jitter   time.Duration
+	cancel   context.CancelFunc
+
+	running bool
+	nextRun time.Time
+	lastRun *RunResult
+	// lastScheduled is the latest scheduled time that has been accounted
+	// for, whether it ran, was skipped or was missed
+	lastScheduled *time.Time
+}
+
+// Scheduler runs service actions on cron schedules, and one-shot actions at
+// a given time. Jobs come from the configuration file or are added at
+// runtime; runtime jobs, one-shot actions and the run state of every job are
+// persisted so missed runs can be detected after a restart.
+type Scheduler struct {
+	manager   services.ServiceManager
+	bus       *events.Bus
+	logger    *utils.Logger
+	stateFile string
+
+	mu       sync.Mutex
+	jobs     map[string]*scheduledJob
+	oneShots map[string]*pendingOneShot
+	ctx      context.Context
+	cancel   context.CancelFunc
+	started  bool
+	wg       sync.WaitGroup
+
+	saveMu sync.Mutex
+}
+
+// New creates a scheduler for the jobs in cfg and those saved in its state
+// file. It does not run anything until Start is called. Actions it runs are
+// published on bus, which may be nil.
+func New(manager services.ServiceManager, cfg utils.SchedulerConfig, bus *events.Bus, logger *utils.Logger) (*Scheduler, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		manager:   manager,
+		bus:       bus,
+		logger:    logger,
+		stateFile: cfg.StateFile,
+		jobs:      make(map[string]*scheduledJob),
+		oneShots:  make(map[string]*pendingOneShot),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	for _, c := range cfg.Jobs {
+		job := Job{
+			ID:       c.ID,
+			Service:  c.Service,
+			Action:   c.Action,
+			Schedule: c.Schedule,
+			Timezone: c.Timezone,
+			Jitter:   c.Jitter,
+			Misfire:  c.Misfire,
+			Source:   SourceConfig,
+		}
+		if err := s.register(job); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	state, err := loadState(s.stateFile)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for _, job := range state.Jobs {
+		job.Source = SourceAPI
+		if err := s.register(job); err != nil {
+			s.logger.Warn("Dropping saved job %s: %v", job.ID, err)
+		}
+	}
+	for _, shot := range state.OneShots {
+		s.oneShots[shot.ID] = &pendingOneShot{shot: shot}
+	}
+	for id, run := range state.Runs {
+		if sj, ok := s.jobs[id]; ok {
+			sj.lastScheduled = run.LastScheduled
+			sj.lastRun = run.LastRun
+		}
+	}
+	return s, nil
+}
+
+// compile validates job and prepares it for running
+func compile(job Job) (*scheduledJob, error) {
+	if job.ID == "" {
+		return nil, fmt.Errorf("%w: id is required", ErrInvalidJob)
+	}
+	if !utils.ValidateServiceName(job.Service) {
+		return nil, fmt.Errorf("%w %s: invalid service name %q", ErrInvalidJob, job.ID, job.Service)
+	}
+	if !services.ValidAction(job.Action) {
+		return nil, fmt.Errorf("%w %s: unknown action %q", ErrInvalidJob, job.ID, job.Action)
+	}
+
+	loc := time.Local
+	if job.Timezone != "" {
+		l, err := time.LoadLocation(job.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: invalid timezone %q: %v", ErrInvalidJob, job.ID, job.Timezone, err)
+		}
+		loc = l
+	}
+	schedule, err := ParseSchedule(job.Schedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidJob, job.ID, err)
+	}
+
+	var jitter time.Duration
+	if job.Jitter != "" {
+		if jitter, err = time.ParseDuration(job.Jitter); err != nil || jitter < 0 {
+			return nil, fmt.Errorf("%w %s: invalid jitter %q", ErrInvalidJob, job.ID, job.Jitter)
+		}
+	}
+
+	switch job.Misfire {
+	case "":
+		job.Misfire = MisfireSkip
+	case MisfireSkip, MisfireRunOnce:
+	default:
+		return nil, fmt.Errorf("%w %s: unknown misfire policy %q", ErrInvalidJob, job.ID, job.Misfire)
+	}
+
+	return &scheduledJob{job: job, schedule: schedule, jitter: jitter}, nil
+}
+
+// register compiles job and adds it, starting it if the scheduler is running
+func (s *Scheduler) register(job Job) error {
+	sj, err := compile(job)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.jobs[job.ID]; exists {
+		return fmt.Errorf("%w: %s", ErrJobExists, job.ID)
+	}
+	s.jobs[job.ID] = sj
+	if s.started {
+		s.startJob(sj)
+	}
+	return nil
+}
+
+// Start begins running every job
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, sj := range s.jobs {
+		s.startJob(sj)
+	}
+	for _, p := range s.oneShots {
+		if !p.shot.finished() {
+			s.startOneShot(p)
+		}
+	}
+	s.logger.Info("Scheduler started with %d jobs and %d one-shot actions", len(s.jobs), len(s.oneShots))
+}
+
+// startJob launches the run loop of sj. Callers must hold s.mu.
+func (s *Scheduler) startJob(sj *scheduledJob) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	sj.cancel = cancel
+	s.wg.Add(1)
+	go s.loop(ctx, sj)
+}
+
+// Stop stops scheduling and waits for runs in progress to finish
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Add adds a runtime job and persists it. An empty ID is generated.
+func (s *Scheduler) Add(job Job) (JobStatus, error) {
+	if job.ID == "" {
+		job.ID = newID()
+	}
+	job.Source = SourceAPI
+	if err := s.register(job); err != nil {
+		return JobStatus{}, err
+	}
+	s.save()
+
+	status, _ := s.Job(job.ID)
+	s.logger.Info("Job %s added by %q: %s %s at %q", job.ID, job.CreatedBy, job.Action, job.Service, job.Schedule)
+	return status, nil
+}
+
+// Remove deletes a runtime job. Jobs from the configuration file cannot be
+// removed.
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	sj, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if sj.job.Source == SourceConfig {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrReadOnlyJob, id)
+	}
+	delete(s.jobs, id)
+	if sj.cancel != nil {
+		sj.cancel()
+	}
+	s.mu.Unlock()
+
+	s.save()
+	s.logger.Info("Job %s removed", id)
+	return nil
+}
+
+// Jobs returns the status of every job, ordered by ID
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]JobStatus, 0, len(s.jobs))
+	for _, sj := range s.jobs {
+		list = append(list, sj.status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Job returns the status of the job with the given ID
+func (s *Scheduler) Job(id string) (JobStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sj, ok := s.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return sj.status(), true
+}
+
+// status snapshots sj. Callers must hold s.mu.
+func (sj *scheduledJob) status() JobStatus {
+	status := JobStatus{Job: sj.job, Running: sj.running}
+	if !sj.nextRun.IsZero() {
+		next := sj.nextRun
+		status.NextRun = &next
+	}
+	if sj.lastRun != nil {
+		last := *sj.lastRun
+		status.LastRun = &last
+	}
+	return status
+}
+
+// loop waits for each scheduled time of sj and fires it until ctx is done
+func (s *Scheduler) loop(ctx context.Context, sj *scheduledJob) {
+	defer s.wg.Done()
+
+	s.catchUp(ctx, sj)
+
+	for {
+		s.mu.Lock()
+		next := sj.schedule.Next(time.Now())
+		sj.nextRun = next
+		s.mu.Unlock()
+		if next.IsZero() {
+			s.logger.Warn("Job %s: schedule %q never fires again", sj.job.ID, sj.job.Schedule)
+			return
+		}
+
+		delay := time.Until(next)
+		if sj.jitter > 0 {
+			delay += mathrand.N(sj.jitter)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.fire(ctx, sj, next)
+	}
+}
+
+// catchUp applies the misfire policy when a scheduled time passed while
+// ChronoServe was not running
+func (s *Scheduler) catchUp(ctx context.Context, sj *scheduledJob) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if sj.lastScheduled == nil {
+		// A new job only runs at times scheduled from now on
+		sj.lastScheduled = &now
+		s.mu.Unlock()
+		s.save()
+		return
+	}
+	missed := sj.schedule.Next(*sj.lastScheduled)
+	s.mu.Unlock()
+
+	if missed.IsZero() || missed.After(now) {
+		return
+	}
+
+	if sj.job.Misfire == MisfireRunOnce {
+		s.logger.Warn("Job %s missed its run at %s, running now", sj.job.ID, missed.Format(time.RFC3339))
+		s.fire(ctx, sj, missed)
+		return
+	}
+
+	s.logger.Warn("Job %s missed its run at %s, skipping", sj.job.ID, missed.Format(time.RFC3339))
+	s.mu.Lock()
+	sj.lastScheduled = &now
+	sj.lastRun = &RunResult{
+		ScheduledAt: missed,
+		StartedAt:   now,
+		FinishedAt:  now,
+		Status:      RunSkipped,
+		Message:     "missed while ChronoServe was not running",
+	}
+	s.mu.Unlock()
+	s.save()
+}
+
+// fire runs sj for scheduledAt unless its previous run is still in progress
+func (s *Scheduler) fire(ctx context.Context, sj *scheduledJob, scheduledAt time.Time) {
+	s.mu.Lock()
+	sj.lastScheduled = &scheduledAt
+	if sj.running {
+		now := time.Now()
+		sj.lastRun = &RunResult{
+			ScheduledAt: scheduledAt,
+			StartedAt:   now,
+			FinishedAt:  now,
+			Status:      RunSkipped,
+			Message:     "previous run still in progress",
+		}
+		s.mu.Unlock()
+		s.logger.Warn("Job %s skipped: previous run still in progress", sj.job.ID)
+		s.save()
+		return
+	}
+	sj.running = true
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		// Let an action that has begun finish even if the scheduler stops
+		s.run(context.WithoutCancel(ctx), sj, scheduledAt)
+	}()
+}
+
+// run performs the action of sj and records the result
+func (s *Scheduler) run(ctx context.Context, sj *scheduledJob, scheduledAt time.Time) {
+	result := s.execute(ctx, "Job "+sj.job.ID, "schedule:"+sj.job.ID, sj.job.Action, sj.job.Service, scheduledAt)
+
+	s.mu.Lock()
+	sj.running = false
+	sj.lastRun = result
+	s.mu.Unlock()
+	s.save()
+}
+
+// execute runs action against service for the run scheduled at scheduledAt,
+// logging the outcome under label. Successful actions are published on the
+// event bus on behalf of actor.
+func (s *Scheduler) execute(ctx context.Context, label, actor, action, service string, scheduledAt time.Time) *RunResult {
+	result := &RunResult{ScheduledAt: scheduledAt, StartedAt: time.Now()}
+	outcome, err := services.RunAction(ctx, s.manager, action, service)
+	result.FinishedAt = time.Now()
+	if err != nil {
+		result.Status = RunFailed
+		result.Message = err.Error()
+		s.logger.Error("%s: %s %s failed: %v", label, action, service, err)
+	} else {
+		result.Status = RunSucceeded
+		result.Message = outcome.Message
+		s.logger.Info("%s: %s", label, outcome.Message)
+		if s.bus != nil {
+			s.bus.Publish(events.Event{
+				Type:    events.ServiceAction,
+				Service: service,
+				Action:  action,
+				Actor:   actor,
+				Message: outcome.Message,
+			})
+		}
+	}
+	return result
+}
+
+// newID returns a random job ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// yearly is a schedule that does not fire while a test runs
+const yearly = "0 0 1 1 *"
+
+// waitForRun waits until the last run of job id has a result and returns it
+func waitForRun(t *testing.T, s *Scheduler, id string) *RunResult {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := s.Job(id)
+		if status.LastRun != nil && !status.Running {
+			return status.LastRun
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s has not run", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFireSkipsOverlappingRun(t *testing.T) {
+	manager := &blockingManager{started: make(chan string, 1), release: make(chan struct{})}
+	s := newTestScheduler(t, manager)
+	if _, err := s.Add(Job{ID: "nightly", Service: "app", Action: "start", Schedule: yearly}); err != nil {
+		t.Fatal(err)
+	}
+	sj := s.jobs["nightly"]
+
+	first := time.Now()
+	s.fire(s.ctx, sj, first)
+	<-manager.started
+
+	second := first.Add(time.Minute)
+	s.fire(s.ctx, sj, second)
+	status, _ := s.Job("nightly")
+	if !status.Running || status.LastRun == nil || status.LastRun.Status != RunSkipped || !status.LastRun.ScheduledAt.Equal(second) {
+		t.Fatalf("status after overlapping run = %+v, want the second run skipped", status)
+	}
+
+	close(manager.release)
+	if result := waitForRun(t, s, "nightly"); result.Status != RunSucceeded || !result.ScheduledAt.Equal(first) {
+		t.Errorf("last run = %+v, want the first run succeeded", result)
+	}
+	if len(manager.started) != 0 {
+		t.Error("skipped run started the service")
+	}
+}
+
+func TestCatchUpMisfire(t *testing.T) {
+	tests := []struct {
+		misfire    string
+		wantStatus string
+		runs       bool
+	}{
+		{MisfireSkip, RunSkipped, false},
+		{MisfireRunOnce, RunSucceeded, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.misfire, func(t *testing.T) {
+			// The job last fired three hours ago, so three hourly runs
+			// were missed
+			lastScheduled := time.Now().Add(-3 * time.Hour)
+			path := filepath.Join(t.TempDir(), "state.json")
+			err := writeFileAtomic(path, state{
+				Jobs: []Job{{ID: "hourly", Service: "app", Action: "start", Schedule: "0 * * * *", Timezone: "UTC", Misfire: tt.misfire}},
+				Runs: map[string]runState{"hourly": {LastScheduled: &lastScheduled}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manager := &blockingManager{started: make(chan string, 4), release: make(chan struct{})}
+			close(manager.release)
+			s := loadTestScheduler(t, manager, path)
+			s.Start()
+
+			result := waitForRun(t, s, "hourly")
+			if result.Status != tt.wantStatus {
+				t.Errorf("missed run status = %s (%s), want %s", result.Status, result.Message, tt.wantStatus)
+			}
+			if want := lastScheduled.Truncate(time.Hour).Add(time.Hour); !result.ScheduledAt.Equal(want) {
+				t.Errorf("missed run scheduled at %s, want the first missed time %s", result.ScheduledAt, want)
+			}
+			s.Stop()
+			if runs := len(manager.started); runs != map[bool]int{true: 1, false: 0}[tt.runs] {
+				t.Errorf("service started %d times", runs)
+			}
+		})
+	}
+}
+
+func TestReloadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	manager := &blockingManager{started: make(chan string, 1), release: make(chan struct{})}
+	close(manager.release)
+
+	s := loadTestScheduler(t, manager, path)
+	s.Start()
+	if _, err := s.Add(Job{ID: "nightly", Service: "app", Action: "start", Schedule: yearly, Misfire: MisfireRunOnce, CreatedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	scheduledAt := time.Now().Truncate(time.Second)
+	s.fire(s.ctx, s.jobs["nightly"], scheduledAt)
+	ran := waitForRun(t, s, "nightly")
+	s.Stop()
+
+	reloaded := loadTestScheduler(t, manager, path)
+	status, ok := reloaded.Job("nightly")
+	if !ok {
+		t.Fatal("job not reloaded")
+	}
+	if status.Source != SourceAPI || status.Schedule != yearly || status.Misfire != MisfireRunOnce || status.CreatedBy != "alice" {
+		t.Errorf("reloaded job = %+v", status.Job)
+	}
+	if status.LastRun == nil || status.LastRun.Status != ran.Status || !status.LastRun.ScheduledAt.Equal(ran.ScheduledAt) {
+		t.Errorf("reloaded last run = %+v, want %+v", status.LastRun, ran)
+	}
+	if last := reloaded.jobs["nightly"].lastScheduled; last == nil || !last.Equal(scheduledAt) {
+		t.Errorf("reloaded lastScheduled = %v, want %s", last, scheduledAt)
+	}
+
+	// A reloaded job cannot be added twice
+	if _, err := reloaded.Add(Job{ID: "nightly", Service: "app", Action: "stop", Schedule: yearly}); err == nil {
+		t.Error("Add() of a reloaded job ID succeeded")
+	}
+}
@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// state is the content of the scheduler's state file
+type state struct {
+	Jobs     []Job               `json:"jobs"`     // jobs added through the API
+	Runs     map[string]runState `json:"runs"`     // run state of every job, by ID
+	OneShots []OneShot           `json:"oneShots"` // pending and recently finished one-shot actions
+}
+
+// runState is the persisted run state of one job
+type runState struct {
+	LastScheduled *time.Time `json:"lastScheduled,omitempty"`
+	LastRun       *RunResult `json:"lastRun,omitempty"`
+}
+
+// loadState reads the state file at path. A missing file is an empty state.
+func loadState(path string) (*state, error) {
+	st := &state{Runs: make(map[string]runState)}
+	if path == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading scheduler state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("error parsing scheduler state %s: %w", path, err)
+	}
+	if st.Runs == nil {
+		st.Runs = make(map[string]runState)
+	}
+	return st, nil
+}
+
+// save writes the runtime jobs and every job's run state to the state file
+func (s *Scheduler) save() {
+	if s.stateFile == "" {
+		return
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	st := state{Jobs: []Job{}, Runs: make(map[string]runState, len(s.jobs))}
+	for id, sj := range s.jobs {
+		if sj.job.Source == SourceAPI {
+			st.Jobs = append(st.Jobs, sj.job)
+		}
+		st.Runs[id] = runState{LastScheduled: sj.lastScheduled, LastRun: sj.lastRun}
+	}
+	s.pruneOneShots(time.Now())
+	st.OneShots = make([]OneShot, 0, len(s.oneShots))
+	for _, p := range s.oneShots {
+		st.OneShots = append(st.OneShots, p.shot.clone())
+	}
+	s.mu.Unlock()
+
+	if err := writeFileAtomic(s.stateFile, st); err != nil {
+		s.logger.Error("Failed to save scheduler state: %v", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to path through a temporary file, so a
+// crash never leaves a truncated file behind
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
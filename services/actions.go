@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Actions lists the action names accepted by RunAction
+var Actions = []string{"start", "stop", "restart", "reload", "reload-or-restart"}
+
+// ValidAction reports whether action is one of Actions
+func ValidAction(action string) bool {
+	for _, a := range Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// RunAction runs the named action against service name through manager, so
+// scheduled and delayed actions share the code paths of the HTTP handlers.
+// A service already in the requested state counts as success.
+func RunAction(ctx context.Context, manager ServiceManager, action, name string) (*ActionResult, error) {
+	var run func(context.Context, string) (*ActionResult, error)
+	switch action {
+	case "start":
+		run = manager.Start
+	case "stop":
+		run = manager.Stop
+	case "restart":
+		run = manager.Restart
+	case "reload":
+		run = manager.Reload
+	case "reload-or-restart":
+		run = manager.ReloadOrRestart
+	default:
+		return nil, newServiceError(action, name, ErrInvalidArgument, fmt.Sprintf("unknown action %q", action))
+	}
+
+	result, err := run(ctx, name)
+	if errors.Is(err, ErrAlreadyInState) {
+		var svcErr *ServiceError
+		message := err.Error()
+		if errors.As(err, &svcErr) && svcErr.Detail != "" {
+			message = svcErr.Detail
+		}
+		return &ActionResult{Name: name, Action: action, Message: message}, nil
+	}
+	return result, err
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// NewServiceManager creates the ServiceManager backend for the current
+// operating system as selected by cfg
+func NewServiceManager(cfg utils.Config) (ServiceManager, error) {
+	executor := NewCommandExecutor(cfg.Exec)
+
+	switch utils.GetOperatingSystem() {
+	case "linux":
+		command := cfg.Linux.ServiceCommand
+		if command == "" || command == "auto" {
+			command = DetectInitSystem()
+		}
+		switch command {
+		case "systemctl":
+			return NewSystemdService(executor), nil
+		case "dbus":
+			return NewDbusSystemdService(executor, cfg.Linux.DbusAddress)
+		case "openrc":
+			return NewOpenRCService(executor, cfg.Linux.LogDirectory), nil
+		case "sysv":
+			return NewSysVService(executor, cfg.Linux.LogDirectory), nil
+		case "docker":
+			return NewDockerService(executor, cfg.Linux.DockerSocket), nil
+		case "supervisor":
+			return NewSupervisorService(cfg.Supervisor)
+		default:
+			return nil, fmt.Errorf("unsupported linux service command: %s", cfg.Linux.ServiceCommand)
+		}
+	case "windows":
+		if cfg.Windows.ServiceCommand == "supervisor" {
+			return NewSupervisorService(cfg.Supervisor)
+		}
+		return NewWindowsService(executor), nil
+	default:
+		// Without a known service manager ChronoServe supervises its
+		// configured programs itself
+		return NewSupervisorService(cfg.Supervisor)
+	}
+}
+
+// DetectInitSystem returns the linux.serviceCommand backend matching the
+// init system the host was booted with: "systemctl", "openrc", "sysv", or
+// "supervisor" when there is none, as in most containers
+func DetectInitSystem() string {
+	return detectInitSystem("/")
+}
+
+// detectInitSystem detects the init system of the host whose root
+// filesystem is at root. What runs as PID 1 is checked first: the files of
+// an init system may be installed without it running, as in a container
+// built from a full distribution image.
+func detectInitSystem(root string) string {
+	exists := func(path string) bool {
+		_, err := os.Stat(filepath.Join(root, path))
+		return err == nil
+	}
+	if exists("/run/systemd/system") {
+		// The check sd_booted(3) uses
+		return "systemctl"
+	}
+
+	comm, err := os.ReadFile(filepath.Join(root, "/proc/1/comm"))
+	if err == nil {
+		switch strings.TrimSpace(string(comm)) {
+		case "systemd":
+			return "systemctl"
+		case "openrc-init":
+			return "openrc"
+		case "init":
+			// SysV or BusyBox init, which may be running OpenRC
+		default:
+			// A shell, tini or the application itself: nothing manages
+			// services
+			return "supervisor"
+		}
+	}
+
+	switch {
+	case exists("/run/openrc"), exists("/sbin/openrc-run"):
+		return "openrc"
+	case exists("/sbin/init") && exists(defaultInitDir):
+		return "sysv"
+	default:
+		return "supervisor"
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectInitSystem(t *testing.T) {
+	tests := []struct {
+		name  string
+		comm  string // PID 1, empty for no /proc
+		paths []string
+		want  string
+	}{
+		{"systemd booted", "systemd", []string{"/run/systemd/system/", "/sbin/init", "/etc/init.d/"}, "systemctl"},
+		{"systemd as PID 1", "systemd", nil, "systemctl"},
+		{"openrc-init", "openrc-init", []string{"/sbin/init", "/etc/init.d/"}, "openrc"},
+		{"openrc under init", "init", []string{"/run/openrc/", "/sbin/init", "/etc/init.d/"}, "openrc"},
+		{"sysv init", "init", []string{"/sbin/init", "/etc/init.d/"}, "sysv"},
+		{"init without scripts", "init", []string{"/sbin/init"}, "supervisor"},
+		{"container with init scripts installed", "sh", []string{"/sbin/init", "/sbin/openrc-run", "/etc/init.d/"}, "supervisor"},
+		{"container running tini", "tini", []string{"/run/openrc/"}, "supervisor"},
+		{"no /proc", "", []string{"/sbin/init", "/etc/init.d/"}, "sysv"},
+		{"nothing", "", nil, "supervisor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if tt.comm != "" {
+				tt.paths = append(tt.paths, "/proc/1/comm")
+			}
+			for _, path := range tt.paths {
+				full := filepath.Join(root, path)
+				if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if path[len(path)-1] == '/' {
+					os.MkdirAll(full, 0755)
+					continue
+				}
+				if err := os.WriteFile(full, []byte(tt.comm+"\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := detectInitSystem(root); got != tt.want {
+				t.Errorf("detectInitSystem() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
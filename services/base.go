@@ -1,18 +1,127 @@
 package services
 
 import (
-	"net/http"
+	"context"
 	"regexp"
-
-	"github.com/therealtoxicdev/chronoserve/utils"
+	"time"
 )
 
-type ServiceHandler interface {
-	ListServices(w http.ResponseWriter, r *http.Request)
-	StartService(w http.ResponseWriter, r *http.Request)
-	StopService(w http.ResponseWriter, r *http.Request)
-	ViewServiceLogs(w http.ResponseWriter, r *http.Request)
-	GetServiceStatus(w http.ResponseWriter, r *http.Request)
+// ServiceManager is the transport-agnostic interface implemented by every
+// service backend. Methods return typed results and ServiceError values
+// wrapping the sentinel errors in errors.go, so the same logic can be driven
+// from HTTP handlers, the CLI, schedulers or tests.
+type ServiceManager interface {
+	List(ctx context.Context) ([]ServiceInfo, error)
+	Status(ctx context.Context, name string) (*ServiceStatus, error)
+	Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error)
+
+	Start(ctx context.Context, name string) (*ActionResult, error)
+	Stop(ctx context.Context, name string) (*ActionResult, error)
+	Restart(ctx context.Context, name string) (*ActionResult, error)
+	Reload(ctx context.Context, name string) (*ActionResult, error)
+	ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error)
+
+	// now also applies the change to the running service, like systemctl --now
+	Enable(ctx context.Context, name string, now bool) (*ActionResult, error)
+	Disable(ctx context.Context, name string, now bool) (*ActionResult, error)
+	Mask(ctx context.Context, name string, now bool) (*ActionResult, error)
+	Unmask(ctx context.Context, name string) (*ActionResult, error)
+}
+
+// LogFollower is implemented by backends that can stream log entries as
+// they are written
+type LogFollower interface {
+	// FollowLogs sends the entries selected by query and then every new
+	// entry until ctx is cancelled. The channel is closed when following
+	// stops.
+	FollowLogs(ctx context.Context, name string, query LogQuery) (<-chan LogEntry, error)
+}
+
+// StatusRefresher is implemented by backends that cache service status.
+// Refresh reads the status from the service manager and updates the cache.
+type StatusRefresher interface {
+	Refresh(ctx context.Context, name string) (*ServiceStatus, error)
+}
+
+// ServiceInfo is a summary of a service as returned by List
+type ServiceInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	LoadState   string `json:"loadState,omitempty"`
+	ActiveState string `json:"activeState"`
+	SubState    string `json:"subState,omitempty"`
+}
+
+// ActionResult describes a successfully completed service action
+type ActionResult struct {
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Message string `json:"message"`
+}
+
+// ServiceStatus represents the status of a service
+type ServiceStatus struct {
+	Name          string `json:"name"`
+	Description   string `json:"description,omitempty"`
+	LoadState     string `json:"loadState,omitempty"`
+	Status        string `json:"status"`
+	SubState      string `json:"subState,omitempty"`
+	UnitFileState string `json:"unitFileState"`
+	Enabled       bool   `json:"enabled"`
+	IsActive      bool   `json:"isActive"`
+
+	// Why the service is in its current state
+	Result         string `json:"result,omitempty"`
+	MainPID        int    `json:"mainPid"`
+	NRestarts      int    `json:"nRestarts"`
+	ExecMainStatus int    `json:"execMainStatus"`
+	ExecMainCode   int    `json:"execMainCode"`
+	FragmentPath   string `json:"fragmentPath,omitempty"`
+
+	// When the service last changed state
+	StateChangeAt   *time.Time `json:"stateChangeAt,omitempty"`
+	ActiveEnterAt   *time.Time `json:"activeEnterAt,omitempty"`
+	ActiveExitAt    *time.Time `json:"activeExitAt,omitempty"`
+	InactiveEnterAt *time.Time `json:"inactiveEnterAt,omitempty"`
+	InactiveExitAt  *time.Time `json:"inactiveExitAt,omitempty"`
+
+	// Resource accounting, nil when accounting is disabled for the unit
+	MemoryCurrent *uint64 `json:"memoryCurrent,omitempty"`
+	MemoryPeak    *uint64 `json:"memoryPeak,omitempty"`
+	CPUUsageNSec  *uint64 `json:"cpuUsageNSec,omitempty"`
+
+	Dependencies ServiceDependencies `json:"dependencies"`
+	UpdatedAt    time.Time           `json:"updatedAt"`
+
+	// Verdict of the configured health probes ("healthy", "unhealthy" or
+	// "unknown") and their results, empty when the service has no probes
+	Health string        `json:"health,omitempty"`
+	Probes []ProbeResult `json:"probes,omitempty"`
+}
+
+// ProbeResult is the state of a health probe attached to a service
+type ProbeResult struct {
+	Name                 string     `json:"name"`
+	Type                 string     `json:"type"`
+	State                string     `json:"state"` // healthy, unhealthy, or unknown until a threshold is reached
+	ConsecutiveFailures  int        `json:"consecutiveFailures"`
+	ConsecutiveSuccesses int        `json:"consecutiveSuccesses"`
+	LastCheck            *time.Time `json:"lastCheck,omitempty"`
+	LastDuration         string     `json:"lastDuration,omitempty"`
+	Message              string     `json:"message,omitempty"` // outcome of the last check
+}
+
+// ServiceDependencies lists the units a service is ordered against or
+// depends on
+type ServiceDependencies struct {
+	Requires   []string `json:"requires,omitempty"`
+	Wants      []string `json:"wants,omitempty"`
+	BindsTo    []string `json:"bindsTo,omitempty"`
+	Conflicts  []string `json:"conflicts,omitempty"`
+	After      []string `json:"after,omitempty"`
+	Before     []string `json:"before,omitempty"`
+	RequiredBy []string `json:"requiredBy,omitempty"`
+	WantedBy   []string `json:"wantedBy,omitempty"`
 }
 
 type BaseServiceHandler struct{}
@@ -21,7 +130,3 @@ func (h *BaseServiceHandler) ValidateServiceName(name string) bool {
 	matched, _ := regexp.MatchString(`^[a-zA-Z0-9\-_.]+$`, name)
 	return matched
 }
-
-func (h *BaseServiceHandler) HandleError(w http.ResponseWriter, message string, statusCode int) {
-	utils.WriteErrorResponse(w, message, statusCode)
-}
@@ -0,0 +1,700 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/metrics"
+)
+
+// Ensure DockerService implements ServiceManager and LogFollower
+var (
+	_ ServiceManager = (*DockerService)(nil)
+	_ LogFollower    = (*DockerService)(nil)
+)
+
+// DefaultDockerSocket is where the Docker Engine API listens by default
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// DockerService implements the ServiceManager interface for containers,
+// talking to the Docker Engine API over its unix socket. Each container is
+// a service named after the container.
+type DockerService struct {
+	BaseServiceHandler
+	exec   Executor // only consulted for the timeout of each operation
+	client *http.Client
+}
+
+// NewDockerService creates a Docker service manager using the Engine API at
+// socket. Operations are bounded by the timeouts configured for executor.
+func NewDockerService(executor Executor, socket string) *DockerService {
+	if socket == "" {
+		socket = DefaultDockerSocket
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &DockerService{exec: executor, client: &http.Client{Transport: transport}}
+}
+
+// dockerContainer is an entry of GET /containers/json
+type dockerContainer struct {
+	ID     string   `json:"Id"`
+	Names  []string `json:"Names"`
+	Image  string   `json:"Image"`
+	State  string   `json:"State"`
+	Status string   `json:"Status"`
+}
+
+// dockerInspect is the part of GET /containers/{name}/json used for status
+type dockerInspect struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Status     string `json:"Status"`
+		OOMKilled  bool   `json:"OOMKilled"`
+		Pid        int    `json:"Pid"`
+		ExitCode   int    `json:"ExitCode"`
+		StartedAt  string `json:"StartedAt"`
+		FinishedAt string `json:"FinishedAt"`
+		Health     *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	RestartCount int `json:"RestartCount"`
+	Config       struct {
+		Image string `json:"Image"`
+		Tty   bool   `json:"Tty"`
+	} `json:"Config"`
+	HostConfig struct {
+		RestartPolicy struct {
+			Name string `json:"Name"`
+		} `json:"RestartPolicy"`
+	} `json:"HostConfig"`
+}
+
+// dockerStates maps container states to an active state and sub-state
+var dockerStates = map[string][2]string{
+	"created":    {"inactive", "created"},
+	"running":    {"active", "running"},
+	"paused":     {"active", "paused"},
+	"restarting": {"activating", "auto-restart"},
+	"removing":   {"deactivating", "removing"},
+	"exited":     {"inactive", "exited"},
+	"dead":       {"failed", "dead"},
+}
+
+// dockerActiveState maps a container state onto an active state and
+// sub-state. Containers that exited with a non-zero code have failed.
+func dockerActiveState(state string, exitCode int) (string, string) {
+	s, ok := dockerStates[state]
+	if !ok {
+		return "unknown", state
+	}
+	if state == "exited" && exitCode != 0 {
+		return "failed", s[1]
+	}
+	return s[0], s[1]
+}
+
+// dockerStartsAtBoot reports whether a restart policy starts the container
+// when the Docker daemon starts
+func dockerStartsAtBoot(policy string) bool {
+	return policy == "always" || policy == "unless-stopped"
+}
+
+// List lists all containers, running or not
+func (s *DockerService) List(ctx context.Context) ([]ServiceInfo, error) {
+	var containers []dockerContainer
+	if err := s.get(ctx, "list", "", "/containers/json?all=1", &containers); err != nil {
+		return nil, err
+	}
+
+	services := make([]ServiceInfo, 0, len(containers))
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		// The list API has no exit code, but reports it in Status, e.g.
+		// "Exited (1) 2 hours ago"
+		exitCode := 0
+		if c.State == "exited" {
+			if _, rest, ok := strings.Cut(c.Status, "("); ok {
+				exitCode, _ = strconv.Atoi(strings.SplitN(rest, ")", 2)[0])
+			}
+		}
+		active, sub := dockerActiveState(c.State, exitCode)
+		services = append(services, ServiceInfo{
+			Name:        strings.TrimPrefix(c.Names[0], "/"),
+			Description: c.Image,
+			LoadState:   "loaded",
+			ActiveState: active,
+			SubState:    sub,
+		})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// Status inspects a container
+func (s *DockerService) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("status", name, ErrInvalidName, "")
+	}
+	info, err := s.inspect(ctx, "status", name)
+	if err != nil {
+		return nil, err
+	}
+
+	active, sub := dockerActiveState(info.State.Status, info.State.ExitCode)
+	status := &ServiceStatus{
+		Name:           strings.TrimPrefix(info.Name, "/"),
+		Description:    info.Config.Image,
+		LoadState:      "loaded",
+		Status:         active,
+		SubState:       sub,
+		UnitFileState:  "disabled",
+		Enabled:        dockerStartsAtBoot(info.HostConfig.RestartPolicy.Name),
+		IsActive:       active == "active",
+		MainPID:        info.State.Pid,
+		NRestarts:      info.RestartCount,
+		ExecMainStatus: info.State.ExitCode,
+		FragmentPath:   info.ID,
+		UpdatedAt:      time.Now(),
+	}
+	if status.Enabled {
+		status.UnitFileState = "enabled"
+	}
+	switch {
+	case info.State.OOMKilled:
+		status.Result = "oom-kill"
+	case info.State.Status == "exited" && info.State.ExitCode != 0:
+		status.Result = "exit-code"
+	case info.State.Status == "exited":
+		status.Result = "success"
+	}
+	if info.State.Status == "exited" || info.State.Status == "dead" {
+		status.ExecMainCode = cldExited
+	}
+
+	startedAt, finishedAt := parseDockerTime(info.State.StartedAt), parseDockerTime(info.State.FinishedAt)
+	status.ActiveEnterAt, status.InactiveExitAt = startedAt, startedAt
+	if !status.IsActive && finishedAt != nil {
+		status.ActiveExitAt, status.InactiveEnterAt = finishedAt, finishedAt
+	}
+	status.StateChangeAt = startedAt
+	if finishedAt != nil && (startedAt == nil || finishedAt.After(*startedAt)) {
+		status.StateChangeAt = finishedAt
+	}
+	if info.State.Health != nil {
+		// The container's own HEALTHCHECK, replaced by the results of
+		// ChronoServe probes when the service has any
+		status.Health = info.State.Health.Status
+		if status.Health == "starting" {
+			status.Health = "unknown"
+		}
+	}
+	return status, nil
+}
+
+// parseDockerTime parses an Engine API timestamp. Docker reports unset
+// times as 0001-01-01T00:00:00Z.
+func parseDockerTime(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.Year() <= 1 {
+		return nil
+	}
+	return &t
+}
+
+// Start starts a container
+func (s *DockerService) Start(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "start", name, "/start", "started")
+}
+
+// Stop stops a container, letting Docker kill it after its stop timeout
+func (s *DockerService) Stop(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "stop", name, "/stop", "stopped")
+}
+
+// Restart restarts a container, starting it if it is not running
+func (s *DockerService) Restart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "restart", name, "/restart", "restarted")
+}
+
+// Reload sends SIGHUP to the container's main process, the signal most
+// daemons reload their configuration on
+func (s *DockerService) Reload(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "reload", name, "/kill?signal=HUP", "reloaded")
+}
+
+// ReloadOrRestart reloads a running container and starts a stopped one
+func (s *DockerService) ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error) {
+	status, err := s.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.IsActive {
+		return s.runAction(ctx, "reload-or-restart", name, "/kill?signal=HUP", "reloaded")
+	}
+	return s.runAction(ctx, "reload-or-restart", name, "/restart", "restarted")
+}
+
+// Enable sets the container's restart policy to unless-stopped, so Docker
+// starts it at boot, starting it as well when now is set
+func (s *DockerService) Enable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.setRestartPolicy(ctx, "enable", name, "unless-stopped", "/start", "enabled", now)
+}
+
+// Disable sets the container's restart policy to no, stopping it as well
+// when now is set
+func (s *DockerService) Disable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.setRestartPolicy(ctx, "disable", name, "no", "/stop", "disabled", now)
+}
+
+// Mask is not supported: Docker has no way to forbid starting a container
+func (s *DockerService) Mask(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return nil, newServiceError("mask", name, ErrUnsupported, "containers cannot be masked")
+}
+
+// Unmask is not supported, see Mask
+func (s *DockerService) Unmask(ctx context.Context, name string) (*ActionResult, error) {
+	return nil, newServiceError("unmask", name, ErrUnsupported, "containers cannot be masked")
+}
+
+// runAction posts to a container endpoint such as /containers/{name}/start.
+// Docker answers 304 when the container is already in the requested state.
+func (s *DockerService) runAction(ctx context.Context, op, name, path, pastTense string) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(op, name, ErrInvalidName, "")
+	}
+	status, err := s.do(ctx, op, name, http.MethodPost, "/containers/"+url.PathEscape(name)+path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotModified {
+		state := "running"
+		if op == "stop" {
+			state = "stopped"
+		}
+		return nil, newServiceError(op, name, ErrAlreadyInState, fmt.Sprintf("Service %s is already %s", name, state))
+	}
+	return &ActionResult{
+		Name:    name,
+		Action:  op,
+		Message: fmt.Sprintf("Service %s %s successfully", name, pastTense),
+	}, nil
+}
+
+// setRestartPolicy updates the container's restart policy, then posts to
+// nowPath when now is set
+func (s *DockerService) setRestartPolicy(ctx context.Context, op, name, policy, nowPath, pastTense string, now bool) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(op, name, ErrInvalidName, "")
+	}
+	body := map[string]any{"RestartPolicy": map[string]any{"Name": policy}}
+	if _, err := s.do(ctx, op, name, http.MethodPost, "/containers/"+url.PathEscape(name)+"/update", body, nil); err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Service %s %s successfully", name, pastTense)
+	if now {
+		if _, err := s.do(ctx, op, name, http.MethodPost, "/containers/"+url.PathEscape(name)+nowPath, nil, nil); err != nil {
+			return nil, err
+		}
+		message += " (applied now)"
+	}
+	return &ActionResult{Name: name, Action: op, Message: message}, nil
+}
+
+// Logs reads a container's output. stdout lines have priority info and
+// stderr lines err. Cursors are the UnixNano timestamps Docker records for
+// each line.
+func (s *DockerService) Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error) {
+	entries, _, err := s.logs(ctx, name, query)
+	return entries, err
+}
+
+// logs reads the output selected by query, returning the container's
+// inspect information as well
+func (s *DockerService) logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, *dockerInspect, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	if err := query.Validate(); err != nil {
+		return nil, nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+	params, after, err := dockerLogParams(query)
+	if err != nil {
+		return nil, nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+	// Whether the container has a TTY decides the response format
+	info, err := s.inspect(ctx, "logs", name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := s.withTimeout(ctx, "logs")
+	defer cancel()
+	body, err := s.stream(ctx, "logs", name, "/containers/"+url.PathEscape(name)+"/logs?"+params.Encode())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer body.Close()
+
+	filter := newLogFilter(query)
+	var entries []LogEntry
+	err = readDockerLogs(body, info.Config.Tty, name, func(entry LogEntry) bool {
+		if dockerCursorIncluded(entry, query, after) && filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, newServiceError("logs", name, classifyError(ctx, err, ""), "")
+	}
+	// stdout and stderr frames may interleave out of order
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+	return selectLogEntries(entries, query), info, nil
+}
+
+// FollowLogs sends the output selected by query and then every new line
+// until ctx is cancelled
+func (s *DockerService) FollowLogs(ctx context.Context, name string, query LogQuery) (<-chan LogEntry, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	if query.Reverse {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, "reverse cannot be combined with follow")
+	}
+	backlog, info, err := s.logs(ctx, name, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Follow from the last line sent, or from now
+	since := time.Now()
+	var last int64
+	if len(backlog) > 0 {
+		last, _ = strconv.ParseInt(backlog[len(backlog)-1].Cursor, 10, 64)
+		since = time.Unix(0, last)
+	}
+	params := url.Values{
+		"stdout": {"1"}, "stderr": {"1"}, "timestamps": {"1"}, "follow": {"1"},
+		"since": {dockerUnixTime(since)},
+	}
+	if query.Until != nil {
+		params.Set("until", dockerUnixTime(*query.Until))
+	}
+	body, err := s.stream(ctx, "logs", name, "/containers/"+url.PathEscape(name)+"/logs?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := newLogFilter(query)
+	entries := make(chan LogEntry, 64)
+	done := make(chan struct{})
+	go func() {
+		defer close(entries)
+		defer close(done)
+		defer body.Close()
+		for _, entry := range backlog {
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+		readDockerLogs(body, info.Config.Tty, name, func(entry LogEntry) bool {
+			if c, _ := strconv.ParseInt(entry.Cursor, 10, 64); c <= last || !filter.matches(entry) {
+				return true
+			}
+			select {
+			case entries <- entry:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	// Closing the body ends the blocked read when the client goes away
+	go func() {
+		select {
+		case <-ctx.Done():
+			body.Close()
+		case <-done:
+		}
+	}()
+	return entries, nil
+}
+
+// dockerLogParams translates query into /logs parameters. Docker can only
+// limit the number of lines from the end, so filtered queries and paging
+// forward from a cursor read every line in the time range instead. after is
+// the cursor as a number.
+func dockerLogParams(query LogQuery) (url.Values, int64, error) {
+	params := url.Values{"stdout": {"1"}, "stderr": {"1"}, "timestamps": {"1"}}
+	var after int64
+	if query.Cursor != "" {
+		c, err := strconv.ParseInt(query.Cursor, 10, 64)
+		if err != nil {
+			return nil, 0, errors.New("invalid cursor")
+		}
+		after = c
+	}
+
+	since, until := query.Since, query.Until
+	if query.Cursor != "" {
+		cursor := time.Unix(0, after)
+		if query.Reverse && (until == nil || cursor.Before(*until)) {
+			until = &cursor
+		} else if !query.Reverse && (since == nil || cursor.After(*since)) {
+			since = &cursor
+		}
+	}
+	if since != nil {
+		params.Set("since", dockerUnixTime(*since))
+	}
+	if until != nil {
+		params.Set("until", dockerUnixTime(*until))
+	}
+
+	if query.Grep == "" && query.Priority == "" && (query.Cursor == "" || query.Reverse) {
+		// One extra line, as the line at the cursor itself is dropped
+		params.Set("tail", strconv.Itoa(query.Lines+1))
+	}
+	return params, after, nil
+}
+
+// dockerCursorIncluded reports whether entry lies beyond the query's cursor
+func dockerCursorIncluded(entry LogEntry, query LogQuery, after int64) bool {
+	if query.Cursor == "" {
+		return true
+	}
+	c, _ := strconv.ParseInt(entry.Cursor, 10, 64)
+	if query.Reverse {
+		return c < after
+	}
+	return c > after
+}
+
+// dockerUnixTime formats t as the fractional Unix time the Engine API takes
+func dockerUnixTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// readDockerLogs parses a /logs response and passes each line to emit until
+// it returns false. Without a TTY the response is multiplexed: every frame
+// has an 8-byte header holding the stream (1 stdout, 2 stderr) and the
+// frame length. Output left without a trailing newline is emitted at the
+// end; a response cut off within a frame is an error.
+func readDockerLogs(body io.Reader, tty bool, name string, emit func(LogEntry) bool) error {
+	if tty {
+		return readDockerLogLines(body, 6, name, emit)
+	}
+
+	reader := bufio.NewReader(body)
+	header := make([]byte, 8)
+	// Frames are not aligned to lines, so keep a partial line per stream
+	partial := map[byte]string{}
+	// write emits the complete lines of data on stream, reporting whether
+	// emit wants more
+	write := func(stream byte, data string) bool {
+		lines := strings.Split(partial[stream]+data, "\n")
+		partial[stream] = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			if !emit(parseDockerLogLine(line, dockerStreamPriority(stream), name)) {
+				return false
+			}
+		}
+		return true
+	}
+	flush := func() {
+		for _, stream := range []byte{1, 2} {
+			if line := partial[stream]; line != "" && !emit(parseDockerLogLine(line, dockerStreamPriority(stream), name)) {
+				return
+			}
+		}
+	}
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				flush()
+				return nil
+			}
+			flush()
+			return fmt.Errorf("truncated log frame header: %w", err)
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if n, err := io.ReadFull(reader, frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			if write(header[0], string(frame[:n])) {
+				flush()
+			}
+			return fmt.Errorf("truncated log frame: %d of %d bytes: %w", n, len(frame), err)
+		}
+		if !write(header[0], string(frame)) {
+			return nil
+		}
+	}
+}
+
+// dockerStreamPriority returns the priority of lines from a multiplexed
+// stream: err for stderr, info otherwise
+func dockerStreamPriority(stream byte) int {
+	if stream == 2 {
+		return 3
+	}
+	return 6
+}
+
+// readDockerLogLines reads the raw output of a container with a TTY, where
+// stdout and stderr are one stream
+func readDockerLogLines(body io.Reader, priority int, name string, emit func(LogEntry) bool) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if !emit(parseDockerLogLine(scanner.Text(), priority, name)) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// parseDockerLogLine parses "<RFC3339Nano timestamp> <message>"
+func parseDockerLogLine(line string, priority int, name string) LogEntry {
+	line = strings.TrimRight(line, "\r")
+	entry := LogEntry{
+		Priority:   priority,
+		Level:      priorityName(priority),
+		Identifier: name,
+		Message:    line,
+	}
+	if stamp, message, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			entry.Timestamp, entry.Message = t, message
+			entry.Cursor = strconv.FormatInt(t.UnixNano(), 10)
+		}
+	}
+	return entry
+}
+
+// inspect returns the low-level information on a container
+func (s *DockerService) inspect(ctx context.Context, op, name string) (*dockerInspect, error) {
+	var info dockerInspect
+	if err := s.get(ctx, op, name, "/containers/"+url.PathEscape(name)+"/json", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// get fetches path and decodes the JSON response into v
+func (s *DockerService) get(ctx context.Context, op, name, path string, v any) error {
+	_, err := s.do(ctx, op, name, http.MethodGet, path, nil, v)
+	return err
+}
+
+// do sends a request to the Engine API within the timeout of op, encoding
+// body as JSON and decoding the response into v when they are not nil. It
+// returns the HTTP status of successful requests, including 304.
+func (s *DockerService) do(ctx context.Context, op, name, method, path string, body, v any) (int, error) {
+	ctx, cancel := s.withTimeout(ctx, op)
+	defer cancel()
+
+	resp, err := s.send(ctx, op, name, method, path, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode != http.StatusNotModified {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return 0, fmt.Errorf("failed to parse docker response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// stream sends a GET request without a timeout of its own and returns the
+// response body, for log output
+func (s *DockerService) stream(ctx context.Context, op, name, path string) (io.ReadCloser, error) {
+	resp, err := s.send(ctx, op, name, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// send performs a request and converts failures and error responses into
+// ServiceErrors
+func (s *DockerService) send(ctx context.Context, op, name, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = strings.NewReader(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, reader)
+	if err != nil {
+		return nil, newServiceError(op, name, ErrInvalidArgument, err.Error())
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	countCommand("docker", op, err)
+	if err != nil {
+		return nil, newServiceError(op, name, classifyError(ctx, err, err.Error()), "")
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	metrics.CommandFailures.Inc("docker", op)
+
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+	var sentinel error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		sentinel = ErrNotFound
+	case http.StatusBadRequest, http.StatusConflict:
+		// 409 is returned e.g. when signalling a container that is not running
+		sentinel = ErrInvalidArgument
+	case http.StatusUnauthorized, http.StatusForbidden:
+		sentinel = ErrPermissionDenied
+	default:
+		sentinel = fmt.Errorf("docker returned %s", resp.Status)
+	}
+	return nil, newServiceError(op, name, sentinel, apiErr.Message)
+}
+
+// withTimeout bounds ctx by the timeout configured for op
+func (s *DockerService) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := defaultExecTimeout
+	if t, ok := s.exec.(interface{ Timeout(string) time.Duration }); ok {
+		timeout = t.Timeout(op)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
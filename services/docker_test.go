@@ -0,0 +1,359 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDocker is a Docker Engine API serving canned containers on a unix
+// socket
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers map[string]*dockerInspect
+	logs       map[string][]byte // /logs response body by container
+	logQueries []string
+}
+
+// newFakeDocker starts a fake Engine API for containers and returns it with
+// a DockerService talking to it
+func newFakeDocker(t *testing.T, containers ...*dockerInspect) (*fakeDocker, *DockerService) {
+	t.Helper()
+	d := &fakeDocker{containers: make(map[string]*dockerInspect), logs: make(map[string][]byte)}
+	for _, c := range containers {
+		d.containers[strings.TrimPrefix(c.Name, "/")] = c
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", d.list)
+	mux.HandleFunc("GET /containers/{name}/json", d.inspect)
+	mux.HandleFunc("GET /containers/{name}/logs", d.containerLogs)
+	mux.HandleFunc("POST /containers/{name}/{action}", d.action)
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets not available: %v", err)
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return d, NewDockerService(NewFakeExecutor(), socket)
+}
+
+// container returns the named container, writing a 404 like the Engine API
+// when there is none
+func (d *fakeDocker) container(w http.ResponseWriter, r *http.Request) (*dockerInspect, bool) {
+	c, ok := d.containers[r.PathValue("name")]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "No such container: " + r.PathValue("name")})
+	}
+	return c, ok
+}
+
+func (d *fakeDocker) list(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var list []dockerContainer
+	for _, c := range d.containers {
+		status := "Up 2 hours"
+		if c.State.Status == "exited" {
+			status = "Exited (" + strconv.Itoa(c.State.ExitCode) + ") 5 minutes ago"
+		}
+		list = append(list, dockerContainer{ID: c.ID, Names: []string{c.Name}, Image: c.Config.Image, State: c.State.Status, Status: status})
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func (d *fakeDocker) inspect(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c, ok := d.container(w, r); ok {
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// action starts or stops a container, answering 304 when it is already in
+// that state as the Engine API does
+func (d *fakeDocker) action(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.container(w, r)
+	if !ok {
+		return
+	}
+	running := c.State.Status == "running"
+	switch r.PathValue("action") {
+	case "start":
+		if running {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		c.State.Status, c.State.ExitCode = "running", 0
+	case "stop":
+		if !running {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		c.State.Status = "exited"
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *fakeDocker) containerLogs(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.container(w, r); !ok {
+		return
+	}
+	d.logQueries = append(d.logQueries, r.URL.RawQuery)
+	w.Write(d.logs[r.PathValue("name")])
+}
+
+// testContainer returns inspect information for a container in state
+func testContainer(name, state string, exitCode int) *dockerInspect {
+	c := &dockerInspect{ID: "id-" + name, Name: "/" + name}
+	c.State.Status = state
+	c.State.ExitCode = exitCode
+	c.State.StartedAt = "2024-03-05T10:20:30.5Z"
+	c.State.FinishedAt = "0001-01-01T00:00:00Z"
+	c.Config.Image = name + ":latest"
+	return c
+}
+
+// dockerFrame builds a multiplexed log frame of data on stream
+func dockerFrame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+func TestDockerList(t *testing.T) {
+	_, s := newFakeDocker(t,
+		testContainer("web", "running", 0),
+		testContainer("batch", "exited", 0),
+		testContainer("crashed", "exited", 137),
+		testContainer("flapping", "restarting", 1),
+		testContainer("frozen", "paused", 0),
+		testContainer("zombie", "dead", 0),
+	)
+
+	list, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range list {
+		got = append(got, info.Name+"="+info.ActiveState+"/"+info.SubState)
+	}
+	want := []string{
+		"batch=inactive/exited",
+		"crashed=failed/exited",
+		"flapping=activating/auto-restart",
+		"frozen=active/paused",
+		"web=active/running",
+		"zombie=failed/dead",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestDockerStatus(t *testing.T) {
+	oom := testContainer("worker", "exited", 137)
+	oom.State.OOMKilled = true
+	oom.State.FinishedAt = "2024-03-05T11:00:00Z"
+	oom.RestartCount = 4
+	oom.HostConfig.RestartPolicy.Name = "unless-stopped"
+	_, s := newFakeDocker(t, oom, testContainer("web", "running", 0))
+
+	status, err := s.Status(context.Background(), "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "failed" || status.IsActive || status.Result != "oom-kill" || status.ExecMainStatus != 137 || status.ExecMainCode != cldExited {
+		t.Errorf("status = %s, active %v, result %s, exit %d/%d", status.Status, status.IsActive, status.Result, status.ExecMainCode, status.ExecMainStatus)
+	}
+	if !status.Enabled || status.UnitFileState != "enabled" || status.NRestarts != 4 {
+		t.Errorf("enabled = %v (%s), restarts %d", status.Enabled, status.UnitFileState, status.NRestarts)
+	}
+	if status.InactiveEnterAt == nil || status.StateChangeAt == nil || !status.StateChangeAt.Equal(*status.InactiveEnterAt) {
+		t.Errorf("InactiveEnterAt = %v, StateChangeAt = %v", status.InactiveEnterAt, status.StateChangeAt)
+	}
+
+	status, err = s.Status(context.Background(), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "active" || !status.IsActive || status.Enabled || status.ActiveExitAt != nil {
+		t.Errorf("status = %+v", status)
+	}
+
+	_, err = s.Status(context.Background(), "missing")
+	var svcErr *ServiceError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &svcErr) || svcErr.Detail != "No such container: missing" {
+		t.Errorf("Status() of a missing container error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDockerActionAlreadyInState(t *testing.T) {
+	_, s := newFakeDocker(t, testContainer("web", "running", 0), testContainer("batch", "exited", 0))
+	ctx := context.Background()
+
+	if _, err := s.Start(ctx, "web"); !errors.Is(err, ErrAlreadyInState) || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("Start() of a running container error = %v, want %v", err, ErrAlreadyInState)
+	}
+	if _, err := s.Stop(ctx, "batch"); !errors.Is(err, ErrAlreadyInState) || !strings.Contains(err.Error(), "already stopped") {
+		t.Errorf("Stop() of a stopped container error = %v, want %v", err, ErrAlreadyInState)
+	}
+
+	result, err := s.Start(ctx, "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Message != "Service batch started successfully" {
+		t.Errorf("message = %q", result.Message)
+	}
+	// Actions run through RunAction report a 304 as done
+	if result, err := RunAction(ctx, s, "start", "batch"); err != nil || !strings.Contains(result.Message, "already running") {
+		t.Errorf("RunAction() = %+v, %v", result, err)
+	}
+
+	if _, err := s.Start(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Start() of a missing container error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDockerLogsMultiplexed(t *testing.T) {
+	d, s := newFakeDocker(t, testContainer("web", "running", 0))
+	var body bytes.Buffer
+	// Frames split lines, and the streams interleave
+	body.Write(dockerFrame(1, "2024-03-05T10:20:30.000000001Z listening\n2024-03-05T10:20:30.000000003Z req"))
+	body.Write(dockerFrame(2, "2024-03-05T10:20:30.000000002Z warning: slow disk\n"))
+	body.Write(dockerFrame(1, "uest served\n2024-03-05T10:20:30.000000004Z shutting"))
+	d.logs["web"] = body.Bytes()
+
+	entries, err := s.Logs(context.Background(), "web", LogQuery{Lines: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Level+" "+entry.Message)
+	}
+	want := []string{"info listening", "err warning: slow disk", "info request served", "info shutting"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+	if entries[0].Cursor != "1709634030000000001" || entries[0].Identifier != "web" {
+		t.Errorf("first entry = %+v", entries[0])
+	}
+	if len(d.logQueries) != 1 || !strings.Contains(d.logQueries[0], "tail=11") {
+		t.Errorf("log queries = %v", d.logQueries)
+	}
+
+	// Paging from a cursor drops the entry at the cursor
+	entries, err = s.Logs(context.Background(), "web", LogQuery{Lines: 2, Cursor: "1709634030000000002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Message != "request served" || entries[1].Message != "shutting" {
+		t.Errorf("entries after cursor = %+v", entries)
+	}
+}
+
+func TestDockerLogsTruncated(t *testing.T) {
+	d, s := newFakeDocker(t, testContainer("web", "running", 0))
+	frame := dockerFrame(1, "2024-03-05T10:20:30Z complete\n2024-03-05T10:20:31Z cut off")
+	d.logs["web"] = frame[:len(frame)-4]
+
+	if _, err := s.Logs(context.Background(), "web", LogQuery{Lines: 10}); err == nil {
+		t.Error("Logs() of a truncated response succeeded")
+	}
+}
+
+func TestReadDockerLogs(t *testing.T) {
+	full := dockerFrame(2, "2024-03-05T10:20:30Z oops\n2024-03-05T10:20:31Z partial")
+	tests := []struct {
+		name    string
+		body    []byte
+		tty     bool
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "trailing partial lines",
+			body: append(dockerFrame(2, "2024-03-05T10:20:30Z err tail"), dockerFrame(1, "2024-03-05T10:20:31Z out tail")...),
+			want: []string{"info out tail", "err err tail"},
+		},
+		{
+			name:    "truncated frame",
+			body:    full[:len(full)-3],
+			want:    []string{"err oops", "err part"},
+			wantErr: true,
+		},
+		{
+			name:    "truncated header",
+			body:    append(dockerFrame(1, "2024-03-05T10:20:30Z whole\n"), 1, 0, 0),
+			want:    []string{"info whole"},
+			wantErr: true,
+		},
+		{
+			name: "tty",
+			body: []byte("2024-03-05T10:20:30Z one\r\n2024-03-05T10:20:31Z two"),
+			tty:  true,
+			want: []string{"info one", "info two"},
+		},
+		{
+			name: "empty",
+			body: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := readDockerLogs(bytes.NewReader(tt.body), tt.tty, "web", func(entry LogEntry) bool {
+				got = append(got, entry.Level+" "+entry.Message)
+				return true
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readDockerLogs() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("error = %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDockerLogsStops(t *testing.T) {
+	body := dockerFrame(1, "2024-03-05T10:20:30Z one\n2024-03-05T10:20:31Z two\n2024-03-05T10:20:32Z three")
+	count := 0
+	err := readDockerLogs(bytes.NewReader(body), false, "web", func(LogEntry) bool {
+		count++
+		return false
+	})
+	if err != nil || count != 1 {
+		t.Errorf("readDockerLogs() = %v after %d lines, want nil after 1", err, count)
+	}
+}
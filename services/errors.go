@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Sentinel errors returned (wrapped in a ServiceError) by ServiceManager
+// implementations. Callers should test for them with errors.Is.
+var (
+	ErrInvalidName      = errors.New("invalid service name")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrNotFound         = errors.New("service not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrTimeout          = errors.New("operation timed out")
+	ErrAlreadyInState   = errors.New("service already in desired state")
+	ErrUnsupported      = errors.New("operation not supported")
+)
+
+// ServiceError describes a failed operation against a single service
+type ServiceError struct {
+	Op     string // operation that failed, e.g. "start"
+	Name   string // service the operation targeted
+	Err    error  // one of the sentinel errors above, or the underlying cause
+	Detail string // backend output explaining the failure, if any
+}
+
+func (e *ServiceError) Error() string {
+	msg := fmt.Sprintf("%s %s: %v", e.Op, e.Name, e.Err)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// newServiceError builds a ServiceError for op on name
+func newServiceError(op, name string, err error, detail string) *ServiceError {
+	return &ServiceError{Op: op, Name: name, Err: err, Detail: strings.TrimSpace(detail)}
+}
+
+// notFoundPatterns match the messages with which service managers report
+// an unknown service. They are specific to the tools that print them, so
+// that e.g. a missing executable ("no such file or directory") is not taken
+// for a missing service.
+var notFoundPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`unit \S+ (not found|could not be found|not loaded)`), // systemctl
+	regexp.MustCompile(`does not exist as an installed service`),             // sc, error 1060
+	regexp.MustCompile(`cannot find any service with service name`),          // PowerShell Get-Service
+	regexp.MustCompile(`service \S+ does not exist`),                         // OpenRC rc-service
+	regexp.MustCompile(`unrecognized service`),                               // SysV service
+}
+
+// permissionPatterns match the messages with which service managers report
+// that the caller may not manage a service
+var permissionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`access denied`),                       // systemctl, polkit
+	regexp.MustCompile(`interactive authentication required`), // systemctl, polkit
+	regexp.MustCompile(`access is denied`),                    // sc, PowerShell
+	regexp.MustCompile(`cannot open \S+ service on computer`), // PowerShell Start-Service, Stop-Service
+	regexp.MustCompile(`permission denied`),                   // EACCES
+}
+
+// classifyError maps a failed backend command onto one of the sentinel errors
+// by inspecting the context and the command's diagnostic output. Unrecognised
+// failures are returned unchanged.
+func classifyError(ctx context.Context, err error, output string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+
+	lower := strings.ToLower(output)
+	if matchAny(notFoundPatterns, lower) {
+		return ErrNotFound
+	}
+	if matchAny(permissionPatterns, lower) {
+		return ErrPermissionDenied
+	}
+	return err
+}
+
+// matchAny reports whether s matches one of patterns
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/metrics"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// defaultExecTimeout bounds commands when no timeout is configured
+const defaultExecTimeout = 30 * time.Second
+
+// Executor runs external commands on behalf of service backends. op names
+// the service operation the command belongs to (e.g. "stop") and selects
+// the timeout applied to it.
+type Executor interface {
+	Run(ctx context.Context, op string, command string, args ...string) (*ExecResult, error)
+
+	// Stream starts a long-running command, such as journalctl --follow, and
+	// returns its standard output as it is produced. No timeout is applied:
+	// the command runs until ctx is cancelled, it exits, or the returned
+	// reader is closed.
+	Stream(ctx context.Context, op string, command string, args ...string) (io.ReadCloser, error)
+}
+
+// ExecResult holds the outcome of a command run by an Executor
+type ExecResult struct {
+	Command  string
+	Args     []string
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	Duration time.Duration
+}
+
+// ExecError is returned by an Executor when a command fails to start, exits
+// non-zero, times out or is cancelled
+type ExecError struct {
+	Command  string
+	Args     []string
+	ExitCode int    // -1 if the command did not exit normally
+	Stderr   string // captured standard error
+	Err      error  // context.DeadlineExceeded / context.Canceled when interrupted
+}
+
+func (e *ExecError) Error() string {
+	msg := fmt.Sprintf("%s %s: %v", e.Command, strings.Join(e.Args, " "), e.Err)
+	if e.Stderr != "" {
+		msg += ": " + strings.TrimSpace(e.Stderr)
+	}
+	return msg
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// CommandExecutor runs commands with os/exec, bounding each one by the
+// timeout configured for its operation
+type CommandExecutor struct {
+	defaultTimeout time.Duration
+	timeouts       map[string]time.Duration
+}
+
+// Ensure CommandExecutor implements Executor
+var _ Executor = (*CommandExecutor)(nil)
+
+// NewCommandExecutor creates an executor using the timeouts in cfg. Invalid
+// or missing durations fall back to the default timeout.
+func NewCommandExecutor(cfg utils.ExecConfig) *CommandExecutor {
+	e := &CommandExecutor{
+		defaultTimeout: defaultExecTimeout,
+		timeouts:       make(map[string]time.Duration),
+	}
+	if d, err := time.ParseDuration(cfg.Timeout); err == nil && d > 0 {
+		e.defaultTimeout = d
+	}
+	for op, value := range cfg.Operations {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			e.timeouts[op] = d
+		}
+	}
+	return e
+}
+
+// Timeout returns the timeout applied to commands run for op
+func (e *CommandExecutor) Timeout(op string) time.Duration {
+	if d, ok := e.timeouts[op]; ok {
+		return d
+	}
+	return e.defaultTimeout
+}
+
+// Run executes command, capturing stdout and stderr separately. The command
+// is killed when ctx is cancelled (e.g. the HTTP client went away) or the
+// operation's timeout elapses.
+func (e *CommandExecutor) Run(ctx context.Context, op string, command string, args ...string) (*ExecResult, error) {
+	runCtx, cancel := context.WithTimeout(ctx, e.Timeout(op))
+	defer cancel()
+
+	cmd := exec.CommandContext(runCtx, command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	countCommand(command, op, err)
+	result := &ExecResult{
+		Command:  command,
+		Args:     args,
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Duration: time.Since(start),
+	}
+
+	if err != nil {
+		// Report why the command was interrupted rather than "signal: killed"
+		if ctxErr := runCtx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return result, &ExecError{
+			Command:  command,
+			Args:     args,
+			ExitCode: result.ExitCode,
+			Stderr:   stderr.String(),
+			Err:      err,
+		}
+	}
+	return result, nil
+}
+
+// Stream starts command and returns a reader over its standard output.
+// Closing the reader kills the command and waits for it to exit.
+func (e *CommandExecutor) Stream(ctx context.Context, op string, command string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, &ExecError{Command: command, Args: args, ExitCode: -1, Err: err}
+	}
+	err = cmd.Start()
+	countCommand(command, op, err)
+	if err != nil {
+		return nil, &ExecError{Command: command, Args: args, ExitCode: -1, Stderr: stderr.String(), Err: err}
+	}
+	return &commandStream{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// countCommand records a command run for op in the command metrics
+func countCommand(command, op string, err error) {
+	name := filepath.Base(command)
+	metrics.CommandExecutions.Inc(name, op)
+	if err != nil {
+		metrics.CommandFailures.Inc(name, op)
+	}
+}
+
+// commandStream is the reader returned by CommandExecutor.Stream
+type commandStream struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	once sync.Once
+}
+
+func (s *commandStream) Close() error {
+	s.once.Do(func() {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+	})
+	return nil
+}
+
+// runCommand runs a backend command through executor and converts failures
+// into ServiceErrors classified from the command's stderr
+func runCommand(ctx context.Context, executor Executor, op, name, command string, args ...string) ([]byte, error) {
+	result, err := executor.Run(ctx, op, command, args...)
+	if err != nil {
+		var execErr *ExecError
+		if errors.As(err, &execErr) {
+			return nil, newServiceError(op, name, classifyError(ctx, execErr.Err, execErr.Stderr), execErr.Stderr)
+		}
+		return nil, newServiceError(op, name, classifyError(ctx, err, ""), "")
+	}
+	return result.Stdout, nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// FakeResponse is the canned outcome a FakeExecutor replays for a command
+type FakeResponse struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Err      error // returned wrapped in an ExecError when set
+}
+
+// err returns the error a command with this response fails with, nil if it
+// succeeds. Like CommandExecutor, a non-zero exit code is a failure.
+func (r FakeResponse) err() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	if r.ExitCode != 0 {
+		return fmt.Errorf("exit status %d", r.ExitCode)
+	}
+	return nil
+}
+
+// FakeCommand is a command recorded by a FakeExecutor
+type FakeCommand struct {
+	Op      string
+	Command string
+	Args    []string
+}
+
+// String returns the command line, e.g. "systemctl start nginx"
+func (c FakeCommand) String() string {
+	return strings.TrimSpace(c.Command + " " + strings.Join(c.Args, " "))
+}
+
+// FakeExecutor is an Executor for tests. It records every command it is
+// asked to run and replays canned responses keyed by command line.
+type FakeExecutor struct {
+	mu        sync.Mutex
+	responses map[string]FakeResponse
+	commands  []FakeCommand
+
+	// Default is replayed for commands without a registered response
+	Default FakeResponse
+}
+
+// Ensure FakeExecutor implements Executor
+var _ Executor = (*FakeExecutor)(nil)
+
+// NewFakeExecutor creates an empty FakeExecutor
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{responses: make(map[string]FakeResponse)}
+}
+
+// On registers the response replayed for commandLine, e.g.
+// On("systemctl start nginx", FakeResponse{})
+func (f *FakeExecutor) On(commandLine string, response FakeResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[commandLine] = response
+}
+
+// Commands returns the commands run so far, in order
+func (f *FakeExecutor) Commands() []FakeCommand {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCommand(nil), f.commands...)
+}
+
+// Stream records the command and replays the stdout of its canned response
+// as a stream
+func (f *FakeExecutor) Stream(ctx context.Context, op string, command string, args ...string) (io.ReadCloser, error) {
+	result, err := f.Run(ctx, op, command, args...)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(result.Stdout)), nil
+}
+
+// Run records the command and replays its canned response
+func (f *FakeExecutor) Run(ctx context.Context, op string, command string, args ...string) (*ExecResult, error) {
+	recorded := FakeCommand{Op: op, Command: command, Args: append([]string(nil), args...)}
+
+	f.mu.Lock()
+	f.commands = append(f.commands, recorded)
+	response, ok := f.responses[recorded.String()]
+	if !ok {
+		response = f.Default
+	}
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, &ExecError{Command: command, Args: args, ExitCode: -1, Err: err}
+	}
+
+	result := &ExecResult{
+		Command:  command,
+		Args:     args,
+		Stdout:   []byte(response.Stdout),
+		Stderr:   []byte(response.Stderr),
+		ExitCode: response.ExitCode,
+	}
+	if err := response.err(); err != nil {
+		return result, &ExecError{
+			Command:  command,
+			Args:     args,
+			ExitCode: response.ExitCode,
+			Stderr:   response.Stderr,
+			Err:      err,
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		response FakeResponse
+		expired  bool
+		wantErr  error // sentinel wrapped in the ServiceError, nil for success
+		wantOut  string
+	}{
+		{
+			name:     "success",
+			response: FakeResponse{Stdout: "ActiveState=active\n"},
+			wantOut:  "ActiveState=active\n",
+		},
+		{
+			name:     "unit not found",
+			response: FakeResponse{ExitCode: 5, Stderr: "Failed to start nginx.service: Unit nginx.service not found.\n"},
+			wantErr:  ErrNotFound,
+		},
+		{
+			name:     "access denied",
+			response: FakeResponse{ExitCode: 1, Stderr: "Failed to start nginx.service: Access denied\n"},
+			wantErr:  ErrPermissionDenied,
+		},
+		{
+			name:     "interactive authentication",
+			response: FakeResponse{ExitCode: 1, Stderr: "Failed to start nginx.service: Interactive authentication required.\n"},
+			wantErr:  ErrPermissionDenied,
+		},
+		{
+			name:     "deadline exceeded",
+			response: FakeResponse{ExitCode: -1, Err: context.DeadlineExceeded},
+			wantErr:  ErrTimeout,
+		},
+		{
+			name:    "context expired",
+			expired: true,
+			wantErr: ErrTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := NewFakeExecutor()
+			executor.On("systemctl start nginx", tt.response)
+
+			ctx := context.Background()
+			if tt.expired {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, time.Now().Add(-time.Second))
+				defer cancel()
+			}
+
+			out, err := runCommand(ctx, executor, "start", "nginx", "systemctl", "start", "nginx")
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("runCommand() error = %v", err)
+				}
+				if string(out) != tt.wantOut {
+					t.Errorf("runCommand() = %q, want %q", out, tt.wantOut)
+				}
+				return
+			}
+
+			var svcErr *ServiceError
+			if !errors.As(err, &svcErr) {
+				t.Fatalf("runCommand() error = %v, want a ServiceError", err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("runCommand() error = %v, want %v", err, tt.wantErr)
+			}
+			if svcErr.Op != "start" || svcErr.Name != "nginx" {
+				t.Errorf("ServiceError = %s %s, want start nginx", svcErr.Op, svcErr.Name)
+			}
+		})
+	}
+}
+
+func TestRunCommandNonZeroExit(t *testing.T) {
+	executor := NewFakeExecutor()
+	executor.On("systemctl reload nginx", FakeResponse{ExitCode: 1, Stderr: "Job for nginx.service failed.\n"})
+
+	_, err := runCommand(context.Background(), executor, "reload", "nginx", "systemctl", "reload", "nginx")
+	var svcErr *ServiceError
+	if !errors.As(err, &svcErr) {
+		t.Fatalf("runCommand() error = %v, want a ServiceError", err)
+	}
+	for _, sentinel := range []error{ErrNotFound, ErrPermissionDenied, ErrTimeout} {
+		if errors.Is(err, sentinel) {
+			t.Errorf("runCommand() error = %v, must not be %v", err, sentinel)
+		}
+	}
+	if svcErr.Detail != "Job for nginx.service failed." {
+		t.Errorf("Detail = %q", svcErr.Detail)
+	}
+}
+
+func TestCommandExecutorTimeout(t *testing.T) {
+	executor := NewCommandExecutor(utils.ExecConfig{
+		Timeout:    "10s",
+		Operations: map[string]string{"stop": "50ms"},
+	})
+	if got := executor.Timeout("stop"); got != 50*time.Millisecond {
+		t.Fatalf("Timeout(stop) = %v", got)
+	}
+	if got := executor.Timeout("start"); got != 10*time.Second {
+		t.Fatalf("Timeout(start) = %v", got)
+	}
+
+	_, err := runCommand(context.Background(), executor, "stop", "slow", "sleep", "5")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("runCommand() error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		name   string
+		output string
+		want   error
+	}{
+		{"systemctl not found", "Failed to start foo.service: Unit foo.service not found.", ErrNotFound},
+		{"systemctl could not be found", "Unit foo.service could not be found.", ErrNotFound},
+		{"systemctl not loaded", "Failed to stop foo.service: Unit foo.service not loaded.", ErrNotFound},
+		{"sc not installed", "[SC] OpenService FAILED 1060:\r\n\r\nThe specified service does not exist as an installed service.", ErrNotFound},
+		{"powershell not found", "Get-Service : Cannot find any service with service name 'foo'.", ErrNotFound},
+		{"openrc not found", " * rc-service: service `foo' does not exist", ErrNotFound},
+		{"sysv not found", "foo: unrecognized service", ErrNotFound},
+		{"systemctl access denied", "Failed to start foo.service: Access denied", ErrPermissionDenied},
+		{"polkit", "Failed to start foo.service: Interactive authentication required.", ErrPermissionDenied},
+		{"sc access denied", "[SC] OpenSCManager FAILED 5:\r\n\r\nAccess is denied.", ErrPermissionDenied},
+		{"powershell cannot open", "Start-Service : Service 'foo (foo)' cannot be started due to the following error: Cannot open foo service on computer '.'.", ErrPermissionDenied},
+		{"eacces", "fork/exec /usr/bin/foo: permission denied", ErrPermissionDenied},
+		{"missing binary", "fork/exec /opt/app/bin/server: no such file or directory", failed},
+		{"missing file", "cannot open /etc/app.conf", failed},
+		{"unrelated not found", "exec: \"systemctl\": executable file not found in $PATH", failed},
+		{"unrecognised", "Job for foo.service failed because the control process exited with error code.", failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(context.Background(), failed, tt.output); got != tt.want {
+				t.Errorf("classifyError(%q) = %v, want %v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyErrorTimeout(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if got := classifyError(ctx, errors.New("signal: killed"), ""); got != ErrTimeout {
+		t.Errorf("classifyError() with expired context = %v, want %v", got, ErrTimeout)
+	}
+	if got := classifyError(context.Background(), context.DeadlineExceeded, "Access denied"); got != ErrTimeout {
+		t.Errorf("classifyError(DeadlineExceeded) = %v, want %v", got, ErrTimeout)
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Helpers shared by the init-script backends, OpenRC and SysV init, which
+// have no journal or unit properties to read
+
+const (
+	// defaultInitDir holds the init scripts of both OpenRC and SysV init
+	defaultInitDir = "/etc/init.d"
+	// initLogBackups is how many numbered backups of a service log are read
+	initLogBackups = 5
+)
+
+// initStatus runs a status command and returns its exit code. Init scripts
+// report the service state in the exit code, so a non-zero exit is not a
+// failure; only a command that could not run at all is.
+func initStatus(ctx context.Context, executor Executor, name, command string, args ...string) (int, error) {
+	result, err := executor.Run(ctx, "status", command, args...)
+	if err == nil {
+		return result.ExitCode, nil
+	}
+	var execErr *ExecError
+	if errors.As(err, &execErr) && execErr.ExitCode > 0 && ctx.Err() == nil {
+		if classifyError(ctx, execErr.Err, execErr.Stderr) == ErrNotFound {
+			return 0, newServiceError("status", name, ErrNotFound, execErr.Stderr)
+		}
+		return execErr.ExitCode, nil
+	}
+	if errors.As(err, &execErr) {
+		return 0, newServiceError("status", name, classifyError(ctx, execErr.Err, execErr.Stderr), execErr.Stderr)
+	}
+	return 0, newServiceError("status", name, classifyError(ctx, err, ""), "")
+}
+
+// initScriptStatus builds the parts of a ServiceStatus common to init-script
+// backends
+func initScriptStatus(name, script, active, sub string, enabled bool) *ServiceStatus {
+	status := &ServiceStatus{
+		Name:          name,
+		LoadState:     "loaded",
+		Status:        active,
+		SubState:      sub,
+		UnitFileState: "disabled",
+		Enabled:       enabled,
+		IsActive:      active == "active",
+		FragmentPath:  script,
+		UpdatedAt:     time.Now(),
+	}
+	if enabled {
+		status.UnitFileState = "enabled"
+	}
+	if status.IsActive {
+		status.MainPID = readPIDFile(name)
+	}
+	return status
+}
+
+// readPIDFile returns the PID in the conventional pid file of a daemon, or
+// 0 if there is none
+func readPIDFile(name string) int {
+	for _, path := range []string{
+		filepath.Join("/run", name+".pid"),
+		filepath.Join("/run", name, name+".pid"),
+		filepath.Join("/var/run", name+".pid"),
+		filepath.Join("/var/run", name, name+".pid"),
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid > 0 {
+			return pid
+		}
+	}
+	return 0
+}
+
+// readServiceLogFile reads <logDir>/<name>.log and its numbered backups.
+// Cursors are line numbers across the files, oldest first, so they shift
+// when the log is rotated.
+func readServiceLogFile(logDir, name string, query LogQuery) ([]LogEntry, error) {
+	if err := query.Validate(); err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+	path := filepath.Join(logDir, UnitBaseName(name)+".log")
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, newServiceError("logs", name, ErrNotFound, fmt.Sprintf("no log file at %s", path))
+		}
+		return nil, newServiceError("logs", name, classifyError(context.Background(), err, err.Error()), err.Error())
+	}
+
+	now := time.Now()
+	entries, err := readLogFiles(rotatedPaths(path, initLogBackups), query, func(line string, n int64) (LogEntry, bool) {
+		if strings.TrimSpace(line) == "" {
+			return LogEntry{}, false
+		}
+		return parseLogFileLine(UnitBaseName(name), line, n, now), true
+	})
+	if err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+	return entries, nil
+}
+
+// logLineTimestamps are the timestamp prefixes recognised in plain log
+// files, with the length of the prefix they match
+var logLineTimestamps = []struct {
+	layout string
+	length int
+}{
+	{"2006-01-02T15:04:05Z07:00", 0}, // RFC 3339, any fraction, up to the first space
+	{"2006-01-02 15:04:05", 19},
+	{"2006/01/02 15:04:05", 19},
+	{time.Stamp, 15}, // syslog, without a year
+}
+
+// logLevelWords maps level words found at the start of a log message to
+// syslog priorities
+var logLevelWords = map[string]int{
+	"fatal": 2, "critical": 2, "error": 3, "warn": 4, "trace": 7,
+}
+
+// parseLogFileLine turns a line of a plain-text log into an entry. A leading
+// timestamp and level word are recognised when present; other lines get no
+// timestamp and priority info.
+func parseLogFileLine(ident, line string, n int64, now time.Time) LogEntry {
+	entry := LogEntry{
+		Priority:   6,
+		Identifier: ident,
+		Message:    line,
+		Cursor:     strconv.FormatInt(n, 10),
+	}
+
+	for _, ts := range logLineTimestamps {
+		prefix := line
+		if ts.length == 0 {
+			prefix, _, _ = strings.Cut(line, " ")
+		} else if len(line) >= ts.length {
+			prefix = line[:ts.length]
+		} else {
+			continue
+		}
+		t, err := time.ParseInLocation(ts.layout, prefix, time.Local)
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			// Syslog timestamps omit the year; assume the most recent one
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		entry.Timestamp = t
+		entry.Message = strings.TrimLeft(line[len(prefix):], " ")
+		break
+	}
+
+	words := strings.Fields(entry.Message)
+	for _, word := range words[:min(3, len(words))] {
+		word = strings.ToLower(strings.Trim(word, "[]<>():"))
+		if p, ok := syslogPriorities[word]; ok {
+			entry.Priority = p
+			break
+		}
+		if p, ok := logLevelWords[word]; ok {
+			entry.Priority = p
+			break
+		}
+	}
+	entry.Level = priorityName(entry.Priority)
+	return entry
+}
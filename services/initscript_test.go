@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogFileLine(t *testing.T) {
+	now := time.Date(2025, 6, 5, 12, 0, 0, 0, time.Local)
+	newYear := time.Date(2025, 1, 1, 0, 0, 10, 0, time.Local)
+
+	tests := []struct {
+		name         string
+		line         string
+		now          time.Time
+		wantTime     time.Time
+		wantMessage  string
+		wantPriority int
+	}{
+		{"rfc 3339", "2025-06-04T10:00:00.5Z [error] disk full", now,
+			time.Date(2025, 6, 4, 10, 0, 0, 5e8, time.UTC), "[error] disk full", 3},
+		{"date and time", "2025-06-04 10:00:00 WARN slow request", now,
+			time.Date(2025, 6, 4, 10, 0, 0, 0, time.Local), "WARN slow request", 4},
+		{"go log", "2025/06/04 10:00:00 listening on :80", now,
+			time.Date(2025, 6, 4, 10, 0, 0, 0, time.Local), "listening on :80", 6},
+		{"syslog", "Jun  4 10:00:00 host app[12]: <notice> ready", now,
+			time.Date(2025, 6, 4, 10, 0, 0, 0, time.Local), "host app[12]: <notice> ready", 5},
+		{"syslog from last year", "Dec 31 23:59:59 host app: done", newYear,
+			time.Date(2024, 12, 31, 23, 59, 59, 0, time.Local), "host app: done", 6},
+		{"level in the first words", "worker (DEBUG): polling", now, time.Time{}, "worker (DEBUG): polling", 7},
+		{"level word too late", "one two three error", now, time.Time{}, "one two three error", 6},
+		{"plain", "starting up", now, time.Time{}, "starting up", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := parseLogFileLine("app", tt.line, 42, tt.now)
+			if !entry.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("timestamp = %s, want %s", entry.Timestamp, tt.wantTime)
+			}
+			if entry.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", entry.Message, tt.wantMessage)
+			}
+			if entry.Priority != tt.wantPriority || entry.Level != priorityName(tt.wantPriority) {
+				t.Errorf("priority = %d (%s), want %d", entry.Priority, entry.Level, tt.wantPriority)
+			}
+			if entry.Identifier != "app" || entry.Cursor != "42" {
+				t.Errorf("identifier = %s, cursor = %s", entry.Identifier, entry.Cursor)
+			}
+		})
+	}
+}
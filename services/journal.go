@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLogLines is the number of entries returned when no limit is given
+	DefaultLogLines = 100
+	// MaxLogLines bounds a single logs request
+	MaxLogLines = 10000
+	// maxGrepLength bounds the pattern passed to journalctl --grep
+	maxGrepLength = 256
+)
+
+// syslogPriorities maps syslog priority names to their numeric levels
+var syslogPriorities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+	"warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// cursorRegex matches journal cursors and numeric record ids
+var cursorRegex = regexp.MustCompile(`^[a-zA-Z0-9=;_\-]+$`)
+
+// LogQuery selects the log entries returned by ServiceManager.Logs. Since
+// and Until have already been resolved to absolute times by ParseLogTime.
+type LogQuery struct {
+	Lines    int        // maximum number of entries, DefaultLogLines if zero
+	Since    *time.Time // only entries at or after this time
+	Until    *time.Time // only entries at or before this time
+	Priority string     // "err", "3" or a range such as "warning..err"
+	Grep     string     // regular expression matched against the message
+	Reverse  bool       // newest entries first
+	Cursor   string     // only entries after this cursor, or before it with Reverse, for pagination
+}
+
+// LogEntry is a single structured log entry
+type LogEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Priority   int       `json:"priority"`
+	Level      string    `json:"level"`
+	PID        int       `json:"pid,omitempty"`
+	Identifier string    `json:"identifier,omitempty"`
+	Message    string    `json:"message"`
+	Cursor     string    `json:"cursor"`
+}
+
+// Validate checks the query and fills in defaults
+func (q *LogQuery) Validate() error {
+	if q.Lines == 0 {
+		q.Lines = DefaultLogLines
+	}
+	if q.Lines < 0 || q.Lines > MaxLogLines {
+		return fmt.Errorf("lines must be between 1 and %d", MaxLogLines)
+	}
+	if q.Since != nil && q.Until != nil && q.Until.Before(*q.Since) {
+		return fmt.Errorf("until must not be before since")
+	}
+	if q.Priority != "" {
+		if _, _, err := parsePriorityRange(q.Priority); err != nil {
+			return err
+		}
+	}
+	if len(q.Grep) > maxGrepLength {
+		return fmt.Errorf("grep pattern must be at most %d characters", maxGrepLength)
+	}
+	if q.Grep != "" {
+		if _, err := regexp.Compile(q.Grep); err != nil {
+			return fmt.Errorf("invalid grep pattern: %w", err)
+		}
+	}
+	if q.Cursor != "" && !cursorRegex.MatchString(q.Cursor) {
+		return fmt.Errorf("invalid cursor")
+	}
+	return nil
+}
+
+// ParseLogTime parses a since/until value. It accepts RFC 3339 timestamps
+// and durations, which are taken as relative to now ("30m" means 30
+// minutes ago).
+func ParseLogTime(value string) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(value, "-")); err == nil {
+		t := time.Now().Add(-d)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("invalid time %q: use RFC 3339 or a duration such as 30m", value)
+}
+
+// parsePriorityRange parses a priority or priority range into its most and
+// least severe numeric levels
+func parsePriorityRange(value string) (int, int, error) {
+	parse := func(p string) (int, error) {
+		if n, err := strconv.Atoi(p); err == nil && n >= 0 && n <= 7 {
+			return n, nil
+		}
+		if n, ok := syslogPriorities[strings.ToLower(p)]; ok {
+			return n, nil
+		}
+		return 0, fmt.Errorf("invalid priority %q", p)
+	}
+
+	from, to, isRange := strings.Cut(value, "..")
+	if !isRange {
+		// A single priority includes everything more severe
+		max, err := parse(from)
+		return 0, max, err
+	}
+	a, err := parse(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := parse(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if a > b {
+		a, b = b, a
+	}
+	return a, b, nil
+}
+
+// priorityName returns the syslog name of a numeric priority
+func priorityName(priority int) string {
+	for name, n := range syslogPriorities {
+		if n == priority {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// journalArgs builds the journalctl arguments for query against unit name.
+// Every backend reads a cursor the same way: the entries after it, oldest
+// first, or with Reverse the entries before it, newest first. journalctl
+// walks from --cursor in the direction of --reverse and -n counts from
+// there, so the output stays bounded. It starts at the cursor entry
+// itself, hence the extra line, which readJournal drops.
+func journalArgs(name string, query LogQuery) []string {
+	args := []string{"-u", name, "--no-pager", "--output=json"}
+	if query.Cursor == "" {
+		args = append(args, "-n", strconv.Itoa(query.Lines))
+	} else {
+		args = append(args, "--cursor="+query.Cursor, "-n", strconv.Itoa(query.Lines+1))
+	}
+	if query.Since != nil {
+		args = append(args, "--since=@"+strconv.FormatInt(query.Since.Unix(), 10))
+	}
+	if query.Until != nil {
+		args = append(args, "--until=@"+strconv.FormatInt(query.Until.Unix(), 10))
+	}
+	if query.Priority != "" {
+		args = append(args, "--priority="+query.Priority)
+	}
+	if query.Grep != "" {
+		args = append(args, "--grep="+query.Grep)
+	}
+	if query.Reverse {
+		args = append(args, "--reverse")
+	}
+	return args
+}
+
+// readJournal reads the journal entries of a unit matching query with
+// journalctl. It is shared by every backend that manages systemd units.
+func readJournal(ctx context.Context, executor Executor, name string, query LogQuery) ([]LogEntry, error) {
+	if err := query.Validate(); err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+
+	output, err := runCommand(ctx, executor, "logs", name, "journalctl", journalArgs(name, query)...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseJournal(output)
+	if err != nil {
+		return nil, err
+	}
+	if query.Cursor != "" && len(entries) > 0 && entries[0].Cursor == query.Cursor {
+		entries = entries[1:]
+	}
+	if len(entries) > query.Lines {
+		entries = entries[:query.Lines]
+	}
+	return entries, nil
+}
+
+// followJournal streams the journal entries of a unit with journalctl
+// --follow. The query selects the backlog sent before new entries.
+func followJournal(ctx context.Context, executor Executor, name string, query LogQuery) (<-chan LogEntry, error) {
+	if query.Reverse {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, "reverse cannot be combined with follow")
+	}
+	if err := query.Validate(); err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+
+	args := append(journalArgs(name, query), "--follow")
+	stream, err := executor.Stream(ctx, "logs", "journalctl", args...)
+	if err != nil {
+		var execErr *ExecError
+		if errors.As(err, &execErr) {
+			return nil, newServiceError("logs", name, classifyError(ctx, execErr.Err, execErr.Stderr), execErr.Stderr)
+		}
+		return nil, newServiceError("logs", name, err, "")
+	}
+
+	entries := make(chan LogEntry, 64)
+	go func() {
+		defer close(entries)
+		defer stream.Close()
+
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			entry, err := parseJournalLine(line)
+			// --cursor starts the stream at the cursor entry itself
+			if err != nil || (query.Cursor != "" && entry.Cursor == query.Cursor) {
+				continue
+			}
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return entries, nil
+}
+
+// journalRecord holds the journal fields ChronoServe reports. journalctl
+// emits every field as a string, or as an array of bytes when the value is
+// not valid UTF-8.
+type journalRecord struct {
+	Cursor     string          `json:"__CURSOR"`
+	Realtime   string          `json:"__REALTIME_TIMESTAMP"`
+	Priority   string          `json:"PRIORITY"`
+	PID        string          `json:"_PID"`
+	Identifier string          `json:"SYSLOG_IDENTIFIER"`
+	Message    json.RawMessage `json:"MESSAGE"`
+}
+
+// parseJournal parses journalctl --output=json, which writes one JSON
+// object per line
+func parseJournal(output []byte) ([]LogEntry, error) {
+	entries := make([]LogEntry, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	// Single entries can exceed bufio's default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entry, err := parseJournalLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log data: %w", err)
+	}
+	return entries, nil
+}
+
+// parseJournalLine parses one line of journalctl --output=json
+func parseJournalLine(line []byte) (LogEntry, error) {
+	var record journalRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return LogEntry{}, fmt.Errorf("failed to parse log data: %w", err)
+	}
+
+	entry := LogEntry{
+		Priority:   6, // journald's default when PRIORITY is absent
+		Identifier: record.Identifier,
+		Message:    journalString(record.Message),
+		Cursor:     record.Cursor,
+	}
+	if usec, err := strconv.ParseInt(record.Realtime, 10, 64); err == nil {
+		entry.Timestamp = time.UnixMicro(usec).UTC()
+	}
+	if p, err := strconv.Atoi(record.Priority); err == nil {
+		entry.Priority = p
+	}
+	entry.Level = priorityName(entry.Priority)
+	entry.PID, _ = strconv.Atoi(record.PID)
+	return entry, nil
+}
+
+// journalString decodes a journal field that is either a string or an
+// array of bytes
+func journalString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var ints []int
+	if err := json.Unmarshal(raw, &ints); err == nil {
+		b := make([]byte, len(ints))
+		for i, n := range ints {
+			b[i] = byte(n)
+		}
+		return string(b)
+	}
+	return ""
+}
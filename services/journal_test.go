@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJournalArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		query LogQuery
+		want  string
+	}{
+		{
+			name:  "latest",
+			query: LogQuery{Lines: 50},
+			want:  "-u nginx --no-pager --output=json -n 50",
+		},
+		{
+			name:  "latest reversed",
+			query: LogQuery{Lines: 50, Reverse: true, Priority: "err"},
+			want:  "-u nginx --no-pager --output=json -n 50 --priority=err --reverse",
+		},
+		{
+			name:  "after cursor",
+			query: LogQuery{Lines: 50, Cursor: "s=1;i=2"},
+			want:  "-u nginx --no-pager --output=json --cursor=s=1;i=2 -n 51",
+		},
+		{
+			name:  "before cursor",
+			query: LogQuery{Lines: 50, Cursor: "s=1;i=2", Reverse: true},
+			want:  "-u nginx --no-pager --output=json --cursor=s=1;i=2 -n 51 --reverse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(journalArgs("nginx", tt.query), " "); got != tt.want {
+				t.Errorf("journalArgs() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// journalLines renders journal entries with the given cursors as
+// journalctl --output=json would
+func journalLines(cursors ...string) string {
+	var b strings.Builder
+	for i, cursor := range cursors {
+		fmt.Fprintf(&b, `{"__CURSOR":%q,"__REALTIME_TIMESTAMP":"%d","PRIORITY":"6","MESSAGE":"entry %s"}`+"\n",
+			cursor, 1709634030000000+i, cursor)
+	}
+	return b.String()
+}
+
+func TestReadJournalCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  LogQuery
+		output string
+		want   []string
+	}{
+		{
+			name:   "drops the cursor entry",
+			query:  LogQuery{Lines: 2, Cursor: "c3"},
+			output: journalLines("c3", "c4", "c5"),
+			want:   []string{"c4", "c5"},
+		},
+		{
+			name:   "reverse drops the cursor entry",
+			query:  LogQuery{Lines: 2, Cursor: "c3", Reverse: true},
+			output: journalLines("c3", "c2", "c1"),
+			want:   []string{"c2", "c1"},
+		},
+		{
+			name:   "truncates when the cursor entry is missing",
+			query:  LogQuery{Lines: 2, Cursor: "c3"},
+			output: journalLines("c4", "c5", "c6"),
+			want:   []string{"c4", "c5"},
+		},
+		{
+			name:   "no cursor",
+			query:  LogQuery{Lines: 3},
+			output: journalLines("c1", "c2", "c3"),
+			want:   []string{"c1", "c2", "c3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := NewFakeExecutor()
+			executor.Default = FakeResponse{Stdout: tt.output}
+
+			entries, err := readJournal(context.Background(), executor, "nginx", tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Cursor)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cursors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJournalLine(t *testing.T) {
+	entry, err := parseJournalLine([]byte(`{"__CURSOR":"s=1","__REALTIME_TIMESTAMP":"1709634030000000","PRIORITY":"3","_PID":"42","SYSLOG_IDENTIFIER":"nginx","MESSAGE":[104,105,255]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Priority != 3 || entry.Level != "err" || entry.PID != 42 || entry.Identifier != "nginx" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.Message != "hi\xff" || entry.Timestamp.Unix() != 1709634030 {
+		t.Errorf("Message, Timestamp = %q, %v", entry.Message, entry.Timestamp)
+	}
+}
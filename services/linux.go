@@ -1,165 +1,227 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os/exec"
+	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/therealtoxicdev/chronoserve/utils"
+	"github.com/therealtoxicdev/chronoserve/metrics"
 )
 
-// Ensure SystemdService implements ServiceHandler
-var _ ServiceHandler = (*SystemdService)(nil)
+// Ensure SystemdService implements ServiceManager and StatusRefresher
+var (
+	_ ServiceManager  = (*SystemdService)(nil)
+	_ StatusRefresher = (*SystemdService)(nil)
+)
 
-// SystemdService implements the ServiceHandler interface for Linux
+// SystemdService implements the ServiceManager interface for Linux
 type SystemdService struct {
 	BaseServiceHandler
+	exec       Executor
 	cache      map[string]ServiceStatus
 	cacheMutex sync.RWMutex
 	cacheTTL   time.Duration
 }
 
-// ServiceStatus represents the status of a service
-type ServiceStatus struct {
-	Name      string    `json:"name"`
-	Status    string    `json:"status"`
-	UpdatedAt time.Time `json:"updatedAt"`
-	IsActive  bool      `json:"isActive"`
+// systemdStatusProperties are the unit properties requested from systemctl
+// show to build a ServiceStatus
+var systemdStatusProperties = []string{
+	"Id", "Description", "LoadState", "ActiveState", "SubState", "UnitFileState",
+	"FragmentPath", "Result", "MainPID", "NRestarts", "ExecMainStatus", "ExecMainCode",
+	"StateChangeTimestamp", "ActiveEnterTimestamp", "ActiveExitTimestamp",
+	"InactiveEnterTimestamp", "InactiveExitTimestamp",
+	"MemoryCurrent", "MemoryPeak", "CPUUsageNSec",
+	"Requires", "Wants", "BindsTo", "Conflicts", "After", "Before", "RequiredBy", "WantedBy",
 }
 
-// NewSystemdService creates a new systemd service handler
-func NewSystemdService() *SystemdService {
+// NewSystemdService creates a new systemd service manager that runs
+// systemctl and journalctl through executor
+func NewSystemdService(executor Executor) *SystemdService {
 	return &SystemdService{
+		exec:     executor,
 		cache:    make(map[string]ServiceStatus),
 		cacheTTL: 5 * time.Minute,
 	}
 }
 
-// ListServices lists all systemd services
-func (s *SystemdService) ListServices(w http.ResponseWriter, r *http.Request) {
-	if utils.GetOperatingSystem() != "linux" {
-		s.HandleError(w, "Systemd is only supported on Linux", http.StatusBadRequest)
-		return
-	}
-
-	cmd := exec.Command("systemctl", "list-units", "--type=service", "--all", "--no-pager", "--output=json")
-	output, err := cmd.Output()
+// List lists all systemd services
+func (s *SystemdService) List(ctx context.Context) ([]ServiceInfo, error) {
+	output, err := s.systemctl(ctx, "list", "", "list-units", "--type=service", "--all", "--no-pager", "--output=json")
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to list services: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	var services []map[string]interface{}
-	if err := json.Unmarshal(output, &services); err != nil {
-		s.HandleError(w, "Failed to parse service data", http.StatusInternalServerError)
-		return
+	var units []struct {
+		Unit        string `json:"unit"`
+		Load        string `json:"load"`
+		Active      string `json:"active"`
+		Sub         string `json:"sub"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(output, &units); err != nil {
+		return nil, fmt.Errorf("failed to parse service data: %w", err)
 	}
 
-	utils.WriteSuccessResponse(w, "Services retrieved successfully", services)
+	services := make([]ServiceInfo, 0, len(units))
+	for _, unit := range units {
+		services = append(services, ServiceInfo{
+			Name:        unit.Unit,
+			Description: unit.Description,
+			LoadState:   unit.Load,
+			ActiveState: unit.Active,
+			SubState:    unit.Sub,
+		})
+	}
+	return services, nil
 }
 
-// StartService starts a systemd service
-func (s *SystemdService) StartService(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// Start starts a systemd service
+func (s *SystemdService) Start(ctx context.Context, name string) (*ActionResult, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError("start", name, ErrInvalidName, "")
 	}
 
 	// Check if service is already running
-	status, err := s.getServiceActiveState(name)
+	status, err := s.getServiceActiveState(ctx, name)
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to check service status: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-
 	if status == "active" {
-		utils.WriteSuccessResponse(w, fmt.Sprintf("Service %s is already running", name), nil)
-		return
-	}
-
-	cmd := exec.Command("systemctl", "start", name)
-	if err := cmd.Run(); err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to start service %s: %v", name, err), http.StatusInternalServerError)
-		return
+		return nil, newServiceError("start", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already running", name))
 	}
 
-	// Invalidate cache
-	s.cacheMutex.Lock()
-	delete(s.cache, name)
-	s.cacheMutex.Unlock()
-
-	utils.WriteSuccessResponse(w, fmt.Sprintf("Service %s started successfully", name), nil)
+	return s.runUnitAction(ctx, "start", name, "started")
 }
 
-// StopService stops a systemd service
-func (s *SystemdService) StopService(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// Stop stops a systemd service
+func (s *SystemdService) Stop(ctx context.Context, name string) (*ActionResult, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError("stop", name, ErrInvalidName, "")
 	}
 
 	// Check if service is already stopped
-	status, err := s.getServiceActiveState(name)
+	status, err := s.getServiceActiveState(ctx, name)
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to check service status: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-
 	if status == "inactive" {
-		utils.WriteSuccessResponse(w, fmt.Sprintf("Service %s is already stopped", name), nil)
-		return
+		return nil, newServiceError("stop", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already stopped", name))
 	}
 
-	cmd := exec.Command("systemctl", "stop", name)
-	if err := cmd.Run(); err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to stop service %s: %v", name, err), http.StatusInternalServerError)
-		return
+	return s.runUnitAction(ctx, "stop", name, "stopped")
+}
+
+// Restart restarts a systemd service, starting it if it is not running
+func (s *SystemdService) Restart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runUnitAction(ctx, "restart", name, "restarted")
+}
+
+// Reload asks a systemd service to reload its configuration
+func (s *SystemdService) Reload(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runUnitAction(ctx, "reload", name, "reloaded")
+}
+
+// ReloadOrRestart reloads a systemd service if it supports reloading and
+// restarts it otherwise
+func (s *SystemdService) ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runUnitAction(ctx, "reload-or-restart", name, "reloaded or restarted")
+}
+
+// Enable enables a systemd unit so it starts at boot, starting it as well
+// when now is set
+func (s *SystemdService) Enable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.runUnitFileAction(ctx, "enable", name, "enabled", now)
+}
+
+// Disable disables a systemd unit so it no longer starts at boot, stopping
+// it as well when now is set
+func (s *SystemdService) Disable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.runUnitFileAction(ctx, "disable", name, "disabled", now)
+}
+
+// Mask masks a systemd unit so it cannot be started at all, stopping it as
+// well when now is set
+func (s *SystemdService) Mask(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.runUnitFileAction(ctx, "mask", name, "masked", now)
+}
+
+// Unmask removes a mask from a systemd unit. systemctl has no --now
+// semantics for unmask.
+func (s *SystemdService) Unmask(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runUnitFileAction(ctx, "unmask", name, "unmasked", false)
+}
+
+// runUnitAction runs a single systemctl verb against the named unit. The verb
+// is executed as one systemctl invocation so systemd handles it atomically.
+func (s *SystemdService) runUnitAction(ctx context.Context, action, name, pastTense string) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(action, name, ErrInvalidName, "")
 	}
 
-	// Invalidate cache
-	s.cacheMutex.Lock()
-	delete(s.cache, name)
-	s.cacheMutex.Unlock()
+	if _, err := s.systemctl(ctx, action, name, action, name); err != nil {
+		return nil, err
+	}
+
+	s.invalidateCache(name)
 
-	utils.WriteSuccessResponse(w, fmt.Sprintf("Service %s stopped successfully", name), nil)
+	return &ActionResult{
+		Name:    name,
+		Action:  action,
+		Message: fmt.Sprintf("Service %s %s successfully", name, pastTense),
+	}, nil
 }
 
-// ViewServiceLogs retrieves systemd service logs
-func (s *SystemdService) ViewServiceLogs(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// runUnitFileAction changes the unit-file state of the named unit, optionally
+// applying the change to the running unit as well via --now
+func (s *SystemdService) runUnitFileAction(ctx context.Context, action, name, pastTense string, now bool) (*ActionResult, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError(action, name, ErrInvalidName, "")
 	}
 
-	cmd := exec.Command("journalctl", "-u", name, "--no-pager", "-n", "100", "--output=json")
-	output, err := cmd.Output()
-	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to retrieve logs for service %s: %v", name, err), http.StatusInternalServerError)
-		return
+	args := []string{action}
+	if now {
+		args = append(args, "--now")
+	}
+	args = append(args, name)
+
+	if _, err := s.systemctl(ctx, action, name, args...); err != nil {
+		return nil, err
 	}
 
-	var logs interface{}
-	if err := json.Unmarshal(output, &logs); err != nil {
-		s.HandleError(w, "Failed to parse log data", http.StatusInternalServerError)
-		return
+	s.invalidateCache(name)
+
+	message := fmt.Sprintf("Service %s %s successfully", name, pastTense)
+	if now {
+		message += " (applied now)"
+	}
+	return &ActionResult{Name: name, Action: action, Message: message}, nil
+}
+
+// Logs retrieves systemd service logs matching query
+func (s *SystemdService) Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
 	}
+	return readJournal(ctx, s.exec, name, query)
+}
 
-	utils.WriteSuccessResponse(w, "Service logs retrieved successfully", logs)
+// FollowLogs streams systemd service logs as they are written
+func (s *SystemdService) FollowLogs(ctx context.Context, name string, query LogQuery) (<-chan LogEntry, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	return followJournal(ctx, s.exec, name, query)
 }
 
-// GetServiceStatus gets the current status of a systemd service
-func (s *SystemdService) GetServiceStatus(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// Status gets the current status of a systemd service
+func (s *SystemdService) Status(ctx context.Context, name string) (*ServiceStatus, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError("status", name, ErrInvalidName, "")
 	}
 
 	// Check cache first
@@ -167,20 +229,33 @@ func (s *SystemdService) GetServiceStatus(w http.ResponseWriter, r *http.Request
 	if status, ok := s.cache[name]; ok {
 		if time.Since(status.UpdatedAt) < s.cacheTTL {
 			s.cacheMutex.RUnlock()
-			utils.WriteSuccessResponse(w, "Service status retrieved from cache", status)
-			return
+			metrics.StatusCacheLookups.Inc("hit")
+			return &status, nil
 		}
 	}
 	s.cacheMutex.RUnlock()
+	metrics.StatusCacheLookups.Inc("miss")
 
-	cmd := exec.Command("systemctl", "show", name, "--property=ActiveState,SubState,UnitFileState")
-	output, err := cmd.Output()
+	return s.Refresh(ctx, name)
+}
+
+// Refresh reads the current status of a systemd service, bypassing and
+// then updating the cache
+func (s *SystemdService) Refresh(ctx context.Context, name string) (*ServiceStatus, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("status", name, ErrInvalidName, "")
+	}
+
+	output, err := s.systemctl(ctx, "status", name, "show", name, "--property="+strings.Join(systemdStatusProperties, ","))
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to get status for service %s: %v", name, err), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	status := parseSystemdStatus(string(output))
+	// systemctl show succeeds for unknown units and reports them as not-found
+	if status.LoadState == "not-found" {
+		return nil, newServiceError("status", name, ErrNotFound, "")
+	}
 	status.Name = name
 	status.UpdatedAt = time.Now()
 
@@ -189,23 +264,45 @@ func (s *SystemdService) GetServiceStatus(w http.ResponseWriter, r *http.Request
 	s.cache[name] = status
 	s.cacheMutex.Unlock()
 
-	utils.WriteSuccessResponse(w, "Service status retrieved successfully", status)
+	return &status, nil
+}
+
+// invalidateCache drops any cached status for the named service
+func (s *SystemdService) invalidateCache(name string) {
+	s.cacheMutex.Lock()
+	delete(s.cache, name)
+	s.cacheMutex.Unlock()
 }
 
 // Helper function to get service active state
-func (s *SystemdService) getServiceActiveState(name string) (string, error) {
-	cmd := exec.Command("systemctl", "show", name, "--property=ActiveState")
-	output, err := cmd.Output()
+func (s *SystemdService) getServiceActiveState(ctx context.Context, name string) (string, error) {
+	output, err := s.systemctl(ctx, "status", name, "show", name, "--property=ActiveState,LoadState")
 	if err != nil {
 		return "", err
 	}
-	parts := strings.Split(string(output), "=")
-	if len(parts) != 2 {
+	status := parseSystemdStatus(string(output))
+	if status.LoadState == "not-found" {
+		return "", newServiceError("status", name, ErrNotFound, "")
+	}
+	if status.Status == "" {
 		return "", fmt.Errorf("unexpected output format")
 	}
-	return strings.TrimSpace(parts[1]), nil
+	return status.Status, nil
+}
+
+// systemctl runs systemctl with the given arguments on behalf of op
+func (s *SystemdService) systemctl(ctx context.Context, op, name string, args ...string) ([]byte, error) {
+	return s.command(ctx, op, name, "systemctl", args...)
 }
 
+// command runs a backend command on behalf of op
+func (s *SystemdService) command(ctx context.Context, op, name, command string, args ...string) ([]byte, error) {
+	return runCommand(ctx, s.exec, op, name, command, args...)
+}
+
+// systemdTimestampLayout is the layout systemctl show uses for timestamps
+const systemdTimestampLayout = "Mon 2006-01-02 15:04:05 MST"
+
 // Helper function to parse systemd status output
 func parseSystemdStatus(output string) ServiceStatus {
 	lines := strings.Split(output, "\n")
@@ -214,7 +311,8 @@ func parseSystemdStatus(output string) ServiceStatus {
 	}
 
 	for _, line := range lines {
-		parts := strings.Split(line, "=")
+		// Values such as ExecStart may themselves contain '='
+		parts := strings.SplitN(line, "=", 2)
 		if len(parts) != 2 {
 			continue
 		}
@@ -222,11 +320,100 @@ func parseSystemdStatus(output string) ServiceStatus {
 		value := strings.TrimSpace(parts[1])
 
 		switch key {
+		case "Id":
+			if status.Name == "" {
+				status.Name = value
+			}
+		case "Description":
+			status.Description = value
+		case "LoadState":
+			status.LoadState = value
 		case "ActiveState":
 			status.Status = value
 			status.IsActive = value == "active"
+		case "SubState":
+			status.SubState = value
+		case "UnitFileState":
+			status.UnitFileState = value
+			status.Enabled = value == "enabled" || value == "enabled-runtime"
+		case "FragmentPath":
+			status.FragmentPath = value
+		case "Result":
+			status.Result = value
+		case "MainPID":
+			status.MainPID, _ = strconv.Atoi(value)
+		case "NRestarts":
+			status.NRestarts, _ = strconv.Atoi(value)
+		case "ExecMainStatus":
+			status.ExecMainStatus, _ = strconv.Atoi(value)
+		case "ExecMainCode":
+			status.ExecMainCode, _ = strconv.Atoi(value)
+		case "StateChangeTimestamp":
+			status.StateChangeAt = parseSystemdTimestamp(value)
+		case "ActiveEnterTimestamp":
+			status.ActiveEnterAt = parseSystemdTimestamp(value)
+		case "ActiveExitTimestamp":
+			status.ActiveExitAt = parseSystemdTimestamp(value)
+		case "InactiveEnterTimestamp":
+			status.InactiveEnterAt = parseSystemdTimestamp(value)
+		case "InactiveExitTimestamp":
+			status.InactiveExitAt = parseSystemdTimestamp(value)
+		case "MemoryCurrent":
+			status.MemoryCurrent = parseSystemdUint(value)
+		case "MemoryPeak":
+			status.MemoryPeak = parseSystemdUint(value)
+		case "CPUUsageNSec":
+			status.CPUUsageNSec = parseSystemdUint(value)
+		case "Requires":
+			status.Dependencies.Requires = strings.Fields(value)
+		case "Wants":
+			status.Dependencies.Wants = strings.Fields(value)
+		case "BindsTo":
+			status.Dependencies.BindsTo = strings.Fields(value)
+		case "Conflicts":
+			status.Dependencies.Conflicts = strings.Fields(value)
+		case "After":
+			status.Dependencies.After = strings.Fields(value)
+		case "Before":
+			status.Dependencies.Before = strings.Fields(value)
+		case "RequiredBy":
+			status.Dependencies.RequiredBy = strings.Fields(value)
+		case "WantedBy":
+			status.Dependencies.WantedBy = strings.Fields(value)
 		}
 	}
 
 	return status
 }
+
+// parseSystemdTimestamp parses a timestamp property from systemctl show.
+// Unset timestamps are reported as an empty value and yield nil.
+func parseSystemdTimestamp(value string) *time.Time {
+	if value == "" || value == "n/a" {
+		return nil
+	}
+	// --timestamp=unix style output
+	if strings.HasPrefix(value, "@") {
+		secs, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil {
+			return nil
+		}
+		t := time.Unix(secs, 0)
+		return &t
+	}
+	t, err := time.ParseInLocation(systemdTimestampLayout, value, time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// parseSystemdUint parses a numeric accounting property. systemd reports
+// "[not set]" or UINT64_MAX when accounting is disabled for the unit.
+func parseSystemdUint(value string) *uint64 {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == math.MaxUint64 {
+		return nil
+	}
+	return &n
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSystemdStatus(t *testing.T) {
+	output := `Id=nginx.service
+Description=A high performance web server
+LoadState=loaded
+ActiveState=active
+SubState=running
+UnitFileState=enabled
+FragmentPath=/lib/systemd/system/nginx.service
+Result=success
+MainPID=1234
+NRestarts=2
+ExecMainStatus=0
+ExecMainCode=0
+StateChangeTimestamp=Tue 2024-03-05 10:20:30 UTC
+ActiveEnterTimestamp=@1709634030
+ActiveExitTimestamp=
+InactiveEnterTimestamp=n/a
+MemoryCurrent=4194304
+MemoryPeak=[not set]
+CPUUsageNSec=18446744073709551615
+Requires=system.slice sysinit.target
+WantedBy=multi-user.target
+After=network.target remote-fs.target
+Environment=A=1 B=2
+`
+	status := parseSystemdStatus(output)
+
+	if status.Name != "nginx.service" || status.Description != "A high performance web server" {
+		t.Errorf("Name, Description = %q, %q", status.Name, status.Description)
+	}
+	if status.LoadState != "loaded" || status.Status != "active" || status.SubState != "running" || !status.IsActive {
+		t.Errorf("state = %q/%q/%q active=%v", status.LoadState, status.Status, status.SubState, status.IsActive)
+	}
+	if status.UnitFileState != "enabled" || !status.Enabled {
+		t.Errorf("UnitFileState = %q, Enabled = %v", status.UnitFileState, status.Enabled)
+	}
+	if status.MainPID != 1234 || status.NRestarts != 2 || status.Result != "success" {
+		t.Errorf("MainPID, NRestarts, Result = %d, %d, %q", status.MainPID, status.NRestarts, status.Result)
+	}
+
+	wantChange := time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC)
+	if status.StateChangeAt == nil || !status.StateChangeAt.Equal(wantChange) {
+		t.Errorf("StateChangeAt = %v, want %v", status.StateChangeAt, wantChange)
+	}
+	if status.ActiveEnterAt == nil || status.ActiveEnterAt.Unix() != 1709634030 {
+		t.Errorf("ActiveEnterAt = %v", status.ActiveEnterAt)
+	}
+	if status.ActiveExitAt != nil || status.InactiveEnterAt != nil {
+		t.Errorf("unset timestamps = %v, %v, want nil", status.ActiveExitAt, status.InactiveEnterAt)
+	}
+
+	if status.MemoryCurrent == nil || *status.MemoryCurrent != 4194304 {
+		t.Errorf("MemoryCurrent = %v", status.MemoryCurrent)
+	}
+	if status.MemoryPeak != nil || status.CPUUsageNSec != nil {
+		t.Errorf("disabled accounting = %v, %v, want nil", status.MemoryPeak, status.CPUUsageNSec)
+	}
+
+	wantDeps := ServiceDependencies{
+		Requires: []string{"system.slice", "sysinit.target"},
+		WantedBy: []string{"multi-user.target"},
+		After:    []string{"network.target", "remote-fs.target"},
+	}
+	if !reflect.DeepEqual(status.Dependencies, wantDeps) {
+		t.Errorf("Dependencies = %+v, want %+v", status.Dependencies, wantDeps)
+	}
+}
+
+func TestParseSystemdStatusStates(t *testing.T) {
+	tests := []struct {
+		output      string
+		wantActive  bool
+		wantEnabled bool
+	}{
+		{"ActiveState=inactive\nUnitFileState=disabled", false, false},
+		{"ActiveState=failed\nUnitFileState=enabled-runtime", false, true},
+		{"ActiveState=activating\nUnitFileState=static", false, false},
+		{"ActiveState=active\nUnitFileState=masked", true, false},
+	}
+	for _, tt := range tests {
+		status := parseSystemdStatus(tt.output)
+		if status.IsActive != tt.wantActive || status.Enabled != tt.wantEnabled {
+			t.Errorf("parseSystemdStatus(%q): active=%v enabled=%v, want %v %v",
+				tt.output, status.IsActive, status.Enabled, tt.wantActive, tt.wantEnabled)
+		}
+	}
+}
+
+func TestSystemdStartStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      func(*SystemdService) (*ActionResult, error)
+		activeState string
+		wantErr     error
+		wantCommand string // last command run, empty if none should run
+	}{
+		{"start inactive", func(s *SystemdService) (*ActionResult, error) { return s.Start(context.Background(), "nginx") }, "inactive", nil, "systemctl start nginx"},
+		{"start active", func(s *SystemdService) (*ActionResult, error) { return s.Start(context.Background(), "nginx") }, "active", ErrAlreadyInState, ""},
+		{"stop active", func(s *SystemdService) (*ActionResult, error) { return s.Stop(context.Background(), "nginx") }, "active", nil, "systemctl stop nginx"},
+		{"stop inactive", func(s *SystemdService) (*ActionResult, error) { return s.Stop(context.Background(), "nginx") }, "inactive", ErrAlreadyInState, ""},
+		{"start unknown", func(s *SystemdService) (*ActionResult, error) { return s.Start(context.Background(), "nginx") }, "", ErrNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := NewFakeExecutor()
+			if tt.activeState == "" {
+				executor.On("systemctl show nginx --property=ActiveState,LoadState", FakeResponse{Stdout: "ActiveState=inactive\nLoadState=not-found\n"})
+			} else {
+				executor.On("systemctl show nginx --property=ActiveState,LoadState", FakeResponse{Stdout: "ActiveState=" + tt.activeState + "\nLoadState=loaded\n"})
+			}
+
+			_, err := tt.action(NewSystemdService(executor))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			commands := executor.Commands()
+			last := commands[len(commands)-1].String()
+			if tt.wantCommand != "" && last != tt.wantCommand {
+				t.Errorf("last command = %q, want %q", last, tt.wantCommand)
+			}
+			if tt.wantCommand == "" && len(commands) != 1 {
+				t.Errorf("commands = %v, want only the state query", commands)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// logFilter applies the filters of a LogQuery other than its line limit,
+// order and cursor to entries read from log files
+type logFilter struct {
+	since, until            *time.Time
+	mostSevere, leastSevere int
+	grep                    *regexp.Regexp
+}
+
+// newLogFilter compiles the filters of a validated query
+func newLogFilter(query LogQuery) logFilter {
+	f := logFilter{since: query.Since, until: query.Until, leastSevere: 7}
+	if query.Priority != "" {
+		f.mostSevere, f.leastSevere, _ = parsePriorityRange(query.Priority)
+	}
+	if query.Grep != "" {
+		f.grep = regexp.MustCompile(query.Grep)
+	}
+	return f
+}
+
+// matches reports whether entry passes the filter. Entries without a
+// timestamp are never excluded by time.
+func (f logFilter) matches(entry LogEntry) bool {
+	if !entry.Timestamp.IsZero() {
+		if f.since != nil && entry.Timestamp.Before(*f.since) {
+			return false
+		}
+		if f.until != nil && entry.Timestamp.After(*f.until) {
+			return false
+		}
+	}
+	if entry.Priority < f.mostSevere || entry.Priority > f.leastSevere {
+		return false
+	}
+	return f.grep == nil || f.grep.MatchString(entry.Message)
+}
+
+// readLogFiles reads paths, oldest file first, and returns the entries
+// selected by query. parse converts a line and its position across all the
+// files into an entry, or reports false to skip the line. Entry cursors must
+// be integers that increase through the files. Missing files are skipped.
+func readLogFiles(paths []string, query LogQuery, parse func(line string, n int64) (LogEntry, bool)) ([]LogEntry, error) {
+	var after int64
+	if query.Cursor != "" {
+		c, err := strconv.ParseInt(query.Cursor, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		after = c
+	}
+
+	filter := newLogFilter(query)
+	var entries []LogEntry
+	var n int64
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			n++
+			entry, ok := parse(scanner.Text(), n)
+			if !ok || !filter.matches(entry) {
+				continue
+			}
+			if query.Cursor != "" {
+				c, _ := strconv.ParseInt(entry.Cursor, 10, 64)
+				// Newer entries than the cursor when paging forward, older in
+				// reverse
+				if (!query.Reverse && c <= after) || (query.Reverse && c >= after) {
+					continue
+				}
+			}
+			entries = append(entries, entry)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	return selectLogEntries(entries, query), nil
+}
+
+// selectLogEntries applies the line limit and order of query to entries in
+// chronological order: the newest entries, unless paging forward from a
+// cursor, and newest first when reversed
+func selectLogEntries(entries []LogEntry, query LogQuery) []LogEntry {
+	if len(entries) > query.Lines {
+		if query.Cursor != "" && !query.Reverse {
+			entries = entries[:query.Lines]
+		} else {
+			entries = entries[len(entries)-query.Lines:]
+		}
+	}
+	if query.Reverse {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	if entries == nil {
+		entries = make([]LogEntry, 0)
+	}
+	return entries
+}
+
+// rotatedPaths returns path and its numbered backups, oldest first:
+// path.<backups>, ..., path.1, path
+func rotatedPaths(path string, backups int) []string {
+	paths := make([]string, 0, backups+1)
+	for i := backups; i >= 1; i-- {
+		paths = append(paths, fmt.Sprintf("%s.%d", path, i))
+	}
+	return append(paths, path)
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ensure OpenRCService implements ServiceManager
+var _ ServiceManager = (*OpenRCService)(nil)
+
+// openrcRunlevel is the runlevel services are added to by Enable and
+// removed from by Disable
+const openrcRunlevel = "default"
+
+// OpenRCService implements the ServiceManager interface for hosts running
+// OpenRC, such as Alpine, through rc-service, rc-status and rc-update
+type OpenRCService struct {
+	BaseServiceHandler
+	exec    Executor
+	initDir string
+	logDir  string
+}
+
+// NewOpenRCService creates an OpenRC service manager that runs its commands
+// through executor and reads service logs from logDir
+func NewOpenRCService(executor Executor, logDir string) *OpenRCService {
+	return &OpenRCService{exec: executor, initDir: defaultInitDir, logDir: logDir}
+}
+
+// openrcStates maps the exit codes of "rc-service <name> status" to an
+// active state and sub-state
+var openrcStates = map[int][2]string{
+	0:  {"active", "running"},
+	3:  {"inactive", "dead"},
+	4:  {"deactivating", "stopping"},
+	8:  {"activating", "starting"},
+	16: {"inactive", "inactive"}, // waiting for a dependency, e.g. the network
+	32: {"failed", "crashed"},
+}
+
+// openrcStatusWords maps the states shown by rc-status to an active state
+// and sub-state
+var openrcStatusWords = map[string][2]string{
+	"started":   {"active", "running"},
+	"stopped":   {"inactive", "dead"},
+	"stopping":  {"deactivating", "stopping"},
+	"starting":  {"activating", "starting"},
+	"inactive":  {"inactive", "inactive"},
+	"crashed":   {"failed", "crashed"},
+	"failed":    {"failed", "failed"},
+	"scheduled": {"activating", "scheduled"},
+}
+
+// List lists every OpenRC service with its state
+func (s *OpenRCService) List(ctx context.Context) ([]ServiceInfo, error) {
+	names, err := runCommand(ctx, s.exec, "list", "", "rc-service", "--list")
+	if err != nil {
+		return nil, err
+	}
+	states, err := runCommand(ctx, s.exec, "list", "", "rc-status", "--all", "--nocolor")
+	if err != nil {
+		return nil, err
+	}
+	parsed := parseRCStatus(string(states))
+
+	var services []ServiceInfo
+	for _, name := range strings.Fields(string(names)) {
+		// Services in no runlevel are not shown by rc-status and are stopped
+		state, ok := parsed[name]
+		if !ok {
+			state = openrcStatusWords["stopped"]
+		}
+		services = append(services, ServiceInfo{
+			Name:        name,
+			LoadState:   "loaded",
+			ActiveState: state[0],
+			SubState:    state[1],
+		})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	if services == nil {
+		services = make([]ServiceInfo, 0)
+	}
+	return services, nil
+}
+
+// parseRCStatus parses lines such as " sshd  [  started 01:02:03 (0) ]"
+// from rc-status, skipping runlevel headers
+func parseRCStatus(output string) map[string][2]string {
+	states := make(map[string][2]string)
+	for _, line := range strings.Split(output, "\n") {
+		open, close := strings.LastIndex(line, "["), strings.LastIndex(line, "]")
+		if open < 0 || close < open {
+			continue
+		}
+		fields := strings.Fields(line[:open])
+		words := strings.FieldsFunc(line[open+1:close], func(r rune) bool { return r == ' ' || r == ',' })
+		if len(fields) != 1 || len(words) == 0 {
+			continue
+		}
+		state, ok := openrcStatusWords[words[0]]
+		// A started service whose daemon died is shown as "started, crashed"
+		for _, word := range words[1:] {
+			if word == "crashed" {
+				state, ok = openrcStatusWords["crashed"], true
+			}
+		}
+		if ok {
+			states[fields[0]] = state
+		}
+	}
+	return states
+}
+
+// Status gets the state of an OpenRC service and whether it is in a runlevel
+func (s *OpenRCService) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("status", name, ErrInvalidName, "")
+	}
+	name = UnitBaseName(name)
+
+	code, err := initStatus(ctx, s.exec, name, "rc-service", name, "status")
+	if err != nil {
+		return nil, err
+	}
+	state, ok := openrcStates[code]
+	if !ok {
+		return nil, newServiceError("status", name, fmt.Errorf("unexpected status exit code %d", code), "")
+	}
+
+	runlevels, err := s.runlevels(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	status := initScriptStatus(name, filepath.Join(s.initDir, name), state[0], state[1], len(runlevels) > 0)
+	if state[1] == "crashed" {
+		status.Result = "crashed"
+	}
+	status.Dependencies.WantedBy = runlevels
+	return status, nil
+}
+
+// runlevels returns the runlevels the named service is in, from lines such
+// as "  sshd | boot default" printed by rc-update show
+func (s *OpenRCService) runlevels(ctx context.Context, name string) ([]string, error) {
+	output, err := runCommand(ctx, s.exec, "status", name, "rc-update", "show")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		service, levels, ok := strings.Cut(line, "|")
+		if ok && strings.TrimSpace(service) == name {
+			return strings.Fields(levels), nil
+		}
+	}
+	return nil, nil
+}
+
+// Start starts an OpenRC service
+func (s *OpenRCService) Start(ctx context.Context, name string) (*ActionResult, error) {
+	status, err := s.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.IsActive {
+		return nil, newServiceError("start", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already running", name))
+	}
+	return s.runAction(ctx, "start", name, "started")
+}
+
+// Stop stops an OpenRC service
+func (s *OpenRCService) Stop(ctx context.Context, name string) (*ActionResult, error) {
+	status, err := s.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status == "inactive" {
+		return nil, newServiceError("stop", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already stopped", name))
+	}
+	return s.runAction(ctx, "stop", name, "stopped")
+}
+
+// Restart restarts an OpenRC service, starting it if it is not running
+func (s *OpenRCService) Restart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "restart", name, "restarted")
+}
+
+// Reload runs the reload command of an OpenRC service, which only exists
+// when its init script defines one
+func (s *OpenRCService) Reload(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "reload", name, "reloaded")
+}
+
+// ReloadOrRestart reloads an OpenRC service and restarts it if the reload
+// fails, e.g. because the init script has no reload command
+func (s *OpenRCService) ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error) {
+	if _, err := s.runAction(ctx, "reload", name, "reloaded"); err == nil {
+		return &ActionResult{Name: name, Action: "reload-or-restart", Message: fmt.Sprintf("Service %s reloaded successfully", name)}, nil
+	} else if errors.Is(err, ErrInvalidName) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrTimeout) {
+		return nil, err
+	}
+	if _, err := s.runAction(ctx, "restart", name, "restarted"); err != nil {
+		return nil, err
+	}
+	return &ActionResult{Name: name, Action: "reload-or-restart", Message: fmt.Sprintf("Service %s restarted successfully", name)}, nil
+}
+
+// Enable adds an OpenRC service to the default runlevel, starting it as
+// well when now is set
+func (s *OpenRCService) Enable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.runUpdateAction(ctx, "enable", name, "add", "start", "enabled", now)
+}
+
+// Disable removes an OpenRC service from the default runlevel, stopping it
+// as well when now is set
+func (s *OpenRCService) Disable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.runUpdateAction(ctx, "disable", name, "del", "stop", "disabled", now)
+}
+
+// Mask is not supported: OpenRC has no way to forbid starting a service
+func (s *OpenRCService) Mask(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return nil, newServiceError("mask", name, ErrUnsupported, "OpenRC services cannot be masked")
+}
+
+// Unmask is not supported, see Mask
+func (s *OpenRCService) Unmask(ctx context.Context, name string) (*ActionResult, error) {
+	return nil, newServiceError("unmask", name, ErrUnsupported, "OpenRC services cannot be masked")
+}
+
+// Logs reads the service's log file from the configured log directory
+func (s *OpenRCService) Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	return readServiceLogFile(s.logDir, name, query)
+}
+
+// runAction runs "rc-service <name> <action>"
+func (s *OpenRCService) runAction(ctx context.Context, action, name, pastTense string) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(action, name, ErrInvalidName, "")
+	}
+	if _, err := runCommand(ctx, s.exec, action, name, "rc-service", UnitBaseName(name), action); err != nil {
+		return nil, err
+	}
+	return &ActionResult{
+		Name:    name,
+		Action:  action,
+		Message: fmt.Sprintf("Service %s %s successfully", name, pastTense),
+	}, nil
+}
+
+// runUpdateAction adds the service to or removes it from the default
+// runlevel with rc-update, then runs nowAction when now is set
+func (s *OpenRCService) runUpdateAction(ctx context.Context, op, name, update, nowAction, pastTense string, now bool) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(op, name, ErrInvalidName, "")
+	}
+	if _, err := runCommand(ctx, s.exec, op, name, "rc-update", update, UnitBaseName(name), openrcRunlevel); err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Service %s %s successfully", name, pastTense)
+	if now {
+		if _, err := runCommand(ctx, s.exec, op, name, "rc-service", UnitBaseName(name), nowAction); err != nil {
+			return nil, err
+		}
+		message += " (applied now)"
+	}
+	return &ActionResult{Name: name, Action: op, Message: message}, nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRCStatus(t *testing.T) {
+	output := `Runlevel: default
+ sshd                                          [  started  ]
+ crond                                         [  started 01:02:03 (0) ]
+ nginx                                         [  started, crashed  ]
+ php-fpm                                       [  stopped  ]
+Dynamic Runlevel: hotplugged
+Dynamic Runlevel: needed/wanted
+ networking                                    [  starting  ]
+ net.eth1                                      [  inactive  ]
+Dynamic Runlevel: manual
+ app                                           [  failed  ]
+ cron-backup                                   [  scheduled  ]
+ unknown-state                                 [  confused  ]
+ two words                                     [  started  ]
+`
+	want := map[string][2]string{
+		"sshd":        {"active", "running"},
+		"crond":       {"active", "running"},
+		"nginx":       {"failed", "crashed"},
+		"php-fpm":     {"inactive", "dead"},
+		"networking":  {"activating", "starting"},
+		"net.eth1":    {"inactive", "inactive"},
+		"app":         {"failed", "failed"},
+		"cron-backup": {"activating", "scheduled"},
+	}
+	if got := parseRCStatus(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRCStatus() = %v, want %v", got, want)
+	}
+}
+
+func TestOpenRCStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    FakeResponse
+		wantActive  string
+		wantSub     string
+		wantResult  string
+		wantErr     error
+		wantMessage string
+	}{
+		{name: "started", response: FakeResponse{}, wantActive: "active", wantSub: "running"},
+		{name: "stopped", response: FakeResponse{ExitCode: 3}, wantActive: "inactive", wantSub: "dead"},
+		{name: "stopping", response: FakeResponse{ExitCode: 4}, wantActive: "deactivating", wantSub: "stopping"},
+		{name: "starting", response: FakeResponse{ExitCode: 8}, wantActive: "activating", wantSub: "starting"},
+		{name: "inactive", response: FakeResponse{ExitCode: 16}, wantActive: "inactive", wantSub: "inactive"},
+		{name: "crashed", response: FakeResponse{ExitCode: 32}, wantActive: "failed", wantSub: "crashed", wantResult: "crashed"},
+		{name: "unexpected code", response: FakeResponse{ExitCode: 1}, wantMessage: "unexpected status exit code 1"},
+		{name: "not found", response: FakeResponse{ExitCode: 1, Stderr: " * rc-service: service `app' does not exist"}, wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := NewFakeExecutor()
+			executor.On("rc-service app status", tt.response)
+			executor.On("rc-update show", FakeResponse{Stdout: "                 app | default\n                sshd | boot default\n"})
+			s := NewOpenRCService(executor, t.TempDir())
+
+			status, err := s.Status(context.Background(), "app.service")
+			switch {
+			case tt.wantErr != nil || tt.wantMessage != "":
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) || !strings.Contains(err.Error(), tt.wantMessage) {
+					t.Fatalf("Status() error = %v, want %v %q", err, tt.wantErr, tt.wantMessage)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			if status.Status != tt.wantActive || status.SubState != tt.wantSub || status.Result != tt.wantResult {
+				t.Errorf("status = %s/%s, result %q, want %s/%s, result %q",
+					status.Status, status.SubState, status.Result, tt.wantActive, tt.wantSub, tt.wantResult)
+			}
+			if status.IsActive != (tt.wantActive == "active") {
+				t.Errorf("IsActive = %v", status.IsActive)
+			}
+			if !status.Enabled || !reflect.DeepEqual(status.Dependencies.WantedBy, []string{"default"}) {
+				t.Errorf("enabled = %v in %v, want enabled in default", status.Enabled, status.Dependencies.WantedBy)
+			}
+		})
+	}
+}
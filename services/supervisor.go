@@ -0,0 +1,681 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// Ensure SupervisorService implements ServiceManager and LogFollower
+var (
+	_ ServiceManager = (*SupervisorService)(nil)
+	_ LogFollower    = (*SupervisorService)(nil)
+)
+
+// Restart policies for supervised programs
+const (
+	RestartNo        = "no"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+const (
+	defaultRestartDelay  = time.Second
+	maxRestartDelay      = time.Minute
+	defaultStopTimeout   = 10 * time.Second
+	defaultLogMaxSize    = 10 // MB
+	defaultLogMaxBackups = 3
+	// stableRunTime is how long a program must run for its restart delay to
+	// reset to the initial value
+	stableRunTime = 10 * time.Second
+)
+
+// stopSignalNames lists the signals programs may be stopped or reloaded with
+var stopSignalNames = []string{"TERM", "INT", "QUIT", "HUP", "USR1", "USR2"}
+
+// Exit codes reported in ExecMainCode, as used by systemd
+const (
+	cldExited = 1
+	cldKilled = 2
+)
+
+// SupervisorService implements the ServiceManager interface by running
+// configured programs itself, for hosts without a service manager. Status
+// comes from its own process table and logs from the captured output of
+// each program.
+type SupervisorService struct {
+	BaseServiceHandler
+	programs map[string]*program // keyed by unit base name
+}
+
+// program is a supervised program and its process state
+type program struct {
+	name   string
+	config programConfig
+	logs   *programLog
+
+	mu             sync.Mutex
+	cmd            *exec.Cmd
+	done           chan struct{} // closed when the current process has exited
+	stopping       bool
+	restartTimer   *time.Timer
+	restartDelay   time.Duration
+	activeState    string
+	subState       string
+	result         string
+	exitCode       int
+	exitKind       int
+	nRestarts      int
+	stateChangeAt  *time.Time
+	activeEnterAt  *time.Time
+	activeExitAt   *time.Time
+	inactiveEnter  *time.Time
+	inactiveExitAt *time.Time
+}
+
+// programConfig is a utils.Program with its defaults filled in
+type programConfig struct {
+	description  string
+	command      []string
+	dir          string
+	env          []string
+	user         string
+	autostart    bool
+	restart      string
+	restartDelay time.Duration
+	stopSignal   string
+	stopTimeout  time.Duration
+	reloadSignal string
+}
+
+// NewSupervisorService validates the programs in cfg, creates their log
+// directory and starts those marked autostart
+func NewSupervisorService(cfg utils.SupervisorConfig) (*SupervisorService, error) {
+	s := &SupervisorService{programs: make(map[string]*program)}
+	if len(cfg.Programs) > 0 {
+		if err := os.MkdirAll(cfg.LogDirectory, 0750); err != nil {
+			return nil, fmt.Errorf("failed to create supervisor log directory: %w", err)
+		}
+	}
+
+	for name, c := range cfg.Programs {
+		if !s.ValidateServiceName(name) {
+			return nil, fmt.Errorf("invalid program name %q", name)
+		}
+		pc, err := newProgramConfig(c)
+		if err != nil {
+			return nil, fmt.Errorf("program %s: %w", name, err)
+		}
+		maxSize, maxBackups := c.LogMaxSize, c.LogMaxBackups
+		if maxSize <= 0 {
+			maxSize = defaultLogMaxSize
+		}
+		if maxBackups <= 0 {
+			maxBackups = defaultLogMaxBackups
+		}
+
+		now := time.Now()
+		s.programs[UnitBaseName(name)] = &program{
+			name:          name,
+			config:        pc,
+			logs:          newProgramLog(filepath.Join(cfg.LogDirectory, UnitBaseName(name)+".log"), UnitBaseName(name), int64(maxSize)<<20, maxBackups),
+			restartDelay:  pc.restartDelay,
+			activeState:   "inactive",
+			subState:      "dead",
+			stateChangeAt: &now,
+		}
+	}
+
+	for _, p := range s.programs {
+		if p.config.autostart {
+			if err := p.start(); err != nil {
+				p.logs.writeLine("supervisor", 0, fmt.Sprintf("Autostart failed: %v", err))
+			}
+		}
+	}
+	return s, nil
+}
+
+// newProgramConfig validates c and fills in defaults
+func newProgramConfig(c utils.Program) (programConfig, error) {
+	pc := programConfig{
+		description:  c.Description,
+		command:      c.Command,
+		dir:          c.WorkingDirectory,
+		user:         c.User,
+		autostart:    c.Autostart,
+		restart:      c.Restart,
+		restartDelay: defaultRestartDelay,
+		stopSignal:   c.StopSignal,
+		stopTimeout:  defaultStopTimeout,
+		reloadSignal: c.ReloadSignal,
+	}
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return pc, errors.New("command is required")
+	}
+	for key, value := range c.Environment {
+		pc.env = append(pc.env, key+"="+value)
+	}
+	sort.Strings(pc.env)
+
+	switch pc.restart {
+	case "":
+		pc.restart = RestartOnFailure
+	case RestartNo, RestartOnFailure, RestartAlways:
+	default:
+		return pc, fmt.Errorf("invalid restart policy %q", c.Restart)
+	}
+	if pc.stopSignal == "" {
+		pc.stopSignal = "TERM"
+	}
+	for _, sig := range []string{pc.stopSignal, pc.reloadSignal} {
+		if sig != "" && !validSignalName(sig) {
+			return pc, fmt.Errorf("unsupported signal %q, use one of %s", sig, strings.Join(stopSignalNames, ", "))
+		}
+	}
+	for _, d := range []struct {
+		value string
+		field *time.Duration
+		name  string
+	}{
+		{c.RestartDelay, &pc.restartDelay, "restartDelay"},
+		{c.StopTimeout, &pc.stopTimeout, "stopTimeout"},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return pc, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.field = parsed
+	}
+	return pc, nil
+}
+
+// validSignalName reports whether name is one of stopSignalNames
+func validSignalName(name string) bool {
+	for _, n := range stopSignalNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the named program or a not-found ServiceError for op
+func (s *SupervisorService) lookup(op, name string) (*program, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(op, name, ErrInvalidName, "")
+	}
+	p, ok := s.programs[UnitBaseName(name)]
+	if !ok {
+		return nil, newServiceError(op, name, ErrNotFound, fmt.Sprintf("Service %s not found", name))
+	}
+	return p, nil
+}
+
+// setState records a state transition. Callers hold p.mu.
+func (p *program) setState(active, sub string) {
+	now := time.Now()
+	switch {
+	case active == "active" && p.activeState != "active":
+		p.activeEnterAt = &now
+	case active != "active" && p.activeState == "active":
+		p.activeExitAt = &now
+	}
+	switch {
+	case active == "inactive" && p.activeState != "inactive":
+		p.inactiveEnter = &now
+	case active != "inactive" && p.activeState == "inactive":
+		p.inactiveExitAt = &now
+	}
+	p.activeState, p.subState = active, sub
+	p.stateChangeAt = &now
+}
+
+// running reports whether the program has a live process. Callers hold p.mu.
+func (p *program) running() bool {
+	return p.cmd != nil
+}
+
+// start launches the program's process
+func (p *program) start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running() {
+		return newServiceError("start", p.name, ErrAlreadyInState, fmt.Sprintf("Service %s is already running", p.name))
+	}
+	if p.restartTimer != nil {
+		p.restartTimer.Stop()
+		p.restartTimer = nil
+	}
+	return p.launch()
+}
+
+// launch starts a new process. Callers hold p.mu.
+func (p *program) launch() error {
+	cmd := exec.Command(p.config.command[0], p.config.command[1:]...)
+	cmd.Dir = p.config.dir
+	cmd.Env = append(os.Environ(), p.config.env...)
+	if err := configureCommand(cmd, p.config.user); err != nil {
+		p.result = "resources"
+		p.setState("failed", "failed")
+		return newServiceError("start", p.name, ErrInvalidArgument, err.Error())
+	}
+
+	stdout := p.logs.lineWriter("stdout")
+	stderr := p.logs.lineWriter("stderr")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// Do not wait forever for output from children the program left behind
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Start(); err != nil {
+		p.result = "resources"
+		p.setState("failed", "failed")
+		p.logs.writeLine("supervisor", 0, fmt.Sprintf("Failed to start: %v", err))
+		return newServiceError("start", p.name, classifyError(context.Background(), err, err.Error()), err.Error())
+	}
+
+	stdout.pid.Store(int64(cmd.Process.Pid))
+	stderr.pid.Store(int64(cmd.Process.Pid))
+	p.cmd = cmd
+	p.done = make(chan struct{})
+	p.stopping = false
+	p.setState("active", "running")
+	p.logs.writeLine("supervisor", cmd.Process.Pid, fmt.Sprintf("Started %s", strings.Join(p.config.command, " ")))
+
+	startedAt := time.Now()
+	done := p.done
+	go func() {
+		err := cmd.Wait()
+		stdout.Close()
+		stderr.Close()
+		p.exited(cmd, err, time.Since(startedAt))
+		close(done)
+	}()
+	return nil
+}
+
+// exited records the exit of cmd and plans a restart if the policy asks
+// for one
+func (p *program) exited(cmd *exec.Cmd, err error, ran time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := cmd.ProcessState
+	p.cmd = nil
+	p.exitKind, p.exitCode = cldExited, state.ExitCode()
+	if sig, ok := exitSignal(state); ok {
+		p.exitKind, p.exitCode = cldKilled, sig
+	}
+	failed := p.exitKind != cldExited || p.exitCode != 0
+	p.result = "success"
+	if failed && !p.stopping {
+		p.result = "exit-code"
+		if p.exitKind == cldKilled {
+			p.result = "signal"
+		}
+	}
+
+	message := fmt.Sprintf("Exited with code %d", p.exitCode)
+	if p.exitKind == cldKilled {
+		message = fmt.Sprintf("Killed by signal %d", p.exitCode)
+	} else if err != nil && state.ExitCode() < 0 {
+		message = fmt.Sprintf("Exited: %v", err)
+	}
+	p.logs.writeLine("supervisor", state.Pid(), message)
+
+	if p.stopping {
+		p.setState("inactive", "dead")
+		return
+	}
+	restart := p.config.restart == RestartAlways || (p.config.restart == RestartOnFailure && failed)
+	if !restart {
+		if failed {
+			p.setState("failed", "failed")
+		} else {
+			p.setState("inactive", "dead")
+		}
+		return
+	}
+
+	// Back off while the program keeps exiting soon after starting
+	if ran >= stableRunTime {
+		p.restartDelay = p.config.restartDelay
+	}
+	delay := p.restartDelay
+	p.restartDelay = min(p.restartDelay*2, max(maxRestartDelay, p.config.restartDelay))
+	p.setState("activating", "auto-restart")
+	p.restartTimer = time.AfterFunc(delay, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.restartTimer == nil || p.running() {
+			return
+		}
+		p.restartTimer = nil
+		p.nRestarts++
+		p.launch()
+	})
+}
+
+// stop sends the stop signal to the program and kills it if it has not
+// exited within the stop timeout
+func (p *program) stop() error {
+	p.mu.Lock()
+	if p.restartTimer != nil {
+		// Waiting to restart: cancelling the restart stops the program
+		p.restartTimer.Stop()
+		p.restartTimer = nil
+		p.setState("inactive", "dead")
+		p.mu.Unlock()
+		return nil
+	}
+	if !p.running() {
+		p.mu.Unlock()
+		return newServiceError("stop", p.name, ErrAlreadyInState, fmt.Sprintf("Service %s is already stopped", p.name))
+	}
+	cmd, done := p.cmd, p.done
+	p.stopping = true
+	p.setState("deactivating", "stop-sigterm")
+	p.mu.Unlock()
+
+	if err := signalProcess(cmd, p.config.stopSignal); err != nil {
+		killProcess(cmd)
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(p.config.stopTimeout):
+	}
+
+	p.mu.Lock()
+	p.setState("deactivating", "stop-sigkill")
+	p.mu.Unlock()
+	p.logs.writeLine("supervisor", cmd.Process.Pid, fmt.Sprintf("Did not stop within %s, killing", p.config.stopTimeout))
+	killProcess(cmd)
+	<-done
+	return nil
+}
+
+// reload sends the reload signal to the running program
+func (p *program) reload() error {
+	if p.config.reloadSignal == "" {
+		return newServiceError("reload", p.name, ErrUnsupported, fmt.Sprintf("Service %s has no reloadSignal configured", p.name))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running() {
+		return newServiceError("reload", p.name, ErrInvalidArgument, fmt.Sprintf("Service %s is not running", p.name))
+	}
+	if err := signalProcess(p.cmd, p.config.reloadSignal); err != nil {
+		return newServiceError("reload", p.name, err, "")
+	}
+	p.logs.writeLine("supervisor", p.cmd.Process.Pid, "Sent "+p.config.reloadSignal+" to reload")
+	return nil
+}
+
+// status snapshots the program's state
+func (p *program) status() *ServiceStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := &ServiceStatus{
+		Name:            p.name,
+		Description:     p.config.description,
+		LoadState:       "loaded",
+		Status:          p.activeState,
+		SubState:        p.subState,
+		UnitFileState:   "disabled",
+		Enabled:         p.config.autostart,
+		IsActive:        p.activeState == "active",
+		Result:          p.result,
+		NRestarts:       p.nRestarts,
+		ExecMainStatus:  p.exitCode,
+		ExecMainCode:    p.exitKind,
+		StateChangeAt:   p.stateChangeAt,
+		ActiveEnterAt:   p.activeEnterAt,
+		ActiveExitAt:    p.activeExitAt,
+		InactiveEnterAt: p.inactiveEnter,
+		InactiveExitAt:  p.inactiveExitAt,
+		UpdatedAt:       time.Now(),
+	}
+	if p.config.autostart {
+		status.UnitFileState = "enabled"
+	}
+	if p.cmd != nil {
+		status.MainPID = p.cmd.Process.Pid
+	}
+	return status
+}
+
+// List returns every supervised program
+func (s *SupervisorService) List(ctx context.Context) ([]ServiceInfo, error) {
+	list := make([]ServiceInfo, 0, len(s.programs))
+	for _, p := range s.programs {
+		status := p.status()
+		list = append(list, ServiceInfo{
+			Name:        p.name,
+			Description: status.Description,
+			LoadState:   status.LoadState,
+			ActiveState: status.Status,
+			SubState:    status.SubState,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Status returns the state of a supervised program from the process table
+func (s *SupervisorService) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	p, err := s.lookup("status", name)
+	if err != nil {
+		return nil, err
+	}
+	return p.status(), nil
+}
+
+// Start starts a supervised program
+func (s *SupervisorService) Start(ctx context.Context, name string) (*ActionResult, error) {
+	p, err := s.lookup("start", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	return actionResult(name, "start", "started"), nil
+}
+
+// Stop stops a supervised program, killing it after its stop timeout
+func (s *SupervisorService) Stop(ctx context.Context, name string) (*ActionResult, error) {
+	p, err := s.lookup("stop", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.stop(); err != nil {
+		return nil, err
+	}
+	return actionResult(name, "stop", "stopped"), nil
+}
+
+// Restart stops a supervised program if it is running and starts it again
+func (s *SupervisorService) Restart(ctx context.Context, name string) (*ActionResult, error) {
+	p, err := s.lookup("restart", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.stop(); err != nil && !errors.Is(err, ErrAlreadyInState) {
+		return nil, err
+	}
+	if err := p.start(); err != nil && !errors.Is(err, ErrAlreadyInState) {
+		return nil, err
+	}
+	return actionResult(name, "restart", "restarted"), nil
+}
+
+// Reload sends the program's reload signal
+func (s *SupervisorService) Reload(ctx context.Context, name string) (*ActionResult, error) {
+	p, err := s.lookup("reload", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return actionResult(name, "reload", "reloaded"), nil
+}
+
+// ReloadOrRestart reloads the program if it is running and has a reload
+// signal, and restarts it otherwise
+func (s *SupervisorService) ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error) {
+	p, err := s.lookup("reload-or-restart", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.reload(); err == nil {
+		return actionResult(name, "reload-or-restart", "reloaded"), nil
+	}
+	if _, err := s.Restart(ctx, name); err != nil {
+		return nil, err
+	}
+	return actionResult(name, "reload-or-restart", "restarted"), nil
+}
+
+// Enable is not supported: programs start with ChronoServe when autostart
+// is set in the configuration
+func (s *SupervisorService) Enable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return nil, s.unsupported("enable", name)
+}
+
+// Disable is not supported, see Enable
+func (s *SupervisorService) Disable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return nil, s.unsupported("disable", name)
+}
+
+// Mask is not supported by the supervisor
+func (s *SupervisorService) Mask(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return nil, s.unsupported("mask", name)
+}
+
+// Unmask is not supported by the supervisor
+func (s *SupervisorService) Unmask(ctx context.Context, name string) (*ActionResult, error) {
+	return nil, s.unsupported("unmask", name)
+}
+
+func (s *SupervisorService) unsupported(op, name string) error {
+	if _, err := s.lookup(op, name); err != nil {
+		return err
+	}
+	return newServiceError(op, name, ErrUnsupported,
+		fmt.Sprintf("%s is not supported for supervised programs; set autostart in supervisor.programs", op))
+}
+
+// Logs returns the captured output of a supervised program
+func (s *SupervisorService) Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error) {
+	p, err := s.lookup("logs", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.Validate(); err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+	entries, err := p.logs.read(query)
+	if err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+	return entries, nil
+}
+
+// FollowLogs sends the output selected by query and then every new line
+// until ctx is cancelled
+func (s *SupervisorService) FollowLogs(ctx context.Context, name string, query LogQuery) (<-chan LogEntry, error) {
+	p, err := s.lookup("logs", name)
+	if err != nil {
+		return nil, err
+	}
+	if query.Reverse {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, "reverse cannot be combined with follow")
+	}
+	if err := query.Validate(); err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+
+	// Subscribe before reading the backlog so no line falls in between
+	live, unsubscribe := p.logs.subscribe()
+	backlog, err := p.logs.read(query)
+	if err != nil {
+		unsubscribe()
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+
+	filter := newLogFilter(LogQuery{Priority: query.Priority, Grep: query.Grep})
+	entries := make(chan LogEntry, 64)
+	go func() {
+		defer close(entries)
+		defer unsubscribe()
+
+		var last int64
+		for _, entry := range backlog {
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return
+			}
+			last, _ = strconv.ParseInt(entry.Cursor, 10, 64)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry := <-live:
+				if c, _ := strconv.ParseInt(entry.Cursor, 10, 64); c <= last || !filter.matches(entry) {
+					continue
+				}
+				select {
+				case entries <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return entries, nil
+}
+
+// Close stops every running program, waiting for them to exit, and closes
+// their log files. Call it when ChronoServe shuts down.
+func (s *SupervisorService) Close() error {
+	var wg sync.WaitGroup
+	for _, p := range s.programs {
+		wg.Add(1)
+		go func(p *program) {
+			defer wg.Done()
+			p.stop()
+		}(p)
+	}
+	wg.Wait()
+	for _, p := range s.programs {
+		p.logs.close()
+	}
+	return nil
+}
+
+// actionResult describes a completed action in the same words as the other
+// backends
+func actionResult(name, action, pastTense string) *ActionResult {
+	return &ActionResult{
+		Name:    name,
+		Action:  action,
+		Message: fmt.Sprintf("Service %s %s successfully", name, pastTense),
+	}
+}
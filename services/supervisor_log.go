@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priorities of the streams a supervised program's log records
+var streamPriorities = map[string]int{
+	"stdout":     6, // info
+	"stderr":     3, // err
+	"supervisor": 5, // notice
+}
+
+// programLog is the rotating log file of a supervised program. Each line is
+// "<RFC3339Nano timestamp> <stream> <pid> <message>"; timestamps are unique
+// and increasing so their UnixNano value serves as the entry cursor.
+type programLog struct {
+	path     string
+	ident    string
+	maxSize  int64
+	backups  int
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	last     time.Time
+	watchers map[chan LogEntry]struct{}
+}
+
+func newProgramLog(path, ident string, maxSize int64, backups int) *programLog {
+	return &programLog{
+		path:     path,
+		ident:    ident,
+		maxSize:  maxSize,
+		backups:  backups,
+		watchers: make(map[chan LogEntry]struct{}),
+	}
+}
+
+// writeLine appends a line from stream to the log and passes it to
+// followers
+func (l *programLog) writeLine(stream string, pid int, message string) {
+	message = strings.TrimRight(message, "\r")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !now.After(l.last) {
+		now = l.last.Add(time.Nanosecond)
+	}
+	l.last = now
+
+	line := fmt.Sprintf("%s %s %d %s\n", now.UTC().Format(time.RFC3339Nano), stream, pid, message)
+	if err := l.ensureOpen(int64(len(line))); err == nil {
+		n, _ := l.file.WriteString(line)
+		l.size += int64(n)
+	}
+
+	entry := l.entry(now, stream, pid, message)
+	for ch := range l.watchers {
+		// Followers that fall behind miss lines rather than block the program
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+// ensureOpen opens the log file, rotating it first if writing n more bytes
+// would exceed the size limit. Callers hold l.mu.
+func (l *programLog) ensureOpen(n int64) error {
+	if l.file != nil && l.size+n <= l.maxSize {
+		return nil
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+		l.rotate()
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	if l.size > 0 && l.size+n > l.maxSize {
+		// Left over from a previous run and already full
+		file.Close()
+		l.file = nil
+		l.rotate()
+		return l.ensureOpen(n)
+	}
+	return nil
+}
+
+// rotate shifts path to path.1, path.1 to path.2 and so on, dropping the
+// oldest backup. Callers hold l.mu.
+func (l *programLog) rotate() {
+	if l.backups <= 0 {
+		os.Remove(l.path)
+		return
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.backups))
+	for i := l.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	os.Rename(l.path, l.path+".1")
+}
+
+func (l *programLog) entry(at time.Time, stream string, pid int, message string) LogEntry {
+	priority := streamPriorities[stream]
+	return LogEntry{
+		Timestamp:  at,
+		Priority:   priority,
+		Level:      priorityName(priority),
+		PID:        pid,
+		Identifier: l.ident,
+		Message:    message,
+		Cursor:     strconv.FormatInt(at.UnixNano(), 10),
+	}
+}
+
+// parse converts a line of the log file into an entry
+func (l *programLog) parse(line string, _ int64) (LogEntry, bool) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return LogEntry{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return LogEntry{}, false
+	}
+	pid, _ := strconv.Atoi(fields[2])
+	message := ""
+	if len(fields) == 4 {
+		message = fields[3]
+	}
+	return l.entry(at, fields[1], pid, message), true
+}
+
+// read returns the entries of the log and its backups selected by query
+func (l *programLog) read(query LogQuery) ([]LogEntry, error) {
+	return readLogFiles(rotatedPaths(l.path, l.backups), query, l.parse)
+}
+
+// subscribe returns a channel receiving every line written from now on and
+// a function that stops delivery
+func (l *programLog) subscribe() (<-chan LogEntry, func()) {
+	ch := make(chan LogEntry, 256)
+	l.mu.Lock()
+	l.watchers[ch] = struct{}{}
+	l.mu.Unlock()
+	return ch, func() {
+		l.mu.Lock()
+		delete(l.watchers, ch)
+		l.mu.Unlock()
+	}
+}
+
+func (l *programLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// lineWriter splits the output of a process into lines for its log
+type lineWriter struct {
+	log    *programLog
+	stream string
+	pid    atomic.Int64
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// maxLineLength is the length at which partial output is written as a line
+const maxLineLength = 64 * 1024
+
+func (l *programLog) lineWriter(stream string) *lineWriter {
+	return &lineWriter{log: l, stream: stream}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.writeLine(w.stream, int(w.pid.Load()), string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLineLength {
+		w.log.writeLine(w.stream, int(w.pid.Load()), string(w.buf))
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Close writes any output left without a trailing newline
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.log.writeLine(w.stream, int(w.pid.Load()), string(w.buf))
+		w.buf = nil
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+//go:build !windows
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// newTestSupervisor supervises program as app, with its logs in a temporary
+// directory, and stops it when the test ends
+func newTestSupervisor(t *testing.T, program utils.Program) *SupervisorService {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	s, err := NewSupervisorService(utils.SupervisorConfig{
+		LogDirectory: t.TempDir(),
+		Programs:     map[string]utils.Program{"app": program},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// waitForStatus waits until ready accepts the status of app and returns it
+func waitForStatus(t *testing.T, s *SupervisorService, ready func(*ServiceStatus) bool) *ServiceStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := s.Status(context.Background(), "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %s/%s, restarts %d, result %s", status.Status, status.SubState, status.NRestarts, status.Result)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForLog waits until app has logged a line from stream containing text
+func waitForLog(t *testing.T, s *SupervisorService, stream, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasLog(t, s, stream, text) {
+		if time.Now().After(deadline) {
+			t.Fatalf("app never logged %q", text)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// hasLog reports whether app has logged a line from stream containing text.
+// The stream matters: the supervisor's own lines repeat the command.
+func hasLog(t *testing.T, s *SupervisorService, stream, text string) bool {
+	t.Helper()
+	entries, err := s.Logs(context.Background(), "app", LogQuery{Lines: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Priority == streamPriorities[stream] && strings.Contains(entry.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSupervisorRestartOnExit(t *testing.T) {
+	tests := []struct {
+		name       string
+		restart    string
+		exitCode   int
+		restarts   bool
+		wantState  string
+		wantResult string
+	}{
+		{"on-failure after a failure", RestartOnFailure, 3, true, "", ""},
+		{"on-failure after success", RestartOnFailure, 0, false, "inactive", "success"},
+		{"always after success", RestartAlways, 0, true, "", ""},
+		{"no after a failure", RestartNo, 3, false, "failed", "exit-code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSupervisor(t, utils.Program{
+				Command:      []string{"sh", "-c", fmt.Sprintf("echo run; exit %d", tt.exitCode)},
+				Restart:      tt.restart,
+				RestartDelay: "10ms",
+			})
+			if _, err := s.Start(context.Background(), "app"); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.restarts {
+				waitForStatus(t, s, func(status *ServiceStatus) bool { return status.NRestarts >= 2 })
+				if _, err := s.Stop(context.Background(), "app"); err != nil {
+					t.Fatal(err)
+				}
+				status := waitForStatus(t, s, func(status *ServiceStatus) bool { return status.Status == "inactive" })
+				// Stopping leaves no restart pending
+				time.Sleep(100 * time.Millisecond)
+				if after, _ := s.Status(context.Background(), "app"); after.NRestarts > status.NRestarts+1 || after.Status != "inactive" {
+					t.Errorf("still restarting after Stop(): %s, %d restarts", after.Status, after.NRestarts)
+				}
+				return
+			}
+
+			status := waitForStatus(t, s, func(status *ServiceStatus) bool { return status.Status == tt.wantState })
+			if status.Result != tt.wantResult || status.ExecMainStatus != tt.exitCode || status.ExecMainCode != cldExited {
+				t.Errorf("result = %s, exit %d/%d", status.Result, status.ExecMainCode, status.ExecMainStatus)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if status, _ := s.Status(context.Background(), "app"); status.NRestarts != 0 {
+				t.Errorf("restarted %d times", status.NRestarts)
+			}
+		})
+	}
+}
+
+func TestSupervisorStopSignal(t *testing.T) {
+	s := newTestSupervisor(t, utils.Program{
+		Command:     []string{"sh", "-c", `trap 'echo got TERM; exit 0' TERM; echo ready; while :; do sleep 0.05; done`},
+		StopTimeout: "5s",
+	})
+	if _, err := s.Start(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	waitForLog(t, s, "stdout", "ready")
+
+	start := time.Now()
+	if _, err := s.Stop(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Stop() took %s", elapsed)
+	}
+	status, _ := s.Status(context.Background(), "app")
+	if status.Status != "inactive" || status.Result != "success" || status.MainPID != 0 {
+		t.Errorf("status = %s, result %s, pid %d", status.Status, status.Result, status.MainPID)
+	}
+	if !hasLog(t, s, "stdout", "got TERM") {
+		t.Error("program did not receive the stop signal")
+	}
+	if hasLog(t, s, "supervisor", "killing") {
+		t.Error("program was killed")
+	}
+
+	if _, err := s.Stop(context.Background(), "app"); !errors.Is(err, ErrAlreadyInState) {
+		t.Errorf("second Stop() error = %v, want %v", err, ErrAlreadyInState)
+	}
+}
+
+func TestSupervisorStopKillsAfterTimeout(t *testing.T) {
+	s := newTestSupervisor(t, utils.Program{
+		Command:     []string{"sh", "-c", `trap '' TERM; echo ready; while :; do sleep 0.05; done`},
+		StopTimeout: "200ms",
+	})
+	if _, err := s.Start(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	waitForLog(t, s, "stdout", "ready")
+
+	start := time.Now()
+	if _, err := s.Stop(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Stop() killed after %s, before the stop timeout", elapsed)
+	}
+	status, _ := s.Status(context.Background(), "app")
+	if status.Status != "inactive" || status.ExecMainCode != cldKilled || status.ExecMainStatus != 9 {
+		t.Errorf("status = %s, exit %d/%d, want inactive killed by 9", status.Status, status.ExecMainCode, status.ExecMainStatus)
+	}
+	if !hasLog(t, s, "supervisor", "Did not stop within 200ms, killing") {
+		t.Error("kill was not logged")
+	}
+}
+
+func TestProgramLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	log := newProgramLog(path, "app", 400, 2)
+	defer log.close()
+
+	for i := 0; i < 40; i++ {
+		log.writeLine("stdout", 42, fmt.Sprintf("line %02d", i))
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 400 {
+			t.Errorf("%s is %d bytes, over the 400 byte limit", filepath.Base(p), info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 kept beyond the backup limit", filepath.Base(path))
+	}
+
+	entries, err := log.read(LogQuery{Lines: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || len(entries) == 40 {
+		t.Fatalf("read %d entries, want the newest lines only", len(entries))
+	}
+	first := 40 - len(entries)
+	for i, entry := range entries {
+		if want := fmt.Sprintf("line %02d", first+i); entry.Message != want || entry.PID != 42 || entry.Level != "info" {
+			t.Fatalf("entry %d = %+v, want %q", i, entry, want)
+		}
+		if i > 0 && entry.Cursor <= entries[i-1].Cursor {
+			t.Fatalf("cursors not increasing: %s after %s", entry.Cursor, entries[i-1].Cursor)
+		}
+	}
+
+	// A log reopened when full is rotated before it is written to
+	log.close()
+	reopened := newProgramLog(path, "app", 400, 2)
+	defer reopened.close()
+	before, _ := os.ReadFile(path)
+	reopened.writeLine("supervisor", 0, strings.Repeat("x", 400-len(before)))
+	if rotated, _ := os.ReadFile(path + ".1"); string(rotated) != string(before) {
+		t.Error("full log was not rotated on reopening")
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	log := newProgramLog(filepath.Join(t.TempDir(), "app.log"), "app", 1<<20, 1)
+	defer log.close()
+	w := log.lineWriter("stderr")
+	w.pid.Store(7)
+
+	fmt.Fprint(w, "first\nsec")
+	fmt.Fprint(w, "ond\r\nunterminated")
+	w.Write([]byte(strings.Repeat("y", maxLineLength)))
+	w.Close()
+
+	entries, err := log.read(LogQuery{Lines: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+		if entry.PID != 7 || entry.Level != "err" {
+			t.Errorf("entry = %+v", entry)
+		}
+	}
+	want := []string{"first", "second", "unterminated" + strings.Repeat("y", maxLineLength)}
+	if len(messages) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(messages), len(want))
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("line %d = %.40q, want %.40q", i, messages[i], want[i])
+		}
+	}
+}
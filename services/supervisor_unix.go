@@ -0,0 +1,73 @@
+//go:build !windows
+
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// signals maps the names in stopSignalNames to signals
+var signals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// configureCommand runs cmd in its own process group, so signals reach its
+// children too, and as username when set
+func configureCommand(cmd *exec.Cmd, username string) error {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return fmt.Errorf("unknown user %q", username)
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("user %q has a non-numeric uid", username)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("user %q has a non-numeric gid", username)
+		}
+		attr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+		cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	}
+	cmd.SysProcAttr = attr
+	return nil
+}
+
+// signalProcess sends the named signal to the process group of cmd
+func signalProcess(cmd *exec.Cmd, name string) error {
+	sig, ok := signals[name]
+	if !ok {
+		return ErrUnsupported
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, sig); err != nil {
+		return cmd.Process.Signal(sig)
+	}
+	return nil
+}
+
+// killProcess kills the process group of cmd
+func killProcess(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// exitSignal returns the signal that terminated a process, if any
+func exitSignal(state *os.ProcessState) (int, bool) {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return int(ws.Signal()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// configureCommand prepares cmd to run. Running programs as another user is
+// not supported on Windows.
+func configureCommand(cmd *exec.Cmd, username string) error {
+	if username != "" {
+		return errors.New("user is not supported on windows")
+	}
+	return nil
+}
+
+// signalProcess cannot deliver signals on Windows; callers fall back to
+// killProcess for stop signals
+func signalProcess(cmd *exec.Cmd, name string) error {
+	return ErrUnsupported
+}
+
+// killProcess terminates cmd
+func killProcess(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+// exitSignal reports no signal, as Windows processes only have exit codes
+func exitSignal(state *os.ProcessState) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,646 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/therealtoxicdev/chronoserve/metrics"
+)
+
+const (
+	systemdBusName      = "org.freedesktop.systemd1"
+	systemdObjectPath   = dbus.ObjectPath("/org/freedesktop/systemd1")
+	systemdUnitPrefix   = dbus.ObjectPath("/org/freedesktop/systemd1/unit")
+	systemdManagerIface = "org.freedesktop.systemd1.Manager"
+	systemdUnitIface    = "org.freedesktop.systemd1.Unit"
+	systemdServiceIface = "org.freedesktop.systemd1.Service"
+	dbusPropertiesIface = "org.freedesktop.DBus.Properties"
+)
+
+// StateChange describes a change of a service's active state pushed by a
+// backend
+type StateChange struct {
+	Name        string    `json:"name"`
+	ActiveState string    `json:"activeState"`
+	SubState    string    `json:"subState,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// StateSubscriber is implemented by backends that can push service state
+// changes instead of having to be polled
+type StateSubscriber interface {
+	SubscribeStateChanges(ctx context.Context) (<-chan StateChange, error)
+}
+
+// Ensure DbusSystemdService implements ServiceManager, StateSubscriber and
+// StatusRefresher
+var (
+	_ ServiceManager  = (*DbusSystemdService)(nil)
+	_ StateSubscriber = (*DbusSystemdService)(nil)
+	_ StatusRefresher = (*DbusSystemdService)(nil)
+)
+
+// DbusSystemdService implements the ServiceManager interface for Linux by
+// talking to systemd over D-Bus instead of shelling out to systemctl. Unit
+// actions wait for their systemd job to complete, and the status cache is
+// invalidated from PropertiesChanged signals.
+type DbusSystemdService struct {
+	BaseServiceHandler
+	conn       *dbus.Conn
+	manager    dbus.BusObject
+	exec       Executor // journalctl, which has no D-Bus equivalent
+	cache      map[string]ServiceStatus
+	cacheMutex sync.RWMutex
+	cacheTTL   time.Duration
+
+	// jobs maps pending systemd job paths to the channel awaiting their result
+	jobsMutex sync.Mutex
+	jobs      map[dbus.ObjectPath]chan string
+
+	subsMutex   sync.Mutex
+	subscribers map[chan StateChange]struct{}
+}
+
+// NewDbusSystemdService connects to systemd on the system bus, or on the bus
+// at address when it is not empty, and subscribes to job and unit signals
+func NewDbusSystemdService(executor Executor, address string) (*DbusSystemdService, error) {
+	var (
+		conn *dbus.Conn
+		err  error
+	)
+	if address != "" {
+		conn, err = dbus.Connect(address)
+	} else {
+		conn, err = dbus.ConnectSystemBus()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to D-Bus: %w", err)
+	}
+
+	s := &DbusSystemdService{
+		conn:        conn,
+		manager:     conn.Object(systemdBusName, systemdObjectPath),
+		exec:        executor,
+		cache:       make(map[string]ServiceStatus),
+		cacheTTL:    5 * time.Minute,
+		jobs:        make(map[dbus.ObjectPath]chan string),
+		subscribers: make(map[chan StateChange]struct{}),
+	}
+
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchInterface(systemdManagerIface),
+		dbus.WithMatchMember("JobRemoved"),
+	); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to subscribe to systemd jobs: %w", err)
+	}
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchInterface(dbusPropertiesIface),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchPathNamespace(systemdUnitPrefix),
+	); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to subscribe to unit changes: %w", err)
+	}
+	// systemd only emits signals to clients that called Subscribe
+	if err := s.manager.Call(systemdManagerIface+".Subscribe", 0).Err; err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to subscribe to systemd: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 64)
+	conn.Signal(signals)
+	go s.dispatchSignals(signals)
+
+	return s, nil
+}
+
+// Close closes the D-Bus connection and every state subscription
+func (s *DbusSystemdService) Close() error {
+	return s.conn.Close()
+}
+
+// List lists all loaded systemd services
+func (s *DbusSystemdService) List(ctx context.Context) ([]ServiceInfo, error) {
+	ctx, cancel := s.withTimeout(ctx, "list")
+	defer cancel()
+
+	// (name, description, load, active, sub, following, path, job id, job type, job path)
+	var units []struct {
+		Name        string
+		Description string
+		LoadState   string
+		ActiveState string
+		SubState    string
+		Following   string
+		Path        dbus.ObjectPath
+		JobID       uint32
+		JobType     string
+		JobPath     dbus.ObjectPath
+	}
+	if err := s.manager.CallWithContext(ctx, systemdManagerIface+".ListUnits", 0).Store(&units); err != nil {
+		return nil, s.serviceError(ctx, "list", "", err)
+	}
+
+	services := make([]ServiceInfo, 0, len(units))
+	for _, unit := range units {
+		if !strings.HasSuffix(unit.Name, ".service") {
+			continue
+		}
+		services = append(services, ServiceInfo{
+			Name:        unit.Name,
+			Description: unit.Description,
+			LoadState:   unit.LoadState,
+			ActiveState: unit.ActiveState,
+			SubState:    unit.SubState,
+		})
+	}
+	return services, nil
+}
+
+// Status gets the current status of a systemd service from its unit and
+// service properties
+func (s *DbusSystemdService) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("status", name, ErrInvalidName, "")
+	}
+
+	s.cacheMutex.RLock()
+	if status, ok := s.cache[name]; ok {
+		if time.Since(status.UpdatedAt) < s.cacheTTL {
+			s.cacheMutex.RUnlock()
+			metrics.StatusCacheLookups.Inc("hit")
+			return &status, nil
+		}
+	}
+	s.cacheMutex.RUnlock()
+	metrics.StatusCacheLookups.Inc("miss")
+
+	return s.Refresh(ctx, name)
+}
+
+// Refresh reads the current status of a unit over D-Bus, bypassing and
+// then updating the cache
+func (s *DbusSystemdService) Refresh(ctx context.Context, name string) (*ServiceStatus, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("status", name, ErrInvalidName, "")
+	}
+
+	ctx, cancel := s.withTimeout(ctx, "status")
+	defer cancel()
+
+	// LoadUnit, unlike GetUnit, also returns units that are not loaded
+	var path dbus.ObjectPath
+	if err := s.manager.CallWithContext(ctx, systemdManagerIface+".LoadUnit", 0, name).Store(&path); err != nil {
+		return nil, s.serviceError(ctx, "status", name, err)
+	}
+
+	unit := s.conn.Object(systemdBusName, path)
+	props := make(map[string]dbus.Variant)
+	if err := unit.CallWithContext(ctx, dbusPropertiesIface+".GetAll", 0, systemdUnitIface).Store(&props); err != nil {
+		return nil, s.serviceError(ctx, "status", name, err)
+	}
+	// Only .service units have the Service interface
+	serviceProps := make(map[string]dbus.Variant)
+	if err := unit.CallWithContext(ctx, dbusPropertiesIface+".GetAll", 0, systemdServiceIface).Store(&serviceProps); err == nil {
+		for key, value := range serviceProps {
+			props[key] = value
+		}
+	}
+
+	status := statusFromProperties(props)
+	if status.LoadState == "not-found" {
+		return nil, newServiceError("status", name, ErrNotFound, "")
+	}
+	status.Name = name
+	status.UpdatedAt = time.Now()
+
+	s.cacheMutex.Lock()
+	s.cache[name] = status
+	s.cacheMutex.Unlock()
+
+	return &status, nil
+}
+
+// Logs retrieves systemd service logs matching query. The journal is not
+// exposed over D-Bus, so this still uses journalctl.
+func (s *DbusSystemdService) Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	return readJournal(ctx, s.exec, name, query)
+}
+
+// FollowLogs streams systemd service logs as they are written
+func (s *DbusSystemdService) FollowLogs(ctx context.Context, name string, query LogQuery) (<-chan LogEntry, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	return followJournal(ctx, s.exec, name, query)
+}
+
+// Start starts a systemd service and waits for its job to complete
+func (s *DbusSystemdService) Start(ctx context.Context, name string) (*ActionResult, error) {
+	status, err := s.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status == "active" {
+		return nil, newServiceError("start", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already running", name))
+	}
+	return s.runJob(ctx, "start", name, "StartUnit", "started")
+}
+
+// Stop stops a systemd service and waits for its job to complete
+func (s *DbusSystemdService) Stop(ctx context.Context, name string) (*ActionResult, error) {
+	status, err := s.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status == "inactive" {
+		return nil, newServiceError("stop", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already stopped", name))
+	}
+	return s.runJob(ctx, "stop", name, "StopUnit", "stopped")
+}
+
+// Restart restarts a systemd service, starting it if it is not running
+func (s *DbusSystemdService) Restart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runJob(ctx, "restart", name, "RestartUnit", "restarted")
+}
+
+// Reload asks a systemd service to reload its configuration
+func (s *DbusSystemdService) Reload(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runJob(ctx, "reload", name, "ReloadUnit", "reloaded")
+}
+
+// ReloadOrRestart reloads a systemd service if it supports reloading and
+// restarts it otherwise
+func (s *DbusSystemdService) ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runJob(ctx, "reload-or-restart", name, "ReloadOrRestartUnit", "reloaded or restarted")
+}
+
+// Enable enables a systemd unit so it starts at boot, starting it as well
+// when now is set
+func (s *DbusSystemdService) Enable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	// EnableUnitFiles(files, runtime, force) -> (carries_install_info, changes)
+	return s.runUnitFileAction(ctx, "enable", name, "enabled", now, "StartUnit", func(ctx context.Context) error {
+		var carriesInstallInfo bool
+		var changes []struct{ Type, Filename, Destination string }
+		return s.manager.CallWithContext(ctx, systemdManagerIface+".EnableUnitFiles", 0, []string{name}, false, false).Store(&carriesInstallInfo, &changes)
+	})
+}
+
+// Disable disables a systemd unit so it no longer starts at boot, stopping
+// it as well when now is set
+func (s *DbusSystemdService) Disable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	// DisableUnitFiles(files, runtime) -> changes
+	return s.runUnitFileAction(ctx, "disable", name, "disabled", now, "StopUnit", func(ctx context.Context) error {
+		return s.manager.CallWithContext(ctx, systemdManagerIface+".DisableUnitFiles", 0, []string{name}, false).Err
+	})
+}
+
+// Mask masks a systemd unit so it cannot be started at all, stopping it as
+// well when now is set
+func (s *DbusSystemdService) Mask(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	// MaskUnitFiles(files, runtime, force) -> changes
+	return s.runUnitFileAction(ctx, "mask", name, "masked", now, "StopUnit", func(ctx context.Context) error {
+		return s.manager.CallWithContext(ctx, systemdManagerIface+".MaskUnitFiles", 0, []string{name}, false, false).Err
+	})
+}
+
+// Unmask removes a mask from a systemd unit
+func (s *DbusSystemdService) Unmask(ctx context.Context, name string) (*ActionResult, error) {
+	// UnmaskUnitFiles(files, runtime) -> changes
+	return s.runUnitFileAction(ctx, "unmask", name, "unmasked", false, "", func(ctx context.Context) error {
+		return s.manager.CallWithContext(ctx, systemdManagerIface+".UnmaskUnitFiles", 0, []string{name}, false).Err
+	})
+}
+
+// SubscribeStateChanges streams active state changes of every systemd unit
+// until ctx is done. Slow subscribers miss changes rather than stalling the
+// D-Bus connection.
+func (s *DbusSystemdService) SubscribeStateChanges(ctx context.Context) (<-chan StateChange, error) {
+	ch := make(chan StateChange, 64)
+
+	s.subsMutex.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.subsMutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.subsMutex.Lock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+		s.subsMutex.Unlock()
+	}()
+
+	return ch, nil
+}
+
+// runJob calls a Manager method that enqueues a job for name, such as
+// StartUnit, and waits for systemd to report the job's result
+func (s *DbusSystemdService) runJob(ctx context.Context, op, name, method, pastTense string) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(op, name, ErrInvalidName, "")
+	}
+
+	ctx, cancel := s.withTimeout(ctx, op)
+	defer cancel()
+
+	if err := s.startJobAndWait(ctx, op, name, method); err != nil {
+		return nil, err
+	}
+
+	s.invalidateCache(name)
+
+	return &ActionResult{
+		Name:    name,
+		Action:  op,
+		Message: fmt.Sprintf("Service %s %s successfully", name, pastTense),
+	}, nil
+}
+
+// startJobAndWait enqueues a job with method and blocks until it is removed.
+// jobsMutex is held across the call so a JobRemoved signal for a job that
+// finishes immediately cannot be dispatched before the job is registered.
+func (s *DbusSystemdService) startJobAndWait(ctx context.Context, op, name, method string) error {
+	done := make(chan string, 1)
+
+	s.jobsMutex.Lock()
+	var job dbus.ObjectPath
+	err := s.manager.CallWithContext(ctx, systemdManagerIface+"."+method, 0, name, "replace").Store(&job)
+	if err == nil {
+		s.jobs[job] = done
+	}
+	s.jobsMutex.Unlock()
+	if err != nil {
+		return s.serviceError(ctx, op, name, err)
+	}
+
+	select {
+	case result := <-done:
+		if result != "done" {
+			return newServiceError(op, name, jobResultError(result), fmt.Sprintf("systemd job finished with result %q", result))
+		}
+		return nil
+	case <-ctx.Done():
+		s.jobsMutex.Lock()
+		delete(s.jobs, job)
+		s.jobsMutex.Unlock()
+		return newServiceError(op, name, classifyError(ctx, ctx.Err(), ""), "")
+	}
+}
+
+// runUnitFileAction changes the unit-file state of name with change, reloads
+// the systemd manager so the change takes effect, and then runs nowMethod
+// against the unit when now is set
+func (s *DbusSystemdService) runUnitFileAction(ctx context.Context, op, name, pastTense string, now bool, nowMethod string, change func(context.Context) error) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(op, name, ErrInvalidName, "")
+	}
+
+	ctx, cancel := s.withTimeout(ctx, op)
+	defer cancel()
+
+	if err := change(ctx); err != nil {
+		return nil, s.serviceError(ctx, op, name, err)
+	}
+	if err := s.manager.CallWithContext(ctx, systemdManagerIface+".Reload", 0).Err; err != nil {
+		return nil, s.serviceError(ctx, op, name, err)
+	}
+	if now && nowMethod != "" {
+		if err := s.startJobAndWait(ctx, op, name, nowMethod); err != nil {
+			return nil, err
+		}
+	}
+
+	s.invalidateCache(name)
+
+	message := fmt.Sprintf("Service %s %s successfully", name, pastTense)
+	if now {
+		message += " (applied now)"
+	}
+	return &ActionResult{Name: name, Action: op, Message: message}, nil
+}
+
+// dispatchSignals routes systemd signals to waiting jobs, the status cache
+// and state subscribers. It returns when the connection is closed.
+func (s *DbusSystemdService) dispatchSignals(signals <-chan *dbus.Signal) {
+	for signal := range signals {
+		switch signal.Name {
+		case systemdManagerIface + ".JobRemoved":
+			// (id, job path, unit, result)
+			if len(signal.Body) < 4 {
+				continue
+			}
+			job, _ := signal.Body[1].(dbus.ObjectPath)
+			unit, _ := signal.Body[2].(string)
+			result, _ := signal.Body[3].(string)
+
+			s.invalidateCache(unit)
+
+			s.jobsMutex.Lock()
+			if done, ok := s.jobs[job]; ok {
+				done <- result
+				delete(s.jobs, job)
+			}
+			s.jobsMutex.Unlock()
+
+		case dbusPropertiesIface + ".PropertiesChanged":
+			// (interface, changed properties, invalidated properties)
+			if len(signal.Body) < 2 {
+				continue
+			}
+			iface, _ := signal.Body[0].(string)
+			changed, _ := signal.Body[1].(map[string]dbus.Variant)
+			if iface != systemdUnitIface {
+				continue
+			}
+
+			name := unitNameFromPath(signal.Path)
+			s.invalidateCache(name)
+
+			active, ok := changed["ActiveState"].Value().(string)
+			if !ok {
+				continue
+			}
+			sub, _ := changed["SubState"].Value().(string)
+			s.publish(StateChange{Name: name, ActiveState: active, SubState: sub, At: time.Now()})
+		}
+	}
+
+	// The connection is closed; release all subscribers
+	s.subsMutex.Lock()
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+	s.subsMutex.Unlock()
+}
+
+// publish sends change to every subscriber without blocking
+func (s *DbusSystemdService) publish(change StateChange) {
+	s.subsMutex.Lock()
+	defer s.subsMutex.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// invalidateCache drops any cached status for the named service
+func (s *DbusSystemdService) invalidateCache(name string) {
+	s.cacheMutex.Lock()
+	delete(s.cache, name)
+	s.cacheMutex.Unlock()
+}
+
+// withTimeout bounds ctx by the timeout the executor applies to op
+func (s *DbusSystemdService) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := defaultExecTimeout
+	if t, ok := s.exec.(interface{ Timeout(string) time.Duration }); ok {
+		timeout = t.Timeout(op)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// serviceError converts a D-Bus call failure into a ServiceError
+func (s *DbusSystemdService) serviceError(ctx context.Context, op, name string, err error) error {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return newServiceError(op, name, classifyError(ctx, err, ""), "")
+	}
+
+	detail := ""
+	if len(dbusErr.Body) > 0 {
+		detail, _ = dbusErr.Body[0].(string)
+	}
+	switch dbusErr.Name {
+	case "org.freedesktop.systemd1.NoSuchUnit", "org.freedesktop.systemd1.LoadFailed":
+		return newServiceError(op, name, ErrNotFound, detail)
+	case "org.freedesktop.DBus.Error.AccessDenied",
+		"org.freedesktop.DBus.Error.InteractiveAuthorizationRequired":
+		return newServiceError(op, name, ErrPermissionDenied, detail)
+	case "org.freedesktop.DBus.Error.NoReply", "org.freedesktop.DBus.Error.Timeout":
+		return newServiceError(op, name, ErrTimeout, detail)
+	}
+	return newServiceError(op, name, classifyError(ctx, err, detail), detail)
+}
+
+// jobResultError maps a failed systemd job result onto a sentinel error
+func jobResultError(result string) error {
+	switch result {
+	case "timeout":
+		return ErrTimeout
+	default:
+		return fmt.Errorf("job %s", result)
+	}
+}
+
+// statusFromProperties builds a ServiceStatus from the properties of the
+// Unit and Service interfaces
+func statusFromProperties(props map[string]dbus.Variant) ServiceStatus {
+	str := func(key string) string {
+		v, _ := props[key].Value().(string)
+		return v
+	}
+	strs := func(key string) []string {
+		v, _ := props[key].Value().([]string)
+		return v
+	}
+	timestamp := func(key string) *time.Time {
+		usec, ok := props[key].Value().(uint64)
+		if !ok || usec == 0 {
+			return nil
+		}
+		t := time.UnixMicro(int64(usec))
+		return &t
+	}
+	accounting := func(key string) *uint64 {
+		v, ok := props[key].Value().(uint64)
+		if !ok || v == math.MaxUint64 {
+			return nil
+		}
+		return &v
+	}
+
+	status := ServiceStatus{
+		Name:          str("Id"),
+		Description:   str("Description"),
+		LoadState:     str("LoadState"),
+		Status:        str("ActiveState"),
+		SubState:      str("SubState"),
+		UnitFileState: str("UnitFileState"),
+		FragmentPath:  str("FragmentPath"),
+		Result:        str("Result"),
+
+		StateChangeAt:   timestamp("StateChangeTimestamp"),
+		ActiveEnterAt:   timestamp("ActiveEnterTimestamp"),
+		ActiveExitAt:    timestamp("ActiveExitTimestamp"),
+		InactiveEnterAt: timestamp("InactiveEnterTimestamp"),
+		InactiveExitAt:  timestamp("InactiveExitTimestamp"),
+
+		MemoryCurrent: accounting("MemoryCurrent"),
+		MemoryPeak:    accounting("MemoryPeak"),
+		CPUUsageNSec:  accounting("CPUUsageNSec"),
+
+		Dependencies: ServiceDependencies{
+			Requires:   strs("Requires"),
+			Wants:      strs("Wants"),
+			BindsTo:    strs("BindsTo"),
+			Conflicts:  strs("Conflicts"),
+			After:      strs("After"),
+			Before:     strs("Before"),
+			RequiredBy: strs("RequiredBy"),
+			WantedBy:   strs("WantedBy"),
+		},
+		UpdatedAt: time.Now(),
+	}
+	status.IsActive = status.Status == "active"
+	status.Enabled = status.UnitFileState == "enabled" || status.UnitFileState == "enabled-runtime"
+
+	if v, ok := props["MainPID"].Value().(uint32); ok {
+		status.MainPID = int(v)
+	}
+	if v, ok := props["NRestarts"].Value().(uint32); ok {
+		status.NRestarts = int(v)
+	}
+	if v, ok := props["ExecMainStatus"].Value().(int32); ok {
+		status.ExecMainStatus = int(v)
+	}
+	if v, ok := props["ExecMainCode"].Value().(int32); ok {
+		status.ExecMainCode = int(v)
+	}
+
+	return status
+}
+
+// unitNameFromPath reverses systemd's object path escaping, turning
+// /org/freedesktop/systemd1/unit/nginx_2eservice into nginx.service
+func unitNameFromPath(path dbus.ObjectPath) string {
+	escaped := strings.TrimPrefix(string(path), string(systemdUnitPrefix)+"/")
+
+	var name strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '_' && i+2 < len(escaped) {
+			if b, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8); err == nil {
+				name.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		name.WriteByte(escaped[i])
+	}
+	return name.String()
+}
@@ -0,0 +1,551 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// busConfig configures a private message bus that lets any client own any
+// name and talk to anyone
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus runs a private dbus-daemon for the test and returns its address.
+// The test is skipped where dbus-daemon is not installed.
+func startBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(busConfig, dir)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--print-address=1", "--nofork")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading bus address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// stubUnit is a unit known to the stub systemd
+type stubUnit struct {
+	activeState string
+	subState    string
+	jobResult   string // result of jobs for the unit; empty never completes them
+	denied      bool   // jobs for the unit fail with AccessDenied
+}
+
+// unitFileChange is an entry of the changes returned by the *UnitFiles
+// methods: (type, file name, destination)
+type unitFileChange struct {
+	Type        string
+	Filename    string
+	Destination string
+}
+
+// unitListEntry is an entry of the ListUnits reply
+type unitListEntry struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Following   string
+	Path        dbus.ObjectPath
+	JobID       uint32
+	JobType     string
+	JobPath     dbus.ObjectPath
+}
+
+// stubSystemd implements the parts of the systemd1 Manager interface used
+// by DbusSystemdService, with jobs that complete as configured per unit
+type stubSystemd struct {
+	conn *dbus.Conn
+
+	mu         sync.Mutex
+	units      map[string]*stubUnit
+	nextJob    uint32
+	calls      []string // Manager methods called, with the unit name
+	getAlls    int      // Properties.GetAll calls on the Unit interface
+	subscribed bool
+}
+
+// startStubSystemd claims the systemd1 name on the bus at address and
+// exports the stub manager and a Properties object for each unit
+func startStubSystemd(t *testing.T, address string, units map[string]*stubUnit) *stubSystemd {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	stub := &stubSystemd{conn: conn, units: units}
+	if err := conn.Export(stub, systemdObjectPath, systemdManagerIface); err != nil {
+		t.Fatal(err)
+	}
+	for name := range units {
+		if err := conn.Export(&stubUnitProperties{stub: stub, name: name}, unitPath(name), dbusPropertiesIface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reply, err := conn.RequestName(systemdBusName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName() = %v, %v", reply, err)
+	}
+	return stub
+}
+
+// unitPath escapes name into its systemd object path
+func unitPath(name string) dbus.ObjectPath {
+	var escaped strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "_%02x", c)
+		}
+	}
+	return systemdUnitPrefix + "/" + dbus.ObjectPath(escaped.String())
+}
+
+func (s *stubSystemd) record(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *stubSystemd) Subscribe() *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribed = true
+	return nil
+}
+
+func (s *stubSystemd) Reload() *dbus.Error {
+	s.record("Reload")
+	return nil
+}
+
+func (s *stubSystemd) ListUnits() ([]unitListEntry, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []unitListEntry
+	for name, unit := range s.units {
+		list = append(list, unitListEntry{
+			Name: name, Description: "Stub " + name, LoadState: "loaded",
+			ActiveState: unit.activeState, SubState: unit.subState, Path: unitPath(name), JobPath: "/",
+		})
+	}
+	return list, nil
+}
+
+func (s *stubSystemd) LoadUnit(name string) (dbus.ObjectPath, *dbus.Error) {
+	return unitPath(name), nil
+}
+
+func (s *stubSystemd) StartUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	return s.job("StartUnit", name)
+}
+
+func (s *stubSystemd) StopUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	return s.job("StopUnit", name)
+}
+
+func (s *stubSystemd) RestartUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	return s.job("RestartUnit", name)
+}
+
+func (s *stubSystemd) EnableUnitFiles(files []string, runtime, force bool) (bool, []unitFileChange, *dbus.Error) {
+	s.record("EnableUnitFiles " + strings.Join(files, " "))
+	return true, []unitFileChange{{"symlink", "/etc/systemd/system/multi-user.target.wants/" + files[0], "/lib/systemd/system/" + files[0]}}, nil
+}
+
+func (s *stubSystemd) DisableUnitFiles(files []string, runtime bool) ([]unitFileChange, *dbus.Error) {
+	s.record("DisableUnitFiles " + strings.Join(files, " "))
+	return []unitFileChange{{"unlink", "/etc/systemd/system/multi-user.target.wants/" + files[0], ""}}, nil
+}
+
+// job enqueues a job for the unit. Its JobRemoved signal is emitted before
+// the reply, as systemd may do for jobs that finish at once.
+func (s *stubSystemd) job(method, name string) (dbus.ObjectPath, *dbus.Error) {
+	s.record(method + " " + name)
+
+	s.mu.Lock()
+	unit, ok := s.units[name]
+	s.nextJob++
+	id := s.nextJob
+	s.mu.Unlock()
+	if !ok {
+		return "", dbus.NewError("org.freedesktop.systemd1.NoSuchUnit", []interface{}{"Unit " + name + " not found."})
+	}
+	if unit.denied {
+		return "", dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []interface{}{"Access denied"})
+	}
+
+	job := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/systemd1/job/%d", id))
+	if unit.jobResult != "" {
+		s.conn.Emit(systemdObjectPath, systemdManagerIface+".JobRemoved", id, job, name, unit.jobResult)
+	}
+	return job, nil
+}
+
+// setState changes a unit's state and emits PropertiesChanged for it
+func (s *stubSystemd) setState(name, active, sub string) error {
+	s.mu.Lock()
+	s.units[name].activeState, s.units[name].subState = active, sub
+	s.mu.Unlock()
+	return s.conn.Emit(unitPath(name), dbusPropertiesIface+".PropertiesChanged", systemdUnitIface,
+		map[string]dbus.Variant{"ActiveState": dbus.MakeVariant(active), "SubState": dbus.MakeVariant(sub)}, []string{})
+}
+
+// stubUnitProperties serves the properties of a unit
+type stubUnitProperties struct {
+	stub *stubSystemd
+	name string
+}
+
+func (p *stubUnitProperties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	p.stub.mu.Lock()
+	defer p.stub.mu.Unlock()
+	unit := p.stub.units[p.name]
+	switch iface {
+	case systemdUnitIface:
+		p.stub.getAlls++
+		return map[string]dbus.Variant{
+			"Id":                   dbus.MakeVariant(p.name),
+			"Description":          dbus.MakeVariant("Stub " + p.name),
+			"LoadState":            dbus.MakeVariant("loaded"),
+			"ActiveState":          dbus.MakeVariant(unit.activeState),
+			"SubState":             dbus.MakeVariant(unit.subState),
+			"UnitFileState":        dbus.MakeVariant("enabled"),
+			"ActiveEnterTimestamp": dbus.MakeVariant(uint64(1709634030000000)),
+			"ActiveExitTimestamp":  dbus.MakeVariant(uint64(0)),
+			"Requires":             dbus.MakeVariant([]string{"sysinit.target"}),
+		}, nil
+	case systemdServiceIface:
+		return map[string]dbus.Variant{
+			"MainPID":       dbus.MakeVariant(uint32(4321)),
+			"NRestarts":     dbus.MakeVariant(uint32(3)),
+			"MemoryCurrent": dbus.MakeVariant(uint64(1 << 20)),
+			"MemoryPeak":    dbus.MakeVariant(uint64(math.MaxUint64)),
+		}, nil
+	}
+	return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", nil)
+}
+
+// newDbusTestService starts a private bus with a stub systemd serving units
+// and connects a DbusSystemdService to it
+func newDbusTestService(t *testing.T, units map[string]*stubUnit) (*DbusSystemdService, *stubSystemd) {
+	t.Helper()
+	address := startBus(t)
+	stub := startStubSystemd(t, address, units)
+	s, err := NewDbusSystemdService(NewFakeExecutor(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if !stub.subscribed {
+		t.Error("the service did not call Subscribe")
+	}
+	return s, stub
+}
+
+func TestDbusList(t *testing.T) {
+	s, _ := newDbusTestService(t, map[string]*stubUnit{
+		"nginx.service": {activeState: "active", subState: "running"},
+		"timers.target": {activeState: "active", subState: "active"},
+	})
+
+	list, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "nginx.service" || list[0].ActiveState != "active" || list[0].SubState != "running" {
+		t.Errorf("List() = %+v, want only nginx.service, active", list)
+	}
+}
+
+func TestDbusStatus(t *testing.T) {
+	s, stub := newDbusTestService(t, map[string]*stubUnit{
+		"nginx.service": {activeState: "active", subState: "running"},
+	})
+
+	status, err := s.Status(context.Background(), "nginx.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsActive || status.SubState != "running" || !status.Enabled {
+		t.Errorf("Status() = %+v", status)
+	}
+	if status.MainPID != 4321 || status.NRestarts != 3 {
+		t.Errorf("MainPID, NRestarts = %d, %d", status.MainPID, status.NRestarts)
+	}
+	if status.MemoryCurrent == nil || *status.MemoryCurrent != 1<<20 || status.MemoryPeak != nil {
+		t.Errorf("MemoryCurrent, MemoryPeak = %v, %v", status.MemoryCurrent, status.MemoryPeak)
+	}
+	if status.ActiveEnterAt == nil || status.ActiveEnterAt.Unix() != 1709634030 || status.ActiveExitAt != nil {
+		t.Errorf("ActiveEnterAt, ActiveExitAt = %v, %v", status.ActiveEnterAt, status.ActiveExitAt)
+	}
+
+	// A second lookup is served from the cache
+	if _, err := s.Status(context.Background(), "nginx.service"); err != nil {
+		t.Fatal(err)
+	}
+	stub.mu.Lock()
+	getAlls := stub.getAlls
+	stub.mu.Unlock()
+	if getAlls != 1 {
+		t.Errorf("GetAll called %d times, want 1", getAlls)
+	}
+}
+
+func TestDbusJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		unit    stubUnit
+		action  func(context.Context, *DbusSystemdService) (*ActionResult, error)
+		wantErr error
+	}{
+		{
+			name: "start done",
+			unit: stubUnit{activeState: "inactive", subState: "dead", jobResult: "done"},
+			action: func(ctx context.Context, s *DbusSystemdService) (*ActionResult, error) {
+				return s.Start(ctx, "app.service")
+			},
+		},
+		{
+			name: "start already running",
+			unit: stubUnit{activeState: "active", subState: "running", jobResult: "done"},
+			action: func(ctx context.Context, s *DbusSystemdService) (*ActionResult, error) {
+				return s.Start(ctx, "app.service")
+			},
+			wantErr: ErrAlreadyInState,
+		},
+		{
+			name: "stop already stopped",
+			unit: stubUnit{activeState: "inactive", subState: "dead", jobResult: "done"},
+			action: func(ctx context.Context, s *DbusSystemdService) (*ActionResult, error) {
+				return s.Stop(ctx, "app.service")
+			},
+			wantErr: ErrAlreadyInState,
+		},
+		{
+			name: "restart job timeout",
+			unit: stubUnit{activeState: "active", subState: "running", jobResult: "timeout"},
+			action: func(ctx context.Context, s *DbusSystemdService) (*ActionResult, error) {
+				return s.Restart(ctx, "app.service")
+			},
+			wantErr: ErrTimeout,
+		},
+		{
+			name: "access denied",
+			unit: stubUnit{activeState: "active", subState: "running", denied: true},
+			action: func(ctx context.Context, s *DbusSystemdService) (*ActionResult, error) {
+				return s.Restart(ctx, "app.service")
+			},
+			wantErr: ErrPermissionDenied,
+		},
+		{
+			name: "job never completes",
+			unit: stubUnit{activeState: "active", subState: "running"},
+			action: func(ctx context.Context, s *DbusSystemdService) (*ActionResult, error) {
+				ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+				defer cancel()
+				return s.Restart(ctx, "app.service")
+			},
+			wantErr: ErrTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unit := tt.unit
+			s, _ := newDbusTestService(t, map[string]*stubUnit{"app.service": &unit})
+			result, err := tt.action(context.Background(), s)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && result == nil {
+				t.Fatal("no result")
+			}
+			s.jobsMutex.Lock()
+			pending := len(s.jobs)
+			s.jobsMutex.Unlock()
+			if pending != 0 {
+				t.Errorf("%d jobs still registered", pending)
+			}
+		})
+	}
+}
+
+func TestDbusJobFailed(t *testing.T) {
+	s, _ := newDbusTestService(t, map[string]*stubUnit{
+		"app.service": {activeState: "inactive", subState: "dead", jobResult: "failed"},
+	})
+	_, err := s.Start(context.Background(), "app.service")
+	var svcErr *ServiceError
+	if !errors.As(err, &svcErr) || svcErr.Detail != `systemd job finished with result "failed"` {
+		t.Fatalf("Start() error = %v", err)
+	}
+}
+
+func TestDbusUnitFiles(t *testing.T) {
+	s, stub := newDbusTestService(t, map[string]*stubUnit{
+		"app.service": {activeState: "inactive", subState: "dead", jobResult: "done"},
+	})
+
+	if _, err := s.Enable(context.Background(), "app.service", true); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if _, err := s.Disable(context.Background(), "app.service", false); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+
+	stub.mu.Lock()
+	calls := strings.Join(stub.calls, ", ")
+	stub.mu.Unlock()
+	want := "EnableUnitFiles app.service, Reload, StartUnit app.service, DisableUnitFiles app.service, Reload"
+	if calls != want {
+		t.Errorf("calls = %s, want %s", calls, want)
+	}
+}
+
+func TestDbusPropertiesChanged(t *testing.T) {
+	s, stub := newDbusTestService(t, map[string]*stubUnit{
+		"app-web.service": {activeState: "active", subState: "running"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := s.SubscribeStateChanges(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cache the status so the change must invalidate it
+	if _, err := s.Status(context.Background(), "app-web.service"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.setState("app-web.service", "failed", "failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case change := <-changes:
+		if change.Name != "app-web.service" || change.ActiveState != "failed" || change.SubState != "failed" {
+			t.Errorf("change = %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no state change received")
+	}
+
+	status, err := s.Status(context.Background(), "app-web.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "failed" {
+		t.Errorf("Status() after change = %s, want failed", status.Status)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("subscription not closed after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not closed after cancel")
+	}
+}
+
+func TestStatusFromProperties(t *testing.T) {
+	status := statusFromProperties(map[string]dbus.Variant{
+		"Id":             dbus.MakeVariant("db.service"),
+		"ActiveState":    dbus.MakeVariant("failed"),
+		"SubState":       dbus.MakeVariant("failed"),
+		"UnitFileState":  dbus.MakeVariant("enabled-runtime"),
+		"Result":         dbus.MakeVariant("exit-code"),
+		"ExecMainStatus": dbus.MakeVariant(int32(2)),
+		"ExecMainCode":   dbus.MakeVariant(int32(1)),
+		"CPUUsageNSec":   dbus.MakeVariant(uint64(math.MaxUint64)),
+		"WantedBy":       dbus.MakeVariant([]string{"multi-user.target"}),
+		"MainPID":        dbus.MakeVariant("not a number"),
+	})
+	if status.Name != "db.service" || status.IsActive || !status.Enabled || status.Result != "exit-code" {
+		t.Errorf("status = %+v", status)
+	}
+	if status.ExecMainStatus != 2 || status.ExecMainCode != 1 || status.MainPID != 0 {
+		t.Errorf("ExecMainStatus, ExecMainCode, MainPID = %d, %d, %d", status.ExecMainStatus, status.ExecMainCode, status.MainPID)
+	}
+	if status.CPUUsageNSec != nil || status.StateChangeAt != nil {
+		t.Errorf("CPUUsageNSec, StateChangeAt = %v, %v, want nil", status.CPUUsageNSec, status.StateChangeAt)
+	}
+	if len(status.Dependencies.WantedBy) != 1 {
+		t.Errorf("WantedBy = %v", status.Dependencies.WantedBy)
+	}
+}
+
+func TestJobResultError(t *testing.T) {
+	if err := jobResultError("timeout"); err != ErrTimeout {
+		t.Errorf("jobResultError(timeout) = %v", err)
+	}
+	for _, result := range []string{"failed", "canceled", "dependency", "skipped"} {
+		err := jobResultError(result)
+		if err == nil || errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), result) {
+			t.Errorf("jobResultError(%s) = %v", result, err)
+		}
+	}
+}
+
+func TestUnitNameFromPath(t *testing.T) {
+	for _, name := range []string{"nginx.service", "app-web.service", "getty@tty1.service", "a_b.service"} {
+		if got := unitNameFromPath(unitPath(name)); got != name {
+			t.Errorf("unitNameFromPath(%s) = %s, want %s", unitPath(name), got, name)
+		}
+	}
+}
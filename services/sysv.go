@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ensure SysVService implements ServiceManager
+var _ ServiceManager = (*SysVService)(nil)
+
+// sysvSkipScripts are files in the init directory that are not services, or
+// that must never be run to query their status
+var sysvSkipScripts = map[string]bool{
+	"README": true, "skeleton": true, "functions": true, "rc": true, "rcS": true,
+	"rc.local": true, "halt": true, "reboot": true, "single": true,
+	"killprocs": true, "sendsigs": true, "umountfs": true, "umountroot": true,
+}
+
+// sysvStates maps the LSB exit codes of "<script> status" to an active
+// state and sub-state
+var sysvStates = map[int][2]string{
+	0: {"active", "running"},
+	1: {"failed", "dead"}, // dead, but the pid file exists
+	2: {"failed", "dead"}, // dead, but the lock file exists
+	3: {"inactive", "dead"},
+}
+
+// SysVService implements the ServiceManager interface for hosts booting with
+// SysV init, such as Devuan, by running the scripts in /etc/init.d
+type SysVService struct {
+	BaseServiceHandler
+	exec    Executor
+	initDir string
+	logDir  string
+}
+
+// NewSysVService creates a SysV init service manager that runs init scripts
+// through executor and reads service logs from logDir
+func NewSysVService(executor Executor, logDir string) *SysVService {
+	return &SysVService{exec: executor, initDir: defaultInitDir, logDir: logDir}
+}
+
+// script returns the path of the named service's init script, or an error
+// for op if there is none
+func (s *SysVService) script(op, name string) (string, error) {
+	if !s.ValidateServiceName(name) {
+		return "", newServiceError(op, name, ErrInvalidName, "")
+	}
+	path := filepath.Join(s.initDir, UnitBaseName(name))
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || sysvSkipScripts[UnitBaseName(name)] {
+		return "", newServiceError(op, name, ErrNotFound, fmt.Sprintf("Service %s not found", name))
+	}
+	return path, nil
+}
+
+// List lists the init scripts with their state. Every script is asked for
+// its status, like service --status-all does.
+func (s *SysVService) List(ctx context.Context) ([]ServiceInfo, error) {
+	entries, err := os.ReadDir(s.initDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", s.initDir, err)
+	}
+
+	services := make([]ServiceInfo, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 ||
+			strings.HasPrefix(name, ".") || sysvSkipScripts[name] || !s.ValidateServiceName(name) {
+			continue
+		}
+
+		active, sub := "unknown", "unknown"
+		if code, err := initStatus(ctx, s.exec, name, filepath.Join(s.initDir, name), "status"); err == nil {
+			if state, ok := sysvStates[code]; ok {
+				active, sub = state[0], state[1]
+			}
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+		services = append(services, ServiceInfo{
+			Name:        name,
+			LoadState:   "loaded",
+			ActiveState: active,
+			SubState:    sub,
+		})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// Status runs the init script's status command and checks whether it is
+// started in any runlevel
+func (s *SysVService) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	path, err := s.script("status", name)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := initStatus(ctx, s.exec, name, path, "status")
+	if err != nil {
+		return nil, err
+	}
+	// 4 is "status unknown"; scripts without a status command usually
+	// print their usage and exit with some other code
+	active, sub := "unknown", "unknown"
+	if state, ok := sysvStates[code]; ok {
+		active, sub = state[0], state[1]
+	}
+
+	runlevels := s.runlevels(UnitBaseName(name))
+	status := initScriptStatus(UnitBaseName(name), path, active, sub, len(runlevels) > 0)
+	if active == "failed" {
+		status.Result = "exit-code"
+	}
+	status.Dependencies.WantedBy = runlevels
+	return status, nil
+}
+
+// runlevels returns the runlevels that start the named service, from the
+// S<nn><name> links in /etc/rc<level>.d
+func (s *SysVService) runlevels(name string) []string {
+	var levels []string
+	for _, level := range []string{"S", "1", "2", "3", "4", "5"} {
+		matches, _ := filepath.Glob(filepath.Join(filepath.Dir(s.initDir), "rc"+level+".d", "S[0-9][0-9]"+name))
+		if len(matches) > 0 {
+			levels = append(levels, "runlevel"+level)
+		}
+	}
+	return levels
+}
+
+// Start starts a SysV service
+func (s *SysVService) Start(ctx context.Context, name string) (*ActionResult, error) {
+	status, err := s.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.IsActive {
+		return nil, newServiceError("start", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already running", name))
+	}
+	return s.runAction(ctx, "start", name, "start", "started")
+}
+
+// Stop stops a SysV service
+func (s *SysVService) Stop(ctx context.Context, name string) (*ActionResult, error) {
+	status, err := s.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status == "inactive" {
+		return nil, newServiceError("stop", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already stopped", name))
+	}
+	return s.runAction(ctx, "stop", name, "stop", "stopped")
+}
+
+// Restart restarts a SysV service
+func (s *SysVService) Restart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "restart", name, "restart", "restarted")
+}
+
+// Reload runs the init script's reload command
+func (s *SysVService) Reload(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "reload", name, "reload", "reloaded")
+}
+
+// ReloadOrRestart runs the LSB force-reload command, which reloads the
+// service if it supports reloading and restarts it otherwise
+func (s *SysVService) ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.runAction(ctx, "reload-or-restart", name, "force-reload", "reloaded or restarted")
+}
+
+// Enable creates the runlevel links that start a SysV service at boot,
+// starting it as well when now is set
+func (s *SysVService) Enable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.runUpdateAction(ctx, "enable", name, [][]string{{"defaults"}, {"enable"}}, "on", "start", "enabled", now)
+}
+
+// Disable turns the runlevel links of a SysV service into stop links,
+// stopping it as well when now is set
+func (s *SysVService) Disable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.runUpdateAction(ctx, "disable", name, [][]string{{"disable"}}, "off", "stop", "disabled", now)
+}
+
+// Mask is not supported: SysV init has no way to forbid starting a service
+func (s *SysVService) Mask(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return nil, newServiceError("mask", name, ErrUnsupported, "SysV init services cannot be masked")
+}
+
+// Unmask is not supported, see Mask
+func (s *SysVService) Unmask(ctx context.Context, name string) (*ActionResult, error) {
+	return nil, newServiceError("unmask", name, ErrUnsupported, "SysV init services cannot be masked")
+}
+
+// Logs reads the service's log file from the configured log directory
+func (s *SysVService) Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	return readServiceLogFile(s.logDir, name, query)
+}
+
+// runAction runs the init script with command, e.g. "/etc/init.d/nginx
+// restart"
+func (s *SysVService) runAction(ctx context.Context, op, name, command, pastTense string) (*ActionResult, error) {
+	path, err := s.script(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := runCommand(ctx, s.exec, op, name, path, command); err != nil {
+		return nil, err
+	}
+	return &ActionResult{
+		Name:    name,
+		Action:  op,
+		Message: fmt.Sprintf("Service %s %s successfully", name, pastTense),
+	}, nil
+}
+
+// runUpdateAction changes the runlevel links of a service with update-rc.d
+// (Debian and derivatives), falling back to chkconfig where update-rc.d is
+// not installed, then runs nowCommand when now is set
+func (s *SysVService) runUpdateAction(ctx context.Context, op, name string, updateArgs [][]string, chkconfigArg, nowCommand, pastTense string, now bool) (*ActionResult, error) {
+	path, err := s.script(op, name)
+	if err != nil {
+		return nil, err
+	}
+	base := UnitBaseName(name)
+
+	for _, args := range updateArgs {
+		_, err = runCommand(ctx, s.exec, op, name, "update-rc.d", append([]string{base}, args...)...)
+		if err != nil {
+			break
+		}
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		_, err = runCommand(ctx, s.exec, op, name, "chkconfig", base, chkconfigArg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Service %s %s successfully", name, pastTense)
+	if now {
+		if _, err := runCommand(ctx, s.exec, op, name, path, nowCommand); err != nil {
+			return nil, err
+		}
+		message += " (applied now)"
+	}
+	return &ActionResult{Name: name, Action: op, Message: message}, nil
+}
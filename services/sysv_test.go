@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestSysV creates a SysV service manager whose init directory holds an
+// executable script for each of scripts, with app started in runlevel 2
+func newTestSysV(t *testing.T, executor Executor, scripts ...string) *SysVService {
+	t.Helper()
+	root := t.TempDir()
+	s := NewSysVService(executor, t.TempDir())
+	s.initDir = filepath.Join(root, "init.d")
+	for _, dir := range []string{s.initDir, filepath.Join(root, "rc2.d")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range scripts {
+		if err := os.WriteFile(filepath.Join(s.initDir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "rc2.d", "S20app"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSysVStatus(t *testing.T) {
+	tests := []struct {
+		exitCode   int
+		wantActive string
+		wantSub    string
+		wantResult string
+	}{
+		{0, "active", "running", ""},
+		{1, "failed", "dead", "exit-code"}, // pid file left behind
+		{2, "failed", "dead", "exit-code"}, // lock file left behind
+		{3, "inactive", "dead", ""},
+		{4, "unknown", "unknown", ""},
+		{64, "unknown", "unknown", ""}, // no status command, usage printed
+	}
+	for _, tt := range tests {
+		executor := NewFakeExecutor()
+		s := newTestSysV(t, executor, "app")
+		executor.On(filepath.Join(s.initDir, "app")+" status", FakeResponse{ExitCode: tt.exitCode})
+
+		status, err := s.Status(context.Background(), "app")
+		if err != nil {
+			t.Fatalf("exit %d: %v", tt.exitCode, err)
+		}
+		if status.Status != tt.wantActive || status.SubState != tt.wantSub || status.Result != tt.wantResult {
+			t.Errorf("exit %d: status = %s/%s, result %q, want %s/%s, result %q", tt.exitCode,
+				status.Status, status.SubState, status.Result, tt.wantActive, tt.wantSub, tt.wantResult)
+		}
+		if !status.Enabled || !reflect.DeepEqual(status.Dependencies.WantedBy, []string{"runlevel2"}) {
+			t.Errorf("exit %d: enabled = %v in %v, want enabled in runlevel2", tt.exitCode, status.Enabled, status.Dependencies.WantedBy)
+		}
+	}
+
+	s := newTestSysV(t, NewFakeExecutor(), "README")
+	for _, name := range []string{"nginx", "README"} {
+		if _, err := s.Status(context.Background(), name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Status(%s) error = %v, want %v", name, err, ErrNotFound)
+		}
+	}
+}
+
+func TestSysVList(t *testing.T) {
+	executor := NewFakeExecutor()
+	s := newTestSysV(t, executor, "app", "db", "README", "rc.local", ".hidden")
+	os.WriteFile(filepath.Join(s.initDir, "notes"), nil, 0644) // not executable
+	executor.On(filepath.Join(s.initDir, "db")+" status", FakeResponse{ExitCode: 3})
+
+	list, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ServiceInfo{
+		{Name: "app", LoadState: "loaded", ActiveState: "active", SubState: "running"},
+		{Name: "db", LoadState: "loaded", ActiveState: "inactive", SubState: "dead"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("List() = %+v, want %+v", list, want)
+	}
+	if commands := executor.Commands(); len(commands) != 2 {
+		t.Errorf("ran %v, want only the status of app and db", commands)
+	}
+}
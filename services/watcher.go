@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+)
+
+// Watcher tracks the state of services in the background and publishes
+// every transition on an event bus. It polls List on an interval and, when
+// the backend is a StateSubscriber, also applies pushed changes as they
+// arrive. Cached status is refreshed whenever a transition is seen.
+type Watcher struct {
+	manager  ServiceManager
+	bus      *events.Bus
+	interval time.Duration
+	watched  map[string]bool // base names of watched services, nil for all
+
+	mu     sync.Mutex
+	states map[string]events.State
+	failed map[string]bool // services that failed and have not been active since
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWatcher creates a watcher for the named services, or for every service
+// if names is empty. Names match with or without the ".service" suffix.
+func NewWatcher(manager ServiceManager, bus *events.Bus, interval time.Duration, names []string) *Watcher {
+	w := &Watcher{
+		manager:  manager,
+		bus:      bus,
+		interval: interval,
+		states:   make(map[string]events.State),
+		failed:   make(map[string]bool),
+	}
+	if len(names) > 0 {
+		w.watched = make(map[string]bool, len(names))
+		for _, name := range names {
+			w.watched[UnitBaseName(name)] = true
+		}
+	}
+	return w
+}
+
+// UnitBaseName strips the ".service" suffix from a systemd unit name, so
+// "nginx" and "nginx.service" compare equal
+func UnitBaseName(name string) string {
+	return strings.TrimSuffix(name, ".service")
+}
+
+// Start begins watching in the background
+func (w *Watcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go w.run(ctx)
+}
+
+// Stop stops watching and waits for the watcher to exit
+func (w *Watcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// State returns the last observed state of the named service
+func (w *Watcher) State(name string) (events.State, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state, ok := w.states[UnitBaseName(name)]
+	return state, ok
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+
+	var pushed <-chan StateChange
+	if subscriber, ok := w.manager.(StateSubscriber); ok {
+		if ch, err := subscriber.SubscribeStateChanges(ctx); err == nil {
+			pushed = ch
+		}
+	}
+
+	// The first poll records a baseline without publishing anything
+	w.poll(ctx)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.poll(ctx)
+		case change, ok := <-pushed:
+			if !ok {
+				pushed = nil
+				continue
+			}
+			w.observe(ctx, change.Name, events.State{ActiveState: change.ActiveState, SubState: change.SubState}, change.At)
+		}
+	}
+}
+
+// poll lists every service and observes the watched ones
+func (w *Watcher) poll(ctx context.Context) {
+	list, err := w.manager.List(ctx)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, info := range list {
+		w.observe(ctx, info.Name, events.State{ActiveState: info.ActiveState, SubState: info.SubState}, now)
+	}
+}
+
+// observe records the state of name and publishes an event if it differs
+// from the last one seen
+func (w *Watcher) observe(ctx context.Context, name string, state events.State, at time.Time) {
+	base := UnitBaseName(name)
+	if w.watched != nil && !w.watched[base] {
+		return
+	}
+
+	w.mu.Lock()
+	previous, seen := w.states[base]
+	// Pushed changes may carry only the active state
+	if state.SubState == "" {
+		state.SubState = previous.SubState
+	}
+	w.states[base] = state
+	eventType := events.ServiceStateChanged
+	switch {
+	case state.ActiveState == "failed" && previous.ActiveState != "failed":
+		eventType = events.ServiceFailed
+		w.failed[base] = true
+	case state.ActiveState == "active" && w.failed[base]:
+		// Recovered, possibly by way of inactive and activating
+		eventType = events.ServiceRecovered
+		delete(w.failed, base)
+	}
+	w.mu.Unlock()
+
+	if !seen || previous == state {
+		return
+	}
+
+	if refresher, ok := w.manager.(StatusRefresher); ok {
+		refresher.Refresh(ctx, name)
+	}
+
+	w.bus.Publish(events.Event{
+		Type:    eventType,
+		Service: name,
+		From:    &previous,
+		To:      &state,
+		At:      at,
+	})
+}
@@ -2,176 +2,466 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+)
 
-	"github.com/therealtoxicdev/chronoserve/utils"
+// Ensure WindowsService implements ServiceManager and StatusRefresher
+var (
+	_ ServiceManager  = (*WindowsService)(nil)
+	_ StatusRefresher = (*WindowsService)(nil)
 )
 
-// WindowsService implements the ServiceHandler interface for Windows
+// WindowsService implements the ServiceManager interface for Windows
 type WindowsService struct {
 	BaseServiceHandler
+	exec       Executor
 	cache      map[string]ServiceStatus
 	cacheMutex sync.RWMutex
 	cacheTTL   time.Duration
 }
 
-// NewWindowsService creates a new Windows service handler
-func NewWindowsService() *WindowsService {
+// windowsServiceInfo is the JSON shape produced by the Get-Service queries
+// below. Enum values are converted to strings in the script because
+// ConvertTo-Json would otherwise emit their numeric values.
+type windowsServiceInfo struct {
+	Name        string `json:"Name"`
+	DisplayName string `json:"DisplayName"`
+	Status      string `json:"Status"`
+	StartType   string `json:"StartType"`
+}
+
+// windowsServiceSelect selects the properties of windowsServiceInfo
+const windowsServiceSelect = `Select-Object Name, DisplayName, @{n='Status';e={$_.Status.ToString()}}, @{n='StartType';e={$_.StartType.ToString()}}`
+
+// NewWindowsService creates a new Windows service manager that runs
+// PowerShell through executor
+func NewWindowsService(executor Executor) *WindowsService {
 	return &WindowsService{
+		exec:     executor,
 		cache:    make(map[string]ServiceStatus),
 		cacheTTL: 5 * time.Minute,
 	}
 }
 
-// ListServices lists all Windows services
-func (s *WindowsService) ListServices(w http.ResponseWriter, r *http.Request) {
+// List lists all Windows services
+func (s *WindowsService) List(ctx context.Context) ([]ServiceInfo, error) {
 	script := `
-        Get-Service | Select-Object Name, DisplayName, Status | ConvertTo-Json
+        ConvertTo-Json -InputObject @(Get-Service | ` + windowsServiceSelect + `)
     `
-	out, err := s.executePowershell(script)
+	out, err := s.executePowershell(ctx, "list", "", script)
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to list services: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	var services []map[string]interface{}
-	if err := json.Unmarshal(out.Bytes(), &services); err != nil {
-		s.HandleError(w, "Failed to parse service data", http.StatusInternalServerError)
-		return
+	var infos []windowsServiceInfo
+	if err := json.Unmarshal(out.Bytes(), &infos); err != nil {
+		return nil, fmt.Errorf("failed to parse service data: %w", err)
 	}
 
-	utils.WriteSuccessResponse(w, "Services retrieved successfully", services)
+	services := make([]ServiceInfo, 0, len(infos))
+	for _, info := range infos {
+		active, _ := windowsActiveState(info.Status)
+		services = append(services, ServiceInfo{
+			Name:        info.Name,
+			Description: info.DisplayName,
+			ActiveState: active,
+			SubState:    strings.ToLower(info.Status),
+		})
+	}
+	return services, nil
 }
 
-// StartService starts a Windows service
-func (s *WindowsService) StartService(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// Start starts a Windows service
+func (s *WindowsService) Start(ctx context.Context, name string) (*ActionResult, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError("start", name, ErrInvalidName, "")
+	}
+
+	// Check the live state: a cached status may predate a crash
+	status, err := s.Refresh(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.IsActive {
+		return nil, newServiceError("start", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already running", name))
 	}
 
 	script := fmt.Sprintf(`
         $service = Get-Service -Name "%s"
-        if ($service.Status -eq "Running") {
-            Write-Output "Service is already running"
-            exit 0
-        }
         Start-Service -Name "%s"
         $service.WaitForStatus("Running", "00:00:30")
-        Write-Output "Service started successfully"
     `, name, name)
+	return s.runAction(ctx, "start", name, "started", script)
+}
+
+// Stop stops a Windows service
+func (s *WindowsService) Stop(ctx context.Context, name string) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("stop", name, ErrInvalidName, "")
+	}
 
-	out, err := s.executePowershell(script)
+	status, err := s.Refresh(ctx, name)
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to start service %s: %v", name, err), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if status.SubState == "stopped" {
+		return nil, newServiceError("stop", name, ErrAlreadyInState, fmt.Sprintf("Service %s is already stopped", name))
 	}
 
-	utils.WriteSuccessResponse(w, out.String(), nil)
+	script := fmt.Sprintf(`
+        $service = Get-Service -Name "%s"
+        Stop-Service -Name "%s"
+        $service.WaitForStatus("Stopped", "00:00:30")
+    `, name, name)
+	return s.runAction(ctx, "stop", name, "stopped", script)
 }
 
-// StopService stops a Windows service
-func (s *WindowsService) StopService(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// Restart restarts a Windows service
+func (s *WindowsService) Restart(ctx context.Context, name string) (*ActionResult, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError("restart", name, ErrInvalidName, "")
 	}
 
 	script := fmt.Sprintf(`
         $service = Get-Service -Name "%s"
-        if ($service.Status -eq "Stopped") {
-            Write-Output "Service is already stopped"
-            exit 0
-        }
-        Stop-Service -Name "%s"
-        $service.WaitForStatus("Stopped", "00:00:30")
-        Write-Output "Service stopped successfully"
+        Restart-Service -Name "%s" -Force
+        $service.WaitForStatus("Running", "00:00:30")
     `, name, name)
+	return s.runAction(ctx, "restart", name, "restarted", script)
+}
+
+// Reload is not supported by the Windows service control manager
+func (s *WindowsService) Reload(ctx context.Context, name string) (*ActionResult, error) {
+	return nil, newServiceError("reload", name, ErrUnsupported, "Reloading services is not supported on Windows")
+}
+
+// ReloadOrRestart restarts a Windows service, since Windows services have no
+// reload operation
+func (s *WindowsService) ReloadOrRestart(ctx context.Context, name string) (*ActionResult, error) {
+	return s.Restart(ctx, name)
+}
+
+// Enable sets a Windows service to start automatically, starting it as well
+// when now is set
+func (s *WindowsService) Enable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.setStartupType(ctx, "enable", name, "Automatic", "Start-Service", "enabled", now)
+}
+
+// Disable prevents a Windows service from starting, stopping it as well when
+// now is set
+func (s *WindowsService) Disable(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return s.setStartupType(ctx, "disable", name, "Disabled", "Stop-Service", "disabled", now)
+}
+
+// Mask is not supported on Windows
+func (s *WindowsService) Mask(ctx context.Context, name string, now bool) (*ActionResult, error) {
+	return nil, newServiceError("mask", name, ErrUnsupported, "Masking services is not supported on Windows")
+}
 
-	out, err := s.executePowershell(script)
+// Unmask is not supported on Windows
+func (s *WindowsService) Unmask(ctx context.Context, name string) (*ActionResult, error) {
+	return nil, newServiceError("unmask", name, ErrUnsupported, "Unmasking services is not supported on Windows")
+}
+
+// setStartupType changes the startup type of a Windows service and, when now
+// is set, runs nowCmdlet against the service afterwards
+func (s *WindowsService) setStartupType(ctx context.Context, op, name, startupType, nowCmdlet, pastTense string, now bool) (*ActionResult, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError(op, name, ErrInvalidName, "")
+	}
+
+	script := fmt.Sprintf(`
+        Set-Service -Name "%s" -StartupType %s
+    `, name, startupType)
+	if now {
+		script += fmt.Sprintf(`
+        %s -Name "%s"
+    `, nowCmdlet, name)
+	}
+
+	result, err := s.runAction(ctx, op, name, pastTense, script)
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to stop service %s: %v", name, err), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if now {
+		result.Message += " (applied now)"
+	}
+	return result, nil
+}
+
+// runAction runs a PowerShell script that changes the state of a service
+func (s *WindowsService) runAction(ctx context.Context, op, name, pastTense, script string) (*ActionResult, error) {
+	if _, err := s.executePowershell(ctx, op, name, script); err != nil {
+		return nil, err
 	}
 
-	utils.WriteSuccessResponse(w, out.String(), nil)
+	s.invalidateCache(name)
+
+	return &ActionResult{
+		Name:    name,
+		Action:  op,
+		Message: fmt.Sprintf("Service %s %s successfully", name, pastTense),
+	}, nil
+}
+
+// windowsEvent is the JSON shape produced by the Get-WinEvent query in Logs
+type windowsEvent struct {
+	TimeCreated string `json:"TimeCreated"`
+	Level       int    `json:"Level"`
+	ProcessID   int    `json:"ProcessId"`
+	Provider    string `json:"ProviderName"`
+	Message     string `json:"Message"`
+	RecordID    int64  `json:"RecordId"`
 }
 
-// ViewServiceLogs retrieves Windows service logs
-func (s *WindowsService) ViewServiceLogs(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// Logs retrieves Windows service logs matching query. The event record id
+// serves as the cursor.
+func (s *WindowsService) Logs(ctx context.Context, name string, query LogQuery) ([]LogEntry, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError("logs", name, ErrInvalidName, "")
+	}
+	if err := query.Validate(); err != nil {
+		return nil, newServiceError("logs", name, ErrInvalidArgument, err.Error())
+	}
+	if query.Cursor != "" {
+		if _, err := strconv.ParseInt(query.Cursor, 10, 64); err != nil {
+			return nil, newServiceError("logs", name, ErrInvalidArgument, "invalid cursor")
+		}
+	}
+
+	filter := fmt.Sprintf("LogName = 'Application'; ProviderName = '%s'", name)
+	if query.Since != nil {
+		filter += fmt.Sprintf("; StartTime = [DateTime]::Parse('%s')", query.Since.UTC().Format(time.RFC3339))
+	}
+	if query.Until != nil {
+		filter += fmt.Sprintf("; EndTime = [DateTime]::Parse('%s')", query.Until.UTC().Format(time.RFC3339))
+	}
+	if query.Priority != "" {
+		mostSevere, leastSevere, _ := parsePriorityRange(query.Priority)
+		levels := windowsEventLevels(mostSevere, leastSevere)
+		if len(levels) == 0 {
+			// No Windows event level maps into the range
+			return []LogEntry{}, nil
+		}
+		filter += fmt.Sprintf("; Level = @(%s)", strings.Join(levels, ","))
+	}
+
+	var where []string
+	if query.Grep != "" {
+		where = append(where, fmt.Sprintf("$_.Message -match '%s'", strings.ReplaceAll(query.Grep, "'", "''")))
+	}
+	if query.Cursor != "" {
+		// Newer events than the cursor when paging forward, older in reverse
+		op := "-gt"
+		if query.Reverse {
+			op = "-lt"
+		}
+		where = append(where, fmt.Sprintf("$_.RecordId %s %s", op, query.Cursor))
+	}
+	whereClause := ""
+	if len(where) > 0 {
+		whereClause = "| Where-Object { " + strings.Join(where, " -and ") + " } "
 	}
 
+	// Get-WinEvent returns the newest events first; -Oldest is needed to page
+	// forward from a cursor without skipping events
+	order := ""
+	if query.Cursor != "" && !query.Reverse {
+		order = "-Oldest "
+	}
+
+	// -InputObject keeps a single event an array; piped into ConvertTo-Json
+	// it would be serialised as a bare object
 	script := fmt.Sprintf(`
-        Get-WinEvent -FilterHashtable @{
-            LogName = 'Application'
-            ProviderName = '%s'
-        } -MaxEvents 100 | Select-Object TimeCreated, LevelDisplayName, Message | ConvertTo-Json
-    `, name)
+        ConvertTo-Json -InputObject @(Get-WinEvent -FilterHashtable @{ %s } %s-ErrorAction SilentlyContinue %s|
+            Select-Object -First %d |
+            Select-Object @{n='TimeCreated';e={$_.TimeCreated.ToUniversalTime().ToString('o')}}, Level, ProcessId, ProviderName, Message, RecordId)
+    `, filter, order, whereClause, query.Lines)
 
-	out, err := s.executePowershell(script)
+	out, err := s.executePowershell(ctx, "logs", name, script)
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to retrieve logs for service %s: %v", name, err), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+
+	events, err := parseWindowsEvents(out.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LogEntry, 0, len(events))
+	for _, event := range events {
+		priority := windowsLevelPriority(event.Level)
+		timestamp, _ := time.Parse(time.RFC3339Nano, event.TimeCreated)
+		entries = append(entries, LogEntry{
+			Timestamp:  timestamp,
+			Priority:   priority,
+			Level:      priorityName(priority),
+			PID:        event.ProcessID,
+			Identifier: event.Provider,
+			Message:    event.Message,
+			Cursor:     strconv.FormatInt(event.RecordID, 10),
+		})
+	}
+
+	// Match journalctl: oldest first unless reverse was requested. Record ids
+	// order events written within the same timestamp.
+	sort.Slice(entries, func(i, j int) bool {
+		a, _ := strconv.ParseInt(entries[i].Cursor, 10, 64)
+		b, _ := strconv.ParseInt(entries[j].Cursor, 10, 64)
+		if query.Reverse {
+			return a > b
+		}
+		return a < b
+	})
+	return entries, nil
+}
+
+// parseWindowsEvents parses the events printed by the Get-WinEvent query in
+// Logs. A single object is accepted as well as an array, in case the shell
+// unrolled the array.
+func parseWindowsEvents(data []byte) ([]windowsEvent, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
 	}
+	if data[0] == '{' {
+		var event windowsEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse log data: %w", err)
+		}
+		return []windowsEvent{event}, nil
+	}
+	var events []windowsEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("failed to parse log data: %w", err)
+	}
+	return events, nil
+}
 
-	var logs interface{}
-	if err := json.Unmarshal(out.Bytes(), &logs); err != nil {
-		s.HandleError(w, "Failed to parse log data", http.StatusInternalServerError)
-		return
+// Status gets the current status of a Windows service
+func (s *WindowsService) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	if !s.ValidateServiceName(name) {
+		return nil, newServiceError("status", name, ErrInvalidName, "")
+	}
+
+	s.cacheMutex.RLock()
+	if status, ok := s.cache[name]; ok {
+		if time.Since(status.UpdatedAt) < s.cacheTTL {
+			s.cacheMutex.RUnlock()
+			return &status, nil
+		}
 	}
+	s.cacheMutex.RUnlock()
 
-	utils.WriteSuccessResponse(w, "Logs retrieved successfully", logs)
+	return s.Refresh(ctx, name)
 }
 
-// GetServiceStatus gets the current status of a Windows service
-func (s *WindowsService) GetServiceStatus(w http.ResponseWriter, r *http.Request) {
-	name := utils.ExtractServiceName(r.URL.Path)
+// Refresh reads the current status of a Windows service, bypassing and then
+// updating the cache
+func (s *WindowsService) Refresh(ctx context.Context, name string) (*ServiceStatus, error) {
 	if !s.ValidateServiceName(name) {
-		s.HandleError(w, "Invalid service name", http.StatusBadRequest)
-		return
+		return nil, newServiceError("status", name, ErrInvalidName, "")
 	}
 
 	script := fmt.Sprintf(`
-        Get-Service -Name "%s" | Select-Object Name, DisplayName, Status | ConvertTo-Json
-    `, name)
+        Get-Service -Name "%s" | %s | ConvertTo-Json
+    `, name, windowsServiceSelect)
 
-	out, err := s.executePowershell(script)
+	out, err := s.executePowershell(ctx, "status", name, script)
 	if err != nil {
-		s.HandleError(w, fmt.Sprintf("Failed to get status for service %s: %v", name, err), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+
+	var info windowsServiceInfo
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse service status: %w", err)
 	}
 
-	var status interface{}
-	if err := json.Unmarshal(out.Bytes(), &status); err != nil {
-		s.HandleError(w, "Failed to parse service status", http.StatusInternalServerError)
-		return
+	active, isActive := windowsActiveState(info.Status)
+	status := ServiceStatus{
+		Name:          name,
+		Description:   info.DisplayName,
+		Status:        active,
+		SubState:      strings.ToLower(info.Status),
+		UnitFileState: strings.ToLower(info.StartType),
+		Enabled:       strings.HasPrefix(info.StartType, "Automatic"),
+		IsActive:      isActive,
+		UpdatedAt:     time.Now(),
 	}
 
-	utils.WriteSuccessResponse(w, "Service status retrieved successfully", status)
+	s.cacheMutex.Lock()
+	s.cache[name] = status
+	s.cacheMutex.Unlock()
+
+	return &status, nil
+}
+
+// invalidateCache drops any cached status for the named service
+func (s *WindowsService) invalidateCache(name string) {
+	s.cacheMutex.Lock()
+	delete(s.cache, name)
+	s.cacheMutex.Unlock()
 }
 
-// executePowershell executes a PowerShell script and returns its output
-func (s *WindowsService) executePowershell(script string) (*bytes.Buffer, error) {
-	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
+// windowsLevelPriority maps a Windows event level onto a syslog priority
+func windowsLevelPriority(level int) int {
+	switch level {
+	case 1: // Critical
+		return 2
+	case 2: // Error
+		return 3
+	case 3: // Warning
+		return 4
+	case 5: // Verbose
+		return 7
+	default: // Information, LogAlways (0) and custom levels
+		return 6
+	}
+}
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("%v: %s", err, stderr.String())
+// windowsEventLevels lists the Windows event levels whose syslog priority
+// lies between mostSevere and leastSevere
+func windowsEventLevels(mostSevere, leastSevere int) []string {
+	var levels []string
+	for _, level := range []int{0, 1, 2, 3, 4, 5} {
+		priority := windowsLevelPriority(level)
+		if priority >= mostSevere && priority <= leastSevere {
+			levels = append(levels, strconv.Itoa(level))
+		}
 	}
+	return levels
+}
 
-	return &out, nil
+// windowsActiveState maps a Windows service status onto the systemd-style
+// active state used by ServiceStatus
+func windowsActiveState(status string) (string, bool) {
+	switch status {
+	case "Running":
+		return "active", true
+	case "StartPending", "ContinuePending":
+		return "activating", false
+	case "StopPending", "PausePending":
+		return "deactivating", false
+	case "Stopped", "Paused":
+		return "inactive", false
+	default:
+		return "unknown", false
+	}
+}
+
+// executePowershell executes a PowerShell script on behalf of op and returns
+// its output
+func (s *WindowsService) executePowershell(ctx context.Context, op, name, script string) (*bytes.Buffer, error) {
+	out, err := runCommand(ctx, s.exec, op, name, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(out), nil
 }
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindowsEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []int64
+	}{
+		{"empty", "", nil},
+		{"empty array", "[]", nil},
+		{"single object", `{"TimeCreated":"2024-03-05T10:20:30Z","Level":4,"RecordId":7,"Message":"started"}`, []int64{7}},
+		{"array", `[{"RecordId":7},{"RecordId":8}]`, []int64{7, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := parseWindowsEvents([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, event := range events {
+				got = append(got, event.RecordID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("record ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowsLogsSingleEvent(t *testing.T) {
+	executor := NewFakeExecutor()
+	executor.Default = FakeResponse{Stdout: `[{"TimeCreated":"2024-03-05T10:20:30Z","Level":0,"ProcessId":9,"ProviderName":"app","Message":"hello","RecordId":12}]`}
+
+	entries, err := NewWindowsService(executor).Logs(context.Background(), "app", LogQuery{Lines: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Cursor != "12" || entries[0].Level != "info" || entries[0].Message != "hello" {
+		t.Errorf("entries = %+v", entries)
+	}
+	script := executor.Commands()[0].Args[3]
+	if !strings.Contains(script, "ConvertTo-Json -InputObject @(") {
+		t.Errorf("script does not keep the events an array:\n%s", script)
+	}
+}
+
+func TestWindowsEventLevels(t *testing.T) {
+	tests := []struct {
+		mostSevere, leastSevere int
+		want                    []string
+	}{
+		{0, 7, []string{"0", "1", "2", "3", "4", "5"}},
+		{0, 3, []string{"1", "2"}},
+		{6, 6, []string{"0", "4"}},
+		{0, 1, nil},
+	}
+	for _, tt := range tests {
+		if got := windowsEventLevels(tt.mostSevere, tt.leastSevere); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("windowsEventLevels(%d, %d) = %v, want %v", tt.mostSevere, tt.leastSevere, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsStartChecksLiveState(t *testing.T) {
+	executor := NewFakeExecutor()
+	executor.Default = FakeResponse{Stdout: `{"Name":"app","DisplayName":"App","Status":"Stopped","StartType":"Automatic"}`}
+	s := NewWindowsService(executor)
+
+	// A status cached while the service was running must not stop a start
+	// after it crashed
+	s.cache["app"] = ServiceStatus{Name: "app", Status: "active", IsActive: true, UpdatedAt: time.Now()}
+	if _, err := s.Start(context.Background(), "app"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	s.cache["app"] = ServiceStatus{Name: "app", Status: "active", IsActive: true, SubState: "running", UpdatedAt: time.Now()}
+	if _, err := s.Stop(context.Background(), "app"); !errors.Is(err, ErrAlreadyInState) {
+		t.Fatalf("Stop() error = %v, want %v", err, ErrAlreadyInState)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,11 +15,18 @@ import (
 
 // Config represents the root configuration structure
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	Auth    AuthConfig    `yaml:"auth"`
-	Linux   LinuxConfig   `yaml:"linux"`
-	Windows WindowsConfig `yaml:"windows"`
-	Logging LogConfig     `yaml:"logging"`
+	Server     ServerConfig     `yaml:"server"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Linux      LinuxConfig      `yaml:"linux"`
+	Windows    WindowsConfig    `yaml:"windows"`
+	Logging    LogConfig        `yaml:"logging"`
+	Exec       ExecConfig       `yaml:"exec"`
+	Scheduler  SchedulerConfig  `yaml:"scheduler"`
+	Watcher    WatcherConfig    `yaml:"watcher"`
+	Webhooks   WebhooksConfig   `yaml:"webhooks"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Supervisor SupervisorConfig `yaml:"supervisor"`
+	Fleet      FleetConfig      `yaml:"fleet"`
 }
 
 type ServerConfig struct {
@@ -26,32 +35,77 @@ type ServerConfig struct {
 	ReadTimeout    string `yaml:"readTimeout"`
 	WriteTimeout   string `yaml:"writeTimeout"`
 	MaxHeaderBytes int    `yaml:"maxHeaderBytes"`
+
+	// MaxLogFollowers caps the concurrent live log streams per user
+	MaxLogFollowers int `yaml:"maxLogFollowers"`
+
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig makes the server listen with TLS when CertFile is set. Clients
+// presenting a certificate signed by ClientCAFile are verified, which fleet
+// agents can use instead of a token; other clients are still accepted.
+type TLSConfig struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
 }
 
 type AuthConfig struct {
-	SecretKey     string                 `yaml:"secretKey"`
-	TokenDuration time.Duration          `yaml:"tokenDuration"`
-	IssuedBy      string                 `yaml:"issuedBy"`
-	AllowedRoles  []string               `yaml:"allowedRoles"`
-	Users         map[string]Credentials `yaml:"users"`
+	SecretKey            string                 `yaml:"secretKey"`            // HS256 key, used when no signing keys are configured
+	SigningKeys          []SigningKey           `yaml:"signingKeys"`          // asymmetric keys, replacing secretKey
+	SigningKeyID         string                 `yaml:"signingKeyId"`         // key that signs new tokens; default the first with a private key
+	TokenDuration        time.Duration          `yaml:"tokenDuration"`        // lifetime of access tokens
+	RefreshTokenDuration time.Duration          `yaml:"refreshTokenDuration"` // lifetime of refresh tokens, renewed on each refresh
+	SessionFile          string                 `yaml:"sessionFile"`          // login sessions and revoked tokens
+	APIKeyFile           string                 `yaml:"apiKeyFile"`           // hashed API keys
+	IssuedBy             string                 `yaml:"issuedBy"`
+	Roles                map[string]RoleConfig  `yaml:"roles"`
+	AllowedRoles         []string               `yaml:"allowedRoles"` // deprecated and ignored, roles are defined in Roles
+	Users                map[string]Credentials `yaml:"users"`
+}
+
+// SigningKey is an asymmetric JWT key, identified in tokens by its ID (kid).
+// A key with a private key can sign tokens; a key with only a public key,
+// such as one being retired, only verifies them.
+type SigningKey struct {
+	ID             string `yaml:"id"`
+	Algorithm      string `yaml:"algorithm"` // RS256, ES256 or EdDSA
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	PublicKeyFile  string `yaml:"publicKeyFile"` // only needed without a private key
+}
+
+// SigningAlgorithms are the algorithms a SigningKey can use
+var SigningAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// RoleConfig defines a role as a set of permissions, such as
+// "services:start", "logs:read", "services:*" or "*", optionally limited to
+// the services matching some glob patterns
+type RoleConfig struct {
+	Permissions []string `yaml:"permissions"`
+	Services    []string `yaml:"services"` // empty for every service
 }
 
 type Credentials struct {
 	Username string   `yaml:"username"`
-	Password string   `yaml:"password"`
+	Password string   `yaml:"password"` // bcrypt or argon2id hash from "chronoserve hash-password", or plaintext
 	Roles    []string `yaml:"roles"`
 }
 
 type LinuxConfig struct {
-	ServiceCommand string             `yaml:"serviceCommand"`
-	LogDirectory   string             `yaml:"logDirectory"`
-	Services       map[string]Service `yaml:"services"`
+	ServiceCommand       string             `yaml:"serviceCommand"` // "auto", "systemctl", "dbus", "openrc", "sysv", "docker" or "supervisor"
+	DbusAddress          string             `yaml:"dbusAddress"`    // bus used by the dbus backend, empty for the system bus
+	DockerSocket         string             `yaml:"dockerSocket"`   // Engine API socket of the docker backend, default /var/run/docker.sock
+	LogDirectory         string             `yaml:"logDirectory"`
+	RestrictToConfigured bool               `yaml:"restrictToConfigured"` // hide services missing from Services
+	Services             map[string]Service `yaml:"services"`
 }
 
 type WindowsConfig struct {
-	ServiceCommand string             `yaml:"serviceCommand"`
-	LogDirectory   string             `yaml:"logDirectory"`
-	Services       map[string]Service `yaml:"services"`
+	ServiceCommand       string             `yaml:"serviceCommand"` // "sc" or "supervisor"
+	LogDirectory         string             `yaml:"logDirectory"`
+	RestrictToConfigured bool               `yaml:"restrictToConfigured"` // hide services missing from Services
+	Services             map[string]Service `yaml:"services"`
 }
 
 type LogConfig struct {
@@ -63,27 +117,232 @@ type LogConfig struct {
 	Compress   bool   `yaml:"compress"`
 }
 
+// ExecConfig controls how long backend commands (systemctl, journalctl,
+// powershell) may run before they are killed
+type ExecConfig struct {
+	Timeout    string            `yaml:"timeout"`    // default for every operation
+	Operations map[string]string `yaml:"operations"` // per-operation overrides, e.g. stop: "90s"
+}
+
+// SchedulerConfig declares service actions run on a cron schedule. Jobs
+// added through the API are kept in StateFile alongside each job's last run.
+type SchedulerConfig struct {
+	StateFile string         `yaml:"stateFile"`
+	Jobs      []ScheduledJob `yaml:"jobs"`
+}
+
+// ScheduledJob runs Action against Service whenever Schedule matches
+type ScheduledJob struct {
+	ID       string `yaml:"id"`
+	Service  string `yaml:"service"`
+	Action   string `yaml:"action"`   // start, stop, restart, reload or reload-or-restart
+	Schedule string `yaml:"schedule"` // 5-field cron expression, or 6 with leading seconds
+	Timezone string `yaml:"timezone"` // IANA zone the schedule is evaluated in, default local
+	Jitter   string `yaml:"jitter"`   // random delay of up to this duration before each run
+	Misfire  string `yaml:"misfire"`  // "skip" (default) or "run-once" for runs missed while down
+}
+
+// WatcherConfig controls the background watcher that detects service state
+// transitions
+type WatcherConfig struct {
+	Interval string   `yaml:"interval"` // poll interval, "0s" disables the watcher
+	Services []string `yaml:"services"` // services to watch, default the enabled configured services, or all
+}
+
+// WebhooksConfig lists the targets notified of service events. Deliveries
+// that still fail after every retry are appended to DeadLetterFile.
+type WebhooksConfig struct {
+	DeadLetterFile string          `yaml:"deadLetterFile"`
+	Targets        []WebhookTarget `yaml:"targets"`
+}
+
+// WebhookTarget receives a signed JSON POST for each matching event
+type WebhookTarget struct {
+	Name           string   `yaml:"name"`
+	URL            string   `yaml:"url"`
+	Secret         string   `yaml:"secret"`         // HMAC-SHA256 key for the signature header
+	Events         []string `yaml:"events"`         // event types, e.g. service.failed; empty for all
+	Services       []string `yaml:"services"`       // services, with or without .service; empty for all
+	Actions        []string `yaml:"actions"`        // for service.action events, e.g. stop; empty for all
+	Timeout        string   `yaml:"timeout"`        // per attempt, default 10s
+	MaxAttempts    int      `yaml:"maxAttempts"`    // including the first, default 5
+	InitialBackoff string   `yaml:"initialBackoff"` // delay before the first retry, doubled each time, default 1s
+	MaxBackoff     string   `yaml:"maxBackoff"`     // default 5m
+}
+
+// SupervisorConfig declares the programs run by the built-in process
+// supervisor. It is the backend when linux.serviceCommand or
+// windows.serviceCommand is "supervisor", and on systems without a
+// supported service manager.
+type SupervisorConfig struct {
+	LogDirectory string             `yaml:"logDirectory"` // captured program output
+	Programs     map[string]Program `yaml:"programs"`
+}
+
+// Program is a command started, restarted and stopped by the supervisor
+type Program struct {
+	Description      string            `yaml:"description"`
+	Command          []string          `yaml:"command"` // program and arguments
+	WorkingDirectory string            `yaml:"workingDirectory"`
+	Environment      map[string]string `yaml:"environment"`   // added to ChronoServe's own environment
+	User             string            `yaml:"user"`          // run as this user, which needs root; not on Windows
+	Autostart        bool              `yaml:"autostart"`     // start with ChronoServe
+	Restart          string            `yaml:"restart"`       // "no", "on-failure" (default) or "always"
+	RestartDelay     string            `yaml:"restartDelay"`  // default 1s, doubled after each quick exit up to 1m
+	StopSignal       string            `yaml:"stopSignal"`    // TERM (default), INT, QUIT, HUP, USR1 or USR2
+	StopTimeout      string            `yaml:"stopTimeout"`   // wait this long after StopSignal before killing, default 10s
+	ReloadSignal     string            `yaml:"reloadSignal"`  // sent on reload, e.g. HUP; reload is unsupported if empty
+	LogMaxSize       int               `yaml:"logMaxSize"`    // MB of output per file before rotating, default 10
+	LogMaxBackups    int               `yaml:"logMaxBackups"` // rotated files kept, default 3
+}
+
+// FleetConfig configures controller/agent mode. A controller accepts
+// connections from agents and exposes their services under /nodes/{node};
+// an agent keeps an outbound connection to its controller. An instance may
+// be both.
+type FleetConfig struct {
+	Controller ControllerConfig `yaml:"controller"`
+	Agent      AgentConfig      `yaml:"agent"`
+}
+
+// ControllerConfig lists the agents allowed to connect, keyed by node name
+type ControllerConfig struct {
+	Enabled        bool                        `yaml:"enabled"`
+	RequestTimeout string                      `yaml:"requestTimeout"` // bound on a call to an agent, default 2m
+	Agents         map[string]AgentCredentials `yaml:"agents"`
+}
+
+// AgentCredentials authenticates one agent: by bearer token, or by a client
+// certificate with the given common name verified against
+// server.tls.clientCAFile
+type AgentCredentials struct {
+	Token      string `yaml:"token"`
+	CommonName string `yaml:"commonName"`
+}
+
+// AgentConfig connects this instance to a controller. Controller is the
+// WebSocket URL of the controller's /fleet/connect endpoint.
+type AgentConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Controller string `yaml:"controller"` // e.g. wss://controller.example.com:8080/fleet/connect
+	Node       string `yaml:"node"`       // name of this node, default the hostname
+	Token      string `yaml:"token"`
+	CAFile     string `yaml:"caFile"`   // CA verifying the controller, default the system pool
+	CertFile   string `yaml:"certFile"` // client certificate for mutual TLS
+	KeyFile    string `yaml:"keyFile"`
+}
+
+// MetricsConfig controls the Prometheus /metrics endpoint. Set Username and
+// Password to require basic auth, or Token to require a bearer token; with
+// neither the endpoint is open to anyone who can reach the server.
+type MetricsConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+}
+
+// Service is the access policy for a single service, keyed by service name
+// in LinuxConfig.Services and WindowsConfig.Services. A disabled service is
+// hidden from the API as if it did not exist.
 type Service struct {
 	Name         string   `yaml:"name"`
 	Description  string   `yaml:"description"`
 	Enabled      bool     `yaml:"enabled"`
-	AllowedRoles []string `yaml:"allowedRoles"`
+	AllowedRoles []string `yaml:"allowedRoles"` // roles that may change the service; empty allows any
+	ReadRoles    []string `yaml:"readRoles"`    // roles that may view status and logs; empty falls back to AllowedRoles
+
+	Restart RestartPolicy `yaml:"restart"`
+	Probes  []Probe       `yaml:"probes"`
+}
+
+// Probe checks that a service is actually working, beyond what the service
+// manager reports. Type selects the check: "http" fetches URL, "tcp"
+// connects to Address and "exec" runs Command.
+type Probe struct {
+	Name             string   `yaml:"name"` // default <type>-<position>
+	Type             string   `yaml:"type"`
+	URL              string   `yaml:"url"`              // http: URL to GET
+	ExpectStatus     int      `yaml:"expectStatus"`     // http: required status code, default any 2xx or 3xx
+	ExpectBody       string   `yaml:"expectBody"`       // http: regular expression the body must match
+	Address          string   `yaml:"address"`          // tcp: host:port
+	Command          []string `yaml:"command"`          // exec: program and arguments
+	ExpectExitCode   int      `yaml:"expectExitCode"`   // exec: required exit code, default 0
+	Interval         string   `yaml:"interval"`         // default 30s
+	Timeout          string   `yaml:"timeout"`          // default 5s
+	FailureThreshold int      `yaml:"failureThreshold"` // consecutive failures before unhealthy, default 3
+	SuccessThreshold int      `yaml:"successThreshold"` // consecutive successes before healthy, default 1
+}
+
+// RestartPolicy has ChronoServe restart the service whenever it fails, for
+// units without a systemd Restart= setting. After MaxAttempts restarts
+// within Window the service is quarantined and left alone.
+type RestartPolicy struct {
+	Enabled        bool   `yaml:"enabled"`
+	InitialBackoff string `yaml:"initialBackoff"` // delay before the first restart, doubled for each later one in the window, default 1s
+	MaxBackoff     string `yaml:"maxBackoff"`     // default 5m
+	MaxAttempts    int    `yaml:"maxAttempts"`    // restarts per window, default 5
+	Window         string `yaml:"window"`         // default 10m
+	Quarantine     string `yaml:"quarantine"`     // how long quarantine lasts; empty until released through the API
+	RestartOn      string `yaml:"restartOn"`      // "failed" (default), or "inactive" to also restart a service found stopped
+}
+
+// WatchedServices returns the services the state watcher follows: those in
+// Watcher.Services plus any under a restart policy, else the enabled
+// configured services. Nil means all.
+func (c Config) WatchedServices() []string {
+	configured, _ := c.ManagedServices()
+	if len(c.Watcher.Services) > 0 {
+		names := append([]string(nil), c.Watcher.Services...)
+		// The restart watchdog reacts to failures seen by the watcher
+		for name, service := range configured {
+			if service.Enabled && service.Restart.Enabled {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	var names []string
+	for name, service := range configured {
+		if service.Enabled {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ManagedServices returns the service policies for the current OS and
+// whether services without a policy are hidden
+func (c Config) ManagedServices() (map[string]Service, bool) {
+	if runtime.GOOS == "windows" {
+		return c.Windows.Services, c.Windows.RestrictToConfigured
+	}
+	return c.Linux.Services, c.Linux.RestrictToConfigured
 }
 
 // Default configuration values
 var defaultConfig = Config{
 	Server: ServerConfig{
-		Host:           "localhost",
-		Port:           8080,
-		ReadTimeout:    "15s",
-		WriteTimeout:   "15s",
-		MaxHeaderBytes: 1 << 20, // 1MB
+		Host:            "localhost",
+		Port:            8080,
+		ReadTimeout:     "15s",
+		WriteTimeout:    "15s",
+		MaxHeaderBytes:  1 << 20, // 1MB
+		MaxLogFollowers: 5,
 	},
 	Auth: AuthConfig{
-		SecretKey:     "change-me",
-		TokenDuration: 24 * time.Hour,
-		IssuedBy:      "ChronoServe",
-		AllowedRoles:  []string{"admin", "viewer"},
+		SecretKey:            "change-me",
+		TokenDuration:        15 * time.Minute,
+		RefreshTokenDuration: 7 * 24 * time.Hour,
+		SessionFile:          "data/sessions.json",
+		APIKeyFile:           "data/apikeys.json",
+		IssuedBy:             "ChronoServe",
+		Roles: map[string]RoleConfig{
+			"admin": {Permissions: []string{"*"}},
+			"viewer": {Permissions: []string{
+				"services:list", "services:status", "logs:read", "watchdog:read", "nodes:read",
+			}},
+		},
 		Users: map[string]Credentials{
 			"admin": {
 				Username: "admin",
@@ -98,7 +357,7 @@ var defaultConfig = Config{
 		},
 	},
 	Linux: LinuxConfig{
-		ServiceCommand: "systemctl",
+		ServiceCommand: "auto",
 		LogDirectory:   "/var/log/chronoserve",
 	},
 	Windows: WindowsConfig{
@@ -113,6 +372,26 @@ var defaultConfig = Config{
 		MaxAge:     30, // 30 days
 		Compress:   true,
 	},
+	Exec: ExecConfig{
+		Timeout: "30s",
+	},
+	Scheduler: SchedulerConfig{
+		StateFile: "data/scheduler.json",
+	},
+	Watcher: WatcherConfig{
+		Interval: "15s",
+	},
+	Webhooks: WebhooksConfig{
+		DeadLetterFile: "data/webhooks-dead-letter.jsonl",
+	},
+	Supervisor: SupervisorConfig{
+		LogDirectory: "logs/supervisor",
+	},
+	Fleet: FleetConfig{
+		Controller: ControllerConfig{
+			RequestTimeout: "2m",
+		},
+	},
 }
 
 func (c *Config) Validate() error {
@@ -120,18 +399,106 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid port number: %d", c.Server.Port)
 	}
 
-	if c.Auth.SecretKey == "" || c.Auth.SecretKey == defaultConfig.Auth.SecretKey {
+	if len(c.Auth.SigningKeys) == 0 && (c.Auth.SecretKey == "" || c.Auth.SecretKey == defaultConfig.Auth.SecretKey) {
 		return fmt.Errorf("security risk: default secret key must be changed")
 	}
+	if err := c.Auth.validateSigningKeys(); err != nil {
+		return err
+	}
 
-	if len(c.Auth.AllowedRoles) == 0 {
+	if len(c.Auth.Roles) == 0 {
 		return fmt.Errorf("at least one role must be defined")
 	}
 
+	if c.Auth.TokenDuration < 0 || c.Auth.RefreshTokenDuration < 0 {
+		return fmt.Errorf("token durations must be positive")
+	}
+
 	if c.Logging.MaxSize < 1 {
 		return fmt.Errorf("invalid log max size: %d", c.Logging.MaxSize)
 	}
 
+	if _, err := time.ParseDuration(c.Exec.Timeout); err != nil {
+		return fmt.Errorf("invalid exec timeout %q: %w", c.Exec.Timeout, err)
+	}
+	for op, timeout := range c.Exec.Operations {
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return fmt.Errorf("invalid exec timeout for %s %q: %w", op, timeout, err)
+		}
+	}
+
+	if d, err := time.ParseDuration(c.Watcher.Interval); err != nil || d < 0 {
+		return fmt.Errorf("invalid watcher interval %q", c.Watcher.Interval)
+	}
+
+	if (c.Metrics.Username == "") != (c.Metrics.Password == "") {
+		return fmt.Errorf("metrics username and password must be set together")
+	}
+
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("server TLS certificate and key must be set together")
+	}
+	if c.Server.TLS.ClientCAFile != "" && c.Server.TLS.CertFile == "" {
+		return fmt.Errorf("server TLS client CA requires a certificate and key")
+	}
+
+	if c.Fleet.Controller.Enabled {
+		if d, err := time.ParseDuration(c.Fleet.Controller.RequestTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid fleet controller request timeout %q", c.Fleet.Controller.RequestTimeout)
+		}
+		for node, creds := range c.Fleet.Controller.Agents {
+			if creds.Token == "" && creds.CommonName == "" {
+				return fmt.Errorf("fleet agent %s needs a token or a certificate common name", node)
+			}
+		}
+	}
+	if c.Fleet.Agent.Enabled {
+		if c.Fleet.Agent.Controller == "" {
+			return fmt.Errorf("fleet agent requires a controller URL")
+		}
+		if c.Fleet.Agent.Token == "" && c.Fleet.Agent.CertFile == "" {
+			return fmt.Errorf("fleet agent requires a token or a client certificate")
+		}
+		if (c.Fleet.Agent.CertFile == "") != (c.Fleet.Agent.KeyFile == "") {
+			return fmt.Errorf("fleet agent certificate and key must be set together")
+		}
+	}
+
+	return nil
+}
+
+// validateSigningKeys checks the asymmetric JWT keys. The key files
+// themselves are read when authentication starts.
+func (a AuthConfig) validateSigningKeys() error {
+	ids := make(map[string]bool)
+	signers := 0
+	for i, key := range a.SigningKeys {
+		if key.ID == "" {
+			return fmt.Errorf("signing key %d needs an id", i+1)
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+		ids[key.ID] = true
+		if !slices.Contains(SigningAlgorithms, key.Algorithm) {
+			return fmt.Errorf("signing key %s: algorithm must be one of %s", key.ID, strings.Join(SigningAlgorithms, ", "))
+		}
+		if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+			return fmt.Errorf("signing key %s needs a privateKeyFile or a publicKeyFile", key.ID)
+		}
+		if key.PrivateKeyFile != "" {
+			signers++
+		}
+		if key.ID == a.SigningKeyID && key.PrivateKeyFile == "" {
+			return fmt.Errorf("signing key %s has no privateKeyFile to sign with", key.ID)
+		}
+	}
+	if a.SigningKeyID != "" && !ids[a.SigningKeyID] {
+		return fmt.Errorf("unknown signingKeyId %q", a.SigningKeyID)
+	}
+	if len(a.SigningKeys) > 0 && signers == 0 {
+		return fmt.Errorf("at least one signing key needs a privateKeyFile")
+	}
 	return nil
 }
 
@@ -170,6 +537,13 @@ func InitConfig(filePath string) error {
 		os.Exit(1)
 	}
 
+	// Plaintext passwords still work, but the file then leaks them
+	if users := config.PlaintextPasswordUsers(); len(users) > 0 {
+		fmt.Printf("\n=== Plaintext Passwords ===\n")
+		fmt.Printf("These users have plaintext passwords in %s: %s\n", filePath, strings.Join(users, ", "))
+		fmt.Println("Replace each password with the output of \"chronoserve hash-password\".")
+	}
+
 	// Validate configuration
 	return config.Validate()
 }
@@ -177,20 +551,21 @@ func InitConfig(filePath string) error {
 // Add this new helper function
 func hasDefaultCredentials() bool {
 	// Check if any security-sensitive values are still set to defaults
-	if config.Auth.SecretKey == defaultConfig.Auth.SecretKey {
+	// The secret key is unused once tokens are signed with asymmetric keys
+	if len(config.Auth.SigningKeys) == 0 && config.Auth.SecretKey == defaultConfig.Auth.SecretKey {
 		return true
 	}
 
-	// Check admin credentials
+	// Check admin credentials, which may hash the default password
 	if admin, exists := config.Auth.Users["admin"]; exists {
-		if admin.Password == defaultConfig.Auth.Users["admin"].Password {
+		if admin.CheckPassword(defaultConfig.Auth.Users["admin"].Password) {
 			return true
 		}
 	}
 
 	// Check viewer credentials
 	if viewer, exists := config.Auth.Users["viewer"]; exists {
-		if viewer.Password == defaultConfig.Auth.Users["viewer"].Password {
+		if viewer.CheckPassword(defaultConfig.Auth.Users["viewer"].Password) {
 			return true
 		}
 	}
@@ -271,16 +646,28 @@ func mergeWithDefaults(cfg *Config) {
 	if cfg.Server.MaxHeaderBytes == 0 {
 		cfg.Server.MaxHeaderBytes = defaultConfig.Server.MaxHeaderBytes
 	}
+	if cfg.Server.MaxLogFollowers == 0 {
+		cfg.Server.MaxLogFollowers = defaultConfig.Server.MaxLogFollowers
+	}
 
 	// Auth defaults
 	if cfg.Auth.TokenDuration == 0 {
 		cfg.Auth.TokenDuration = defaultConfig.Auth.TokenDuration
 	}
+	if cfg.Auth.RefreshTokenDuration == 0 {
+		cfg.Auth.RefreshTokenDuration = defaultConfig.Auth.RefreshTokenDuration
+	}
+	if cfg.Auth.SessionFile == "" {
+		cfg.Auth.SessionFile = defaultConfig.Auth.SessionFile
+	}
+	if cfg.Auth.APIKeyFile == "" {
+		cfg.Auth.APIKeyFile = defaultConfig.Auth.APIKeyFile
+	}
 	if cfg.Auth.IssuedBy == "" {
 		cfg.Auth.IssuedBy = defaultConfig.Auth.IssuedBy
 	}
-	if len(cfg.Auth.AllowedRoles) == 0 {
-		cfg.Auth.AllowedRoles = defaultConfig.Auth.AllowedRoles
+	if len(cfg.Auth.Roles) == 0 {
+		cfg.Auth.Roles = defaultConfig.Auth.Roles
 	}
 
 	// OS-specific defaults
@@ -316,6 +703,39 @@ func mergeWithDefaults(cfg *Config) {
 	if cfg.Logging.MaxAge == 0 {
 		cfg.Logging.MaxAge = defaultConfig.Logging.MaxAge
 	}
+
+	// Exec defaults
+	if cfg.Exec.Timeout == "" {
+		cfg.Exec.Timeout = defaultConfig.Exec.Timeout
+	}
+
+	// Scheduler defaults
+	if cfg.Scheduler.StateFile == "" {
+		cfg.Scheduler.StateFile = defaultConfig.Scheduler.StateFile
+	}
+
+	// Watcher defaults
+	if cfg.Watcher.Interval == "" {
+		cfg.Watcher.Interval = defaultConfig.Watcher.Interval
+	}
+
+	// Webhook defaults
+	if cfg.Webhooks.DeadLetterFile == "" {
+		cfg.Webhooks.DeadLetterFile = defaultConfig.Webhooks.DeadLetterFile
+	}
+
+	// Supervisor defaults
+	if cfg.Supervisor.LogDirectory == "" {
+		cfg.Supervisor.LogDirectory = defaultConfig.Supervisor.LogDirectory
+	}
+
+	// Fleet defaults
+	if cfg.Fleet.Controller.RequestTimeout == "" {
+		cfg.Fleet.Controller.RequestTimeout = defaultConfig.Fleet.Controller.RequestTimeout
+	}
+	if cfg.Fleet.Agent.Node == "" {
+		cfg.Fleet.Agent.Node, _ = os.Hostname()
+	}
 }
 
 // UpdateConfig updates the configuration and optionally saves it to disk
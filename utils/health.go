@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -14,6 +15,56 @@ type HealthStatus struct {
 	GoVersion string    `json:"goVersion"`
 	Memory    MemStats  `json:"memory"`
 	StartTime time.Time `json:"startTime"`
+
+	// Checks holds the result of each registered component check
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a component check
+type CheckResult struct {
+	Status string `json:"status"` // "ok" or "failing"
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthCheckFunc reports whether a component is working, with a short
+// detail for the health endpoint
+type HealthCheckFunc func() (ok bool, detail string)
+
+var (
+	healthChecks   = make(map[string]HealthCheckFunc)
+	healthChecksMu sync.RWMutex
+)
+
+// RegisterHealthCheck adds a component check to the health endpoint. Any
+// failing check reports the server as degraded. The endpoint is public, so
+// details must not name services.
+func RegisterHealthCheck(name string, check HealthCheckFunc) {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	healthChecks[name] = check
+}
+
+// runHealthChecks runs the registered checks and returns their results and
+// whether all of them passed
+func runHealthChecks() (map[string]CheckResult, bool) {
+	healthChecksMu.RLock()
+	defer healthChecksMu.RUnlock()
+	if len(healthChecks) == 0 {
+		return nil, true
+	}
+
+	results := make(map[string]CheckResult, len(healthChecks))
+	healthy := true
+	for name, check := range healthChecks {
+		ok, detail := check()
+		result := CheckResult{Status: "ok", Detail: detail}
+		if !ok {
+			result.Status = "failing"
+			healthy = false
+		}
+		results[name] = result
+	}
+	return results, healthy
 }
 
 type MemStats struct {
@@ -30,6 +81,7 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
 
+	checks, healthy := runHealthChecks()
 	status := HealthStatus{
 		Status:    "healthy",
 		Uptime:    time.Since(startTime).String(),
@@ -43,6 +95,10 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 			HeapInUse:  float64(mem.HeapInuse) / 1024 / 1024, // MB
 		},
 		StartTime: startTime,
+		Checks:    checks,
+	}
+	if !healthy {
+		status.Status = "degraded"
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing schemes, detected from the prefix of a stored password
+const (
+	PasswordPlaintext = "plaintext"
+	PasswordBcrypt    = "bcrypt"
+	PasswordArgon2id  = "argon2id"
+)
+
+// bcryptCost is the work factor of new bcrypt hashes
+const bcryptCost = 12
+
+// argon2idParams are the parameters of new argon2id hashes: 64 MiB of
+// memory, 3 passes, 4 lanes, a 16 byte salt and a 32 byte key
+var argon2idParams = struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	saltLen int
+	keyLen  uint32
+}{64 * 1024, 3, 4, 16, 32}
+
+// PasswordScheme returns the scheme of a stored password: bcrypt for
+// "$2a$", "$2b$" and "$2y$" hashes, argon2id for "$argon2id$" hashes in the
+// PHC string format, and plaintext otherwise
+func PasswordScheme(stored string) string {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		return PasswordBcrypt
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return PasswordArgon2id
+	default:
+		return PasswordPlaintext
+	}
+}
+
+// HashPassword hashes password with scheme, bcrypt or argon2id, for storing
+// in the configuration
+func HashPassword(password, scheme string) (string, error) {
+	switch scheme {
+	case PasswordBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case PasswordArgon2id:
+		p := argon2idParams
+		salt := make([]byte, p.saltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unknown password hashing scheme %q, expected bcrypt or argon2id", scheme)
+	}
+}
+
+// CheckPassword reports whether password matches the stored password or
+// hash. Timing does not reveal how much of the password matched.
+func CheckPassword(stored, password string) bool {
+	switch PasswordScheme(stored) {
+	case PasswordBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case PasswordArgon2id:
+		return checkArgon2id(stored, password)
+	default:
+		// Compare digests so the time taken does not depend on the length
+		// of the stored password either
+		want, got := sha256.Sum256([]byte(stored)), sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(want[:], got[:]) == 1
+	}
+}
+
+// checkArgon2id verifies password against a PHC argon2id string such as
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>. Malformed hashes never match.
+func checkArgon2id(stored, password string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || memory == 0 || time == 0 || threads == 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(want, got) == 1
+}
+
+// IsHashed reports whether the credentials store a password hash rather
+// than the password itself
+func (c Credentials) IsHashed() bool {
+	return PasswordScheme(c.Password) != PasswordPlaintext
+}
+
+// CheckPassword reports whether password is the user's password
+func (c Credentials) CheckPassword(password string) bool {
+	return CheckPassword(c.Password, password)
+}
+
+// PlaintextPasswordUsers returns the users whose password is stored in
+// plaintext, sorted
+func (c Config) PlaintextPasswordUsers() []string {
+	var users []string
+	for name, user := range c.Auth.Users {
+		if !user.IsHashed() {
+			users = append(users, name)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	bcryptHash, err := HashPassword("s3cret", PasswordBcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := HashPassword("s3cret", PasswordArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hashes of "password" as other tools make them, with the $2y$ prefix
+	// or other argon2id parameters
+	const phpBcrypt = "$2y$04$VmerDishuAKUr7DF9VrZYuENXMQpYwMXBYR/OBA5FFUjAaulSWA5m"
+	const lightArgon2 = "$argon2id$v=19$m=16,t=2,p=1$c29tZXNhbHQ$97FcQ2XrXRGBu161IDNkhQ"
+
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		scheme   string
+		want     bool
+	}{
+		{"bcrypt", bcryptHash, "s3cret", PasswordBcrypt, true},
+		{"bcrypt wrong password", bcryptHash, "s3cret!", PasswordBcrypt, false},
+		{"bcrypt $2y$", phpBcrypt, "password", PasswordBcrypt, true},
+		{"argon2id", argon2Hash, "s3cret", PasswordArgon2id, true},
+		{"argon2id wrong password", argon2Hash, "S3cret", PasswordArgon2id, false},
+		{"argon2id other parameters", lightArgon2, "password", PasswordArgon2id, true},
+		{"argon2id wrong version", strings.Replace(lightArgon2, "v=19", "v=16", 1), "password", PasswordArgon2id, false},
+		{"argon2id zero memory", strings.Replace(lightArgon2, "m=16", "m=0", 1), "password", PasswordArgon2id, false},
+		{"argon2id missing key", strings.TrimSuffix(lightArgon2, "$97FcQ2XrXRGBu161IDNkhQ"), "password", PasswordArgon2id, false},
+		{"argon2id bad salt", strings.Replace(lightArgon2, "c29tZXNhbHQ", "!!", 1), "password", PasswordArgon2id, false},
+		{"plaintext", "s3cret", "s3cret", PasswordPlaintext, true},
+		{"plaintext wrong password", "s3cret", "s3cre", PasswordPlaintext, false},
+		{"plaintext prefix", "s3cret", "s3cret s3cret", PasswordPlaintext, false},
+		{"empty password", "s3cret", "", PasswordPlaintext, false},
+		// The hash itself is not the password
+		{"bcrypt hash as password", bcryptHash, bcryptHash, PasswordBcrypt, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PasswordScheme(tt.stored); got != tt.scheme {
+				t.Errorf("PasswordScheme() = %s, want %s", got, tt.scheme)
+			}
+			if got := CheckPassword(tt.stored, tt.password); got != tt.want {
+				t.Errorf("CheckPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashPasswordSalted(t *testing.T) {
+	for _, scheme := range []string{PasswordBcrypt, PasswordArgon2id} {
+		first, err := HashPassword("s3cret", scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := HashPassword("s3cret", scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == second {
+			t.Errorf("%s hashes of the same password are equal", scheme)
+		}
+	}
+	if _, err := HashPassword("s3cret", "md5"); err == nil {
+		t.Error("HashPassword() with an unknown scheme succeeded")
+	}
+}
@@ -0,0 +1,545 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// Actor is the actor of the service actions the watchdog runs
+const Actor = "watchdog"
+
+// Record actions
+const (
+	ActionRestart    = "restart"    // the service was restarted
+	ActionSkip       = "skip"       // a planned restart was not needed
+	ActionQuarantine = "quarantine" // restarts stopped after a crash loop
+	ActionRelease    = "release"    // quarantine ended
+)
+
+// Supervision states reported by Status
+const (
+	StateWatching       = "watching"
+	StateRestartPending = "restart-pending"
+	StateQuarantined    = "quarantined"
+)
+
+// Restart triggers of a policy
+const (
+	RestartOnFailed   = "failed"   // the service is in the failed state
+	RestartOnInactive = "inactive" // the service is failed, or stopped other than through ChronoServe
+)
+
+const (
+	defaultInterval       = 15 * time.Second
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+	defaultMaxAttempts    = 5
+	defaultWindow         = 10 * time.Minute
+	// defaultStopTimeout bounds how long Stop waits for restarts in progress
+	defaultStopTimeout = 30 * time.Second
+	// historySize bounds the records kept for each service
+	historySize = 50
+)
+
+var (
+	ErrNotSupervised  = errors.New("service is not supervised")
+	ErrNotQuarantined = errors.New("service is not quarantined")
+)
+
+// Record is an automatic action taken by the watchdog and why
+type Record struct {
+	At      time.Time `json:"at"`
+	Service string    `json:"service"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Status is the supervision state of one service
+type Status struct {
+	Service          string     `json:"service"`
+	State            string     `json:"state"`
+	Attempts         int        `json:"attempts"` // restarts within the current window
+	MaxAttempts      int        `json:"maxAttempts"`
+	Window           string     `json:"window"`
+	NextRestart      *time.Time `json:"nextRestart,omitempty"`
+	QuarantinedAt    *time.Time `json:"quarantinedAt,omitempty"`
+	QuarantinedUntil *time.Time `json:"quarantinedUntil,omitempty"`
+	History          []Record   `json:"history"`
+}
+
+// policy is a restart policy with its defaults filled in
+type policy struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxAttempts    int
+	window         time.Duration
+	quarantine     time.Duration // 0 until released
+	onInactive     bool          // restart stopped services too
+}
+
+// backoff returns the delay before the restart following n earlier ones
+func (p policy) backoff(n int) time.Duration {
+	delay := p.initialBackoff
+	for i := 0; i < n && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, p.maxBackoff)
+}
+
+// supervised is the state of a service under a restart policy
+type supervised struct {
+	name   string // as configured, used to run actions
+	policy policy
+
+	attempts         []time.Time // restarts within the window, oldest first
+	nextRestart      *time.Time
+	quarantinedAt    *time.Time
+	quarantinedUntil *time.Time
+	history          []Record
+	// stopped is set when the service was stopped through ChronoServe, so
+	// a policy restarting inactive services leaves it stopped
+	stopped bool
+}
+
+// prune forgets restarts that have left the window
+func (s *supervised) prune(now time.Time) {
+	cutoff := now.Add(-s.policy.window)
+	i := 0
+	for i < len(s.attempts) && !s.attempts[i].After(cutoff) {
+		i++
+	}
+	s.attempts = s.attempts[i:]
+}
+
+// Watchdog restarts failed services that have a restart policy. It reacts
+// to ServiceFailed events from the state watcher and, as a backstop, lists
+// services on an interval. Backends such as Windows report a crashed
+// service as inactive rather than failed; a policy with RestartOn set to
+// "inactive" restarts those too. A service restarted MaxAttempts times
+// within its window is quarantined until the quarantine expires or it is
+// released.
+type Watchdog struct {
+	manager  services.ServiceManager
+	bus      *events.Bus
+	logger   *utils.Logger
+	interval time.Duration
+
+	mu       sync.Mutex
+	services map[string]*supervised // keyed by unit base name
+
+	// ctx ends supervision and pending restarts; actionCtx is used by
+	// restarts in progress, which Stop lets finish for up to stopTimeout
+	ctx           context.Context
+	cancel        context.CancelFunc
+	actionCtx     context.Context
+	cancelActions context.CancelFunc
+	stopTimeout   time.Duration
+	wg            sync.WaitGroup
+}
+
+// New creates a watchdog for the enabled services in configured whose
+// restart policy is enabled. Services are listed every interval, or every
+// 15s if interval is zero.
+func New(manager services.ServiceManager, configured map[string]utils.Service, interval time.Duration, bus *events.Bus, logger *utils.Logger) (*Watchdog, error) {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	actionCtx, cancelActions := context.WithCancel(context.Background())
+	w := &Watchdog{
+		manager:       manager,
+		bus:           bus,
+		logger:        logger,
+		interval:      interval,
+		services:      make(map[string]*supervised),
+		ctx:           ctx,
+		cancel:        cancel,
+		actionCtx:     actionCtx,
+		cancelActions: cancelActions,
+		stopTimeout:   defaultStopTimeout,
+	}
+	for name, service := range configured {
+		if !service.Enabled || !service.Restart.Enabled {
+			continue
+		}
+		p, err := newPolicy(service.Restart)
+		if err != nil {
+			cancel()
+			cancelActions()
+			return nil, fmt.Errorf("restart policy for %s: %w", name, err)
+		}
+		w.services[services.UnitBaseName(name)] = &supervised{name: name, policy: p}
+	}
+	return w, nil
+}
+
+// newPolicy validates c and fills in defaults
+func newPolicy(c utils.RestartPolicy) (policy, error) {
+	p := policy{
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		maxAttempts:    defaultMaxAttempts,
+		window:         defaultWindow,
+	}
+	switch c.RestartOn {
+	case "", RestartOnFailed:
+	case RestartOnInactive:
+		p.onInactive = true
+	default:
+		return p, fmt.Errorf("invalid restartOn %q", c.RestartOn)
+	}
+	if c.MaxAttempts < 0 {
+		return p, fmt.Errorf("invalid maxAttempts %d", c.MaxAttempts)
+	} else if c.MaxAttempts > 0 {
+		p.maxAttempts = c.MaxAttempts
+	}
+	for _, d := range []struct {
+		value string
+		field *time.Duration
+		name  string
+	}{
+		{c.InitialBackoff, &p.initialBackoff, "initialBackoff"},
+		{c.MaxBackoff, &p.maxBackoff, "maxBackoff"},
+		{c.Window, &p.window, "window"},
+		{c.Quarantine, &p.quarantine, "quarantine"},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return p, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.field = parsed
+	}
+	return p, nil
+}
+
+// Start begins supervising in the background
+func (w *Watchdog) Start() {
+	if len(w.services) == 0 {
+		return
+	}
+	w.wg.Add(1)
+	go w.run()
+	w.logger.Info("Restart watchdog started for %d services", len(w.services))
+}
+
+// Stop stops supervising, abandoning pending restarts, and waits for a
+// restart in progress to finish. A restart still running after 30s is
+// aborted.
+func (w *Watchdog) Stop() {
+	w.cancel()
+	defer w.cancelActions()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.stopTimeout):
+		w.logger.Warn("Watchdog restart still in progress after %s, aborting it", w.stopTimeout)
+		w.cancelActions()
+		<-done
+	}
+}
+
+func (w *Watchdog) run() {
+	defer w.wg.Done()
+
+	var failures <-chan events.Event
+	if w.bus != nil {
+		ch, unsubscribe := w.bus.Subscribe("watchdog", 64, events.ServiceFailed, events.ServiceStateChanged, events.ServiceRecovered, events.ServiceAction)
+		defer unsubscribe()
+		failures = ch
+	}
+
+	// Services that were already failed at startup are picked up here
+	w.sweep()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			w.sweep()
+		case e, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+			w.handleEvent(e)
+		}
+	}
+}
+
+// handleEvent handles a failure, a stop or an action reported on the bus
+func (w *Watchdog) handleEvent(e events.Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.services[services.UnitBaseName(e.Service)]
+	if !ok {
+		return
+	}
+	switch e.Type {
+	case events.ServiceFailed:
+		w.handleFailure(s, "service entered the failed state")
+	case events.ServiceStateChanged, events.ServiceRecovered:
+		switch {
+		case e.To == nil:
+		case e.To.ActiveState == "active":
+			s.stopped = false
+		case e.To.ActiveState == "inactive" && s.policy.onInactive && !s.stopped:
+			w.handleFailure(s, "service stopped unexpectedly")
+		}
+	case events.ServiceAction:
+		// Remember whether the service was last stopped or started on
+		// purpose; the watchdog's own restarts start it
+		switch e.Action {
+		case "stop":
+			s.stopped = true
+		case "start", "restart", "reload-or-restart":
+			s.stopped = false
+		}
+	}
+}
+
+// sweep ends expired quarantines and handles supervised services that are
+// failed, or stopped under a policy restarting inactive services, but were
+// not reported by the watcher
+func (w *Watchdog) sweep() {
+	list, err := w.manager.List(w.ctx)
+	if err != nil {
+		w.logger.Warn("Watchdog failed to list services: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for _, s := range w.services {
+		if s.quarantinedUntil != nil && !now.Before(*s.quarantinedUntil) {
+			w.release(s, "quarantine expired")
+		}
+	}
+	for _, info := range list {
+		s, ok := w.services[services.UnitBaseName(info.Name)]
+		if !ok {
+			continue
+		}
+		switch {
+		case info.ActiveState == "failed":
+			w.handleFailure(s, "service is in the failed state")
+		case info.ActiveState == "inactive" && s.policy.onInactive && !s.stopped:
+			w.handleFailure(s, "service is stopped")
+		}
+	}
+}
+
+// handleFailure plans a restart of s after its backoff, or quarantines it
+// once it has used up its restarts for the window. Callers hold w.mu.
+func (w *Watchdog) handleFailure(s *supervised, cause string) {
+	if s.quarantinedAt != nil || s.nextRestart != nil {
+		return
+	}
+
+	now := time.Now()
+	s.prune(now)
+	if len(s.attempts) >= s.policy.maxAttempts {
+		s.quarantinedAt = &now
+		if s.policy.quarantine > 0 {
+			until := now.Add(s.policy.quarantine)
+			s.quarantinedUntil = &until
+		}
+		reason := fmt.Sprintf("crash loop: %s after %d restarts within %s", cause, len(s.attempts), s.policy.window)
+		w.record(s, Record{At: now, Action: ActionQuarantine, Reason: reason})
+		if w.bus != nil {
+			w.bus.Publish(events.Event{Type: events.ServiceQuarantined, Service: s.name, Actor: Actor, Message: reason})
+		}
+		return
+	}
+
+	delay := s.policy.backoff(len(s.attempts))
+	at := now.Add(delay)
+	s.nextRestart = &at
+	reason := fmt.Sprintf("%s; restart %d of %d within %s after %s backoff",
+		cause, len(s.attempts)+1, s.policy.maxAttempts, s.policy.window, delay)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if w.ctx.Err() != nil {
+			return
+		}
+		w.restart(s, reason)
+	}()
+}
+
+// restart restarts s unless it is running or starting again, or was
+// stopped through ChronoServe. Stop lets a restart that has begun finish, so
+// it runs under w.actionCtx.
+func (w *Watchdog) restart(s *supervised, reason string) {
+	// The service may have been started by someone else while we waited.
+	// Status holds the active state normalised by every backend; backends
+	// without a failed state, such as Windows, report a crash as inactive.
+	var status *services.ServiceStatus
+	var err error
+	if refresher, ok := w.manager.(services.StatusRefresher); ok {
+		status, err = refresher.Refresh(w.actionCtx, s.name)
+	} else {
+		status, err = w.manager.Status(w.actionCtx, s.name)
+	}
+	skip := ""
+	w.mu.Lock()
+	switch {
+	case err == nil && (status.IsActive || status.Status == "activating"):
+		skip = fmt.Sprintf("service is %s again, restart not needed", status.Status)
+	case s.stopped:
+		skip = "service was stopped through ChronoServe, restart not needed"
+	}
+	if skip != "" {
+		s.nextRestart = nil
+		w.record(s, Record{At: time.Now(), Action: ActionSkip, Reason: skip})
+	}
+	w.mu.Unlock()
+	if skip != "" {
+		return
+	}
+
+	result, err := services.RunAction(w.actionCtx, w.manager, ActionRestart, s.name)
+	rec := Record{At: time.Now(), Action: ActionRestart, Reason: reason}
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		rec.Message = result.Message
+	}
+
+	// A failed restart still counts towards the window, and the next sweep
+	// sees the service still failed and tries again
+	w.mu.Lock()
+	s.nextRestart = nil
+	s.attempts = append(s.attempts, rec.At)
+	w.record(s, rec)
+	w.mu.Unlock()
+
+	if err == nil && w.bus != nil {
+		w.bus.Publish(events.Event{
+			Type:    events.ServiceAction,
+			Service: s.name,
+			Action:  ActionRestart,
+			Actor:   Actor,
+			Message: result.Message,
+		})
+	}
+}
+
+// release ends the quarantine of s and forgets its restarts. Callers hold
+// w.mu.
+func (w *Watchdog) release(s *supervised, reason string) {
+	s.quarantinedAt = nil
+	s.quarantinedUntil = nil
+	s.attempts = nil
+	w.record(s, Record{At: time.Now(), Action: ActionRelease, Reason: reason})
+}
+
+// record adds rec to the history of s and logs it. Callers hold w.mu.
+func (w *Watchdog) record(s *supervised, rec Record) {
+	rec.Service = s.name
+	s.history = append(s.history, rec)
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+
+	switch {
+	case rec.Error != "":
+		w.logger.Error("Watchdog %s %s failed (%s): %s", rec.Action, s.name, rec.Reason, rec.Error)
+	case rec.Action == ActionQuarantine:
+		w.logger.Warn("Watchdog quarantined %s: %s", s.name, rec.Reason)
+	default:
+		w.logger.Info("Watchdog %s %s: %s", rec.Action, s.name, rec.Reason)
+	}
+}
+
+// Release ends the quarantine of the named service on behalf of actor, so
+// the watchdog restarts it again the next time it fails
+func (w *Watchdog) Release(name, actor string) (Status, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.services[services.UnitBaseName(name)]
+	if !ok {
+		return Status{}, ErrNotSupervised
+	}
+	if s.quarantinedAt == nil {
+		return Status{}, ErrNotQuarantined
+	}
+	w.release(s, "released by "+actor)
+	return s.status(time.Now()), nil
+}
+
+// Statuses returns the supervision state of every supervised service
+func (w *Watchdog) Statuses() []Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	list := make([]Status, 0, len(w.services))
+	for _, s := range w.services {
+		list = append(list, s.status(now))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Service < list[j].Service })
+	return list
+}
+
+// Status returns the supervision state of the named service
+func (w *Watchdog) Status(name string) (Status, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.services[services.UnitBaseName(name)]
+	if !ok {
+		return Status{}, false
+	}
+	return s.status(time.Now()), true
+}
+
+// status snapshots s. Callers hold the watchdog's lock.
+func (s *supervised) status(now time.Time) Status {
+	s.prune(now)
+	status := Status{
+		Service:          s.name,
+		State:            StateWatching,
+		Attempts:         len(s.attempts),
+		MaxAttempts:      s.policy.maxAttempts,
+		Window:           s.policy.window.String(),
+		NextRestart:      s.nextRestart,
+		QuarantinedAt:    s.quarantinedAt,
+		QuarantinedUntil: s.quarantinedUntil,
+		History:          append([]Record{}, s.history...),
+	}
+	switch {
+	case s.quarantinedAt != nil:
+		status.State = StateQuarantined
+	case s.nextRestart != nil:
+		status.State = StateRestartPending
+	}
+	return status
+}
@@ -0,0 +1,271 @@
+package watchdog
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// fakeManager is a ServiceManager listing list and reporting status for
+// every service, whose Restart reports each call on restarts and then waits
+// for release or for its context to end
+type fakeManager struct {
+	services.ServiceManager
+	list     []services.ServiceInfo
+	status   services.ServiceStatus
+	restarts chan context.Context
+	release  chan struct{}
+}
+
+func newFakeManager(status services.ServiceStatus) *fakeManager {
+	return &fakeManager{status: status, restarts: make(chan context.Context, 4), release: make(chan struct{})}
+}
+
+func (m *fakeManager) List(ctx context.Context) ([]services.ServiceInfo, error) {
+	return m.list, nil
+}
+
+func (m *fakeManager) Status(ctx context.Context, name string) (*services.ServiceStatus, error) {
+	status := m.status
+	status.Name = name
+	return &status, nil
+}
+
+func (m *fakeManager) Restart(ctx context.Context, name string) (*services.ActionResult, error) {
+	m.restarts <- ctx
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &services.ActionResult{Name: name, Action: "restart", Message: "Service " + name + " restarted successfully"}, nil
+}
+
+// newTestWatchdog creates a watchdog supervising app under a policy that
+// restarts it without delay
+func newTestWatchdog(t *testing.T, manager services.ServiceManager) *Watchdog {
+	t.Helper()
+	return newTestWatchdogOn(t, manager, "")
+}
+
+// newTestWatchdogOn creates a watchdog supervising app under a policy that
+// restarts it without delay on restartOn
+func newTestWatchdogOn(t *testing.T, manager services.ServiceManager, restartOn string) *Watchdog {
+	t.Helper()
+	logger, err := utils.NewLogger(utils.LoggerOptions{Level: utils.ERROR, Directory: t.TempDir(), Filename: "test.log", MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+
+	w, err := New(manager, map[string]utils.Service{
+		"app": {Enabled: true, Restart: utils.RestartPolicy{Enabled: true, InitialBackoff: "1ms", RestartOn: restartOn}},
+	}, time.Hour, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// fail reports app as failed to w
+func fail(w *Watchdog) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handleFailure(w.services["app"], "service entered the failed state")
+}
+
+// waitForRecord waits until the history of app holds n records and returns
+// the last
+func waitForRecord(t *testing.T, w *Watchdog, n int) Record {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := w.Status("app")
+		if len(status.History) >= n {
+			return status.History[n-1]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("history = %+v, want %d records", status.History, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRestartSkipsRunningService(t *testing.T) {
+	tests := []struct {
+		name   string
+		status services.ServiceStatus
+		skip   bool
+	}{
+		{"systemd failed", services.ServiceStatus{Status: "failed", SubState: "failed"}, false},
+		{"windows stopped after a crash", services.ServiceStatus{Status: "inactive", SubState: "Stopped"}, false},
+		{"started again", services.ServiceStatus{Status: "active", SubState: "running", IsActive: true}, true},
+		{"starting again", services.ServiceStatus{Status: "activating", SubState: "auto-restart"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newFakeManager(tt.status)
+			close(manager.release)
+			w := newTestWatchdog(t, manager)
+			defer w.Stop()
+
+			fail(w)
+			rec := waitForRecord(t, w, 1)
+			if want := map[bool]string{true: ActionSkip, false: ActionRestart}[tt.skip]; rec.Action != want {
+				t.Errorf("action = %s (%s), want %s", rec.Action, rec.Reason, want)
+			}
+		})
+	}
+}
+
+func TestStopLetsRestartFinish(t *testing.T) {
+	manager := newFakeManager(services.ServiceStatus{Status: "failed"})
+	w := newTestWatchdog(t, manager)
+
+	fail(w)
+	ctx := <-manager.restarts
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop() returned while a restart was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("restart context ended by Stop(): %v", ctx.Err())
+	}
+	close(manager.release)
+	<-stopped
+
+	if rec := waitForRecord(t, w, 1); rec.Action != ActionRestart || rec.Error != "" {
+		t.Errorf("record = %+v, want a successful restart", rec)
+	}
+}
+
+func TestStopAbortsStuckRestart(t *testing.T) {
+	manager := newFakeManager(services.ServiceStatus{Status: "failed"})
+	w := newTestWatchdog(t, manager)
+	w.stopTimeout = 50 * time.Millisecond
+
+	fail(w)
+	ctx := <-manager.restarts
+	w.Stop()
+
+	if ctx.Err() == nil {
+		t.Fatal("restart context still live after Stop() timed out")
+	}
+	if rec := waitForRecord(t, w, 1); rec.Action != ActionRestart || rec.Error == "" {
+		t.Errorf("record = %+v, want a failed restart", rec)
+	}
+}
+
+func TestStopDropsPendingRestart(t *testing.T) {
+	manager := newFakeManager(services.ServiceStatus{Status: "failed"})
+	close(manager.release)
+	w := newTestWatchdog(t, manager)
+	w.services["app"].policy.initialBackoff = time.Hour
+
+	fail(w)
+	w.Stop()
+	if len(manager.restarts) != 0 {
+		t.Error("pending restart ran after Stop()")
+	}
+}
+
+func TestSweepRestartOn(t *testing.T) {
+	tests := []struct {
+		name      string
+		restartOn string
+		state     string
+		stopped   bool
+		want      string // record action, empty for none
+	}{
+		{"failed", RestartOnFailed, "failed", false, ActionRestart},
+		{"inactive ignored by default", "", "inactive", false, ""},
+		{"inactive", RestartOnInactive, "inactive", false, ActionRestart},
+		{"inactive after a stop through the API", RestartOnInactive, "inactive", true, ""},
+		// e.g. systemd marks a service killed on stop as failed
+		{"failed after a stop through the API", RestartOnFailed, "failed", true, ActionSkip},
+		{"running", RestartOnInactive, "active", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newFakeManager(services.ServiceStatus{Status: tt.state})
+			manager.list = []services.ServiceInfo{{Name: "app", ActiveState: tt.state}}
+			close(manager.release)
+			w := newTestWatchdogOn(t, manager, tt.restartOn)
+			defer w.Stop()
+			w.services["app"].stopped = tt.stopped
+
+			w.sweep()
+			if tt.want == "" {
+				if w.services["app"].nextRestart != nil {
+					t.Fatal("restart planned")
+				}
+				return
+			}
+			if rec := waitForRecord(t, w, 1); rec.Action != tt.want {
+				t.Errorf("action = %s (%s), want %s", rec.Action, rec.Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEventRestartOnInactive(t *testing.T) {
+	manager := newFakeManager(services.ServiceStatus{Status: "inactive", SubState: "Stopped"})
+	close(manager.release)
+	w := newTestWatchdogOn(t, manager, RestartOnInactive)
+	defer w.Stop()
+	stateChanged := func(state string) events.Event {
+		return events.Event{Type: events.ServiceStateChanged, Service: "app", To: &events.State{ActiveState: state}}
+	}
+
+	// Stopped through ChronoServe: left alone
+	w.handleEvent(events.Event{Type: events.ServiceAction, Service: "app", Action: "stop", Actor: "alice"})
+	w.handleEvent(stateChanged("inactive"))
+	if w.services["app"].nextRestart != nil {
+		t.Fatal("restart planned after a stop through the API")
+	}
+
+	// Started outside ChronoServe, then stopped by a crash
+	w.handleEvent(stateChanged("active"))
+	w.handleEvent(stateChanged("inactive"))
+	if rec := waitForRecord(t, w, 1); rec.Action != ActionRestart || !strings.HasPrefix(rec.Reason, "service stopped unexpectedly") {
+		t.Errorf("record = %s (%s), want a restart", rec.Action, rec.Reason)
+	}
+}
+
+func TestRestartSkipsServiceStoppedDuringBackoff(t *testing.T) {
+	manager := newFakeManager(services.ServiceStatus{Status: "inactive", SubState: "Stopped"})
+	close(manager.release)
+	w := newTestWatchdogOn(t, manager, RestartOnInactive)
+	defer w.Stop()
+	w.services["app"].policy.initialBackoff = 50 * time.Millisecond
+
+	w.handleEvent(events.Event{Type: events.ServiceStateChanged, Service: "app", To: &events.State{ActiveState: "inactive"}})
+	w.handleEvent(events.Event{Type: events.ServiceAction, Service: "app", Action: "stop", Actor: "alice"})
+	if rec := waitForRecord(t, w, 1); rec.Action != ActionSkip {
+		t.Errorf("record = %s (%s), want a skip", rec.Action, rec.Reason)
+	}
+	if len(manager.restarts) != 0 {
+		t.Error("service restarted")
+	}
+}
+
+func TestNewRejectsUnknownRestartOn(t *testing.T) {
+	_, err := New(newFakeManager(services.ServiceStatus{}), map[string]utils.Service{
+		"app": {Enabled: true, Restart: utils.RestartPolicy{Enabled: true, RestartOn: "sometimes"}},
+	}, time.Hour, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid restartOn") {
+		t.Errorf("New() error = %v, want an invalid restartOn", err)
+	}
+}
@@ -0,0 +1,357 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/services"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// Request headers sent with every delivery
+const (
+	HeaderEvent     = "X-ChronoServe-Event"
+	HeaderDelivery  = "X-ChronoServe-Delivery"
+	HeaderTimestamp = "X-ChronoServe-Timestamp"
+	HeaderSignature = "X-ChronoServe-Signature"
+)
+
+const (
+	defaultTimeout        = 10 * time.Second
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+	// queueSize bounds the deliveries waiting for each target
+	queueSize = 256
+)
+
+// target is a configured webhook target ready for delivery
+type target struct {
+	name           string
+	url            string
+	secret         []byte
+	events         map[events.Type]bool
+	services       map[string]bool
+	actions        map[string]bool
+	timeout        time.Duration
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	queue          chan events.Event
+}
+
+// DeadLetter is a delivery that failed permanently, as written to the dead
+// letter file
+type DeadLetter struct {
+	Target    string       `json:"target"`
+	URL       string       `json:"url"`
+	Event     events.Event `json:"event"`
+	Attempts  int          `json:"attempts"`
+	LastError string       `json:"lastError"`
+	FailedAt  time.Time    `json:"failedAt"`
+}
+
+// Dispatcher delivers events from a bus to webhook targets. Each target has
+// its own queue and worker, so a slow or failing target does not hold back
+// the others.
+type Dispatcher struct {
+	bus            *events.Bus
+	logger         *utils.Logger
+	client         *http.Client
+	targets        []*target
+	deadLetterFile string
+	deadLetterMu   sync.Mutex
+
+	unsubscribe func()
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// New validates the targets in cfg and creates a dispatcher for them
+func New(cfg utils.WebhooksConfig, bus *events.Bus, logger *utils.Logger) (*Dispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		bus:            bus,
+		logger:         logger,
+		client:         &http.Client{},
+		deadLetterFile: cfg.DeadLetterFile,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	for i, c := range cfg.Targets {
+		t, err := newTarget(c)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("webhook target %d (%s): %w", i+1, c.Name, err)
+		}
+		d.targets = append(d.targets, t)
+	}
+	return d, nil
+}
+
+// newTarget validates c and fills in defaults
+func newTarget(c utils.WebhookTarget) (*target, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", c.URL)
+	}
+
+	t := &target{
+		name:           c.Name,
+		url:            c.URL,
+		secret:         []byte(c.Secret),
+		events:         toSet(c.Events, func(s string) events.Type { return events.Type(s) }),
+		services:       toSet(c.Services, services.UnitBaseName),
+		actions:        toSet(c.Actions, func(s string) string { return s }),
+		timeout:        defaultTimeout,
+		maxAttempts:    defaultMaxAttempts,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		queue:          make(chan events.Event, queueSize),
+	}
+	if t.name == "" {
+		t.name = u.Host
+	}
+	if c.MaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid maxAttempts %d", c.MaxAttempts)
+	} else if c.MaxAttempts > 0 {
+		t.maxAttempts = c.MaxAttempts
+	}
+	for _, d := range []struct {
+		value string
+		field *time.Duration
+		name  string
+	}{
+		{c.Timeout, &t.timeout, "timeout"},
+		{c.InitialBackoff, &t.initialBackoff, "initialBackoff"},
+		{c.MaxBackoff, &t.maxBackoff, "maxBackoff"},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.field = parsed
+	}
+	return t, nil
+}
+
+// toSet builds a lookup set from values, or nil when values is empty
+func toSet[K comparable](values []string, key func(string) K) map[K]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[K]bool, len(values))
+	for _, v := range values {
+		set[key(v)] = true
+	}
+	return set
+}
+
+// matches reports whether t wants e
+func (t *target) matches(e events.Event) bool {
+	if t.events != nil && !t.events[e.Type] {
+		return false
+	}
+	if t.services != nil && !t.services[services.UnitBaseName(e.Service)] {
+		return false
+	}
+	if e.Type == events.ServiceAction && t.actions != nil && !t.actions[e.Action] {
+		return false
+	}
+	return true
+}
+
+// Start subscribes to the bus and starts a delivery worker per target
+func (d *Dispatcher) Start() {
+	if len(d.targets) == 0 {
+		return
+	}
+
+	ch, unsubscribe := d.bus.Subscribe("webhooks", queueSize)
+	d.unsubscribe = unsubscribe
+	for _, t := range d.targets {
+		d.wg.Add(1)
+		go d.worker(t)
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for e := range ch {
+			d.route(e)
+		}
+		// The bus subscription has ended: let the workers drain and exit
+		for _, t := range d.targets {
+			close(t.queue)
+		}
+	}()
+	d.logger.Info("Webhook dispatcher started with %d targets", len(d.targets))
+}
+
+// Stop unsubscribes from the bus, aborts deliveries in flight and waits for
+// the workers to exit. Deliveries that were in flight, waiting to be retried
+// or still queued are written to the dead letter file.
+func (d *Dispatcher) Stop() {
+	if d.unsubscribe == nil {
+		return
+	}
+	d.unsubscribe()
+	d.cancel()
+	d.wg.Wait()
+}
+
+// route queues e for every target that wants it
+func (d *Dispatcher) route(e events.Event) {
+	for _, t := range d.targets {
+		if !t.matches(e) {
+			continue
+		}
+		select {
+		case t.queue <- e:
+		default:
+			d.deadLetter(t, e, 0, "delivery queue full")
+		}
+	}
+}
+
+// worker delivers the events queued for t in order. Once the dispatcher is
+// stopping, the rest of the queue is dead-lettered without being attempted.
+func (d *Dispatcher) worker(t *target) {
+	defer d.wg.Done()
+	for e := range t.queue {
+		if d.ctx.Err() != nil {
+			d.deadLetter(t, e, 0, "shutting down")
+			continue
+		}
+		d.deliver(t, e)
+	}
+}
+
+// deliver posts e to t, retrying with exponential backoff, and dead-letters
+// it once every attempt has failed
+func (d *Dispatcher) deliver(t *target, e events.Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		d.deadLetter(t, e, 0, err.Error())
+		return
+	}
+
+	backoff := t.initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= t.maxAttempts; attempt++ {
+		if lastErr = d.post(t, e, body); lastErr == nil {
+			return
+		}
+		if d.ctx.Err() != nil {
+			d.deadLetter(t, e, attempt, fmt.Sprintf("shutting down: %v", lastErr))
+			return
+		}
+		d.logger.Warn("Webhook %s: delivery %s attempt %d/%d failed: %v", t.name, e.ID, attempt, t.maxAttempts, lastErr)
+		if attempt == t.maxAttempts {
+			break
+		}
+
+		select {
+		case <-d.ctx.Done():
+			d.deadLetter(t, e, attempt, fmt.Sprintf("shutting down: %v", lastErr))
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, t.maxBackoff)
+	}
+	d.deadLetter(t, e, t.maxAttempts, lastErr.Error())
+}
+
+// post makes a single delivery attempt, aborted when the dispatcher stops
+func (d *Dispatcher) post(t *target, e events.Event, body []byte) error {
+	ctx, cancel := context.WithTimeout(d.ctx, t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ChronoServe/"+utils.Version)
+	req.Header.Set(HeaderEvent, string(e.Type))
+	req.Header.Set(HeaderDelivery, e.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(t.secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(t.secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body sent at timestamp:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter appends an undeliverable event to the dead letter file
+func (d *Dispatcher) deadLetter(t *target, e events.Event, attempts int, reason string) {
+	d.logger.Error("Webhook %s: giving up on delivery %s (%s): %s", t.name, e.ID, e.Type, reason)
+	if d.deadLetterFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(DeadLetter{
+		Target:    t.name,
+		URL:       t.url,
+		Event:     e,
+		Attempts:  attempts,
+		LastError: reason,
+		FailedAt:  time.Now(),
+	})
+	if err != nil {
+		return
+	}
+
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(d.deadLetterFile), 0750); err != nil {
+		d.logger.Error("Failed to write webhook dead letter: %v", err)
+		return
+	}
+	f, err := os.OpenFile(d.deadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		d.logger.Error("Failed to write webhook dead letter: %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
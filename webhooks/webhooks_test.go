@@ -0,0 +1,207 @@
+package webhooks
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealtoxicdev/chronoserve/events"
+	"github.com/therealtoxicdev/chronoserve/utils"
+)
+
+// newTestDispatcher starts a dispatcher for targets with its dead letter
+// file in a temporary directory
+func newTestDispatcher(t *testing.T, targets ...utils.WebhookTarget) (*Dispatcher, *events.Bus) {
+	t.Helper()
+	dir := t.TempDir()
+	logger, err := utils.NewLogger(utils.LoggerOptions{Level: utils.ERROR, Directory: dir, Filename: "webhooks.log", MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+
+	bus := events.NewBus(nil)
+	d, err := New(utils.WebhooksConfig{DeadLetterFile: filepath.Join(dir, "dead-letter.jsonl"), Targets: targets}, bus, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Start()
+	return d, bus
+}
+
+// readDeadLetters returns the dead letters written by d
+func readDeadLetters(t *testing.T, d *Dispatcher) []DeadLetter {
+	t.Helper()
+	f, err := os.Open(d.deadLetterFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestDeliverSigned(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan delivery, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{r.Header.Clone(), body}
+	}))
+	defer server.Close()
+
+	d, bus := newTestDispatcher(t, utils.WebhookTarget{
+		Name:     "ops",
+		URL:      server.URL,
+		Secret:   "s3cret",
+		Events:   []string{string(events.ServiceFailed)},
+		Services: []string{"nginx"},
+	})
+
+	bus.Publish(testEvent(events.ServiceStateChanged, "nginx.service"))
+	bus.Publish(testEvent(events.ServiceFailed, "redis.service"))
+	bus.Publish(testEvent(events.ServiceFailed, "nginx.service"))
+	// Deliveries to a target are made in order, so once this one arrives
+	// the first has completed
+	bus.Publish(testEvent(events.ServiceFailed, "nginx.service"))
+
+	var got delivery
+	for i := 0; i < 2; i++ {
+		select {
+		case delivered := <-received:
+			if i == 0 {
+				got = delivered
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no delivery")
+		}
+	}
+	d.Stop()
+	if len(received) != 0 {
+		t.Errorf("%d unwanted deliveries", len(received))
+	}
+
+	var e events.Event
+	if err := json.Unmarshal(got.body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != events.ServiceFailed || e.Service != "nginx.service" {
+		t.Errorf("event = %+v", e)
+	}
+	if got.header.Get(HeaderEvent) != string(events.ServiceFailed) || got.header.Get(HeaderDelivery) != e.ID {
+		t.Errorf("headers = %v", got.header)
+	}
+	if want := Sign([]byte("s3cret"), got.header.Get(HeaderTimestamp), got.body); got.header.Get(HeaderSignature) != want {
+		t.Errorf("signature = %s, want %s", got.header.Get(HeaderSignature), want)
+	}
+	for _, letter := range readDeadLetters(t, d) {
+		if letter.Event.ID == e.ID {
+			t.Errorf("delivered event was dead-lettered: %+v", letter)
+		}
+	}
+}
+
+func TestDeliverRetriesThenDeadLetters(t *testing.T) {
+	attempts := make(chan struct{}, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts <- struct{}{}
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d, bus := newTestDispatcher(t, utils.WebhookTarget{
+		URL:            server.URL,
+		MaxAttempts:    3,
+		InitialBackoff: "1ms",
+	})
+	bus.Publish(testEvent(events.ServiceFailed, "nginx.service"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(readDeadLetters(t, d)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("delivery was not dead-lettered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Stop()
+
+	if len(attempts) != 3 {
+		t.Errorf("attempts = %d, want 3", len(attempts))
+	}
+	letters := readDeadLetters(t, d)
+	if len(letters) != 1 || letters[0].Attempts != 3 || letters[0].LastError != "unexpected status 503 Service Unavailable" {
+		t.Errorf("dead letters = %+v", letters)
+	}
+}
+
+func TestStopAbortsInFlightAndDeadLettersQueue(t *testing.T) {
+	started := make(chan struct{}, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	d, bus := newTestDispatcher(t, utils.WebhookTarget{URL: server.URL, Timeout: "1m"})
+	for i := 0; i < 3; i++ {
+		bus.Publish(testEvent(events.ServiceFailed, "nginx.service"))
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery attempt")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop() waited for the request in flight")
+	}
+
+	letters := readDeadLetters(t, d)
+	if len(letters) != 3 {
+		t.Fatalf("dead letters = %+v, want 3", letters)
+	}
+	if letters[0].Attempts != 1 {
+		t.Errorf("in-flight delivery attempts = %d, want 1", letters[0].Attempts)
+	}
+	for _, letter := range letters[1:] {
+		if letter.Attempts != 0 || letter.LastError != "shutting down" {
+			t.Errorf("queued delivery dead letter = %+v", letter)
+		}
+	}
+	if len(started) != 0 {
+		t.Errorf("%d queued deliveries were attempted after Stop()", len(started))
+	}
+}
+
+// testEvent returns an event of type typ for service
+func testEvent(typ events.Type, service string) events.Event {
+	return events.Event{Type: typ, Service: service, To: &events.State{ActiveState: "failed"}}
+}